@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"nebula_manager/internal/agent"
+)
+
+func main() {
+	defaultConfig := os.Getenv("NEBULA_AGENT_CONFIG")
+	if defaultConfig == "" {
+		defaultConfig = agent.DefaultConfigFile
+	}
+	configFile := flag.String("config", defaultConfig, "env file with the agent settings")
+	once := flag.Bool("once", false, "run a single probe cycle and exit")
+	version := flag.Bool("version", false, "print the agent version and exit")
+	flag.Parse()
+	if *version {
+		fmt.Println("nebula-agent", agent.Version)
+		return
+	}
+
+	cfg, err := agent.LoadConfig(*configFile)
+	if err != nil {
+		log.Fatalf("agent: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	a := agent.New(cfg)
+	if *once {
+		if err := a.RunOnce(ctx); err != nil {
+			log.Fatalf("agent: %v", err)
+		}
+		return
+	}
+	if err := a.Run(ctx); err != nil {
+		// Exiting lets the service manager start the binary that is now on disk.
+		log.Fatalf("agent: %v", err)
+	}
+}
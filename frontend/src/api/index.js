@@ -23,14 +23,81 @@ export const getNodeArtifacts = (id) => client.get(`/nodes/${id}/artifacts`);
 export const getNodeConfig = (id) => client.get(`/nodes/${id}/config`, { responseType: 'blob' });
 export const downloadNodeBundle = (id) => client.get(`/nodes/${id}/bundle`, { responseType: 'blob' });
 export const getInstallScript = (id) => client.get(`/nodes/${id}/install-script`, { responseType: 'blob' });
-export const getNodeNetwork = (id, range) => client.get(`/nodes/${id}/network`, { params: range ? { range } : {} });
+export const getNodeNetwork = (id, range, path) =>
+  client.get(`/nodes/${id}/network`, { params: { ...(range ? { range } : {}), ...(path ? { path } : {}) } });
+export const getNodeStatusHistory = (id, range) => client.get(`/nodes/${id}/status/history`, { params: range ? { range } : {} });
+export const getNodeStateEvents = (id, range) => client.get(`/nodes/${id}/state/events`, { params: range ? { range } : {} });
+export const getNodeAvailability = (id) => client.get(`/nodes/${id}/availability`);
+export const getNodesAvailability = () => client.get('/nodes/availability');
 export const submitNodeNetworkSamples = (id, payload) => client.post(`/nodes/${id}/network/samples`, payload);
 export const getNodeNetworkTargets = (id) => client.get(`/nodes/${id}/network/targets`);
+export const getNodeConfigVersion = (id) => client.get(`/nodes/${id}/config/version`);
+export const getNodeDrift = (driftedOnly = false) => client.get('/nodes/drift', { params: driftedOnly ? { drifted: true } : {} });
+export const getAgentReleases = () => client.get('/agent/releases');
+export const getAgentFleet = (outdatedOnly = false) => client.get('/agent/fleet', { params: outdatedOnly ? { outdated: true } : {} });
+export const getOIDCConfig = () => client.get('/oidc/config');
+export const getNetworkMatrix = (window) => client.get('/network/matrix', { params: window ? { window } : {} });
+export const getNetworkTunnels = () => client.get('/network/tunnels');
+export const getProbes = () => client.get('/probes');
+export const createProbe = (payload) => client.post('/probes', payload);
+export const updateProbe = (id, payload) => client.put(`/probes/${id}`, payload);
+export const deleteProbe = (id) => client.delete(`/probes/${id}`);
+export const getNodeProbeResults = (id, params = {}) => client.get(`/nodes/${id}/probes`, { params });
+export const getDiagnostics = (params = {}) => client.get('/diagnostics', { params });
+export const getDiagnostic = (id) => client.get(`/diagnostics/${id}`);
+export const createDiagnostic = (payload) => client.post('/diagnostics', payload);
+export const cancelDiagnostic = (id) => client.post(`/diagnostics/${id}/cancel`);
+export const getNetworkTopology = (format = 'json', params = {}) =>
+  client.get('/network/topology', { params: { format, ...params }, responseType: format === 'dot' ? 'blob' : 'json' });
+export const getAlerts = (params = {}) => client.get('/alerts', { params });
+export const getAlertRules = () => client.get('/alerts/rules');
+export const createAlertRule = (payload) => client.post('/alerts/rules', payload);
+export const updateAlertRule = (id, payload) => client.put(`/alerts/rules/${id}`, payload);
+export const deleteAlertRule = (id) => client.delete(`/alerts/rules/${id}`);
+export const getAlertSilences = (all = false) => client.get('/alerts/silences', { params: all ? { all: true } : {} });
+export const createAlertSilence = (payload) => client.post('/alerts/silences', payload);
+export const expireAlertSilence = (id) => client.delete(`/alerts/silences/${id}`);
+export const getNotificationChannels = () => client.get('/notifications/channels');
+export const createNotificationChannel = (payload) => client.post('/notifications/channels', payload);
+export const updateNotificationChannel = (id, payload) => client.put(`/notifications/channels/${id}`, payload);
+export const deleteNotificationChannel = (id) => client.delete(`/notifications/channels/${id}`);
+export const testNotificationChannel = (id) => client.post(`/notifications/channels/${id}/test`);
+export const getNotificationDeliveries = (params = {}) => client.get('/notifications/deliveries', { params });
 export const getPublicStatus = () => client.get('/public/status');
+export const getStatusPage = () => client.get('/status-page');
+export const updateStatusPage = (payload) => client.put('/status-page', payload);
+export const getStatusIncidents = () => client.get('/status-page/incidents');
+export const createStatusIncident = (payload) => client.post('/status-page/incidents', payload);
+export const updateStatusIncident = (id, payload) => client.put(`/status-page/incidents/${id}`, payload);
+export const deleteStatusIncident = (id) => client.delete(`/status-page/incidents/${id}`);
 export const getPublicNodeNetwork = (id, range) => client.get(`/public/nodes/${id}/network`, { params: range ? { range } : {} });
 export const deleteNode = (id) => client.delete(`/nodes/${id}`);
+// Server-Sent Events: topics filters by topic or prefix (e.g. ['node', 'alert']); the browser resumes
+// with Last-Event-ID on reconnect. Returns null where EventSource is unavailable so callers keep polling.
+export const openEventStream = (topics = [], { publicStream = false } = {}) => {
+  if (typeof EventSource === 'undefined') {
+    return null;
+  }
+  const path = publicStream ? '/api/public/events/stream' : '/api/events/stream';
+  const query = topics.length ? `?topics=${encodeURIComponent(topics.join(','))}` : '';
+  return new EventSource(`${path}${query}`, { withCredentials: true });
+};
 export const login = (payload) => client.post('/login', payload);
+export const loginTwoFactor = (payload) => client.post('/login/2fa', payload);
+export const loginTwoFactorEnroll = (payload) => client.post('/login/2fa/enroll', payload);
 export const logout = () => client.post('/logout');
 export const getProfile = () => client.get('/me');
+export const getTwoFactorStatus = () => client.get('/me/2fa');
+export const enrollTwoFactor = () => client.post('/me/2fa/enroll');
+export const confirmTwoFactor = (payload) => client.post('/me/2fa/confirm', payload);
+export const disableTwoFactor = (payload) => client.post('/me/2fa/disable', payload);
+export const regenerateRecoveryCodes = (payload) => client.post('/me/2fa/recovery-codes', payload);
+export const getSecurityPolicy = () => client.get('/security/policy');
+export const updateSecurityPolicy = (payload) => client.put('/security/policy', payload);
+export const listSessions = () => client.get('/sessions');
+export const revokeSession = (id) => client.delete(`/sessions/${id}`);
+export const revokeAllSessions = (keepCurrent) => client.post('/sessions/revoke-all', null, { params: keepCurrent ? { keep_current: true } : {} });
+export const listAuditLogs = (params) => client.get('/audit', { params });
+export const exportAuditLogs = (params) => client.get('/audit/export', { params, responseType: 'blob' });
 
 export default client;
@@ -1,11 +1,13 @@
 import { reactive } from 'vue';
-import { login as apiLogin, logout as apiLogout, getProfile } from '../api';
+import { login as apiLogin, loginTwoFactor, loginTwoFactorEnroll, logout as apiLogout, getProfile } from '../api';
 
 const state = reactive({
   user: null,
   initialized: false,
   loading: false,
-  error: null
+  error: null,
+  mfa: null,
+  recoveryCodes: null
 });
 
 let sessionPromise = null;
@@ -31,12 +33,39 @@ export function useAuth() {
     await sessionPromise;
   };
 
+  // Enter the second-factor step of a login that password or SSO authentication started.
+  const startSecondFactor = async (token, stage) => {
+    state.mfa = { token, stage, enrollment: null };
+    if (stage === 'enroll') {
+      const enroll = await loginTwoFactorEnroll({ mfa_token: token });
+      state.mfa.enrollment = enroll.data?.data ?? null;
+    }
+  };
+
+  const resumeSecondFactor = async (token, stage) => {
+    state.loading = true;
+    state.error = null;
+    try {
+      await startSecondFactor(token, stage);
+    } catch (err) {
+      state.mfa = null;
+      state.error = err?.response?.data?.error || '两步验证已失效，请重新登录';
+    } finally {
+      state.loading = false;
+    }
+  };
+
   const login = async (username, password) => {
     state.loading = true;
     state.error = null;
     try {
       const res = await apiLogin({ username, password });
-      state.user = res.data?.data ?? { username };
+      const data = res.data?.data ?? { username };
+      if (data.mfa_required) {
+        await startSecondFactor(data.mfa_token, data.mfa_stage);
+        return false;
+      }
+      state.user = data;
       state.initialized = true;
       return true;
     } catch (err) {
@@ -48,6 +77,36 @@ export function useAuth() {
     }
   };
 
+  const verifySecondFactor = async (code, recoveryCode) => {
+    if (!state.mfa) {
+      return false;
+    }
+    state.loading = true;
+    state.error = null;
+    try {
+      const res = await loginTwoFactor({ mfa_token: state.mfa.token, code, recovery_code: recoveryCode });
+      const data = res.data?.data ?? null;
+      state.user = data;
+      state.recoveryCodes = data?.recovery_codes ?? null;
+      state.mfa = null;
+      state.initialized = true;
+      return true;
+    } catch (err) {
+      state.error = err?.response?.data?.error || '验证码校验失败';
+      if (err?.response?.status === 401 && /challenge/.test(state.error)) {
+        state.mfa = null;
+      }
+      return false;
+    } finally {
+      state.loading = false;
+    }
+  };
+
+  const cancelSecondFactor = () => {
+    state.mfa = null;
+    state.error = null;
+  };
+
   const logout = async () => {
     try {
       await apiLogout();
@@ -67,6 +126,9 @@ export function useAuth() {
     state,
     ensureSession,
     login,
+    verifySecondFactor,
+    resumeSecondFactor,
+    cancelSecondFactor,
     logout,
     clearError
   };
@@ -0,0 +1,334 @@
+// Package agent implements nebula-agent, the long-running node daemon that replaces the shell probe.
+// It pings peers concurrently, reads host metrics from /proc and uploads both to the controller,
+// spooling a bounded backlog to disk while the controller is unreachable.
+package agent
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// Upload retry policy within a single flush. Whatever still fails stays queued for the next cycle.
+const (
+	uploadAttempts = 3
+	uploadBackoff  = time.Second
+)
+
+// Agent runs the probe cycle.
+type Agent struct {
+	cfg       Config
+	client    *client
+	pinger    *Pinger
+	collector *collector
+	spool     *spool
+
+	peers        []Peer
+	configSync   configSyncState
+	versionCache nebulaVersionCache
+	debugKeys    *debugKeys
+	diagnostics  diagnosticRuns
+	updater      *updater
+	// flushMu keeps the shutdown flush from overlapping an interrupted cycle's flush.
+	flushMu sync.Mutex
+}
+
+// New constructs an Agent from cfg.
+func New(cfg Config) *Agent {
+	return &Agent{
+		cfg:       cfg,
+		client:    newClient(cfg),
+		pinger:    NewPinger(cfg.PingTimeout, cfg.PingCount, cfg.PingInterval),
+		collector: newCollector(),
+		spool:     openSpool(cfg.SpoolDir, cfg.QueueLimit),
+		peers:     cfg.Peers,
+		updater:   newUpdater(cfg.StateDir),
+	}
+}
+
+// Run executes a cycle immediately and then every Interval until ctx is cancelled. Queued data gets
+// one last delivery attempt on shutdown. It returns ErrRestart after a self-update or rollback changed
+// the binary on disk, and nil otherwise.
+func (a *Agent) Run(ctx context.Context) error {
+	log.Printf("agent: %s on node %d reporting to %s every %s", Version, a.cfg.NodeID, a.cfg.APIURL, a.cfg.Interval)
+	if err := a.startUpdateTrial(); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(a.cfg.Interval)
+	defer ticker.Stop()
+	if a.cfg.Diagnostics {
+		go a.runDiagnostics(ctx)
+	}
+
+	for {
+		err := a.RunOnce(ctx)
+		if ctx.Err() == nil {
+			if err := a.updateCycle(ctx, err); err != nil {
+				a.shutdown()
+				return err
+			}
+		}
+		select {
+		case <-ctx.Done():
+			a.shutdown()
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce syncs the nebula config, reports nebula's tunnels, collects one batch and uploads it together
+// with anything still queued.
+func (a *Agent) RunOnce(ctx context.Context) error {
+	if a.cfg.ConfigSync {
+		a.syncConfig(ctx)
+	}
+	if a.cfg.Hostmap {
+		a.reportHostmap(ctx)
+	}
+	b := &batch{Samples: a.probe(ctx)}
+	if !a.cfg.DisableStatus {
+		status, err := a.collector.Collect()
+		if err != nil {
+			log.Printf("agent: collect status: %v", err)
+		} else {
+			status.ID = newID()
+			status.Deployment = a.inspectDeployment(ctx)
+			status.Agent = a.agentInfo()
+			b.Status = &status
+		}
+	}
+	if ctx.Err() != nil {
+		// Interrupted mid-cycle; the partial results are not representative.
+		return ctx.Err()
+	}
+	if len(b.Samples) > 0 || b.Status != nil {
+		if err := a.spool.Push(b); err != nil {
+			log.Printf("agent: spool batch: %v", err)
+		}
+	}
+	// Replaying a large backlog must not delay the next probe cycle; the remainder goes next time.
+	flushCtx, cancel := context.WithTimeout(ctx, a.cfg.Interval/2)
+	defer cancel()
+	return a.flush(flushCtx)
+}
+
+func (a *Agent) shutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := a.flush(ctx); err != nil {
+		if a.spool.dir != "" {
+			log.Printf("agent: final upload failed, %d batches stay spooled: %v", a.spool.Len(), err)
+		} else {
+			log.Printf("agent: final upload failed, %d batches discarded: %v", a.spool.Len(), err)
+		}
+	}
+}
+
+// probe pings every target over the overlay and the underlay and runs its configured probes, with at
+// most Concurrency checks in flight.
+func (a *Agent) probe(ctx context.Context) []Sample {
+	peers := a.targets(ctx)
+	if len(peers) == 0 {
+		log.Printf("agent: no probe targets configured")
+		return nil
+	}
+
+	type check struct {
+		peer    Peer
+		address string
+		path    string
+		probe   *Probe
+	}
+	var checks []check
+	for _, peer := range peers {
+		if peer.Address != "" {
+			checks = append(checks, check{peer: peer, address: peer.Address})
+		}
+		if a.cfg.Underlay && peer.UnderlayAddress != "" {
+			checks = append(checks, check{peer: peer, address: peer.UnderlayAddress, path: PathUnderlay})
+		}
+		for i := range peer.Probes {
+			checks = append(checks, check{peer: peer, probe: &peer.Probes[i]})
+		}
+	}
+
+	samples := make([]Sample, len(checks))
+	sem := make(chan struct{}, a.cfg.Concurrency)
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			timestamp := time.Now().UTC().Format(time.RFC3339)
+			peer := check.peer
+			if check.probe == nil {
+				result, err := a.pinger.Ping(ctx, check.address)
+				if err != nil && ctx.Err() == nil {
+					log.Printf("agent: ping peer %d (%s): %v", peer.ID, check.address, err)
+				}
+				samples[i] = Sample{
+					ID:        newID(),
+					PeerID:    peer.ID,
+					LatencyMs: roundTo(result.LatencyMs, 3),
+					Success:   err == nil && result.Success(),
+					Timestamp: timestamp,
+					Path:      check.path,
+					Sent:      max(result.Sent, 1),
+					Received:  result.Received,
+					MinMs:     roundTo(result.MinMs, 3),
+					MaxMs:     roundTo(result.MaxMs, 3),
+					MdevMs:    roundTo(result.MdevMs, 3),
+					JitterMs:  roundTo(result.JitterMs, 3),
+				}
+				if err != nil {
+					// An echo that could not be sent still counts as lost.
+					samples[i].Received = 0
+				}
+				return
+			}
+			// Failed probes are expected results rather than agent errors, so they are reported, not logged.
+			result := a.runProbe(ctx, *check.probe)
+			sample := Sample{
+				ID:         newID(),
+				PeerID:     peer.ID,
+				LatencyMs:  roundTo(result.LatencyMs, 3),
+				Success:    result.Success,
+				Timestamp:  timestamp,
+				ProbeID:    check.probe.ID,
+				Type:       check.probe.Type,
+				StatusCode: result.StatusCode,
+			}
+			if result.Err != nil {
+				sample.Error = result.Err.Error()
+			}
+			samples[i] = sample
+		}()
+	}
+	wg.Wait()
+	return samples
+}
+
+// targets refreshes the peer list from the controller when dynamic targets are enabled, keeping the
+// last known list (initially NEBULA_PEERS) when the controller cannot be reached.
+func (a *Agent) targets(ctx context.Context) []Peer {
+	if !a.cfg.DynamicTargets {
+		return a.peers
+	}
+	peers, err := a.client.Targets(ctx)
+	if err != nil {
+		log.Printf("agent: fetch targets failed, using %d known peers: %v", len(a.peers), err)
+		return a.peers
+	}
+	if len(peers) > 0 {
+		a.peers = peers
+	}
+	return a.peers
+}
+
+// flush delivers spooled batches oldest first. It stops at the first upload that still fails after
+// the retries, leaving the rest for the next cycle. Uploads the controller rejects outright are
+// dropped, since resending them cannot help. Every sample and report carries an ID, so data resent
+// after an ambiguous failure is stored only once.
+func (a *Agent) flush(ctx context.Context) error {
+	a.flushMu.Lock()
+	defer a.flushMu.Unlock()
+	pending := a.spool.Pending()
+	total := len(pending)
+	for len(pending) > 0 {
+		// Samples of consecutive batches travel in one request so replaying a long backlog stays
+		// within the controller's per-host rate limit.
+		n, count := 1, len(pending[0].batch.Samples)
+		for n < len(pending) && count+len(pending[n].batch.Samples) <= maxSamplesPerRequest {
+			count += len(pending[n].batch.Samples)
+			n++
+		}
+		group := pending[:n]
+		err := a.deliver(ctx, group)
+		for _, entry := range group {
+			if len(entry.batch.Samples) == 0 && entry.batch.Status == nil {
+				a.spool.Remove(entry)
+			} else if err := a.spool.Update(entry); err != nil {
+				log.Printf("agent: update spooled batch: %v", err)
+			}
+		}
+		if err != nil {
+			if remaining := a.spool.Len(); remaining > 1 {
+				log.Printf("agent: upload failed, %d batches queued: %v", remaining, err)
+			} else {
+				log.Printf("agent: upload failed, will retry next cycle: %v", err)
+			}
+			return err
+		}
+		pending = pending[n:]
+	}
+	if total > 1 {
+		log.Printf("agent: backlog of %d batches delivered", total)
+	}
+	return nil
+}
+
+// deliver uploads the samples of group in one request and then each status report, clearing what the
+// controller accepted (or rejected for good) from the batches.
+func (a *Agent) deliver(ctx context.Context, group []spoolEntry) error {
+	var samples []Sample
+	for _, entry := range group {
+		samples = append(samples, entry.batch.Samples...)
+	}
+	if len(samples) > 0 {
+		err := retry(ctx, func() error { return a.client.PostSamples(ctx, samples) })
+		if err != nil && !errors.Is(err, errPermanent) {
+			return err
+		}
+		if err != nil {
+			log.Printf("agent: dropping %d samples: %v", len(samples), err)
+		}
+		for _, entry := range group {
+			entry.batch.Samples = nil
+		}
+	}
+	for _, entry := range group {
+		if entry.batch.Status == nil {
+			continue
+		}
+		err := retry(ctx, func() error { return a.client.PostStatus(ctx, *entry.batch.Status) })
+		if err != nil && !errors.Is(err, errPermanent) {
+			return err
+		}
+		if err != nil {
+			log.Printf("agent: dropping status report: %v", err)
+		}
+		entry.batch.Status = nil
+	}
+	return nil
+}
+
+// newID returns a random upload ID for server-side deduplication.
+func newID() string {
+	buf := make([]byte, 16)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func retry(ctx context.Context, fn func() error) error {
+	var err error
+	for attempt := 0; attempt < uploadAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(uploadBackoff * time.Duration(attempt)):
+			}
+		}
+		if err = fn(); err == nil || errors.Is(err, errPermanent) {
+			return err
+		}
+	}
+	return err
+}
@@ -0,0 +1,157 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// fakeController answers the sample and status uploads of node 7 with fixed responses and counts them.
+type fakeController struct {
+	samples, status response
+
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+type response struct {
+	code        int
+	body        string
+	contentType string
+}
+
+var accepted = response{code: http.StatusOK, body: `{"data":"ok"}`, contentType: "application/json"}
+
+func (f *fakeController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var resp response
+	switch r.URL.Path {
+	case "/api/nodes/7/network/samples":
+		resp = f.samples
+	case "/api/nodes/7/status":
+		resp = f.status
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	f.mu.Lock()
+	f.calls[r.URL.Path]++
+	f.mu.Unlock()
+	w.Header().Set("Content-Type", resp.contentType)
+	w.WriteHeader(resp.code)
+	_, _ = w.Write([]byte(resp.body))
+}
+
+func (f *fakeController) count(path string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[path]
+}
+
+func newTestAgent(t *testing.T, controller *fakeController) *Agent {
+	t.Helper()
+	controller.calls = make(map[string]int)
+	srv := httptest.NewServer(controller)
+	t.Cleanup(srv.Close)
+	a := &Agent{
+		client: &client{baseURL: srv.URL, nodeID: 7, token: "token", http: srv.Client()},
+		spool:  openSpool(t.TempDir(), 10),
+	}
+	for _, id := range []string{"r1", "r2"} {
+		b := &batch{Samples: []Sample{{ID: "s-" + id, PeerID: 2, Success: true}}, Status: &Status{ID: id}}
+		if err := a.spool.Push(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return a
+}
+
+func TestFlushDeliversBacklog(t *testing.T) {
+	controller := &fakeController{samples: accepted, status: accepted}
+	a := newTestAgent(t, controller)
+	if err := a.flush(context.Background()); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if a.spool.Len() != 0 {
+		t.Fatalf("%d batches left after a successful flush", a.spool.Len())
+	}
+	// Samples of both batches share one request; status reports go one by one.
+	if n := controller.count("/api/nodes/7/network/samples"); n != 1 {
+		t.Errorf("sample uploads = %d, want 1", n)
+	}
+	if n := controller.count("/api/nodes/7/status"); n != 2 {
+		t.Errorf("status uploads = %d, want 2", n)
+	}
+}
+
+func TestFlushDropsRejectedUploads(t *testing.T) {
+	tests := map[string]response{
+		"malformed":    {code: http.StatusBadRequest, body: `{"error":"invalid sample"}`, contentType: "application/json"},
+		"deleted node": {code: http.StatusNotFound, body: `{"error":"node 7 not found"}`, contentType: "application/json"},
+	}
+	for name, rejected := range tests {
+		t.Run(name, func(t *testing.T) {
+			controller := &fakeController{samples: rejected, status: rejected}
+			a := newTestAgent(t, controller)
+			if err := a.flush(context.Background()); err != nil {
+				t.Fatalf("flush: %v", err)
+			}
+			if a.spool.Len() != 0 {
+				t.Fatalf("%d rejected batches stayed spooled", a.spool.Len())
+			}
+			// A permanent rejection is not retried.
+			if n := controller.count("/api/nodes/7/network/samples"); n != 1 {
+				t.Errorf("sample uploads = %d, want 1", n)
+			}
+		})
+	}
+}
+
+func TestFlushKeepsDataOnTransientFailure(t *testing.T) {
+	tests := map[string]struct {
+		samples, status response
+		wantSamples     bool
+	}{
+		"server error": {
+			samples:     response{code: http.StatusBadGateway, body: `{"error":"upstream"}`, contentType: "application/json"},
+			status:      accepted,
+			wantSamples: true,
+		},
+		// A 404 without the controller's JSON body comes from a proxy or a wrong base URL.
+		"proxy 404": {
+			samples:     response{code: http.StatusNotFound, body: "404 page not found", contentType: "text/plain"},
+			status:      accepted,
+			wantSamples: true,
+		},
+		// The samples went through, so only the status reports are left to resend.
+		"partial delivery": {
+			samples: accepted,
+			status:  response{code: http.StatusServiceUnavailable, body: "unavailable", contentType: "text/plain"},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			controller := &fakeController{samples: tt.samples, status: tt.status}
+			a := newTestAgent(t, controller)
+			if err := a.flush(context.Background()); err == nil {
+				t.Fatal("flush succeeded against a failing controller")
+			}
+
+			// Reopening reads what was written back to disk.
+			pending := openSpool(a.spool.dir, 10).Pending()
+			if len(pending) != 2 {
+				t.Fatalf("%d batches spooled, want both", len(pending))
+			}
+			for _, entry := range pending {
+				if hasSamples := len(entry.batch.Samples) > 0; hasSamples != tt.wantSamples {
+					t.Errorf("batch %s kept samples = %v, want %v", entry.name, hasSamples, tt.wantSamples)
+				}
+				if entry.batch.Status == nil {
+					t.Errorf("batch %s lost its undelivered status report", entry.name)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,312 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// maxSamplesPerRequest keeps a backlog flush from producing one huge request body.
+	maxSamplesPerRequest = 2000
+	// maxBundleSize bounds a downloaded node bundle; real ones are a few kilobytes.
+	maxBundleSize = 8 << 20
+)
+
+// Sample is one latency measurement, as accepted by POST /api/nodes/:id/network/samples. ProbeID is
+// zero for the built-in ping.
+type Sample struct {
+	ID         string  `json:"id"`
+	PeerID     uint    `json:"peer_id"`
+	LatencyMs  float64 `json:"latency_ms"`
+	Success    bool    `json:"success"`
+	Timestamp  string  `json:"timestamp"`
+	Path       string  `json:"path,omitempty"`
+	Sent       int     `json:"sent,omitempty"`
+	Received   int     `json:"received,omitempty"`
+	MinMs      float64 `json:"min_ms,omitempty"`
+	MaxMs      float64 `json:"max_ms,omitempty"`
+	MdevMs     float64 `json:"mdev_ms,omitempty"`
+	JitterMs   float64 `json:"jitter_ms,omitempty"`
+	ProbeID    uint    `json:"probe_id,omitempty"`
+	Type       string  `json:"type,omitempty"`
+	StatusCode int     `json:"status_code,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// PathUnderlay marks pings of a peer's public address; overlay pings carry no path.
+const PathUnderlay = "underlay"
+
+// Status is a host metrics snapshot, as accepted by POST /api/nodes/:id/status.
+type Status struct {
+	CPUUsage    float64 `json:"cpu_usage"`
+	Load1       float64 `json:"load1"`
+	Load5       float64 `json:"load5"`
+	Load15      float64 `json:"load15"`
+	MemoryTotal uint64  `json:"memory_total"`
+	MemoryUsed  uint64  `json:"memory_used"`
+	SwapTotal   uint64  `json:"swap_total"`
+	SwapUsed    uint64  `json:"swap_used"`
+	DiskTotal   uint64  `json:"disk_total"`
+	DiskUsed    uint64  `json:"disk_used"`
+	NetRxBytes  uint64  `json:"net_rx_bytes"`
+	NetTxBytes  uint64  `json:"net_tx_bytes"`
+	Processes   int     `json:"processes"`
+	Uptime      uint64  `json:"uptime"`
+	ReportedAt  string  `json:"reported_at"`
+	ID          string  `json:"id"`
+	// Deployment is omitted by agents that cannot inspect the nebula installation.
+	Deployment *Deployment `json:"deployment,omitempty"`
+	// Agent identifies the running build for the controller's fleet view.
+	Agent *AgentInfo `json:"agent,omitempty"`
+}
+
+// errPermanent marks responses that will not succeed on retry: a payload the controller refuses or a
+// node it no longer knows. Anything else, including a rotated token, keeps spooled data queued.
+var errPermanent = errors.New("rejected by controller")
+
+// client talks to the controller API on behalf of one node.
+type client struct {
+	baseURL string
+	nodeID  uint
+	token   string
+	http    *http.Client
+}
+
+func newClient(cfg Config) *client {
+	return &client{
+		baseURL: cfg.APIURL,
+		nodeID:  cfg.NodeID,
+		token:   cfg.Token,
+		http:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Targets fetches the node's current probe targets.
+func (c *client) Targets(ctx context.Context) ([]Peer, error) {
+	var resp struct {
+		Data []struct {
+			PeerID          uint    `json:"peer_id"`
+			Address         string  `json:"address"`
+			OverlayAddress  string  `json:"overlay_address"`
+			UnderlayAddress string  `json:"underlay_address"`
+			Probes          []Probe `json:"probes"`
+		} `json:"data"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/network/targets", nil, &resp); err != nil {
+		return nil, err
+	}
+	peers := make([]Peer, 0, len(resp.Data))
+	for _, target := range resp.Data {
+		address := strings.TrimSpace(target.Address)
+		underlay := strings.TrimSpace(target.UnderlayAddress)
+		if target.UnderlayAddress != "" || target.OverlayAddress != "" {
+			// Address falls back to the public address for peers without an overlay address.
+			address = strings.TrimSpace(target.OverlayAddress)
+		}
+		if target.PeerID == 0 || (address == "" && underlay == "") {
+			continue
+		}
+		peers = append(peers, Peer{ID: target.PeerID, Address: address, UnderlayAddress: underlay, Probes: target.Probes})
+	}
+	return peers, nil
+}
+
+// PostSamples uploads latency samples, split into several requests when there are many.
+func (c *client) PostSamples(ctx context.Context, samples []Sample) error {
+	for start := 0; start < len(samples); start += maxSamplesPerRequest {
+		end := min(start+maxSamplesPerRequest, len(samples))
+		body := map[string]any{"samples": samples[start:end]}
+		if err := c.do(ctx, http.MethodPost, "/network/samples", body, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PostStatus uploads one host metrics snapshot.
+func (c *client) PostStatus(ctx context.Context, status Status) error {
+	return c.do(ctx, http.MethodPost, "/status", status, nil)
+}
+
+// ConfigVersion asks whether the node's config differs from current (a version from
+// utils.ConfigVersion, empty when unknown). It returns the controller's version and whether it differs.
+func (c *client) ConfigVersion(ctx context.Context, current string) (string, bool, error) {
+	header := http.Header{}
+	if current != "" {
+		header.Set("If-None-Match", `"`+current+`"`)
+	}
+	resp, err := c.send(ctx, http.MethodGet, "/config/version", nil, header)
+	if err != nil {
+		return "", false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return current, false, nil
+	}
+	var body struct {
+		Data struct {
+			Version string `json:"version"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", false, err
+	}
+	return body.Data.Version, body.Data.Version != current, nil
+}
+
+// Bundle downloads the node's tar.gz bundle with config.yml, certificate, key and CA.
+func (c *client) Bundle(ctx context.Context) ([]byte, error) {
+	resp, err := c.send(ctx, http.MethodGet, "/bundle", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(io.LimitReader(resp.Body, maxBundleSize))
+}
+
+// ReportConfig tells the controller which config version the node runs and why a sync failed.
+func (c *client) ReportConfig(ctx context.Context, version, applyError string) error {
+	return c.do(ctx, http.MethodPost, "/config/applied", map[string]string{"version": version, "error": applyError}, nil)
+}
+
+// PostHostmap uploads the tunnel state scraped from nebula.
+func (c *client) PostHostmap(ctx context.Context, report HostmapReport) error {
+	return c.do(ctx, http.MethodPost, "/hostmap", report, nil)
+}
+
+// PendingDiagnostics fetches the diagnostic jobs waiting for the node.
+func (c *client) PendingDiagnostics(ctx context.Context) ([]DiagnosticTask, error) {
+	var resp struct {
+		Data []DiagnosticTask `json:"data"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/diagnostics/pending", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
+
+// StartDiagnostic claims the node's part of a job; port is the listener of a throughput server.
+func (c *client) StartDiagnostic(ctx context.Context, jobID uint, role string, port int) error {
+	body := map[string]any{"role": role}
+	if port != 0 {
+		body["port"] = port
+	}
+	return c.do(ctx, http.MethodPost, fmt.Sprintf("/diagnostics/%d/start", jobID), body, nil)
+}
+
+// PostDiagnosticResult uploads the outcome of a job.
+func (c *client) PostDiagnosticResult(ctx context.Context, jobID uint, result DiagnosticResult) error {
+	return c.do(ctx, http.MethodPost, fmt.Sprintf("/diagnostics/%d/result", jobID), result, nil)
+}
+
+// AgentRelease fetches the controller's current agent build for platform.
+func (c *client) AgentRelease(ctx context.Context, platform string) (Release, error) {
+	var body struct {
+		Data Release `json:"data"`
+	}
+	resp, err := c.sendAPI(ctx, http.MethodGet, "/agent/release/"+platform, nil, nil)
+	if err != nil {
+		return Release{}, err
+	}
+	defer resp.Body.Close()
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	return body.Data, err
+}
+
+// DownloadAgent writes the agent build for platform to w, stopping after limit bytes.
+func (c *client) DownloadAgent(ctx context.Context, platform string, w io.Writer, limit int64) error {
+	resp, err := c.sendAPI(ctx, http.MethodGet, "/agent/binary/"+platform, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, io.LimitReader(resp.Body, limit))
+	return err
+}
+
+func (c *client) do(ctx context.Context, method, path string, body, out any) error {
+	resp, err := c.send(ctx, method, path, body, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// send performs a request against the node's API.
+func (c *client) send(ctx context.Context, method, path string, body any, header http.Header) (*http.Response, error) {
+	return c.sendAPI(ctx, method, fmt.Sprintf("/nodes/%d%s", c.nodeID, path), body, header)
+}
+
+// sendAPI performs a request against the controller API at path below /api. Error statuses are turned
+// into errors (wrapping errPermanent when retrying cannot help); the caller must close the body of the
+// returned response.
+func (c *client) sendAPI(ctx context.Context, method, path string, body any, header http.Header) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(payload)
+	}
+	url := c.baseURL + "/api" + path
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < http.StatusBadRequest {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	var apiErr struct {
+		Error string `json:"error"`
+	}
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	message := strings.TrimSpace(string(raw))
+	fromController := json.Unmarshal(raw, &apiErr) == nil && apiErr.Error != ""
+	if fromController {
+		message = apiErr.Error
+	}
+	err = fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, message)
+	switch resp.StatusCode {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return nil, fmt.Errorf("%w: %w", errPermanent, err)
+	case http.StatusNotFound:
+		// Only the controller's own answer means the node is gone; a proxy or a wrong base URL also
+		// answers 404, without the JSON error body.
+		if fromController {
+			return nil, fmt.Errorf("%w: %w", errPermanent, err)
+		}
+	}
+	return nil, err
+}
+
+func roundTo(val float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	return math.Round(val*scale) / scale
+}
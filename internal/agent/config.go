@@ -0,0 +1,208 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// DefaultConfigFile is the env file written by the install script.
+const DefaultConfigFile = "/etc/nebula/nebula-network-agent.env"
+
+// DefaultStateDir matches the StateDirectory of the nebula-agent systemd unit.
+const DefaultStateDir = "/var/lib/nebula-agent"
+
+// DefaultSpoolDir is the default retry queue inside the state directory.
+const DefaultSpoolDir = DefaultStateDir + "/spool"
+
+// Config holds the agent settings. It is read from the same variables as the shell probe.
+type Config struct {
+	APIURL         string
+	NodeID         uint
+	Token          string
+	Peers          []Peer
+	DynamicTargets bool
+	DisableStatus  bool
+	PingTimeout    time.Duration
+	PingCount      int
+	PingInterval   time.Duration
+	Interval       time.Duration
+	Concurrency    int
+	// QueueLimit is the number of collection cycles kept for retry while the controller is unreachable.
+	QueueLimit int
+	// SpoolDir holds the retry queue on disk; empty keeps it in memory only.
+	SpoolDir string
+	// ConfigSync enables pulling config changes from the controller and applying them to NebulaDir.
+	ConfigSync    bool
+	NebulaDir     string
+	NebulaBinary  string
+	NebulaService string
+	// ReloadMode is ReloadHUP or ReloadRestart.
+	ReloadMode string
+	// Hostmap enables scraping nebula's debug sshd and stats endpoints for tunnel state. The SSH keys
+	// for nebula's sshd are kept in StateDir.
+	Hostmap  bool
+	StateDir string
+	// Underlay enables pinging peers' public addresses next to their overlay addresses.
+	Underlay bool
+	// Diagnostics enables polling the controller for on-demand diagnostic jobs every JobInterval.
+	// ThroughputPort is where the agent accepts a peer's throughput test while one is scheduled.
+	Diagnostics    bool
+	JobInterval    time.Duration
+	ThroughputPort int
+	// AutoUpdate enables replacing the agent binary with the controller's release, checked every
+	// UpdateInterval. The update state is kept in StateDir.
+	AutoUpdate     bool
+	UpdateInterval time.Duration
+}
+
+// How nebula is told about an applied config. A HUP reloads lighthouses, firewall rules and
+// certificates in place; listen port or tun changes need a restart.
+const (
+	ReloadHUP     = "hup"
+	ReloadRestart = "restart"
+)
+
+// Peer is a probe target. Address is pinged through the overlay and UnderlayAddress, the peer's public
+// address, over the internet. UnderlayAddress and Probes only come with targets fetched from the
+// controller.
+type Peer struct {
+	ID              uint
+	Address         string
+	UnderlayAddress string
+	Probes          []Probe
+}
+
+// LoadConfig reads the env file at path (when it exists) on top of the process environment. As with
+// the shell probe, which sources the file, values in the file win.
+func LoadConfig(path string) (Config, error) {
+	file := map[string]string{}
+	if path != "" {
+		values, err := godotenv.Read(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return Config{}, fmt.Errorf("read %s: %w", path, err)
+		}
+		if values != nil {
+			file = values
+		}
+	}
+	lookup := func(key string) (string, bool) {
+		if val, ok := file[key]; ok {
+			return strings.TrimSpace(val), true
+		}
+		val, ok := os.LookupEnv(key)
+		return strings.TrimSpace(val), ok
+	}
+	get := func(key string) string {
+		val, _ := lookup(key)
+		return val
+	}
+
+	cfg := Config{
+		APIURL:         strings.TrimRight(fallback(get("NEBULA_MANAGER_API"), "http://127.0.0.1:8080"), "/"),
+		Token:          get("NEBULA_ACCESS_TOKEN"),
+		DynamicTargets: get("NEBULA_DYNAMIC_TARGETS") != "0",
+		DisableStatus:  get("NEBULA_DISABLE_STATUS") == "1",
+		PingTimeout:    secondsOrDuration(get("NEBULA_AGENT_PING_TIMEOUT"), 3*time.Second),
+		PingCount:      positiveInt(get("NEBULA_AGENT_PING_COUNT"), 5),
+		PingInterval:   secondsOrDuration(get("NEBULA_AGENT_PING_INTERVAL"), 200*time.Millisecond),
+		Interval:       secondsOrDuration(get("NEBULA_AGENT_INTERVAL"), time.Minute),
+		Concurrency:    positiveInt(get("NEBULA_AGENT_CONCURRENCY"), 16),
+		QueueLimit:     positiveInt(get("NEBULA_AGENT_QUEUE_LIMIT"), 1440),
+		SpoolDir:       DefaultSpoolDir,
+		ConfigSync:     get("NEBULA_CONFIG_SYNC") != "0",
+		NebulaDir:      fallback(get("NEBULA_DIR"), "/etc/nebula"),
+		NebulaBinary:   fallback(get("NEBULA_BINARY"), "/usr/local/bin/nebula"),
+		NebulaService:  fallback(get("NEBULA_SERVICE"), "nebula.service"),
+		ReloadMode:     ReloadHUP,
+		Hostmap:        get("NEBULA_AGENT_HOSTMAP") != "0",
+		StateDir:       fallback(get("NEBULA_AGENT_STATE_DIR"), DefaultStateDir),
+		Underlay:       get("NEBULA_AGENT_UNDERLAY") != "0",
+		Diagnostics:    get("NEBULA_AGENT_DIAGNOSTICS") != "0",
+		JobInterval:    secondsOrDuration(get("NEBULA_AGENT_JOB_INTERVAL"), 15*time.Second),
+		ThroughputPort: positiveInt(get("NEBULA_AGENT_THROUGHPUT_PORT"), 4243),
+		AutoUpdate:     get("NEBULA_AGENT_AUTO_UPDATE") != "0",
+		UpdateInterval: secondsOrDuration(get("NEBULA_AGENT_UPDATE_INTERVAL"), time.Hour),
+	}
+	switch mode := strings.ToLower(get("NEBULA_RELOAD_MODE")); mode {
+	case "", ReloadHUP:
+	case ReloadRestart:
+		cfg.ReloadMode = mode
+	default:
+		return Config{}, fmt.Errorf("invalid NEBULA_RELOAD_MODE %q (use %s or %s)", mode, ReloadHUP, ReloadRestart)
+	}
+	if dir, ok := lookup("NEBULA_AGENT_SPOOL_DIR"); ok {
+		cfg.SpoolDir = dir
+	}
+
+	nodeID := get("NEBULA_NODE_ID")
+	if nodeID == "" {
+		return Config{}, errors.New("NEBULA_NODE_ID is required (the node's ID in the console)")
+	}
+	id, err := strconv.ParseUint(nodeID, 10, 64)
+	if err != nil || id == 0 {
+		return Config{}, fmt.Errorf("invalid NEBULA_NODE_ID %q", nodeID)
+	}
+	cfg.NodeID = uint(id)
+	if cfg.Token == "" {
+		return Config{}, errors.New("NEBULA_ACCESS_TOKEN is required")
+	}
+	cfg.Peers = ParsePeers(get("NEBULA_PEERS"))
+	return cfg, nil
+}
+
+// ParsePeers parses the "id:address,id:address" list used by NEBULA_PEERS. Invalid entries are skipped.
+func ParsePeers(raw string) []Peer {
+	peers := make([]Peer, 0)
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.Join(strings.Fields(entry), "")
+		idText, address, ok := strings.Cut(entry, ":")
+		if !ok || address == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(idText, 10, 64)
+		if err != nil || id == 0 {
+			continue
+		}
+		peers = append(peers, Peer{ID: uint(id), Address: address})
+	}
+	return peers
+}
+
+func fallback(value, defaultVal string) string {
+	if value == "" {
+		return defaultVal
+	}
+	return value
+}
+
+func positiveInt(val string, defaultVal int) int {
+	parsed, err := strconv.Atoi(val)
+	if err != nil || parsed <= 0 {
+		return defaultVal
+	}
+	return parsed
+}
+
+// secondsOrDuration accepts bare seconds ("3", "0.5") as the shell probe did, or Go durations ("90s").
+func secondsOrDuration(val string, defaultVal time.Duration) time.Duration {
+	if val == "" {
+		return defaultVal
+	}
+	if seconds, err := strconv.ParseFloat(val, 64); err == nil {
+		if seconds <= 0 {
+			return defaultVal
+		}
+		return time.Duration(seconds * float64(time.Second))
+	}
+	parsed, err := time.ParseDuration(val)
+	if err != nil || parsed <= 0 {
+		return defaultVal
+	}
+	return parsed
+}
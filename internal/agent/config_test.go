@@ -0,0 +1,51 @@
+package agent
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParsePeers(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want []Peer
+	}{
+		{"empty", "", []Peer{}},
+		{"single", "2:10.0.0.2", []Peer{{ID: 2, Address: "10.0.0.2"}}},
+		{"spaces", " 2 : 10.0.0.2 , 3:host.example ", []Peer{{ID: 2, Address: "10.0.0.2"}, {ID: 3, Address: "host.example"}}},
+		{"ipv6 keeps the rest after the first colon", "4:fd00::4", []Peer{{ID: 4, Address: "fd00::4"}}},
+		{"skips invalid entries", "x:10.0.0.1,0:10.0.0.2,5:,6,,7:10.0.0.7", []Peer{{ID: 7, Address: "10.0.0.7"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParsePeers(tt.raw); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("ParsePeers(%q) = %+v, want %+v", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSecondsOrDuration(t *testing.T) {
+	const def = 5 * time.Second
+	tests := []struct {
+		val  string
+		want time.Duration
+	}{
+		{"", def},
+		{"3", 3 * time.Second},
+		{"0.5", 500 * time.Millisecond},
+		{"90s", 90 * time.Second},
+		{"1m30s", 90 * time.Second},
+		{"0", def},
+		{"-2", def},
+		{"-1s", def},
+		{"soon", def},
+	}
+	for _, tt := range tests {
+		if got := secondsOrDuration(tt.val, def); got != tt.want {
+			t.Errorf("secondsOrDuration(%q) = %s, want %s", tt.val, got, tt.want)
+		}
+	}
+}
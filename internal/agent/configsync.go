@@ -0,0 +1,305 @@
+package agent
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"nebula_manager/internal/utils"
+)
+
+const (
+	configFile = "config.yml"
+	caFile     = "ca.crt"
+	// backupDirName holds the files replaced by the last applied config, for rollback.
+	backupDirName = ".backup"
+	// reloadSettle is how long nebula gets to come back after a reload before it is checked.
+	reloadSettle = 3 * time.Second
+)
+
+// configSyncState remembers what was reported so the controller is not told the same thing every cycle.
+type configSyncState struct {
+	reportedVersion string
+	reportedError   string
+	reported        bool
+	// failedVersion is a controller version that could not be applied; it is not retried until the
+	// controller's version changes again.
+	failedVersion string
+}
+
+// syncConfig applies the controller's config when it differs from the one on disk and reports the
+// version the node ends up running.
+func (a *Agent) syncConfig(ctx context.Context) {
+	local, err := localConfigVersion(a.cfg.NebulaDir)
+	if err != nil {
+		log.Printf("agent: read local config: %v", err)
+	}
+	remote, changed, err := a.client.ConfigVersion(ctx, local)
+	if err != nil {
+		log.Printf("agent: check config version: %v", err)
+		return
+	}
+	if !changed {
+		a.reportConfig(ctx, local, "")
+		return
+	}
+	if remote == a.configSync.failedVersion {
+		return
+	}
+
+	applied, err := a.applyConfig(ctx)
+	if err != nil {
+		log.Printf("agent: apply config %s: %v", shortVersion(remote), err)
+		a.configSync.failedVersion = remote
+		a.reportConfig(ctx, local, err.Error())
+		return
+	}
+	a.configSync.failedVersion = ""
+	log.Printf("agent: applied config %s", shortVersion(applied))
+	a.reportConfig(ctx, applied, "")
+}
+
+func (a *Agent) reportConfig(ctx context.Context, version, applyError string) {
+	state := &a.configSync
+	if version == "" || (state.reported && state.reportedVersion == version && state.reportedError == applyError) {
+		return
+	}
+	if err := a.client.ReportConfig(ctx, version, applyError); err != nil {
+		log.Printf("agent: report config version: %v", err)
+		return
+	}
+	state.reported, state.reportedVersion, state.reportedError = true, version, applyError
+}
+
+// applyConfig downloads the node bundle, validates it, swaps it into NebulaDir and reloads nebula.
+// When nebula does not survive the reload the previous files are restored. It returns the version of
+// the applied files, which may be newer than the one advertised if the config changed meanwhile.
+func (a *Agent) applyConfig(ctx context.Context) (string, error) {
+	dir := a.cfg.NebulaDir
+	bundle, err := a.client.Bundle(ctx)
+	if err != nil {
+		return "", fmt.Errorf("download bundle: %w", err)
+	}
+
+	// Staging inside dir keeps the final renames on one filesystem, which makes them atomic.
+	staging, err := os.MkdirTemp(dir, ".sync-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(staging)
+
+	names, err := extractBundle(bundle, staging)
+	if err != nil {
+		return "", fmt.Errorf("extract bundle: %w", err)
+	}
+	version, err := localConfigVersion(staging)
+	if err != nil || version == "" {
+		return "", errors.New("bundle lacks config.yml or ca.crt")
+	}
+	if err := a.validateConfig(ctx, staging); err != nil {
+		return "", err
+	}
+
+	backup := filepath.Join(dir, backupDirName)
+	if err := backupFiles(dir, backup, names); err != nil {
+		return "", fmt.Errorf("back up current config: %w", err)
+	}
+	if err := installFiles(staging, dir, names); err != nil {
+		restoreFiles(backup, dir, names)
+		return "", fmt.Errorf("install files: %w", err)
+	}
+	if err := a.reloadNebula(ctx); err != nil {
+		restoreFiles(backup, dir, names)
+		if rollbackErr := a.reloadNebula(ctx); rollbackErr != nil {
+			log.Printf("agent: reload after rollback: %v", rollbackErr)
+		}
+		return "", fmt.Errorf("reload nebula (previous config restored): %w", err)
+	}
+	return version, nil
+}
+
+// validateConfig runs `nebula -test` on the staged config. Relative paths in config.yml resolve
+// against the working directory, as they do for the nebula service.
+func (a *Agent) validateConfig(ctx context.Context, staging string) error {
+	if _, err := os.Stat(a.cfg.NebulaBinary); err != nil {
+		log.Printf("agent: %s not found, applying config without validation", a.cfg.NebulaBinary)
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, a.cfg.NebulaBinary, "-test", "-config", filepath.Join(staging, configFile))
+	cmd.Dir = staging
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("config rejected by nebula -test: %v: %s", err, lastLine(output))
+	}
+	return nil
+}
+
+// reloadNebula signals or restarts the nebula service and checks that it is still running afterwards.
+func (a *Agent) reloadNebula(ctx context.Context) error {
+	args := []string{"kill", "--kill-who=main", "--signal=SIGHUP", a.cfg.NebulaService}
+	if a.cfg.ReloadMode == ReloadRestart {
+		args = []string{"restart", a.cfg.NebulaService}
+	}
+	if output, err := exec.CommandContext(ctx, "systemctl", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("systemctl %s: %v: %s", args[0], err, lastLine(output))
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(reloadSettle):
+	}
+	if err := exec.CommandContext(ctx, "systemctl", "is-active", "--quiet", a.cfg.NebulaService).Run(); err != nil {
+		return fmt.Errorf("%s is not active after reload", a.cfg.NebulaService)
+	}
+	return nil
+}
+
+// localConfigVersion returns the version of the config in dir, or "" when it has none.
+func localConfigVersion(dir string) (string, error) {
+	config, err := os.ReadFile(filepath.Join(dir, configFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	ca, err := os.ReadFile(filepath.Join(dir, caFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return utils.ConfigVersion(config, ca), nil
+}
+
+// extractBundle writes the bundle's flat list of regular files into dir and returns their names.
+func extractBundle(bundle []byte, dir string) ([]string, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(bundle))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	var names []string
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := header.Name
+		if header.Typeflag != tar.TypeReg || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+			return nil, fmt.Errorf("unexpected entry %q", name)
+		}
+		content, err := io.ReadAll(io.LimitReader(tr, maxBundleSize))
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), content, bundleFileMode(name)); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// bundleFileMode matches the permissions used by the install script.
+func bundleFileMode(name string) os.FileMode {
+	if name == configFile {
+		return 0o640
+	}
+	return 0o600
+}
+
+// installOrder puts config.yml last, so nebula never reads a new config next to old certificates.
+func installOrder(names []string) []string {
+	ordered := make([]string, 0, len(names))
+	for _, name := range names {
+		if name != configFile {
+			ordered = append(ordered, name)
+		}
+	}
+	for _, name := range names {
+		if name == configFile {
+			ordered = append(ordered, name)
+		}
+	}
+	return ordered
+}
+
+func backupFiles(dir, backup string, names []string) error {
+	if err := os.MkdirAll(backup, 0o700); err != nil {
+		return err
+	}
+	for _, name := range names {
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			// Nothing to restore; a rollback removes the new file instead.
+			_ = os.Remove(filepath.Join(backup, name))
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(backup, name), content, bundleFileMode(name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func installFiles(staging, dir string, names []string) error {
+	for _, name := range installOrder(names) {
+		if err := os.Rename(filepath.Join(staging, name), filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func restoreFiles(backup, dir string, names []string) {
+	for _, name := range installOrder(names) {
+		target := filepath.Join(dir, name)
+		content, err := os.ReadFile(filepath.Join(backup, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			_ = os.Remove(target)
+			continue
+		}
+		if err == nil {
+			err = os.WriteFile(target+".tmp", content, bundleFileMode(name))
+		}
+		if err == nil {
+			err = os.Rename(target+".tmp", target)
+		}
+		if err != nil {
+			log.Printf("agent: restore %s: %v", name, err)
+		}
+	}
+}
+
+func lastLine(output []byte) string {
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+func shortVersion(version string) string {
+	if len(version) > 12 {
+		return version[:12]
+	}
+	return version
+}
@@ -0,0 +1,109 @@
+package agent
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type bundleEntry struct {
+	name     string
+	typeflag byte
+	content  string
+}
+
+func buildBundle(t *testing.T, entries ...bundleEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Typeflag: entry.typeflag, Mode: 0o600, Size: int64(len(entry.content))}
+		if entry.typeflag != tar.TypeReg {
+			header.Size = 0
+		}
+		if entry.typeflag == tar.TypeSymlink {
+			header.Linkname = "/etc/passwd"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if entry.typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte(entry.content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtractBundleWritesFlatFiles(t *testing.T) {
+	dir := t.TempDir()
+	bundle := buildBundle(t,
+		bundleEntry{name: caFile, typeflag: tar.TypeReg, content: "ca"},
+		bundleEntry{name: configFile, typeflag: tar.TypeReg, content: "pki: {}"},
+	)
+	names, err := extractBundle(bundle, dir)
+	if err != nil {
+		t.Fatalf("extractBundle: %v", err)
+	}
+	if want := []string{caFile, configFile}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("names = %v, want %v", names, want)
+	}
+	content, err := os.ReadFile(filepath.Join(dir, configFile))
+	if err != nil || string(content) != "pki: {}" {
+		t.Fatalf("config.yml = %q, %v", content, err)
+	}
+	info, err := os.Stat(filepath.Join(dir, configFile))
+	if err != nil || info.Mode().Perm() != 0o640 {
+		t.Fatalf("config.yml mode = %v, %v", info.Mode().Perm(), err)
+	}
+}
+
+func TestExtractBundleRejectsUnsafeEntries(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry bundleEntry
+	}{
+		{"parent path", bundleEntry{name: "../config.yml", typeflag: tar.TypeReg, content: "x"}},
+		{"absolute path", bundleEntry{name: "/etc/nebula/config.yml", typeflag: tar.TypeReg, content: "x"}},
+		{"nested path", bundleEntry{name: "certs/host.key", typeflag: tar.TypeReg, content: "x"}},
+		{"dot file", bundleEntry{name: ".bashrc", typeflag: tar.TypeReg, content: "x"}},
+		{"dot entry", bundleEntry{name: ".", typeflag: tar.TypeReg, content: "x"}},
+		{"dot dot entry", bundleEntry{name: "..", typeflag: tar.TypeReg, content: "x"}},
+		{"directory", bundleEntry{name: "certs", typeflag: tar.TypeDir}},
+		{"symlink", bundleEntry{name: "host.key", typeflag: tar.TypeSymlink}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parent := t.TempDir()
+			dir := filepath.Join(parent, "nebula")
+			if err := os.Mkdir(dir, 0o700); err != nil {
+				t.Fatal(err)
+			}
+			bundle := buildBundle(t, tt.entry)
+			if _, err := extractBundle(bundle, dir); err == nil {
+				t.Fatalf("extractBundle accepted %q", tt.entry.name)
+			}
+			for _, d := range []string{parent, dir} {
+				entries, err := os.ReadDir(d)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if d == parent && len(entries) != 1 || d == dir && len(entries) != 0 {
+					t.Fatalf("%s was written to: %v", d, entries)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"nebula_manager/internal/utils"
+)
+
+// Deployment fingerprints the nebula files and binary on the host, so the controller can detect nodes
+// that drifted from the artifacts it renders. Hashes are empty for missing files.
+type Deployment struct {
+	ConfigHash    string `json:"config_hash"`
+	CertHash      string `json:"cert_hash"`
+	CAHash        string `json:"ca_hash"`
+	NebulaVersion string `json:"nebula_version"`
+}
+
+// nebulaVersionCache avoids running `nebula -version` every cycle while the binary is unchanged.
+type nebulaVersionCache struct {
+	modTime time.Time
+	size    int64
+	version string
+}
+
+// inspectDeployment hashes config.yml and the certificates it points to, and asks the nebula binary
+// for its version.
+func (a *Agent) inspectDeployment(ctx context.Context) *Deployment {
+	dir := a.cfg.NebulaDir
+	d := &Deployment{NebulaVersion: a.nebulaVersion(ctx)}
+	certPath, caPath := "", filepath.Join(dir, caFile)
+	if config, err := os.ReadFile(filepath.Join(dir, configFile)); err == nil {
+		d.ConfigHash = utils.ArtifactHash(config)
+		paths := configSection(config, "pki")
+		if path := paths["cert"]; path != "" {
+			certPath = resolvePath(dir, path)
+		}
+		if path := paths["ca"]; path != "" {
+			caPath = resolvePath(dir, path)
+		}
+	}
+	d.CertHash = hashFile(certPath)
+	d.CAHash = hashFile(caPath)
+	return d
+}
+
+// nebulaVersion returns the version printed by `nebula -version`, or "" when it cannot be determined.
+func (a *Agent) nebulaVersion(ctx context.Context) string {
+	info, err := os.Stat(a.cfg.NebulaBinary)
+	if err != nil {
+		return ""
+	}
+	cache := &a.versionCache
+	if cache.version != "" && info.ModTime().Equal(cache.modTime) && info.Size() == cache.size {
+		return cache.version
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, a.cfg.NebulaBinary, "-version").CombinedOutput()
+	if err != nil {
+		return ""
+	}
+	version := parseNebulaVersion(output)
+	cache.modTime, cache.size, cache.version = info.ModTime(), info.Size(), version
+	return version
+}
+
+// parseNebulaVersion extracts "1.9.3" from output like "Version: 1.9.3".
+func parseNebulaVersion(output []byte) string {
+	for _, line := range strings.Split(string(output), "\n") {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(line), "Version:"); ok {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// configSection reads the scalar keys of a top-level section of a nebula config, such as pki or sshd.
+// It understands the plain `key: value` layout the controller renders, which is all the agent needs.
+func configSection(config []byte, name string) map[string]string {
+	values := map[string]string{}
+	inSection := false
+	scanner := bufio.NewScanner(bytes.NewReader(config))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			inSection = strings.TrimSpace(strings.SplitN(trimmed, "#", 2)[0]) == name+":"
+			continue
+		}
+		if !inSection || line[0] == '\t' || strings.HasPrefix(line, "   ") {
+			// Only direct children; nested lists and maps are not needed.
+			continue
+		}
+		key, value, ok := strings.Cut(trimmed, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(strings.SplitN(value, " #", 2)[0])
+		values[strings.TrimSpace(key)] = strings.Trim(value, `"'`)
+	}
+	return values
+}
+
+// resolvePath resolves a config path against dir, nebula's working directory.
+func resolvePath(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+func hashFile(path string) string {
+	if path == "" {
+		return ""
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return utils.ArtifactHash(content)
+}
@@ -0,0 +1,173 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// Diagnostic job types and roles, matching the controller's.
+const (
+	DiagnosticTraceroute = "traceroute"
+	DiagnosticMTR        = "mtr"
+	DiagnosticThroughput = "throughput"
+
+	diagnosticRoleClient = "client"
+	diagnosticRoleServer = "server"
+	// maxDiagnostics bounds the jobs running at once; a throughput test would skew anything else.
+	maxDiagnostics = 2
+)
+
+// DiagnosticTask is an on-demand job the controller queued for this node. Role is server when the
+// node only has to accept the peer's throughput test.
+type DiagnosticTask struct {
+	JobID           uint   `json:"job_id"`
+	Type            string `json:"type"`
+	Role            string `json:"role"`
+	Address         string `json:"address"`
+	Port            int    `json:"port"`
+	Count           int    `json:"count"`
+	DurationSeconds int    `json:"duration_seconds"`
+	TimeoutSeconds  int    `json:"timeout_seconds"`
+	Token           string `json:"token"`
+}
+
+// DiagnosticResult is the outcome of a task, as accepted by POST /api/nodes/:id/diagnostics/:job/result.
+type DiagnosticResult struct {
+	Role       string            `json:"role"`
+	Success    bool              `json:"success"`
+	Error      string            `json:"error,omitempty"`
+	Address    string            `json:"address,omitempty"`
+	Reached    bool              `json:"reached"`
+	Hops       []TraceHop        `json:"hops,omitempty"`
+	Throughput *ThroughputResult `json:"throughput,omitempty"`
+}
+
+// diagnosticRuns tracks the jobs in progress so a poll does not start one twice.
+type diagnosticRuns struct {
+	mu      sync.Mutex
+	running map[uint]bool
+}
+
+func (r *diagnosticRuns) claim(id uint) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running == nil {
+		r.running = make(map[uint]bool)
+	}
+	if r.running[id] || len(r.running) >= maxDiagnostics {
+		return false
+	}
+	r.running[id] = true
+	return true
+}
+
+func (r *diagnosticRuns) release(id uint) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.running, id)
+}
+
+// runDiagnostics polls for diagnostic jobs every JobInterval until ctx is cancelled. Jobs run in
+// the background, so a long test does not delay the next poll.
+func (a *Agent) runDiagnostics(ctx context.Context) {
+	ticker := time.NewTicker(a.cfg.JobInterval)
+	defer ticker.Stop()
+	for {
+		a.pollDiagnostics(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (a *Agent) pollDiagnostics(ctx context.Context) {
+	tasks, err := a.client.PendingDiagnostics(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("agent: fetch diagnostic jobs: %v", err)
+		}
+		return
+	}
+	for _, task := range tasks {
+		if !a.diagnostics.claim(task.JobID) {
+			continue
+		}
+		go func() {
+			defer a.diagnostics.release(task.JobID)
+			a.runDiagnostic(ctx, task)
+		}()
+	}
+}
+
+// runDiagnostic claims task with the controller, runs it within its timeout and uploads the result.
+func (a *Agent) runDiagnostic(ctx context.Context, task DiagnosticTask) {
+	jobCtx, cancel := context.WithTimeout(ctx, time.Duration(task.TimeoutSeconds)*time.Second)
+	defer cancel()
+
+	if task.Role == diagnosticRoleServer {
+		a.serveDiagnostic(ctx, jobCtx, task)
+		return
+	}
+	if err := a.client.StartDiagnostic(ctx, task.JobID, diagnosticRoleClient, 0); err != nil {
+		// Another poll, a cancellation or the timeout got there first.
+		log.Printf("agent: claim diagnostic job %d: %v", task.JobID, err)
+		return
+	}
+	log.Printf("agent: running %s job %d towards %s", task.Type, task.JobID, task.Address)
+
+	result := DiagnosticResult{Role: diagnosticRoleClient, Address: task.Address}
+	var err error
+	switch task.Type {
+	case DiagnosticTraceroute, DiagnosticMTR:
+		result.Hops, result.Reached, err = trace(jobCtx, task.Address, max(task.Count, 1), a.cfg.PingTimeout)
+		if err == nil && !result.Reached {
+			err = errors.New("destination did not answer")
+		}
+	case DiagnosticThroughput:
+		var throughput ThroughputResult
+		throughput, err = measureThroughput(jobCtx, task.Address, task.Port, task.Token, time.Duration(task.DurationSeconds)*time.Second)
+		result.Throughput = &throughput
+		result.Reached = err == nil
+	default:
+		err = fmt.Errorf("unsupported diagnostic type %q", task.Type)
+	}
+	result.Success = err == nil
+	if err != nil {
+		result.Error = err.Error()
+	}
+	a.reportDiagnostic(ctx, task, result)
+}
+
+// serveDiagnostic opens the throughput listener for the peer's test and tells the controller its
+// port, which releases the job to the peer.
+func (a *Agent) serveDiagnostic(ctx, jobCtx context.Context, task DiagnosticTask) {
+	if task.Type != DiagnosticThroughput {
+		return
+	}
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", a.cfg.ThroughputPort))
+	if err != nil {
+		a.reportDiagnostic(ctx, task, DiagnosticResult{Role: diagnosticRoleServer, Error: fmt.Sprintf("listen: %v", err)})
+		return
+	}
+	if err := a.client.StartDiagnostic(ctx, task.JobID, diagnosticRoleServer, a.cfg.ThroughputPort); err != nil {
+		ln.Close()
+		log.Printf("agent: announce throughput listener for job %d: %v", task.JobID, err)
+		return
+	}
+	log.Printf("agent: accepting throughput test for job %d on port %d", task.JobID, a.cfg.ThroughputPort)
+	serveThroughput(jobCtx, ln, task.Token, time.Duration(task.DurationSeconds)*time.Second)
+}
+
+func (a *Agent) reportDiagnostic(ctx context.Context, task DiagnosticTask, result DiagnosticResult) {
+	err := retry(ctx, func() error { return a.client.PostDiagnosticResult(ctx, task.JobID, result) })
+	if err != nil {
+		log.Printf("agent: upload result of diagnostic job %d: %v", task.JobID, err)
+	}
+}
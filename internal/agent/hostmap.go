@@ -0,0 +1,310 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"nebula_manager/internal/utils"
+)
+
+const (
+	sshClientKeyFile = "ssh_client_key"
+	// sshHostKeyFile is nebula's sshd host key. The agent generates it so the controller only enables
+	// sshd on nodes where it exists; nebula refuses to start with a missing host key.
+	sshHostKeyFile = "nebula_ssh_host_key"
+	scrapeTimeout  = 15 * time.Second
+)
+
+// HostmapReport is nebula's own view of its tunnels, as accepted by POST /api/nodes/:id/hostmap.
+type HostmapReport struct {
+	SSHPublicKey        string        `json:"ssh_public_key,omitempty"`
+	SSHHostKey          string        `json:"ssh_host_key,omitempty"`
+	Sources             []string      `json:"sources"`
+	Tunnels             int           `json:"tunnels"`
+	PendingHandshakes   int           `json:"pending_handshakes"`
+	RelayingTunnels     int           `json:"relaying_tunnels"`
+	HandshakesInitiated *uint64       `json:"handshakes_initiated,omitempty"`
+	HandshakesTimedOut  *uint64       `json:"handshakes_timed_out,omitempty"`
+	Peers               []HostmapPeer `json:"peers"`
+	ReportedAt          string        `json:"reported_at"`
+}
+
+// HostmapPeer is one tunnel. Remote is empty when nebula reaches the peer through Relays.
+type HostmapPeer struct {
+	VpnIP  string   `json:"vpn_ip"`
+	Remote string   `json:"remote,omitempty"`
+	Relays []string `json:"relays,omitempty"`
+}
+
+// debugKeys are the agent's login key for nebula's sshd and the host key nebula's sshd serves.
+type debugKeys struct {
+	client      ssh.Signer
+	hostKey     ssh.PublicKey
+	hostKeyPath string
+}
+
+// reportHostmap scrapes whichever of nebula's sshd and stats endpoints the config enables and uploads
+// the result. The report also registers the agent's SSH keys, which is what makes the controller
+// render the sshd section in the first place.
+func (a *Agent) reportHostmap(ctx context.Context) {
+	if a.debugKeys == nil {
+		keys, err := loadDebugKeys(a.cfg.StateDir)
+		if err != nil {
+			log.Printf("agent: prepare nebula sshd keys: %v", err)
+		}
+		a.debugKeys = keys
+	}
+	report := HostmapReport{Sources: []string{}, Peers: []HostmapPeer{}, ReportedAt: time.Now().UTC().Format(time.RFC3339)}
+	if keys := a.debugKeys; keys != nil {
+		report.SSHPublicKey = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(keys.client.PublicKey())))
+		report.SSHHostKey = keys.hostKeyPath
+	}
+
+	config, _ := os.ReadFile(filepath.Join(a.cfg.NebulaDir, configFile))
+	if sshd := configSection(config, "sshd"); sshd["enabled"] == "true" && sshd["listen"] != "" && a.debugKeys != nil {
+		if err := scrapeSSHD(ctx, sshd["listen"], a.debugKeys, &report); err != nil {
+			log.Printf("agent: scrape nebula sshd: %v", err)
+		} else {
+			report.Sources = append(report.Sources, "sshd")
+		}
+	}
+	if stats := configSection(config, "stats"); stats["type"] == "prometheus" && stats["listen"] != "" {
+		if err := scrapeStats(ctx, stats["listen"], fallback(stats["path"], "/metrics"), len(report.Sources) == 0, &report); err != nil {
+			log.Printf("agent: scrape nebula stats: %v", err)
+		} else {
+			report.Sources = append(report.Sources, "stats")
+		}
+	}
+	if report.SSHPublicKey == "" && len(report.Sources) == 0 {
+		return
+	}
+	if err := a.client.PostHostmap(ctx, report); err != nil {
+		log.Printf("agent: upload hostmap: %v", err)
+	}
+}
+
+// controlHostInfo is an entry of `list-hostmap -json`. Nebula 1.9 reports a single vpnIp and relays as
+// strings; later releases use vpnAddrs. Addresses are decoded leniently to cover both.
+type controlHostInfo struct {
+	VpnIP                  json.RawMessage   `json:"vpnIp"`
+	VpnAddrs               []json.RawMessage `json:"vpnAddrs"`
+	CurrentRemote          json.RawMessage   `json:"currentRemote"`
+	CurrentRelaysToMe      []json.RawMessage `json:"currentRelaysToMe"`
+	CurrentRelaysThroughMe []json.RawMessage `json:"currentRelaysThroughMe"`
+}
+
+// scrapeSSHD runs list-hostmap and list-pending-hostmap on nebula's debug sshd.
+func scrapeSSHD(ctx context.Context, listen string, keys *debugKeys, report *HostmapReport) error {
+	dialer := net.Dialer{Timeout: 5 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", listen)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(scrapeTimeout))
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, listen, &ssh.ClientConfig{
+		User:            utils.AgentSSHUser,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(keys.client)},
+		HostKeyCallback: ssh.FixedHostKey(keys.hostKey),
+	})
+	if err != nil {
+		return err
+	}
+	client := ssh.NewClient(sshConn, chans, reqs)
+	defer client.Close()
+
+	run := func(command string) ([]byte, error) {
+		session, err := client.NewSession()
+		if err != nil {
+			return nil, err
+		}
+		defer session.Close()
+		return session.Output(command)
+	}
+
+	output, err := run("list-hostmap -json")
+	if err != nil {
+		return fmt.Errorf("list-hostmap: %w", err)
+	}
+	var hosts []controlHostInfo
+	if err := json.Unmarshal(output, &hosts); err != nil {
+		return fmt.Errorf("list-hostmap: %w", err)
+	}
+	report.Tunnels = len(hosts)
+	for _, host := range hosts {
+		vpnIP := jsonAddr(host.VpnIP)
+		if vpnIP == "" && len(host.VpnAddrs) > 0 {
+			vpnIP = jsonAddr(host.VpnAddrs[0])
+		}
+		if vpnIP == "" {
+			continue
+		}
+		peer := HostmapPeer{VpnIP: vpnIP, Remote: jsonAddr(host.CurrentRemote)}
+		for _, relay := range host.CurrentRelaysToMe {
+			if addr := jsonAddr(relay); addr != "" {
+				peer.Relays = append(peer.Relays, addr)
+			}
+		}
+		if len(host.CurrentRelaysThroughMe) > 0 {
+			report.RelayingTunnels++
+		}
+		report.Peers = append(report.Peers, peer)
+	}
+
+	if output, err := run("list-pending-hostmap -json"); err == nil {
+		var pending []json.RawMessage
+		if json.Unmarshal(output, &pending) == nil {
+			report.PendingHandshakes = len(pending)
+		}
+	}
+	return nil
+}
+
+// scrapeStats reads handshake counters, and the tunnel count when sshd did not provide it, from nebula's
+// Prometheus endpoint. The controller renders the endpoint with the "nebula" namespace.
+func scrapeStats(ctx context.Context, listen, path string, countTunnels bool, report *HostmapReport) error {
+	ctx, cancel := context.WithTimeout(ctx, scrapeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+listen+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", path, resp.Status)
+	}
+	metrics := parsePrometheus(io.LimitReader(resp.Body, 4<<20))
+	if val, ok := metrics["nebula_handshake_manager_initiated"]; ok {
+		count := uint64(val)
+		report.HandshakesInitiated = &count
+	}
+	if val, ok := metrics["nebula_handshake_manager_timed_out"]; ok {
+		count := uint64(val)
+		report.HandshakesTimedOut = &count
+	}
+	if val, ok := metrics["nebula_hostmap_main_hosts"]; ok && countTunnels {
+		report.Tunnels = int(val)
+	}
+	return nil
+}
+
+// parsePrometheus reads unlabelled samples of the text exposition format; counters are keyed without
+// their _total suffix.
+func parsePrometheus(r io.Reader) map[string]float64 {
+	metrics := map[string]float64{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") || strings.Contains(fields[0], "{") {
+			continue
+		}
+		val, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			continue
+		}
+		metrics[strings.TrimSuffix(fields[0], "_total")] = val
+	}
+	return metrics
+}
+
+// jsonAddr decodes an address nebula printed as a string ("10.0.0.1", "1.2.3.4:4242"), a uint32 IPv4
+// or an {"IP", "Port"} object. Unset and unspecified addresses yield "".
+func jsonAddr(raw json.RawMessage) string {
+	var text string
+	if json.Unmarshal(raw, &text) == nil {
+		return validAddr(text)
+	}
+	var number uint32
+	if json.Unmarshal(raw, &number) == nil {
+		if number == 0 {
+			return ""
+		}
+		return netip.AddrFrom4([4]byte{byte(number >> 24), byte(number >> 16), byte(number >> 8), byte(number)}).String()
+	}
+	var object struct {
+		IP   string
+		Port uint16
+	}
+	if json.Unmarshal(raw, &object) == nil && object.IP != "" {
+		return validAddr(net.JoinHostPort(object.IP, strconv.Itoa(int(object.Port))))
+	}
+	return ""
+}
+
+func validAddr(text string) string {
+	if addrPort, err := netip.ParseAddrPort(text); err == nil {
+		if !addrPort.Addr().IsValid() || addrPort.Addr().IsUnspecified() {
+			return ""
+		}
+		return addrPort.String()
+	}
+	if addr, err := netip.ParseAddr(text); err == nil && !addr.IsUnspecified() {
+		return addr.String()
+	}
+	return ""
+}
+
+// loadDebugKeys loads the agent's SSH keys from dir, generating them on first use.
+func loadDebugKeys(dir string) (*debugKeys, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	client, err := loadOrCreateKey(filepath.Join(dir, sshClientKeyFile))
+	if err != nil {
+		return nil, err
+	}
+	hostKeyPath := filepath.Join(dir, sshHostKeyFile)
+	host, err := loadOrCreateKey(hostKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	return &debugKeys{client: client, hostKey: host.PublicKey(), hostKeyPath: hostKeyPath}, nil
+}
+
+func loadOrCreateKey(path string) (ssh.Signer, error) {
+	raw, err := os.ReadFile(path)
+	if err == nil {
+		return ssh.ParsePrivateKey(raw)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	block, err := ssh.MarshalPrivateKey(key, "nebula-agent")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path+".tmp", pem.EncodeToMemory(block), 0o600); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return nil, err
+	}
+	return ssh.NewSignerFromKey(key)
+}
@@ -0,0 +1,251 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	protocolICMP   = 1
+	protocolICMPv6 = 58
+)
+
+// PingResult is the outcome of probing one peer.
+type PingResult struct {
+	// LatencyMs is the mean round trip time of the answered echoes.
+	LatencyMs float64
+	Sent      int
+	Received  int
+	MinMs     float64
+	MaxMs     float64
+	// MdevMs is the standard deviation of the round trips, as ping reports it.
+	MdevMs float64
+	// JitterMs is the mean difference between the round trips of consecutive answered echoes.
+	JitterMs float64
+}
+
+// Success reports whether at least one echo was answered.
+func (r PingResult) Success() bool {
+	return r.Received > 0
+}
+
+// Pinger sends ICMP echo requests without shelling out to ping. It prefers unprivileged datagram
+// sockets (net.ipv4.ping_group_range) and falls back to raw sockets, which need root or CAP_NET_RAW.
+type Pinger struct {
+	Timeout time.Duration
+	Count   int
+	// Interval spaces the echoes of one ping; replies are awaited concurrently.
+	Interval time.Duration
+	// raw is set once datagram sockets turned out to be unavailable.
+	raw atomic.Bool
+}
+
+// NewPinger constructs a Pinger sending count echoes per peer, interval apart, and waiting up to
+// timeout for each.
+func NewPinger(timeout time.Duration, count int, interval time.Duration) *Pinger {
+	return &Pinger{Timeout: timeout, Count: count, Interval: interval}
+}
+
+// Ping probes address, which may be an IP or a host name.
+func (p *Pinger) Ping(ctx context.Context, address string) (PingResult, error) {
+	ip, err := resolveIP(ctx, address)
+	if err != nil {
+		return PingResult{}, err
+	}
+
+	conn, raw, err := p.listen(ip)
+	if err != nil {
+		return PingResult{}, err
+	}
+	defer conn.Close()
+
+	var dst net.Addr = &net.UDPAddr{IP: ip}
+	if raw {
+		dst = &net.IPAddr{IP: ip}
+	}
+	var echoType icmp.Type = ipv4.ICMPTypeEcho
+	protocol := protocolICMP
+	if ip.To4() == nil {
+		echoType, protocol = ipv6.ICMPTypeEchoRequest, protocolICMPv6
+	}
+
+	// A random token identifies our replies: raw sockets see every ICMP packet on the host, and with
+	// datagram sockets the kernel rewrites the echo ID.
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return PingResult{}, err
+	}
+	id := int(token[0])<<8 | int(token[1])
+
+	// Echoes go out Interval apart while a reader collects the replies, so a lost echo costs one
+	// timeout per ping rather than one per echo.
+	var mu sync.Mutex
+	sentAt := make([]time.Time, p.Count)
+	rtts := make([]time.Duration, p.Count)
+	answered := make([]bool, p.Count)
+	var readErr error
+	done := make(chan struct{})
+	lastDeadline := time.Now().Add(time.Duration(max(p.Count-1, 0))*p.Interval + p.Timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(lastDeadline) {
+		lastDeadline = ctxDeadline
+	}
+	if err := conn.SetReadDeadline(lastDeadline); err != nil {
+		return PingResult{}, err
+	}
+	go func() {
+		defer close(done)
+		buf := make([]byte, 1500)
+		for received := 0; received < p.Count; {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				var netErr net.Error
+				if !errors.As(err, &netErr) || !netErr.Timeout() {
+					mu.Lock()
+					readErr = err
+					mu.Unlock()
+				}
+				return
+			}
+			arrived := time.Now()
+			reply, err := icmp.ParseMessage(protocol, buf[:n])
+			if err != nil {
+				continue
+			}
+			echo, ok := reply.Body.(*icmp.Echo)
+			if !ok || (reply.Type != ipv4.ICMPTypeEchoReply && reply.Type != ipv6.ICMPTypeEchoReply) {
+				continue
+			}
+			if echo.Seq < 0 || echo.Seq >= p.Count || !bytes.Equal(echo.Data, token) {
+				continue
+			}
+			mu.Lock()
+			if !answered[echo.Seq] && !sentAt[echo.Seq].IsZero() && arrived.Sub(sentAt[echo.Seq]) <= p.Timeout {
+				answered[echo.Seq] = true
+				rtts[echo.Seq] = arrived.Sub(sentAt[echo.Seq])
+				received++
+			}
+			mu.Unlock()
+		}
+	}()
+
+	result := PingResult{}
+	var sendErr error
+	for seq := 0; seq < p.Count; seq++ {
+		if seq > 0 {
+			select {
+			case <-ctx.Done():
+			case <-done:
+			case <-time.After(p.Interval):
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		msg := icmp.Message{Type: echoType, Body: &icmp.Echo{ID: id, Seq: seq, Data: token}}
+		packet, err := msg.Marshal(nil)
+		if err != nil {
+			sendErr = err
+			break
+		}
+		mu.Lock()
+		sentAt[seq] = time.Now()
+		mu.Unlock()
+		if _, err := conn.WriteTo(packet, dst); err != nil {
+			sendErr = err
+			break
+		}
+		result.Sent++
+	}
+	if sendErr != nil || result.Sent < p.Count {
+		// Stop waiting for echoes that were never sent.
+		_ = conn.SetReadDeadline(time.Now().Add(p.Timeout))
+	}
+	<-done
+
+	var ordered []float64
+	for seq := 0; seq < result.Sent; seq++ {
+		if answered[seq] {
+			ordered = append(ordered, float64(rtts[seq].Microseconds())/1000)
+		}
+	}
+	result.Received = len(ordered)
+	fillRTTStats(&result, ordered)
+	if sendErr != nil {
+		return result, sendErr
+	}
+	return result, readErr
+}
+
+// fillRTTStats computes the round trip statistics of the answered echoes, in sequence order.
+func fillRTTStats(result *PingResult, rtts []float64) {
+	if len(rtts) == 0 {
+		return
+	}
+	var sum, sumSquares, diffs float64
+	result.MinMs, result.MaxMs = rtts[0], rtts[0]
+	for i, rtt := range rtts {
+		sum += rtt
+		sumSquares += rtt * rtt
+		result.MinMs = min(result.MinMs, rtt)
+		result.MaxMs = max(result.MaxMs, rtt)
+		if i > 0 {
+			diffs += math.Abs(rtt - rtts[i-1])
+		}
+	}
+	n := float64(len(rtts))
+	result.LatencyMs = sum / n
+	result.MdevMs = math.Sqrt(max(sumSquares/n-result.LatencyMs*result.LatencyMs, 0))
+	if len(rtts) > 1 {
+		result.JitterMs = diffs / (n - 1)
+	}
+}
+
+// resolveIP looks up address, preferring IPv4.
+func resolveIP(ctx context.Context, address string) (net.IP, error) {
+	ipAddr, err := net.DefaultResolver.LookupIPAddr(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	if len(ipAddr) == 0 {
+		return nil, fmt.Errorf("no address for %s", address)
+	}
+	for _, candidate := range ipAddr {
+		if candidate.IP.To4() != nil {
+			return candidate.IP, nil
+		}
+	}
+	return ipAddr[0].IP, nil
+}
+
+func (p *Pinger) listen(ip net.IP) (*icmp.PacketConn, bool, error) {
+	datagram, rawNetwork, listenAddr := "udp4", "ip4:icmp", "0.0.0.0"
+	if ip.To4() == nil {
+		datagram, rawNetwork, listenAddr = "udp6", "ip6:ipv6-icmp", "::"
+	}
+	if !p.raw.Load() {
+		// Datagram ICMP sockets fail with EACCES when the group is outside ping_group_range and with
+		// EPROTONOSUPPORT on kernels without them; either way the raw socket is the remaining option.
+		conn, err := icmp.ListenPacket(datagram, listenAddr)
+		if err == nil {
+			return conn, false, nil
+		}
+	}
+	conn, err := icmp.ListenPacket(rawNetwork, listenAddr)
+	if err != nil {
+		return nil, true, fmt.Errorf("open ICMP socket (run as root, grant CAP_NET_RAW or widen net.ipv4.ping_group_range): %w", err)
+	}
+	p.raw.Store(true)
+	return conn, true, nil
+}
@@ -0,0 +1,72 @@
+package agent
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFillRTTStats(t *testing.T) {
+	tests := []struct {
+		name string
+		sent int
+		rtts []float64
+		want PingResult
+	}{
+		{
+			name: "all lost",
+			sent: 3,
+			want: PingResult{Sent: 3},
+		},
+		{
+			name: "single echo has no spread",
+			sent: 1,
+			rtts: []float64{12},
+			want: PingResult{Sent: 1, Received: 1, LatencyMs: 12, MinMs: 12, MaxMs: 12},
+		},
+		{
+			name: "partial loss",
+			sent: 4,
+			rtts: []float64{10, 20},
+			want: PingResult{Sent: 4, Received: 2, LatencyMs: 15, MinMs: 10, MaxMs: 20, MdevMs: 5, JitterMs: 10},
+		},
+		{
+			// Jitter follows the sequence order, mdev does not.
+			name: "jitter uses consecutive echoes",
+			sent: 4,
+			rtts: []float64{10, 30, 10, 30},
+			want: PingResult{Sent: 4, Received: 4, LatencyMs: 20, MinMs: 10, MaxMs: 30, MdevMs: 10, JitterMs: 20},
+		},
+		{
+			name: "steady rise",
+			sent: 4,
+			rtts: []float64{10, 20, 30, 40},
+			want: PingResult{Sent: 4, Received: 4, LatencyMs: 25, MinMs: 10, MaxMs: 40, MdevMs: math.Sqrt(125), JitterMs: 10},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := PingResult{Sent: tt.sent, Received: len(tt.rtts)}
+			fillRTTStats(&got, tt.rtts)
+			if got.Sent != tt.want.Sent || got.Received != tt.want.Received {
+				t.Fatalf("sent/received = %d/%d, want %d/%d", got.Sent, got.Received, tt.want.Sent, tt.want.Received)
+			}
+			if got.Success() != (tt.want.Received > 0) {
+				t.Errorf("Success() = %v with %d received", got.Success(), got.Received)
+			}
+			for _, field := range []struct {
+				name      string
+				got, want float64
+			}{
+				{"latency", got.LatencyMs, tt.want.LatencyMs},
+				{"min", got.MinMs, tt.want.MinMs},
+				{"max", got.MaxMs, tt.want.MaxMs},
+				{"mdev", got.MdevMs, tt.want.MdevMs},
+				{"jitter", got.JitterMs, tt.want.JitterMs},
+			} {
+				if math.Abs(field.got-field.want) > 1e-9 {
+					t.Errorf("%s = %v, want %v", field.name, field.got, field.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package agent
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Probe types, matching the controller's probe definitions.
+const (
+	ProbeICMP       = "icmp"
+	ProbeTCP        = "tcp"
+	ProbeNebulaPort = "nebula_port"
+	ProbeHTTP       = "http"
+)
+
+// Nebula packet header: version 1 in the high nibble of the first byte and the message type in the low.
+const (
+	nebulaHeaderLen      = 16
+	nebulaTypeMessage    = 0x11
+	nebulaTypeRecvError  = 0x13
+	nebulaPortProbeTries = 3
+)
+
+// Probe is a check the controller configured for a peer in addition to the ping.
+type Probe struct {
+	ID            uint   `json:"probe_id"`
+	Type          string `json:"type"`
+	Address       string `json:"address"`
+	Port          int    `json:"port"`
+	URL           string `json:"url"`
+	ExpectStatus  int    `json:"expect_status"`
+	SkipTLSVerify bool   `json:"skip_tls_verify"`
+	TimeoutMs     int    `json:"timeout_ms"`
+}
+
+// ProbeResult is the outcome of one Probe run.
+type ProbeResult struct {
+	LatencyMs  float64
+	Success    bool
+	StatusCode int
+	Err        error
+}
+
+// runProbe executes probe with its own timeout, falling back to the ping timeout.
+func (a *Agent) runProbe(ctx context.Context, probe Probe) ProbeResult {
+	timeout := a.cfg.PingTimeout
+	if probe.TimeoutMs > 0 {
+		timeout = time.Duration(probe.TimeoutMs) * time.Millisecond
+	}
+	switch probe.Type {
+	case ProbeICMP:
+		result, err := a.pinger.Ping(ctx, probe.Address)
+		return ProbeResult{LatencyMs: result.LatencyMs, Success: err == nil && result.Success(), Err: err}
+	case ProbeTCP:
+		return probeTCP(ctx, probe.Address, probe.Port, timeout)
+	case ProbeNebulaPort:
+		return probeNebulaPort(ctx, probe.Address, probe.Port, timeout)
+	case ProbeHTTP:
+		return probeHTTP(ctx, probe, timeout)
+	default:
+		return ProbeResult{Err: fmt.Errorf("unsupported probe type %q", probe.Type)}
+	}
+}
+
+// probeTCP measures how long a TCP connect to address:port takes.
+func probeTCP(ctx context.Context, address string, port int, timeout time.Duration) ProbeResult {
+	dialer := net.Dialer{Timeout: timeout}
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(address, strconv.Itoa(port)))
+	if err != nil {
+		return ProbeResult{Err: err}
+	}
+	elapsed := time.Since(start)
+	conn.Close()
+	return ProbeResult{LatencyMs: durationMs(elapsed), Success: true}
+}
+
+// probeNebulaPort checks that nebula answers on its public UDP listen port. It sends a message packet
+// for a random, unknown tunnel index; nebula replies with a recv_error header carrying the same index,
+// which tells the sender to re-handshake. Nodes running with listen.send_recv_error set to never (or
+// private, for public senders) stay silent and report as unreachable.
+func probeNebulaPort(ctx context.Context, address string, port int, timeout time.Duration) ProbeResult {
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "udp", net.JoinHostPort(address, strconv.Itoa(port)))
+	if err != nil {
+		return ProbeResult{Err: err}
+	}
+	defer conn.Close()
+
+	packet := make([]byte, nebulaHeaderLen)
+	if _, err := rand.Read(packet[4:]); err != nil {
+		return ProbeResult{Err: err}
+	}
+	packet[0] = nebulaTypeMessage
+	index := packet[4:8]
+
+	// UDP may drop a single datagram, so the wait is split across a few sends.
+	wait := max(timeout/nebulaPortProbeTries, 100*time.Millisecond)
+	buf := make([]byte, 1500)
+	var lastErr error
+	for try := 0; try < nebulaPortProbeTries && ctx.Err() == nil; try++ {
+		start := time.Now()
+		if _, err := conn.Write(packet); err != nil {
+			return ProbeResult{Err: err}
+		}
+		deadline := start.Add(wait)
+		if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+			deadline = ctxDeadline
+		}
+		_ = conn.SetReadDeadline(deadline)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				// ECONNREFUSED from an ICMP port unreachable means nothing listens on the port.
+				lastErr = err
+				break
+			}
+			if n >= nebulaHeaderLen && buf[0] == nebulaTypeRecvError &&
+				binary.BigEndian.Uint32(buf[4:8]) == binary.BigEndian.Uint32(index) {
+				return ProbeResult{LatencyMs: durationMs(time.Since(start)), Success: true}
+			}
+		}
+		var netErr net.Error
+		if !errors.As(lastErr, &netErr) || !netErr.Timeout() {
+			break
+		}
+	}
+	if lastErr == nil {
+		lastErr = ctx.Err()
+	}
+	return ProbeResult{Err: fmt.Errorf("no reply from nebula: %w", lastErr)}
+}
+
+// probeHTTP times a GET of the probe URL. Any 2xx or 3xx counts as success unless a status is expected.
+func probeHTTP(ctx context.Context, probe Probe, timeout time.Duration) ProbeResult {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, probe.URL, nil)
+	if err != nil {
+		return ProbeResult{Err: err}
+	}
+	req.Header.Set("User-Agent", "nebula-agent")
+	transport := &http.Transport{
+		Proxy:             nil,
+		DisableKeepAlives: true,
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: probe.SkipTLSVerify},
+	}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{
+		Transport: transport,
+		// Redirects are reported as they are rather than followed to another host.
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return ProbeResult{Err: err}
+	}
+	elapsed := time.Since(start)
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+
+	result := ProbeResult{LatencyMs: durationMs(elapsed), StatusCode: resp.StatusCode}
+	if probe.ExpectStatus != 0 {
+		result.Success = resp.StatusCode == probe.ExpectStatus
+	} else {
+		result.Success = resp.StatusCode >= 200 && resp.StatusCode < 400
+	}
+	if !result.Success {
+		result.Err = fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return result
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
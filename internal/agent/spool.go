@@ -0,0 +1,201 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const spoolSuffix = ".json"
+
+// batch is what one collection cycle produced. Parts that were uploaded are cleared so a partially
+// delivered batch is not sent twice.
+type batch struct {
+	Samples []Sample `json:"samples,omitempty"`
+	Status  *Status  `json:"status,omitempty"`
+}
+
+// spoolEntry is a queued batch; name orders entries and is the file name when spooled to disk.
+type spoolEntry struct {
+	name  string
+	batch *batch
+}
+
+// spool is a bounded FIFO of batches awaiting upload. With a directory it keeps one file per batch
+// so the backlog survives agent restarts; without one it only lives in memory.
+type spool struct {
+	dir   string
+	limit int
+
+	mu      sync.Mutex
+	memory  []spoolEntry
+	lastSeq int64
+}
+
+// openSpool prepares dir for spooling. An unusable directory degrades to an in-memory queue rather
+// than keeping the agent from reporting.
+func openSpool(dir string, limit int) *spool {
+	s := &spool{dir: dir, limit: limit}
+	if dir == "" {
+		return s
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		log.Printf("agent: spool dir %s unusable, queueing in memory: %v", dir, err)
+		s.dir = ""
+		return s
+	}
+	// Remove leftovers of writes interrupted by a crash.
+	if tmp, err := filepath.Glob(filepath.Join(dir, "*.tmp")); err == nil {
+		for _, path := range tmp {
+			_ = os.Remove(path)
+		}
+	}
+	if entries, err := s.names(); err == nil && len(entries) > 0 {
+		log.Printf("agent: %d spooled batches pending from a previous run", len(entries))
+	}
+	return s
+}
+
+// Push appends b, discarding the oldest batches beyond the limit.
+func (s *spool) Push(b *batch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Names sort chronologically; the counter keeps them unique within the same nanosecond tick.
+	seq := time.Now().UnixNano()
+	if seq <= s.lastSeq {
+		seq = s.lastSeq + 1
+	}
+	s.lastSeq = seq
+	name := fmt.Sprintf("%020d", seq)
+
+	if s.dir == "" {
+		s.memory = append(s.memory, spoolEntry{name: name, batch: b})
+		if overflow := len(s.memory) - s.limit; overflow > 0 {
+			log.Printf("agent: upload backlog full, dropping %d oldest batches", overflow)
+			s.memory = append([]spoolEntry(nil), s.memory[overflow:]...)
+		}
+		return nil
+	}
+
+	if err := s.write(name, b); err != nil {
+		return err
+	}
+	names, err := s.names()
+	if err != nil {
+		return err
+	}
+	if overflow := len(names) - s.limit; overflow > 0 {
+		log.Printf("agent: upload backlog full, dropping %d oldest batches", overflow)
+		for _, old := range names[:overflow] {
+			_ = os.Remove(filepath.Join(s.dir, old+spoolSuffix))
+		}
+	}
+	return nil
+}
+
+// Pending returns the queued entries, oldest first. Unreadable files are discarded.
+func (s *spool) Pending() []spoolEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dir == "" {
+		return append([]spoolEntry(nil), s.memory...)
+	}
+	names, err := s.names()
+	if err != nil {
+		log.Printf("agent: read spool: %v", err)
+		return nil
+	}
+	entries := make([]spoolEntry, 0, len(names))
+	for _, name := range names {
+		path := filepath.Join(s.dir, name+spoolSuffix)
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("agent: read spooled batch %s: %v", name, err)
+			continue
+		}
+		var b batch
+		if err := json.Unmarshal(raw, &b); err != nil {
+			log.Printf("agent: discarding corrupt spooled batch %s: %v", name, err)
+			_ = os.Remove(path)
+			continue
+		}
+		entries = append(entries, spoolEntry{name: name, batch: &b})
+	}
+	return entries
+}
+
+// Len returns the number of queued batches.
+func (s *spool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dir == "" {
+		return len(s.memory)
+	}
+	names, _ := s.names()
+	return len(names)
+}
+
+// Update persists what is left of a partially delivered entry.
+func (s *spool) Update(entry spoolEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dir == "" {
+		// Memory entries share the batch pointer, so there is nothing to write back.
+		return nil
+	}
+	return s.write(entry.name, entry.batch)
+}
+
+// Remove drops a delivered entry.
+func (s *spool) Remove(entry spoolEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dir == "" {
+		for i, queued := range s.memory {
+			if queued.name == entry.name {
+				s.memory = append(s.memory[:i], s.memory[i+1:]...)
+				break
+			}
+		}
+		return
+	}
+	if err := os.Remove(filepath.Join(s.dir, entry.name+spoolSuffix)); err != nil && !os.IsNotExist(err) {
+		log.Printf("agent: remove spooled batch %s: %v", entry.name, err)
+	}
+}
+
+// write stores b atomically so a crash never leaves a truncated batch behind.
+func (s *spool) write(name string, b *batch) error {
+	raw, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(s.dir, name+spoolSuffix)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *spool) names() ([]string, error) {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		if name, ok := strings.CutSuffix(file.Name(), spoolSuffix); ok && !file.IsDir() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,91 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func statusBatch(id string) *batch {
+	return &batch{Status: &Status{ID: id}}
+}
+
+func pendingIDs(s *spool) []string {
+	var ids []string
+	for _, entry := range s.Pending() {
+		ids = append(ids, entry.batch.Status.ID)
+	}
+	return ids
+}
+
+func TestSpoolKeepsOrderAndTrimsOldest(t *testing.T) {
+	for name, dir := range map[string]string{"disk": t.TempDir(), "memory": ""} {
+		t.Run(name, func(t *testing.T) {
+			s := openSpool(dir, 3)
+			for _, id := range []string{"a", "b", "c", "d", "e"} {
+				if err := s.Push(statusBatch(id)); err != nil {
+					t.Fatalf("push %s: %v", id, err)
+				}
+			}
+			if want := []string{"c", "d", "e"}; !reflect.DeepEqual(pendingIDs(s), want) {
+				t.Fatalf("pending = %v, want %v", pendingIDs(s), want)
+			}
+			if s.Len() != 3 {
+				t.Fatalf("len = %d, want 3", s.Len())
+			}
+
+			s.Remove(s.Pending()[0])
+			if want := []string{"d", "e"}; !reflect.DeepEqual(pendingIDs(s), want) {
+				t.Fatalf("after remove pending = %v, want %v", pendingIDs(s), want)
+			}
+		})
+	}
+}
+
+func TestSpoolSurvivesReopen(t *testing.T) {
+	dir := t.TempDir()
+	s := openSpool(dir, 10)
+	for _, id := range []string{"a", "b"} {
+		if err := s.Push(statusBatch(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Leftovers of an interrupted write and corrupt batches are discarded.
+	if err := os.WriteFile(filepath.Join(dir, "00000000000000000001.json.tmp"), []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "00000000000000000002.json"), []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := openSpool(dir, 10)
+	if want := []string{"a", "b"}; !reflect.DeepEqual(pendingIDs(reopened), want) {
+		t.Fatalf("pending = %v, want %v", pendingIDs(reopened), want)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 2 {
+		t.Fatalf("spool dir holds %v, want only the two batches", files)
+	}
+}
+
+func TestSpoolUpdateRewritesEntry(t *testing.T) {
+	dir := t.TempDir()
+	s := openSpool(dir, 10)
+	if err := s.Push(&batch{Samples: []Sample{{ID: "s1", PeerID: 2}}, Status: &Status{ID: "r1"}}); err != nil {
+		t.Fatal(err)
+	}
+	entry := s.Pending()[0]
+	entry.batch.Samples = nil
+	if err := s.Update(entry); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+
+	pending := openSpool(dir, 10).Pending()
+	if len(pending) != 1 || pending[0].name != entry.name {
+		t.Fatalf("pending = %+v, want the updated entry", pending)
+	}
+	if got := pending[0].batch; len(got.Samples) != 0 || got.Status == nil || got.Status.ID != "r1" {
+		t.Fatalf("rewritten batch = %+v, want only the status report", got)
+	}
+}
@@ -0,0 +1,177 @@
+//go:build linux
+
+package agent
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// collector reads host metrics from /proc. CPU usage is the busy share since the previous call, so
+// a long-lived agent reports the average over the whole interval instead of a short sample.
+type collector struct {
+	prevIdle  uint64
+	prevTotal uint64
+}
+
+func newCollector() *collector {
+	return &collector{}
+}
+
+func (c *collector) Collect() (Status, error) {
+	status := Status{ReportedAt: time.Now().UTC().Format(time.RFC3339)}
+
+	idle, total, err := readCPUTimes()
+	if err != nil {
+		return status, err
+	}
+	if c.prevTotal == 0 {
+		// First cycle: take a short second snapshot as the shell probe did.
+		time.Sleep(200 * time.Millisecond)
+		c.prevIdle, c.prevTotal = idle, total
+		if idle, total, err = readCPUTimes(); err != nil {
+			return status, err
+		}
+	}
+	if total > c.prevTotal {
+		busy := 1 - float64(idle-c.prevIdle)/float64(total-c.prevTotal)
+		status.CPUUsage = roundTo(min(max(busy*100, 0), 100), 2)
+	}
+	c.prevIdle, c.prevTotal = idle, total
+
+	if mem, err := readKeyValueFile("/proc/meminfo"); err == nil {
+		status.MemoryTotal = mem["MemTotal"] * 1024
+		status.MemoryUsed = saturatingSub(mem["MemTotal"], mem["MemAvailable"]) * 1024
+		status.SwapTotal = mem["SwapTotal"] * 1024
+		status.SwapUsed = saturatingSub(mem["SwapTotal"], mem["SwapFree"]) * 1024
+	}
+
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs("/", &fs); err == nil {
+		blockSize := uint64(fs.Frsize)
+		status.DiskTotal = fs.Blocks * blockSize
+		status.DiskUsed = saturatingSub(fs.Blocks, fs.Bfree) * blockSize
+	}
+
+	status.NetRxBytes, status.NetTxBytes = readNetDev()
+
+	if fields, err := readFields("/proc/loadavg"); err == nil && len(fields) >= 4 {
+		status.Load1 = roundTo(parseFloat(fields[0]), 2)
+		status.Load5 = roundTo(parseFloat(fields[1]), 2)
+		status.Load15 = roundTo(parseFloat(fields[2]), 2)
+		if _, procs, ok := strings.Cut(fields[3], "/"); ok {
+			status.Processes, _ = strconv.Atoi(procs)
+		}
+	}
+
+	if fields, err := readFields("/proc/uptime"); err == nil && len(fields) > 0 {
+		status.Uptime = uint64(parseFloat(fields[0]))
+	}
+	return status, nil
+}
+
+func readCPUTimes() (idle, total uint64, err error) {
+	fields, err := readFields("/proc/stat")
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(fields) < 6 || fields[0] != "cpu" {
+		return 0, 0, errors.New("unexpected /proc/stat format")
+	}
+	for i, field := range fields[1:] {
+		value, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			break
+		}
+		total += value
+		// idle and iowait
+		if i == 3 || i == 4 {
+			idle += value
+		}
+	}
+	return idle, total, nil
+}
+
+// readFields returns the whitespace separated fields of the file's first line.
+func readFields(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		return nil, scanner.Err()
+	}
+	return strings.Fields(scanner.Text()), nil
+}
+
+// readKeyValueFile parses "Key:   value kB" lines into the numeric value.
+func readKeyValueFile(path string) (map[string]uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	values := make(map[string]uint64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, rest, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			continue
+		}
+		if value, err := strconv.ParseUint(fields[0], 10, 64); err == nil {
+			values[key] = value
+		}
+	}
+	return values, scanner.Err()
+}
+
+// readNetDev sums the byte counters of all interfaces, matching the shell probe.
+func readNetDev() (rx, tx uint64) {
+	f, err := os.Open("/proc/net/dev")
+	if err != nil {
+		return 0, 0
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		_, data, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(data)
+		if len(fields) < 16 {
+			continue
+		}
+		r, errRx := strconv.ParseUint(fields[0], 10, 64)
+		t, errTx := strconv.ParseUint(fields[8], 10, 64)
+		if errRx != nil || errTx != nil {
+			continue
+		}
+		rx += r
+		tx += t
+	}
+	return rx, tx
+}
+
+func parseFloat(val string) float64 {
+	parsed, _ := strconv.ParseFloat(val, 64)
+	return parsed
+}
+
+func saturatingSub(a, b uint64) uint64 {
+	if b > a {
+		return 0
+	}
+	return a - b
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package agent
+
+import (
+	"errors"
+	"time"
+)
+
+// collector is only implemented on Linux, where the metrics come from /proc.
+type collector struct{}
+
+func newCollector() *collector {
+	return &collector{}
+}
+
+func (c *collector) Collect() (Status, error) {
+	return Status{ReportedAt: time.Now().UTC().Format(time.RFC3339)}, errors.New("status collection is only supported on linux")
+}
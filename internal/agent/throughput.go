@@ -0,0 +1,169 @@
+package agent
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Throughput test wire format: the client opens one TCP connection per direction and starts it
+// with throughputMagic, the mode byte, the token length and the job token. For an upload the client
+// sends until its duration is up and half-closes; the server answers with the byte count it
+// received. For a download the server sends for the duration and closes.
+const (
+	throughputMagic    = "NBTP"
+	throughputUpload   = 'u'
+	throughputDownload = 'd'
+	throughputChunk    = 128 << 10
+	// throughputSlack is added to the connection deadlines on top of the test duration.
+	throughputSlack = 10 * time.Second
+)
+
+// ThroughputResult is a TCP bandwidth test in both directions; upload is towards the peer.
+type ThroughputResult struct {
+	UploadBytes     int64   `json:"upload_bytes"`
+	UploadSeconds   float64 `json:"upload_seconds"`
+	DownloadBytes   int64   `json:"download_bytes"`
+	DownloadSeconds float64 `json:"download_seconds"`
+}
+
+// serveThroughput accepts tests authenticated with token on ln until both directions have been
+// measured once or ctx ends. Connections with a wrong token are dropped without counting.
+func serveThroughput(ctx context.Context, ln net.Listener, token string, duration time.Duration) {
+	stop := context.AfterFunc(ctx, func() { ln.Close() })
+	defer stop()
+	defer ln.Close()
+
+	served := map[byte]bool{}
+	for !served[throughputUpload] || !served[throughputDownload] {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("agent: throughput listener: %v", err)
+			}
+			return
+		}
+		mode, err := handleThroughput(conn, token, duration)
+		if err != nil {
+			log.Printf("agent: throughput test from %s: %v", conn.RemoteAddr(), err)
+			continue
+		}
+		served[mode] = true
+	}
+}
+
+func handleThroughput(conn net.Conn, token string, duration time.Duration) (byte, error) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(duration + throughputSlack))
+	header := make([]byte, len(throughputMagic)+2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return 0, err
+	}
+	if string(header[:len(throughputMagic)]) != throughputMagic {
+		return 0, errors.New("not a throughput test")
+	}
+	mode := header[len(throughputMagic)]
+	got := make([]byte, header[len(throughputMagic)+1])
+	if _, err := io.ReadFull(conn, got); err != nil {
+		return 0, err
+	}
+	if subtle.ConstantTimeCompare(got, []byte(token)) != 1 {
+		return 0, errors.New("invalid token")
+	}
+
+	switch mode {
+	case throughputUpload:
+		received, err := io.Copy(io.Discard, conn)
+		if err != nil {
+			return 0, err
+		}
+		ack := make([]byte, 8)
+		binary.BigEndian.PutUint64(ack, uint64(received))
+		_, err = conn.Write(ack)
+		return mode, err
+	case throughputDownload:
+		_, err := sendFor(conn, duration)
+		return mode, err
+	default:
+		return 0, fmt.Errorf("unknown mode %q", mode)
+	}
+}
+
+// measureThroughput runs an upload and then a download test against the peer's listener.
+func measureThroughput(ctx context.Context, address string, port int, token string, duration time.Duration) (ThroughputResult, error) {
+	var result ThroughputResult
+	target := net.JoinHostPort(address, strconv.Itoa(port))
+
+	conn, err := dialThroughput(ctx, target, throughputUpload, token, duration)
+	if err != nil {
+		return result, fmt.Errorf("upload: %w", err)
+	}
+	start := time.Now()
+	if _, err := sendFor(conn, duration); err != nil {
+		conn.Close()
+		return result, fmt.Errorf("upload: %w", err)
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		_ = tcp.CloseWrite()
+	}
+	ack := make([]byte, 8)
+	_, err = io.ReadFull(conn, ack)
+	conn.Close()
+	if err != nil {
+		return result, fmt.Errorf("upload: read byte count: %w", err)
+	}
+	result.UploadBytes = int64(binary.BigEndian.Uint64(ack))
+	result.UploadSeconds = roundTo(time.Since(start).Seconds(), 3)
+
+	conn, err = dialThroughput(ctx, target, throughputDownload, token, duration)
+	if err != nil {
+		return result, fmt.Errorf("download: %w", err)
+	}
+	defer conn.Close()
+	start = time.Now()
+	received, err := io.Copy(io.Discard, conn)
+	result.DownloadBytes = received
+	result.DownloadSeconds = roundTo(time.Since(start).Seconds(), 3)
+	if err != nil {
+		return result, fmt.Errorf("download: %w", err)
+	}
+	return result, nil
+}
+
+func dialThroughput(ctx context.Context, target string, mode byte, token string, duration time.Duration) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: throughputSlack}
+	conn, err := dialer.DialContext(ctx, "tcp", target)
+	if err != nil {
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Now().Add(duration + throughputSlack))
+	header := append([]byte(throughputMagic), mode, byte(len(token)))
+	if _, err := conn.Write(append(header, token...)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// sendFor writes zeros to conn for duration. Nothing on the way compresses TCP payloads, so zeros
+// measure the same as random data.
+func sendFor(conn net.Conn, duration time.Duration) (int64, error) {
+	buf := make([]byte, throughputChunk)
+	var sent int64
+	deadline := time.Now().Add(duration)
+	for time.Now().Before(deadline) {
+		n, err := conn.Write(buf)
+		sent += int64(n)
+		if err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
@@ -0,0 +1,244 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	traceMaxHops       = 30
+	traceRoundInterval = time.Second
+	// traceProbeSpacing keeps a round from tripping the ICMP rate limits of the routers on the way.
+	traceProbeSpacing = 20 * time.Millisecond
+)
+
+// TraceHop summarises the probes sent with one TTL, as mtr reports them. Address is empty when no
+// router answered.
+type TraceHop struct {
+	TTL      int     `json:"ttl"`
+	Address  string  `json:"address"`
+	Sent     int     `json:"sent"`
+	Received int     `json:"received"`
+	LastMs   float64 `json:"last_ms"`
+	AvgMs    float64 `json:"avg_ms"`
+	BestMs   float64 `json:"best_ms"`
+	WorstMs  float64 `json:"worst_ms"`
+	StdevMs  float64 `json:"stdev_ms"`
+}
+
+type traceReply struct {
+	from string
+	rtt  time.Duration
+}
+
+// trace sends rounds of ICMP echoes with TTLs from 1 up to the destination, one round per second as
+// mtr does, and waits up to timeout for each answer. It returns the hops up to the destination (or
+// the last router that answered) and whether the destination itself replied. Reading the routers'
+// time exceeded errors needs a raw socket, so unlike Ping this requires root or CAP_NET_RAW.
+func trace(ctx context.Context, address string, rounds int, timeout time.Duration) ([]TraceHop, bool, error) {
+	ip, err := resolveIP(ctx, address)
+	if err != nil {
+		return nil, false, err
+	}
+	v6 := ip.To4() == nil
+	network, listenAddr, protocol := "ip4:icmp", "0.0.0.0", protocolICMP
+	var echoType icmp.Type = ipv4.ICMPTypeEcho
+	if v6 {
+		network, listenAddr, protocol = "ip6:ipv6-icmp", "::", protocolICMPv6
+		echoType = ipv6.ICMPTypeEchoRequest
+	}
+	conn, err := icmp.ListenPacket(network, listenAddr)
+	if err != nil {
+		return nil, false, fmt.Errorf("open raw ICMP socket (run as root or grant CAP_NET_RAW): %w", err)
+	}
+	defer conn.Close()
+	setTTL := func(ttl int) error {
+		if v6 {
+			return conn.IPv6PacketConn().SetHopLimit(ttl)
+		}
+		return conn.IPv4PacketConn().SetTTL(ttl)
+	}
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, false, err
+	}
+	id := int(token[0])<<8 | int(token[1])
+
+	// Sequence numbers encode the round and the TTL of each probe.
+	var mu sync.Mutex
+	sentAt := make(map[int]time.Time)
+	replies := make(map[int]traceReply)
+	destTTL, reached := 0, false
+	var readErr error
+	done := make(chan struct{})
+	if err := conn.SetReadDeadline(time.Now().Add(time.Duration(rounds)*traceRoundInterval + traceMaxHops*traceProbeSpacing + timeout)); err != nil {
+		return nil, false, err
+	}
+	go func() {
+		defer close(done)
+		buf := make([]byte, 1500)
+		for {
+			n, peer, err := conn.ReadFrom(buf)
+			if err != nil {
+				var netErr net.Error
+				if !errors.As(err, &netErr) || !netErr.Timeout() {
+					mu.Lock()
+					readErr = err
+					mu.Unlock()
+				}
+				return
+			}
+			arrived := time.Now()
+			msg, err := icmp.ParseMessage(protocol, buf[:n])
+			if err != nil {
+				continue
+			}
+			seq, final, fromDest := -1, false, false
+			switch body := msg.Body.(type) {
+			case *icmp.Echo:
+				if (msg.Type == ipv4.ICMPTypeEchoReply || msg.Type == ipv6.ICMPTypeEchoReply) && body.ID == id && bytes.Equal(body.Data, token) {
+					seq, final, fromDest = body.Seq, true, true
+				}
+			case *icmp.TimeExceeded:
+				seq = quotedEchoSeq(body.Data, v6, id)
+			case *icmp.DstUnreach:
+				seq, final = quotedEchoSeq(body.Data, v6, id), true
+			}
+			mu.Lock()
+			start, ok := sentAt[seq]
+			if _, answered := replies[seq]; ok && !answered && arrived.Sub(start) <= timeout {
+				from := ""
+				if addr, ok := peer.(*net.IPAddr); ok {
+					from = addr.IP.String()
+				}
+				replies[seq] = traceReply{from: from, rtt: arrived.Sub(start)}
+				if ttl := seq%traceMaxHops + 1; final && (destTTL == 0 || ttl < destTTL) {
+					destTTL, reached = ttl, fromDest
+				}
+			}
+			mu.Unlock()
+		}
+	}()
+
+	var sendErr error
+	next := time.Now()
+send:
+	for round := 0; round < rounds; round++ {
+		select {
+		case <-ctx.Done():
+			break send
+		case <-time.After(time.Until(next)):
+		}
+		next = time.Now().Add(traceRoundInterval)
+		mu.Lock()
+		limit := destTTL
+		mu.Unlock()
+		if limit == 0 {
+			limit = traceMaxHops
+		}
+		for ttl := 1; ttl <= limit; ttl++ {
+			seq := round*traceMaxHops + ttl - 1
+			msg := icmp.Message{Type: echoType, Body: &icmp.Echo{ID: id, Seq: seq, Data: token}}
+			packet, err := msg.Marshal(nil)
+			if err == nil {
+				err = setTTL(ttl)
+			}
+			if err != nil {
+				sendErr = err
+				break send
+			}
+			mu.Lock()
+			sentAt[seq] = time.Now()
+			mu.Unlock()
+			if _, err := conn.WriteTo(packet, &net.IPAddr{IP: ip}); err != nil {
+				sendErr = err
+				break send
+			}
+			select {
+			case <-ctx.Done():
+				break send
+			case <-time.After(traceProbeSpacing):
+			}
+		}
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	<-done
+
+	upper := destTTL
+	if upper == 0 {
+		for seq := range replies {
+			upper = max(upper, seq%traceMaxHops+1)
+		}
+	}
+	hops := make([]TraceHop, 0, upper)
+	for ttl := 1; ttl <= upper; ttl++ {
+		hop := TraceHop{TTL: ttl}
+		var rtts []float64
+		for round := 0; round < rounds; round++ {
+			seq := round*traceMaxHops + ttl - 1
+			if _, ok := sentAt[seq]; !ok {
+				continue
+			}
+			hop.Sent++
+			if reply, ok := replies[seq]; ok {
+				if hop.Address == "" {
+					hop.Address = reply.from
+				}
+				rtts = append(rtts, float64(reply.rtt.Microseconds())/1000)
+			}
+		}
+		hop.Received = len(rtts)
+		if len(rtts) > 0 {
+			var stats PingResult
+			fillRTTStats(&stats, rtts)
+			hop.LastMs = roundTo(rtts[len(rtts)-1], 3)
+			hop.AvgMs = roundTo(stats.LatencyMs, 3)
+			hop.BestMs = roundTo(stats.MinMs, 3)
+			hop.WorstMs = roundTo(stats.MaxMs, 3)
+			hop.StdevMs = roundTo(stats.MdevMs, 3)
+		}
+		hops = append(hops, hop)
+	}
+	if sendErr != nil {
+		return hops, reached, sendErr
+	}
+	if readErr != nil {
+		return hops, reached, readErr
+	}
+	if len(hops) == 0 && ctx.Err() == nil {
+		return nil, false, fmt.Errorf("no replies from %s or any router towards it", ip)
+	}
+	return hops, reached, ctx.Err()
+}
+
+// quotedEchoSeq extracts the sequence number of our echo request from the original datagram that
+// an ICMP error quotes, or returns -1 when the error is about someone else's packet.
+func quotedEchoSeq(data []byte, v6 bool, id int) int {
+	headerLen, echoType := 40, byte(ipv6.ICMPTypeEchoRequest)
+	if !v6 {
+		if len(data) == 0 {
+			return -1
+		}
+		headerLen, echoType = int(data[0]&0x0f)*4, byte(ipv4.ICMPTypeEcho)
+	}
+	if len(data) < headerLen+8 {
+		return -1
+	}
+	quoted := data[headerLen:]
+	if quoted[0] != echoType || int(binary.BigEndian.Uint16(quoted[4:6])) != id {
+		return -1
+	}
+	return int(binary.BigEndian.Uint16(quoted[6:8]))
+}
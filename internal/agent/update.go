@@ -0,0 +1,291 @@
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"nebula_manager/internal/utils"
+)
+
+// Version is the agent build, set at link time with
+// -ldflags "-X nebula_manager/internal/agent.Version=<version>".
+var Version = "dev"
+
+// ErrRestart is returned by Run after the agent replaced or restored its own binary. The service
+// manager is expected to start the binary now on disk.
+var ErrRestart = errors.New("agent binary replaced, restart required")
+
+const (
+	updateStateFile = "update.json"
+	// Files next to the executable: the download being verified and the build it replaced.
+	updateNewSuffix      = ".new"
+	updatePreviousSuffix = ".previous"
+	// updateMaxStarts rolls back an updated build that keeps exiting before it is confirmed.
+	updateMaxStarts = 3
+	// updateTrialCycles rolls back an updated build that runs but cannot deliver its data.
+	updateTrialCycles = 5
+	// maxAgentBinarySize bounds a downloaded build; real ones are around 10 MB.
+	maxAgentBinarySize = 256 << 20
+	// updateSmokeTimeout is how long a downloaded build gets to print its version.
+	updateSmokeTimeout = 10 * time.Second
+)
+
+// AgentInfo identifies the running build, as reported with each status.
+type AgentInfo struct {
+	Version     string `json:"version"`
+	Platform    string `json:"platform"`
+	Checksum    string `json:"checksum"`
+	UpdateError string `json:"update_error,omitempty"`
+}
+
+// Release is the controller's current build for a platform, as served by GET /api/agent/release/:platform.
+type Release struct {
+	Version string `json:"version"`
+	SHA256  string `json:"sha256"`
+	Size    int64  `json:"size"`
+}
+
+// updateState carries a self-update across the restart into the new build. Pending is the checksum
+// of an installed build that has not proven itself yet; Failed is the last build that was rolled back
+// or did not run, which is not installed again.
+type updateState struct {
+	Pending string `json:"pending,omitempty"`
+	Starts  int    `json:"starts,omitempty"`
+	Failed  string `json:"failed,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// updater knows the running binary and the state of its self-update. Path is empty when the binary
+// cannot be located, which disables updates.
+type updater struct {
+	path      string
+	checksum  string
+	stateFile string
+	state     updateState
+	// trialCycles counts the cycles in which an updated build failed to deliver its data.
+	trialCycles int
+	lastCheck   time.Time
+}
+
+func newUpdater(stateDir string) *updater {
+	u := &updater{stateFile: filepath.Join(stateDir, updateStateFile)}
+	path, err := os.Executable()
+	if err == nil {
+		path, err = filepath.EvalSymlinks(path)
+	}
+	var content []byte
+	if err == nil {
+		content, err = os.ReadFile(path)
+	}
+	if err != nil {
+		log.Printf("agent: cannot read own binary, self-update disabled: %v", err)
+		return u
+	}
+	u.path, u.checksum = path, utils.ArtifactHash(content)
+	raw, err := os.ReadFile(u.stateFile)
+	if err != nil {
+		return u
+	}
+	if err := json.Unmarshal(raw, &u.state); err != nil {
+		log.Printf("agent: discard update state: %v", err)
+		u.state = updateState{}
+	}
+	return u
+}
+
+func agentPlatform() string {
+	return runtime.GOOS + "-" + runtime.GOARCH
+}
+
+func (a *Agent) agentInfo() *AgentInfo {
+	return &AgentInfo{
+		Version:     Version,
+		Platform:    agentPlatform(),
+		Checksum:    a.updater.checksum,
+		UpdateError: a.updater.state.Error,
+	}
+}
+
+// startUpdateTrial runs when the agent starts. It counts the starts of an updated build on trial and
+// rolls it back when it keeps exiting before it was confirmed.
+func (a *Agent) startUpdateTrial() error {
+	u := a.updater
+	if u.path == "" || u.state.Pending == "" {
+		return nil
+	}
+	if u.state.Pending != u.checksum {
+		// The binary was replaced by other means since the update.
+		u.state.Pending, u.state.Starts = "", 0
+		u.save()
+		return nil
+	}
+	u.state.Starts++
+	if u.state.Starts > updateMaxStarts {
+		if u.rollback(fmt.Sprintf("agent %s exited %d times before it was confirmed", Version, u.state.Starts-1)) {
+			return ErrRestart
+		}
+		return nil
+	}
+	u.save()
+	log.Printf("agent: running updated build %s on trial", Version)
+	return nil
+}
+
+// updateCycle runs after every cycle. An updated build on trial is confirmed once a cycle delivered
+// its data and the controller answers, or rolled back after updateTrialCycles failures; otherwise the
+// controller is asked for a new release every UpdateInterval. It returns ErrRestart when the binary on
+// disk changed.
+func (a *Agent) updateCycle(ctx context.Context, cycleErr error) error {
+	u := a.updater
+	if u.path == "" {
+		return nil
+	}
+	if u.state.Pending != "" {
+		if cycleErr == nil {
+			_, cycleErr = a.client.AgentRelease(ctx, agentPlatform())
+		}
+		if cycleErr == nil {
+			log.Printf("agent: updated build %s confirmed", Version)
+			u.state = updateState{}
+			u.trialCycles = 0
+			u.save()
+			return nil
+		}
+		u.trialCycles++
+		if u.trialCycles >= updateTrialCycles {
+			reason := fmt.Sprintf("agent %s failed %d cycles after the update: %v", Version, u.trialCycles, cycleErr)
+			if u.rollback(reason) {
+				return ErrRestart
+			}
+		}
+		return nil
+	}
+	if !a.cfg.AutoUpdate || time.Since(u.lastCheck) < a.cfg.UpdateInterval {
+		return nil
+	}
+	u.lastCheck = time.Now()
+	if a.selfUpdate(ctx) {
+		return ErrRestart
+	}
+	return nil
+}
+
+// selfUpdate installs the controller's release when it differs from the running build, and reports
+// whether it did.
+func (a *Agent) selfUpdate(ctx context.Context) bool {
+	u := a.updater
+	release, err := a.client.AgentRelease(ctx, agentPlatform())
+	if err != nil {
+		log.Printf("agent: check for update: %v", err)
+		return false
+	}
+	if release.SHA256 == "" || release.SHA256 == u.checksum || release.SHA256 == u.state.Failed {
+		return false
+	}
+	label := release.Version
+	if label == "" {
+		label = shortVersion(release.SHA256)
+	}
+	log.Printf("agent: updating from %s to %s", Version, label)
+	if err := u.install(ctx, a.client, release); err != nil {
+		log.Printf("agent: update to %s: %v", label, err)
+		u.state.Error = fmt.Sprintf("update to %s: %v", label, err)
+		u.save()
+		return false
+	}
+	log.Printf("agent: installed %s, restarting", label)
+	return true
+}
+
+// install downloads release next to the running binary, verifies its checksum, makes sure it runs
+// and swaps it in atomically. The replaced build is kept for rollback.
+func (u *updater) install(ctx context.Context, c *client, release Release) error {
+	tmp := u.path + updateNewSuffix
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o755)
+	if err != nil {
+		return err
+	}
+	// Nothing is left to remove once the download was renamed into place.
+	defer os.Remove(tmp)
+	hash := sha256.New()
+	err = c.DownloadAgent(ctx, agentPlatform(), io.MultiWriter(file, hash), maxAgentBinarySize)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("download: %w", err)
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != release.SHA256 {
+		// The release may have been replaced during the download; the next check tries again.
+		return fmt.Errorf("checksum %s does not match the release", shortVersion(sum))
+	}
+
+	smokeCtx, cancel := context.WithTimeout(ctx, updateSmokeTimeout)
+	defer cancel()
+	if output, err := exec.CommandContext(smokeCtx, tmp, "-version").CombinedOutput(); err != nil {
+		u.state.Failed = release.SHA256
+		return fmt.Errorf("new build does not run: %v: %s", err, lastLine(output))
+	}
+
+	previous := u.path + updatePreviousSuffix
+	_ = os.Remove(previous)
+	if err := os.Link(u.path, previous); err != nil {
+		return fmt.Errorf("keep current build: %w", err)
+	}
+	u.state.Pending, u.state.Starts, u.state.Error = release.SHA256, 0, ""
+	if err := u.save(); err != nil {
+		u.state.Pending = ""
+		return err
+	}
+	if err := os.Rename(tmp, u.path); err != nil {
+		u.state.Pending = ""
+		u.save()
+		return err
+	}
+	return nil
+}
+
+// rollback restores the build replaced by the pending update and remembers the pending one as failed.
+// It reports whether the binary on disk changed.
+func (u *updater) rollback(reason string) bool {
+	log.Printf("agent: rolling back update: %s", reason)
+	u.state = updateState{Failed: u.state.Pending, Error: reason}
+	u.trialCycles = 0
+	if err := os.Rename(u.path+updatePreviousSuffix, u.path); err != nil {
+		log.Printf("agent: restore previous build: %v", err)
+		u.state.Error = fmt.Sprintf("%s; restoring the previous build failed: %v", reason, err)
+		u.save()
+		return false
+	}
+	u.save()
+	return true
+}
+
+// save writes the update state atomically; failures are logged and returned.
+func (u *updater) save() error {
+	raw, err := json.Marshal(u.state)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(u.stateFile), 0o700)
+	}
+	if err == nil {
+		tmp := u.stateFile + ".tmp"
+		if err = os.WriteFile(tmp, raw, 0o600); err == nil {
+			err = os.Rename(tmp, u.stateFile)
+		}
+	}
+	if err != nil {
+		log.Printf("agent: save update state: %v", err)
+	}
+	return err
+}
@@ -9,9 +9,13 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/joho/godotenv"
+
+	"nebula_manager/internal/models"
 )
 
 // Config holds runtime configuration for the application.
@@ -29,8 +33,49 @@ type Config struct {
 	AdminUsername       string
 	AdminPassword       string
 	SessionSecret       string
+	SessionIdleTimeout  time.Duration
+	SessionMaxLifetime  time.Duration
 	SessionSecureCookie bool
 	StaticAccessToken   string
+	LoginRateLimit      RateLimit
+	PublicRateLimit     RateLimit
+	AgentRateLimit      RateLimit
+	APIRateLimit        RateLimit
+	LoginMaxFailures    int
+	LoginUserFailures   int
+	LoginLockout        time.Duration
+	LoginMaxLockout     time.Duration
+	TOTPIssuer          string
+	OIDCIssuer          string
+	OIDCClientID        string
+	OIDCClientSecret    string
+	OIDCRedirectURL     string
+	OIDCScopes          []string
+	OIDCUsernameClaim   string
+	OIDCGroupsClaim     string
+	OIDCRoleMapping     map[string]string
+	OIDCDefaultRole     string
+	OIDCTrustIdPMFA     bool
+	PingRawRetention    time.Duration
+	Ping5mRetention     time.Duration
+	Ping1hRetention     time.Duration
+	StatusRetention     time.Duration
+	RetentionInterval   time.Duration
+	AlertInterval       time.Duration
+	HeartbeatInterval   time.Duration
+	StateEventRetention time.Duration
+	DiagnosticRetention time.Duration
+	MetricsToken        string
+	MetricsPublic       bool
+	MetricsLinkWindow   time.Duration
+	AgentDir            string
+	TrustedProxies      []string
+}
+
+// RateLimit describes a request budget of Requests per Per. A zero budget disables limiting.
+type RateLimit struct {
+	Requests int
+	Per      time.Duration
 }
 
 var (
@@ -76,8 +121,43 @@ func Load() *Config {
 			AdminUsername:       fallback(os.Getenv("NEBULA_ADMIN_USERNAME"), "admin"),
 			AdminPassword:       fallback(os.Getenv("NEBULA_ADMIN_PASSWORD"), "admin123"),
 			SessionSecret:       fallback(os.Getenv("NEBULA_SESSION_SECRET"), randomSecret()),
+			SessionIdleTimeout:  durationFromEnv(os.Getenv("NEBULA_SESSION_IDLE_TIMEOUT"), 12*time.Hour),
+			SessionMaxLifetime:  durationFromEnv(os.Getenv("NEBULA_SESSION_MAX_LIFETIME"), 7*24*time.Hour),
 			SessionSecureCookie: boolFromEnv(os.Getenv("NEBULA_SESSION_SECURE")),
 			StaticAccessToken:   os.Getenv("NEBULA_STATIC_TOKEN"),
+			LoginRateLimit:      rateLimitFromEnv(os.Getenv("NEBULA_RATE_LIMIT_LOGIN"), RateLimit{Requests: 10, Per: time.Minute}),
+			PublicRateLimit:     rateLimitFromEnv(os.Getenv("NEBULA_RATE_LIMIT_PUBLIC"), RateLimit{Requests: 120, Per: time.Minute}),
+			AgentRateLimit:      rateLimitFromEnv(os.Getenv("NEBULA_RATE_LIMIT_AGENT"), RateLimit{Requests: 300, Per: time.Minute}),
+			APIRateLimit:        rateLimitFromEnv(os.Getenv("NEBULA_RATE_LIMIT_API"), RateLimit{Requests: 600, Per: time.Minute}),
+			LoginMaxFailures:    intFromEnv(os.Getenv("NEBULA_LOGIN_MAX_FAILURES"), 5),
+			LoginUserFailures:   intFromEnv(os.Getenv("NEBULA_LOGIN_USER_MAX_FAILURES"), 20),
+			LoginLockout:        durationFromEnv(os.Getenv("NEBULA_LOGIN_LOCKOUT"), time.Minute),
+			LoginMaxLockout:     durationFromEnv(os.Getenv("NEBULA_LOGIN_MAX_LOCKOUT"), time.Hour),
+			TOTPIssuer:          fallback(os.Getenv("NEBULA_TOTP_ISSUER"), "Nebula Manager"),
+			OIDCIssuer:          os.Getenv("NEBULA_OIDC_ISSUER"),
+			OIDCClientID:        os.Getenv("NEBULA_OIDC_CLIENT_ID"),
+			OIDCClientSecret:    os.Getenv("NEBULA_OIDC_CLIENT_SECRET"),
+			OIDCRedirectURL:     fallback(os.Getenv("NEBULA_OIDC_REDIRECT_URL"), strings.TrimRight(apiBase, "/")+"/api/oidc/callback"),
+			OIDCScopes:          strings.Fields(fallback(os.Getenv("NEBULA_OIDC_SCOPES"), "openid profile email groups")),
+			OIDCUsernameClaim:   fallback(os.Getenv("NEBULA_OIDC_USERNAME_CLAIM"), "preferred_username"),
+			OIDCGroupsClaim:     fallback(os.Getenv("NEBULA_OIDC_GROUPS_CLAIM"), "groups"),
+			OIDCRoleMapping:     roleMappingFromEnv(os.Getenv("NEBULA_OIDC_ROLE_MAPPING")),
+			OIDCDefaultRole:     checkedRole("NEBULA_OIDC_DEFAULT_ROLE", strings.ToLower(strings.TrimSpace(os.Getenv("NEBULA_OIDC_DEFAULT_ROLE")))),
+			OIDCTrustIdPMFA:     boolFromEnv(os.Getenv("NEBULA_OIDC_TRUST_IDP_MFA")),
+			PingRawRetention:    durationFromEnv(os.Getenv("NEBULA_PING_RAW_RETENTION"), 48*time.Hour),
+			Ping5mRetention:     durationFromEnv(os.Getenv("NEBULA_PING_5M_RETENTION"), 14*24*time.Hour),
+			Ping1hRetention:     durationFromEnv(os.Getenv("NEBULA_PING_1H_RETENTION"), 180*24*time.Hour),
+			StatusRetention:     durationFromEnv(os.Getenv("NEBULA_STATUS_HISTORY_RETENTION"), 30*24*time.Hour),
+			RetentionInterval:   durationFromEnv(os.Getenv("NEBULA_RETENTION_INTERVAL"), 5*time.Minute),
+			AlertInterval:       durationFromEnv(os.Getenv("NEBULA_ALERT_INTERVAL"), time.Minute),
+			HeartbeatInterval:   durationFromEnv(os.Getenv("NEBULA_HEARTBEAT_INTERVAL"), time.Minute),
+			StateEventRetention: durationFromEnv(os.Getenv("NEBULA_STATE_EVENT_RETENTION"), 90*24*time.Hour),
+			DiagnosticRetention: durationFromEnv(os.Getenv("NEBULA_DIAGNOSTIC_RETENTION"), 90*24*time.Hour),
+			MetricsToken:        os.Getenv("NEBULA_METRICS_TOKEN"),
+			MetricsPublic:       boolFromEnv(os.Getenv("NEBULA_METRICS_PUBLIC")),
+			MetricsLinkWindow:   durationFromEnv(os.Getenv("NEBULA_METRICS_LINK_WINDOW"), 5*time.Minute),
+			AgentDir:            fallback(os.Getenv("NEBULA_AGENT_DIR"), "agent"),
+			TrustedProxies:      trustedProxiesFromEnv(os.Getenv("NEBULA_TRUSTED_PROXIES")),
 		}
 	})
 	return cfg
@@ -101,6 +181,116 @@ func boolFromEnv(val string) bool {
 	return parsed
 }
 
+// durationFromEnv parses Go durations and additionally accepts whole days such as "14d".
+func durationFromEnv(val string, defaultVal time.Duration) time.Duration {
+	if val == "" {
+		return defaultVal
+	}
+	if days, ok := strings.CutSuffix(strings.TrimSpace(val), "d"); ok {
+		count, err := strconv.Atoi(days)
+		if err != nil || count <= 0 {
+			return defaultVal
+		}
+		return time.Duration(count) * 24 * time.Hour
+	}
+	parsed, err := time.ParseDuration(val)
+	if err != nil || parsed <= 0 {
+		return defaultVal
+	}
+	return parsed
+}
+
+func intFromEnv(val string, defaultVal int) int {
+	if val == "" {
+		return defaultVal
+	}
+	parsed, err := strconv.Atoi(strings.TrimSpace(val))
+	if err != nil {
+		return defaultVal
+	}
+	return parsed
+}
+
+// rateLimitFromEnv parses budgets such as "10/m", "300/1h" or "5/30s"; "off" or "0" disables the limit.
+func rateLimitFromEnv(val string, defaultVal RateLimit) RateLimit {
+	val = strings.ToLower(strings.TrimSpace(val))
+	if val == "" {
+		return defaultVal
+	}
+	if val == "off" || val == "0" {
+		return RateLimit{}
+	}
+	countPart, unitPart, ok := strings.Cut(val, "/")
+	if !ok {
+		return defaultVal
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(countPart))
+	if err != nil || count < 0 {
+		return defaultVal
+	}
+	var per time.Duration
+	switch unitPart = strings.TrimSpace(unitPart); unitPart {
+	case "s", "sec", "second":
+		per = time.Second
+	case "m", "min", "minute":
+		per = time.Minute
+	case "h", "hour":
+		per = time.Hour
+	default:
+		parsed, err := time.ParseDuration(unitPart)
+		if err != nil || parsed <= 0 {
+			return defaultVal
+		}
+		per = parsed
+	}
+	return RateLimit{Requests: count, Per: per}
+}
+
+// roleMappingFromEnv parses "group=role" pairs separated by commas, e.g. "nebula-admins=admin,ops=viewer".
+// A role the console does not know is a configuration error rather than a silently stored string.
+func roleMappingFromEnv(val string) map[string]string {
+	mapping := make(map[string]string)
+	for _, pair := range strings.Split(val, ",") {
+		group, role, ok := strings.Cut(pair, "=")
+		group, role = strings.TrimSpace(group), strings.ToLower(strings.TrimSpace(role))
+		if !ok || group == "" || role == "" {
+			continue
+		}
+		mapping[group] = checkedRole("NEBULA_OIDC_ROLE_MAPPING", role)
+	}
+	return mapping
+}
+
+// checkedRole exits on roles other than the console's; an empty role passes through.
+func checkedRole(name, role string) string {
+	switch role {
+	case "", models.UserRoleAdmin, models.UserRoleViewer:
+		return role
+	}
+	log.Fatalf("%s: unknown role %q (want %s or %s)", name, role, models.UserRoleAdmin, models.UserRoleViewer)
+	return ""
+}
+
+// trustedProxiesFromEnv parses comma separated IPs or CIDRs of the reverse proxies whose
+// X-Forwarded-For header is believed. Invalid entries are skipped; nil trusts no proxy.
+func trustedProxiesFromEnv(val string) []string {
+	var proxies []string
+	for _, entry := range strings.Split(val, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if net.ParseIP(entry) == nil {
+			if _, _, err := net.ParseCIDR(entry); err != nil {
+				log.Printf("config: ignore invalid trusted proxy %q", entry)
+				continue
+			}
+		}
+		proxies = append(proxies, entry)
+	}
+	return proxies
+}
+
 func randomSecret() string {
 	buf := make([]byte, 32)
 	if _, err := rand.Read(buf); err != nil {
@@ -9,7 +9,45 @@ import (
 // AutoMigrate runs Gorm migrations for the application's models.
 func AutoMigrate() {
 	conn := DB()
-	if err := conn.AutoMigrate(&models.CA{}, &models.ConfigTemplate{}, &models.NetworkSetting{}, &models.Node{}, &models.NodePing{}, &models.NodeStatus{}); err != nil {
+	if err := conn.AutoMigrate(
+		&models.CA{},
+		&models.ConfigTemplate{},
+		&models.NetworkSetting{},
+		&models.Node{},
+		&models.NodePing{},
+		&models.NodePingRollup{},
+		&models.PingRollupCursor{},
+		&models.NodeStatus{},
+		&models.NodeStatusSample{},
+		&models.NodeStateEvent{},
+		&models.NodeDeployment{},
+		&models.NodeAgent{},
+		&models.NodeCertificate{},
+		&models.NodeHostmap{},
+		&models.NodeTunnel{},
+		&models.ProbeDefinition{},
+		&models.ProbeResult{},
+		&models.DiagnosticJob{},
+		&models.AuditLog{},
+		&models.Session{},
+		&models.User{},
+		&models.SecuritySetting{},
+		&models.AlertRule{},
+		&models.Alert{},
+		&models.AlertSilence{},
+		&models.NotificationChannel{},
+		&models.NotificationDelivery{},
+		&models.StatusPageSetting{},
+		&models.StatusPageNode{},
+		&models.StatusIncident{},
+	); err != nil {
 		log.Fatalf("auto migration failed: %v", err)
 	}
+	// Rollups are keyed by path since underlay samples are stored next to overlay ones; the old key
+	// would make both paths' buckets overwrite each other.
+	if conn.Migrator().HasIndex(&models.NodePingRollup{}, "idx_rollup_pair_bucket") {
+		if err := conn.Migrator().DropIndex(&models.NodePingRollup{}, "idx_rollup_pair_bucket"); err != nil {
+			log.Fatalf("drop legacy rollup index: %v", err)
+		}
+	}
 }
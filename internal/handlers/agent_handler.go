@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+
+	"nebula_manager/internal/services"
+)
+
+// AgentHandler serves the node agent builds to install scripts and agents, and reports which build
+// every node runs.
+type AgentHandler struct {
+	service *services.AgentService
+}
+
+// NewAgentHandler constructs an AgentHandler.
+func NewAgentHandler(service *services.AgentService) *AgentHandler {
+	return &AgentHandler{service: service}
+}
+
+// Binary downloads the agent build for the requested platform.
+func (h *AgentHandler) Binary(c *gin.Context) {
+	path, err := h.service.BinaryPath(c.Param("platform"))
+	if err != nil {
+		writeAgentError(c, err)
+		return
+	}
+	c.FileAttachment(path, filepath.Base(path))
+}
+
+// Script downloads the shell probe for nodes without an agent build.
+func (h *AgentHandler) Script(c *gin.Context) {
+	c.Data(http.StatusOK, "text/x-shellscript; charset=utf-8", h.service.Script())
+}
+
+// Release describes the current build for a platform, or for the shell probe with platform "script".
+func (h *AgentHandler) Release(c *gin.Context) {
+	release, err := h.service.Release(c.Param("platform"))
+	if err != nil {
+		writeAgentError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": release})
+}
+
+// Releases lists the current build of every platform.
+func (h *AgentHandler) Releases(c *gin.Context) {
+	releases, err := h.service.Releases()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": releases})
+}
+
+// Fleet reports the agent build of every node. ?outdated=true limits it to nodes running another
+// build than the current release.
+func (h *AgentHandler) Fleet(c *gin.Context) {
+	fleet, err := h.service.Fleet(c.Query("outdated") == "true")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": fleet})
+}
+
+func writeAgentError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidAgentPlatform):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrAgentReleaseNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"nebula_manager/internal/middleware"
+	"nebula_manager/internal/services"
+)
+
+// AlertHandler exposes alert rules, active alerts and silences.
+type AlertHandler struct {
+	service *services.AlertService
+	audit   *services.AuditService
+}
+
+// NewAlertHandler constructs an AlertHandler.
+func NewAlertHandler(service *services.AlertService, audit *services.AuditService) *AlertHandler {
+	return &AlertHandler{service: service, audit: audit}
+}
+
+// List returns alerts, by default the active (pending and firing) ones.
+func (h *AlertHandler) List(c *gin.Context) {
+	filter := services.AlertFilter{State: c.Query("state")}
+	if val := c.Query("node_id"); val != "" {
+		id, err := parseUintParam(val)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid node id"})
+			return
+		}
+		filter.NodeID = id
+	}
+	alerts, err := h.service.ListAlerts(filter)
+	if err != nil {
+		writeAlertError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": alerts})
+}
+
+// ListRules returns all alert rules.
+func (h *AlertHandler) ListRules(c *gin.Context) {
+	rules, err := h.service.ListRules()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": rules})
+}
+
+// CreateRule adds an alert rule.
+func (h *AlertHandler) CreateRule(c *gin.Context) {
+	var req services.AlertRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rule, err := h.service.CreateRule(req)
+	if err != nil {
+		recordAudit(h.audit, c, services.AuditActionAlertRuleUpsert, req.Name, err.Error(), false)
+		writeAlertError(c, err)
+		return
+	}
+	recordAudit(h.audit, c, services.AuditActionAlertRuleUpsert, alertRuleAuditTarget(rule.ID, rule.Name), "created", true)
+	c.JSON(http.StatusCreated, gin.H{"data": rule})
+}
+
+// UpdateRule replaces an alert rule.
+func (h *AlertHandler) UpdateRule(c *gin.Context) {
+	id, err := parseUintParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid rule id"})
+		return
+	}
+	var req services.AlertRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	before, err := h.service.GetRule(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	snapshot := *before
+	rule, err := h.service.UpdateRule(id, req)
+	if err != nil {
+		writeAlertError(c, err)
+		return
+	}
+	recordAudit(h.audit, c, services.AuditActionAlertRuleUpsert, alertRuleAuditTarget(rule.ID, rule.Name), services.SummarizeChanges(snapshot, *rule), true)
+	c.JSON(http.StatusOK, gin.H{"data": rule})
+}
+
+// DeleteRule removes an alert rule.
+func (h *AlertHandler) DeleteRule(c *gin.Context) {
+	id, err := parseUintParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid rule id"})
+		return
+	}
+	rule, err := h.service.GetRule(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.service.DeleteRule(id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(h.audit, c, services.AuditActionAlertRuleDelete, alertRuleAuditTarget(rule.ID, rule.Name), "", true)
+	c.JSON(http.StatusOK, gin.H{"data": "ok"})
+}
+
+// ListSilences returns active silences, or all of them with ?all=true.
+func (h *AlertHandler) ListSilences(c *gin.Context) {
+	silences, err := h.service.ListSilences(c.Query("all") == "true")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": silences})
+}
+
+// CreateSilence mutes matching alerts for a while.
+func (h *AlertHandler) CreateSilence(c *gin.Context) {
+	var req services.AlertSilenceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	silence, err := h.service.CreateSilence(req, c.GetString(middleware.ContextUserKey))
+	if err != nil {
+		writeAlertError(c, err)
+		return
+	}
+	summary := fmt.Sprintf("rule=%d node=%d until %s %s", silence.RuleID, silence.NodeID, silence.EndsAt.UTC().Format("2006-01-02T15:04:05Z"), silence.Comment)
+	recordAudit(h.audit, c, services.AuditActionAlertSilence, fmt.Sprintf("silence#%d", silence.ID), summary, true)
+	c.JSON(http.StatusCreated, gin.H{"data": silence})
+}
+
+// ExpireSilence ends a silence early.
+func (h *AlertHandler) ExpireSilence(c *gin.Context) {
+	id, err := parseUintParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid silence id"})
+		return
+	}
+	if err := h.service.ExpireSilence(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(h.audit, c, services.AuditActionAlertUnsilence, fmt.Sprintf("silence#%d", id), "", true)
+	c.JSON(http.StatusOK, gin.H{"data": "ok"})
+}
+
+func alertRuleAuditTarget(id uint, name string) string {
+	return fmt.Sprintf("alert_rule#%d %s", id, name)
+}
+
+func writeAlertError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrAlertRuleInvalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"nebula_manager/internal/middleware"
+	"nebula_manager/internal/services"
+)
+
+// AuditHandler exposes the audit log query and export endpoints.
+type AuditHandler struct {
+	service *services.AuditService
+}
+
+// NewAuditHandler constructs an AuditHandler.
+func NewAuditHandler(service *services.AuditService) *AuditHandler {
+	return &AuditHandler{service: service}
+}
+
+// List returns a filtered, paginated page of audit entries.
+func (h *AuditHandler) List(c *gin.Context) {
+	filter, err := parseAuditFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	page, err := h.service.List(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": page.Logs, "total": page.Total, "page": page.Page, "page_size": page.PageSize})
+}
+
+// Export downloads audit entries matching the filter as CSV or JSON.
+func (h *AuditHandler) Export(c *gin.Context) {
+	filter, err := parseAuditFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	format := strings.ToLower(c.DefaultQuery("format", "json"))
+	if format != "json" && format != "csv" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv or json"})
+		return
+	}
+
+	logs, err := h.service.Export(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(h.service, c, services.AuditActionAuditExport, format, fmt.Sprintf("%d entries", len(logs)), true)
+
+	filename := fmt.Sprintf("nebula-audit-%s.%s", time.Now().UTC().Format("20060102-150405"), format)
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	if format == "json" {
+		c.JSON(http.StatusOK, gin.H{"data": logs})
+		return
+	}
+
+	c.Status(http.StatusOK)
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	w := csv.NewWriter(c.Writer)
+	_ = w.Write([]string{"id", "created_at", "actor", "source_ip", "action", "target", "success", "summary"})
+	for _, entry := range logs {
+		_ = w.Write([]string{
+			strconv.FormatUint(uint64(entry.ID), 10),
+			entry.CreatedAt.UTC().Format(time.RFC3339),
+			entry.Actor,
+			entry.SourceIP,
+			entry.Action,
+			entry.Target,
+			strconv.FormatBool(entry.Success),
+			entry.Summary,
+		})
+	}
+	w.Flush()
+}
+
+func parseAuditFilter(c *gin.Context) (services.AuditFilter, error) {
+	filter := services.AuditFilter{
+		Actor:  c.Query("actor"),
+		Action: c.Query("action"),
+		Target: c.Query("target"),
+	}
+	if val := c.Query("from"); val != "" {
+		parsed, err := time.Parse(time.RFC3339, val)
+		if err != nil {
+			return filter, fmt.Errorf("invalid from timestamp: %s", val)
+		}
+		filter.From = parsed
+	}
+	if val := c.Query("to"); val != "" {
+		parsed, err := time.Parse(time.RFC3339, val)
+		if err != nil {
+			return filter, fmt.Errorf("invalid to timestamp: %s", val)
+		}
+		filter.To = parsed
+	}
+	filter.Page, _ = strconv.Atoi(c.DefaultQuery("page", "1"))
+	if filter.Page < 1 {
+		filter.Page = 1
+	}
+	filter.PageSize, _ = strconv.Atoi(c.DefaultQuery("page_size", "50"))
+	return filter, nil
+}
+
+// recordAudit appends an audit entry for the current request. Failures are logged, never surfaced.
+func recordAudit(audit *services.AuditService, c *gin.Context, action, target, summary string, success bool) {
+	if audit == nil {
+		return
+	}
+	actor := ""
+	if user, ok := c.Get(middleware.ContextUserKey); ok {
+		actor, _ = user.(string)
+	}
+	recordAuditAs(audit, c, actor, action, target, summary, success)
+}
+
+func recordAuditAs(audit *services.AuditService, c *gin.Context, actor, action, target, summary string, success bool) {
+	if audit == nil {
+		return
+	}
+	err := audit.Record(services.AuditEntry{
+		Actor:    actor,
+		SourceIP: c.ClientIP(),
+		Action:   action,
+		Target:   target,
+		Summary:  summary,
+		Success:  success,
+	})
+	if err != nil {
+		log.Printf("audit: failed to record %s: %v", action, err)
+	}
+}
@@ -1,23 +1,30 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
 
 	"nebula_manager/internal/middleware"
+	"nebula_manager/internal/models"
 	"nebula_manager/internal/services"
 )
 
 // AuthHandler exposes login/logout endpoints.
 type AuthHandler struct {
 	service *services.AuthService
+	users   *services.UserService
+	audit   *services.AuditService
+	guard   *services.LoginGuard
 }
 
 // NewAuthHandler constructs an AuthHandler.
-func NewAuthHandler(service *services.AuthService) *AuthHandler {
-	return &AuthHandler{service: service}
+func NewAuthHandler(service *services.AuthService, users *services.UserService, audit *services.AuditService, guard *services.LoginGuard) *AuthHandler {
+	return &AuthHandler{service: service, users: users, audit: audit, guard: guard}
 }
 
 type loginRequest struct {
@@ -33,28 +40,193 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
+	clientIP := c.ClientIP()
+	if locked, retryAfter := h.guard.Check(req.Username, clientIP); locked {
+		recordAuditAs(h.audit, c, req.Username, services.AuditActionLogin, req.Username, "rejected: locked out", false)
+		middleware.AbortTooManyRequests(c, retryAfter)
+		return
+	}
+
 	if !h.service.ValidateCredentials(req.Username, req.Password) {
+		summary := "invalid credentials"
+		if lockout := h.guard.RecordFailure(req.Username, clientIP); lockout > 0 {
+			summary = fmt.Sprintf("invalid credentials, locked out for %s", lockout.Round(time.Second))
+		}
+		recordAuditAs(h.audit, c, req.Username, services.AuditActionLogin, req.Username, summary, false)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
 		return
 	}
+	h.guard.RecordSuccess(req.Username, clientIP)
+
+	user, err := h.users.EnsureUser(req.Username, models.UserRoleAdmin)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	stage, mfaToken, err := h.users.BeginLoginChallenge(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if stage != "" {
+		recordAuditAs(h.audit, c, req.Username, services.AuditActionLogin, req.Username, "password accepted, second factor pending ("+stage+")", true)
+		c.JSON(http.StatusOK, gin.H{"data": gin.H{"username": req.Username, "mfa_required": true, "mfa_stage": stage, "mfa_token": mfaToken}})
+		return
+	}
+
+	h.completeLogin(c, user, "", nil)
+}
+
+type mfaRequest struct {
+	MFAToken     string `json:"mfa_token" binding:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// LoginTwoFactorEnroll returns a TOTP secret for users that must enroll before their first session.
+func (h *AuthHandler) LoginTwoFactorEnroll(c *gin.Context) {
+	var req mfaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	enrollment, err := h.users.StartChallengeEnrollment(req.MFAToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": enrollment})
+}
+
+// LoginTwoFactor completes a pending login with a TOTP or recovery code and issues the session.
+func (h *AuthHandler) LoginTwoFactor(c *gin.Context) {
+	var req mfaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user, recoveryCodes, err := h.users.CompleteLoginChallenge(req.MFAToken, req.Code, req.RecoveryCode)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidTwoFactorCode) || errors.Is(err, services.ErrMFAChallengeInvalid) {
+			recordAuditAs(h.audit, c, "", services.AuditActionLoginTwoFactor, "", err.Error(), false)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	summary := "totp"
+	if req.RecoveryCode != "" {
+		summary = "recovery code"
+	}
+	if recoveryCodes != nil {
+		summary = "enrolled during login"
+	}
+	h.completeLogin(c, user, summary, recoveryCodes)
+}
 
-	token, expiresAt, err := h.service.IssueToken(req.Username)
+// completeLogin issues a session for a fully authenticated user and writes the login response.
+func (h *AuthHandler) completeLogin(c *gin.Context, user *models.User, summary string, recoveryCodes []string) {
+	token, session, err := h.service.IssueToken(user.Username, user.Role, services.SessionMeta{
+		SourceIP:  c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to issue session"})
 		return
 	}
+	if err := h.users.MarkLogin(user); err != nil {
+		log.Printf("auth: failed to record login time for %s: %v", user.Username, err)
+	}
 
-	setSessionCookie(c, h.service.CookieName(), token, expiresAt, h.service.SecureCookies())
+	setSessionCookie(c, h.service.CookieName(), token, session.MaxExpiresAt, h.service.SecureCookies())
+	recordAuditAs(h.audit, c, user.Username, services.AuditActionLogin, user.Username, summary, true)
 
-	c.JSON(http.StatusOK, gin.H{"data": gin.H{"username": req.Username, "expires_at": expiresAt.UTC(), "token": token}})
+	data := gin.H{"username": user.Username, "role": user.Role, "expires_at": session.ExpiresAt.UTC(), "token": token}
+	if recoveryCodes != nil {
+		data["recovery_codes"] = recoveryCodes
+	}
+	c.JSON(http.StatusOK, gin.H{"data": data})
 }
 
-// Logout removes the session cookie.
+// Logout revokes the current session and removes the session cookie.
 func (h *AuthHandler) Logout(c *gin.Context) {
+	session, err := h.service.RevokeToken(middleware.ExtractToken(c, h.service.CookieName()))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if session != nil {
+		recordAuditAs(h.audit, c, session.Username, services.AuditActionLogout, sessionAuditTarget(session.ID), "", true)
+	}
 	setSessionCookie(c, h.service.CookieName(), "", time.Unix(0, 0), h.service.SecureCookies())
 	c.JSON(http.StatusOK, gin.H{"data": "ok"})
 }
 
+// Sessions lists the active sessions of the current user.
+func (h *AuthHandler) Sessions(c *gin.Context) {
+	username := c.GetString(middleware.ContextUserKey)
+	sessions, err := h.service.ListSessions(username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	currentID := c.GetUint(middleware.ContextSessionKey)
+	result := make([]gin.H, 0, len(sessions))
+	for _, session := range sessions {
+		result = append(result, gin.H{
+			"id":             session.ID,
+			"source_ip":      session.SourceIP,
+			"user_agent":     session.UserAgent,
+			"created_at":     session.CreatedAt,
+			"last_seen_at":   session.LastSeenAt,
+			"expires_at":     session.ExpiresAt,
+			"max_expires_at": session.MaxExpiresAt,
+			"current":        session.ID == currentID,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"data": result})
+}
+
+// RevokeSession revokes one of the current user's sessions.
+func (h *AuthHandler) RevokeSession(c *gin.Context) {
+	id, err := parseUintParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid session id"})
+		return
+	}
+	username := c.GetString(middleware.ContextUserKey)
+	if err := h.service.RevokeSession(username, id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(h.audit, c, services.AuditActionSessionRevoke, sessionAuditTarget(id), "", true)
+	if id == c.GetUint(middleware.ContextSessionKey) {
+		setSessionCookie(c, h.service.CookieName(), "", time.Unix(0, 0), h.service.SecureCookies())
+	}
+	c.JSON(http.StatusOK, gin.H{"data": "ok"})
+}
+
+// RevokeAllSessions logs the current user out everywhere, optionally keeping the calling session.
+func (h *AuthHandler) RevokeAllSessions(c *gin.Context) {
+	username := c.GetString(middleware.ContextUserKey)
+	currentID := c.GetUint(middleware.ContextSessionKey)
+	keepID := uint(0)
+	if c.Query("keep_current") == "true" {
+		keepID = currentID
+	}
+	revoked, err := h.service.RevokeAllSessions(username, keepID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(h.audit, c, services.AuditActionSessionRevokeAll, username, fmt.Sprintf("%d sessions revoked", revoked), true)
+	if keepID == 0 {
+		setSessionCookie(c, h.service.CookieName(), "", time.Unix(0, 0), h.service.SecureCookies())
+	}
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"revoked": revoked}})
+}
+
 // Profile returns current authenticated user.
 func (h *AuthHandler) Profile(c *gin.Context) {
 	user, ok := c.Get(middleware.ContextUserKey)
@@ -62,7 +234,11 @@ func (h *AuthHandler) Profile(c *gin.Context) {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"data": gin.H{"username": user}})
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"username": user, "role": c.GetString(middleware.ContextRoleKey)}})
+}
+
+func sessionAuditTarget(id uint) string {
+	return fmt.Sprintf("session#%d", id)
 }
 
 func setSessionCookie(c *gin.Context, name, value string, expiresAt time.Time, secure bool) {
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"fmt"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -12,11 +13,12 @@ import (
 // CAHandler exposes endpoints to manage certificate authorities.
 type CAHandler struct {
 	service *services.CAService
+	audit   *services.AuditService
 }
 
 // NewCAHandler creates a new handler instance.
-func NewCAHandler(service *services.CAService) *CAHandler {
-	return &CAHandler{service: service}
+func NewCAHandler(service *services.CAService, audit *services.AuditService) *CAHandler {
+	return &CAHandler{service: service, audit: audit}
 }
 
 // Get returns the current CA metadata.
@@ -43,9 +45,11 @@ func (h *CAHandler) Generate(c *gin.Context) {
 
 	ca, err := h.service.GenerateOrReplaceCA(req)
 	if err != nil {
+		recordAudit(h.audit, c, services.AuditActionCAGenerate, req.Name, err.Error(), false)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	recordAudit(h.audit, c, services.AuditActionCAGenerate, ca.Name, fmt.Sprintf("validity_days=%d", req.ValidityDays), true)
 	c.JSON(http.StatusOK, gin.H{"data": presentCA(ca)})
 }
 
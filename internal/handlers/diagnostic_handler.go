@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"nebula_manager/internal/middleware"
+	"nebula_manager/internal/services"
+)
+
+// DiagnosticHandler queues on-demand diagnostics and serves them to node agents.
+type DiagnosticHandler struct {
+	service *services.DiagnosticService
+	audit   *services.AuditService
+}
+
+// NewDiagnosticHandler constructs a DiagnosticHandler.
+func NewDiagnosticHandler(service *services.DiagnosticService, audit *services.AuditService) *DiagnosticHandler {
+	return &DiagnosticHandler{service: service, audit: audit}
+}
+
+// List returns jobs newest first, narrowed with ?node_id=, ?source_id=, ?target_id=, ?type=, ?status=
+// and ?limit= (default 50).
+func (h *DiagnosticHandler) List(c *gin.Context) {
+	filter := services.DiagnosticJobFilter{Type: c.Query("type"), Status: c.Query("status")}
+	for param, dst := range map[string]*uint{"node_id": &filter.NodeID, "source_id": &filter.SourceID, "target_id": &filter.TargetID} {
+		val := c.Query(param)
+		if val == "" {
+			continue
+		}
+		id, err := parseUintParam(val)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + param})
+			return
+		}
+		*dst = id
+	}
+	if val := c.Query("limit"); val != "" {
+		limit, err := strconv.Atoi(val)
+		if err != nil || limit < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return
+		}
+		filter.Limit = limit
+	}
+	jobs, err := h.service.List(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": jobs})
+}
+
+// Get returns one job with its result.
+func (h *DiagnosticHandler) Get(c *gin.Context) {
+	id, err := parseUintParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid job id"})
+		return
+	}
+	job, err := h.service.Get(id)
+	if err != nil {
+		writeDiagnosticError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": job})
+}
+
+// Create queues a job for the source node's agent.
+func (h *DiagnosticHandler) Create(c *gin.Context) {
+	var req services.DiagnosticJobRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	target := fmt.Sprintf("%s node#%d->node#%d", req.Type, req.SourceID, req.TargetID)
+	job, err := h.service.Create(req, c.GetString(middleware.ContextUserKey))
+	if err != nil {
+		recordAudit(h.audit, c, services.AuditActionDiagnosticRun, target, err.Error(), false)
+		writeDiagnosticError(c, err)
+		return
+	}
+	summary := fmt.Sprintf("%s %s -> %s over %s", job.Type, job.Source.Name, job.Target.Name, job.Path)
+	recordAudit(h.audit, c, services.AuditActionDiagnosticRun, diagnosticAuditTarget(job.ID), summary, true)
+	c.JSON(http.StatusCreated, gin.H{"data": job})
+}
+
+// Cancel stops a queued or running job.
+func (h *DiagnosticHandler) Cancel(c *gin.Context) {
+	id, err := parseUintParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid job id"})
+		return
+	}
+	job, err := h.service.Cancel(id)
+	if err != nil {
+		writeDiagnosticError(c, err)
+		return
+	}
+	recordAudit(h.audit, c, services.AuditActionDiagnosticCancel, diagnosticAuditTarget(job.ID), "", true)
+	c.JSON(http.StatusOK, gin.H{"data": job})
+}
+
+// Pending returns the tasks waiting for the node's agent.
+func (h *DiagnosticHandler) Pending(c *gin.Context) {
+	id, err := parseUintParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid node id"})
+		return
+	}
+	tasks, err := h.service.Pending(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": tasks})
+}
+
+// Start lets an agent claim its part of a job.
+func (h *DiagnosticHandler) Start(c *gin.Context) {
+	nodeID, jobID, ok := diagnosticAgentParams(c)
+	if !ok {
+		return
+	}
+	var req services.DiagnosticStartInput
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.service.Start(nodeID, jobID, req); err != nil {
+		writeDiagnosticError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": "ok"})
+}
+
+// Complete stores the result an agent uploads for a job.
+func (h *DiagnosticHandler) Complete(c *gin.Context) {
+	nodeID, jobID, ok := diagnosticAgentParams(c)
+	if !ok {
+		return
+	}
+	var req services.DiagnosticResultInput
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.service.Complete(nodeID, jobID, req); err != nil {
+		writeDiagnosticError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": "ok"})
+}
+
+func diagnosticAgentParams(c *gin.Context) (uint, uint, bool) {
+	nodeID, err := parseUintParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid node id"})
+		return 0, 0, false
+	}
+	jobID, err := parseUintParam(c.Param("job"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid job id"})
+		return 0, 0, false
+	}
+	return nodeID, jobID, true
+}
+
+func diagnosticAuditTarget(id uint) string {
+	return fmt.Sprintf("diagnostic#%d", id)
+}
+
+func writeDiagnosticError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidDiagnostic):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrDiagnosticNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrDiagnosticConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"nebula_manager/internal/services"
+)
+
+const (
+	eventStreamHeartbeat = 25 * time.Second
+	// eventStreamRetry is the reconnect delay suggested to EventSource clients, in milliseconds.
+	eventStreamRetry = 3000
+	// maxPublicEventStreams caps anonymous streams so that the public page cannot exhaust the controller.
+	maxPublicEventStreams = 200
+)
+
+// EventHandler streams hub events to browsers as Server-Sent Events.
+type EventHandler struct {
+	hub           *services.EventHub
+	statusPage    *services.StatusPageService
+	publicStreams atomic.Int64
+}
+
+// NewEventHandler constructs an EventHandler. The status page settings decide what the public
+// stream publishes.
+func NewEventHandler(hub *services.EventHub, statusPage *services.StatusPageService) *EventHandler {
+	return &EventHandler{hub: hub, statusPage: statusPage}
+}
+
+// Stream serves every event to authenticated users. ?topics= takes a comma separated list of topics
+// or prefixes (e.g. "node.status,alert").
+func (h *EventHandler) Stream(c *gin.Context) {
+	h.serve(c, false)
+}
+
+// PublicStream serves the events of the nodes published on the status page, redacted like
+// PublicStatus, without authentication.
+func (h *EventHandler) PublicStream(c *gin.Context) {
+	enabled, err := h.statusPage.PublicEnabled()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !enabled {
+		writeStatusPageError(c, services.ErrStatusPageDisabled)
+		return
+	}
+	if h.publicStreams.Add(1) > maxPublicEventStreams {
+		h.publicStreams.Add(-1)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "too many open event streams"})
+		return
+	}
+	defer h.publicStreams.Add(-1)
+	h.serve(c, true)
+}
+
+func (h *EventHandler) serve(c *gin.Context, public bool) {
+	var topics []string
+	for _, topic := range strings.Split(c.Query("topics"), ",") {
+		if topic = strings.TrimSpace(topic); topic != "" {
+			topics = append(topics, topic)
+		}
+	}
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+
+	sub, replay, resumed := h.hub.Subscribe(topics, public, lastEventID)
+	defer sub.Close()
+
+	header := c.Writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	// Keep reverse proxies such as nginx from buffering the stream.
+	header.Set("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	w := c.Writer
+	if _, err := w.WriteString("retry: " + strconv.Itoa(eventStreamRetry) + "\n\n"); err != nil {
+		return
+	}
+	if !resumed {
+		// The requested ID is unknown or too old: the client must reload instead of trusting the replay.
+		if !writeEventFrame(w, "", services.EventTopicReset, []byte(`{}`)) {
+			return
+		}
+	}
+	for _, event := range replay {
+		if !h.writeEvent(w, event, public) {
+			return
+		}
+	}
+	w.Flush()
+
+	heartbeat := time.NewTicker(eventStreamHeartbeat)
+	defer heartbeat.Stop()
+	done := c.Request.Context().Done()
+	for {
+		select {
+		case <-done:
+			return
+		case <-heartbeat.C:
+			if _, err := w.WriteString(": ping\n\n"); err != nil {
+				return
+			}
+			w.Flush()
+		case event, ok := <-sub.Events():
+			if !ok {
+				// Dropped for falling behind; the client reconnects with its last event ID.
+				return
+			}
+			if !h.writeEvent(w, event, public) {
+				return
+			}
+			w.Flush()
+		}
+	}
+}
+
+// writeEvent sends one event; public events the status page does not publish are skipped.
+func (h *EventHandler) writeEvent(w gin.ResponseWriter, event services.Event, public bool) bool {
+	data := []byte(event.Data)
+	if public {
+		payload, ok := h.statusPage.PublicEvent(event)
+		if !ok {
+			return true
+		}
+		data = payload
+	}
+	return writeEventFrame(w, event.ID, event.Topic, data)
+}
+
+func writeEventFrame(w gin.ResponseWriter, id, topic string, data []byte) bool {
+	var frame strings.Builder
+	if id != "" {
+		frame.WriteString("id: " + id + "\n")
+	}
+	frame.WriteString("event: " + topic + "\n")
+	frame.WriteString("data: ")
+	frame.Write(data)
+	frame.WriteString("\n\n")
+	_, err := w.WriteString(frame.String())
+	return err == nil
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"nebula_manager/internal/metrics"
+	"nebula_manager/internal/services"
+)
+
+// MetricsHandler serves the Prometheus scrape endpoint.
+type MetricsHandler struct {
+	service *services.MetricsService
+}
+
+// NewMetricsHandler constructs a MetricsHandler.
+func NewMetricsHandler(service *services.MetricsService) *MetricsHandler {
+	return &MetricsHandler{service: service}
+}
+
+// Metrics renders all metrics in the Prometheus text exposition format.
+func (h *MetricsHandler) Metrics(c *gin.Context) {
+	var buf bytes.Buffer
+	if err := h.service.WriteMetrics(&buf); err != nil {
+		c.String(http.StatusInternalServerError, "# collecting metrics failed: %v\n", err)
+		return
+	}
+	c.Data(http.StatusOK, metrics.ContentType, buf.Bytes())
+}
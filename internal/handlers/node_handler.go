@@ -1,11 +1,15 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 
 	"nebula_manager/internal/services"
 )
@@ -13,11 +17,13 @@ import (
 // NodeHandler exposes endpoints for Nebula nodes.
 type NodeHandler struct {
 	service *services.NodeService
+	states  *services.NodeStateService
+	audit   *services.AuditService
 }
 
 // NewNodeHandler constructs a new handler.
-func NewNodeHandler(service *services.NodeService) *NodeHandler {
-	return &NodeHandler{service: service}
+func NewNodeHandler(service *services.NodeService, states *services.NodeStateService, audit *services.AuditService) *NodeHandler {
+	return &NodeHandler{service: service, states: states, audit: audit}
 }
 
 // List returns all nodes in the system.
@@ -30,6 +36,17 @@ func (h *NodeHandler) List(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"data": nodes})
 }
 
+// Drift reports, per node, how the deployed config, certificates and nebula binary differ from what the
+// controller renders. ?drifted=true limits the report to drifted nodes.
+func (h *NodeHandler) Drift(c *gin.Context) {
+	report, err := h.service.DriftReport(c.Query("drifted") == "true")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": report})
+}
+
 // Create provisions a new node and returns its metadata.
 func (h *NodeHandler) Create(c *gin.Context) {
 	var req services.CreateNodeRequest
@@ -40,9 +57,12 @@ func (h *NodeHandler) Create(c *gin.Context) {
 
 	node, err := h.service.Create(req)
 	if err != nil {
+		recordAudit(h.audit, c, services.AuditActionNodeCreate, req.Name, err.Error(), false)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	recordAudit(h.audit, c, services.AuditActionNodeCreate, nodeAuditTarget(node.ID, node.Name),
+		fmt.Sprintf("role=%s subnet=%s public_ip=%s port=%d", node.Role, node.SubnetIP, node.PublicIP, node.Port), true)
 	c.JSON(http.StatusCreated, gin.H{"data": node})
 }
 
@@ -55,9 +75,11 @@ func (h *NodeHandler) Artifacts(c *gin.Context) {
 	}
 	artifacts, err := h.service.GetArtifacts(id)
 	if err != nil {
+		recordAudit(h.audit, c, services.AuditActionNodeArtifacts, nodeAuditTarget(id, ""), err.Error(), false)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	recordAudit(h.audit, c, services.AuditActionNodeArtifacts, nodeAuditTarget(id, ""), "certificate and private key disclosed", true)
 	c.JSON(http.StatusOK, gin.H{"data": artifacts})
 }
 
@@ -76,6 +98,48 @@ func (h *NodeHandler) Config(c *gin.Context) {
 	c.Data(http.StatusOK, "text/yaml", []byte(config))
 }
 
+// ConfigVersion reports the node's current config version with an ETag, so agents polling with
+// If-None-Match get 304 Not Modified until the config changes.
+func (h *NodeHandler) ConfigVersion(c *gin.Context) {
+	id, err := parseUintParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid node id"})
+		return
+	}
+	version, err := h.service.ConfigVersion(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	etag := `"` + version.Version + `"`
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "no-cache")
+	if match := c.GetHeader("If-None-Match"); match != "" && (match == etag || match == version.Version) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": version})
+}
+
+// ConfigApplied records the result of an agent's config sync.
+func (h *NodeHandler) ConfigApplied(c *gin.Context) {
+	id, err := parseUintParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid node id"})
+		return
+	}
+	var req services.ConfigAppliedInput
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.service.RecordConfigApplied(id, req); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": "ok"})
+}
+
 // InstallScript returns a helper shell script to install node artifacts.
 func (h *NodeHandler) InstallScript(c *gin.Context) {
 	id, err := parseUintParam(c.Param("id"))
@@ -85,9 +149,11 @@ func (h *NodeHandler) InstallScript(c *gin.Context) {
 	}
 	script, err := h.service.GenerateInstallScript(id)
 	if err != nil {
+		recordAudit(h.audit, c, services.AuditActionNodeInstallScript, nodeAuditTarget(id, ""), err.Error(), false)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	recordAudit(h.audit, c, services.AuditActionNodeInstallScript, nodeAuditTarget(id, ""), "", true)
 	c.Data(http.StatusOK, "text/plain", []byte(script))
 }
 
@@ -100,9 +166,11 @@ func (h *NodeHandler) Bundle(c *gin.Context) {
 	}
 	data, err := h.service.BuildBundle(id)
 	if err != nil {
+		recordAudit(h.audit, c, services.AuditActionNodeBundle, nodeAuditTarget(id, ""), err.Error(), false)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	recordAudit(h.audit, c, services.AuditActionNodeBundle, nodeAuditTarget(id, ""), "bundle with private key downloaded", true)
 	c.Header("Content-Disposition", "attachment; filename=nebula-node.tar.gz")
 	c.Data(http.StatusOK, "application/gzip", data)
 }
@@ -115,46 +183,92 @@ func (h *NodeHandler) Delete(c *gin.Context) {
 		return
 	}
 	if err := h.service.Delete(id); err != nil {
+		recordAudit(h.audit, c, services.AuditActionNodeDelete, nodeAuditTarget(id, ""), err.Error(), false)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	recordAudit(h.audit, c, services.AuditActionNodeDelete, nodeAuditTarget(id, ""), "", true)
 	c.JSON(http.StatusOK, gin.H{"data": "ok"})
 }
 
 // NetworkStatus returns latency series data for a node.
 func (h *NodeHandler) NetworkStatus(c *gin.Context) {
+	h.writeNetworkSeries(c)
+}
+
+func (h *NodeHandler) writeNetworkSeries(c *gin.Context) {
 	id, err := parseUintParam(c.Param("id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid node id"})
 		return
 	}
 
-	span := parseRangeParam(c.Query("range"))
-	series, err := h.service.GetNetworkSeries(id, span)
+	query, err := parseSeriesQuery(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	series, err := h.service.GetNetworkSeries(id, query)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrInvalidSeriesQuery) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"data": series})
 }
 
-// PublicNetworkStatus exposes latency series without authentication.
-func (h *NodeHandler) PublicNetworkStatus(c *gin.Context) {
-	id, err := parseUintParam(c.Param("id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid node id"})
+// NetworkMatrix returns latency and loss for every ordered node pair.
+func (h *NodeHandler) NetworkMatrix(c *gin.Context) {
+	matrix, ok := h.loadMatrix(c)
+	if !ok {
 		return
 	}
+	c.JSON(http.StatusOK, gin.H{"data": matrix})
+}
 
-	span := parseRangeParam(c.Query("range"))
-	series, err := h.service.GetNetworkSeries(id, span)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+// NetworkTopology exports the mesh as a JSON graph or Graphviz DOT with quality-colored edges.
+func (h *NodeHandler) NetworkTopology(c *gin.Context) {
+	matrix, ok := h.loadMatrix(c)
+	if !ok {
 		return
 	}
+	graph := services.BuildTopology(matrix, c.Query("include_stale") == "true")
+
+	switch c.DefaultQuery("format", "json") {
+	case "json":
+		c.JSON(http.StatusOK, gin.H{"data": graph})
+	case "dot":
+		c.Header("Content-Disposition", "attachment; filename=nebula-topology.dot")
+		c.Data(http.StatusOK, "text/vnd.graphviz; charset=utf-8", []byte(graph.DOT()))
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or dot"})
+	}
+}
 
-	c.JSON(http.StatusOK, gin.H{"data": series})
+func (h *NodeHandler) loadMatrix(c *gin.Context) (*services.NetworkMatrix, bool) {
+	var window time.Duration
+	if val := c.Query("window"); val != "" {
+		parsed, err := parseDurationParam(val)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid window %q: use a duration such as 5m or 1h", val)})
+			return nil, false
+		}
+		window = parsed
+	}
+	matrix, err := h.service.GetNetworkMatrix(window)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrInvalidSeriesQuery) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return matrix, true
 }
 
 // SubmitNetworkSamples stores latency data reported by a node agent.
@@ -174,13 +288,46 @@ func (h *NodeHandler) SubmitNetworkSamples(c *gin.Context) {
 	}
 
 	if err := h.service.RecordNetworkSamples(id, req.Samples); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		writeReportError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"data": "ok"})
 }
 
+// SubmitHostmap stores the tunnel state a node agent scraped from nebula.
+func (h *NodeHandler) SubmitHostmap(c *gin.Context) {
+	id, err := parseUintParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid node id"})
+		return
+	}
+	var req services.HostmapReportInput
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.service.RecordHostmap(id, req); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrInvalidHostmapReport) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": "ok"})
+}
+
+// NetworkTunnels returns whether each node pair is connected directly or through a relay.
+func (h *NodeHandler) NetworkTunnels(c *gin.Context) {
+	tunnels, err := h.service.GetNetworkTunnels()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": tunnels})
+}
+
 // SubmitStatus stores runtime metrics reported by a node agent.
 func (h *NodeHandler) SubmitStatus(c *gin.Context) {
 	id, err := parseUintParam(c.Param("id"))
@@ -196,51 +343,108 @@ func (h *NodeHandler) SubmitStatus(c *gin.Context) {
 	}
 
 	if err := h.service.RecordStatus(id, req); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		writeReportError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"data": "ok"})
 }
 
-// NetworkTargets lists recommended probe targets for the node agent.
-func (h *NodeHandler) NetworkTargets(c *gin.Context) {
+// StatusHistory returns the bucketed runtime metrics history of a node.
+func (h *NodeHandler) StatusHistory(c *gin.Context) {
 	id, err := parseUintParam(c.Param("id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid node id"})
 		return
 	}
 
-	targets, err := h.service.ListNetworkTargets(id)
+	span, err := parseRangeParam(c.Query("range"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	history, err := h.service.GetStatusHistory(id, span)
 	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"data": targets})
+	c.JSON(http.StatusOK, gin.H{"data": history})
 }
 
-// PublicStatus exposes node status for unauthenticated visitors.
-func (h *NodeHandler) PublicStatus(c *gin.Context) {
-	nodes, err := h.service.List()
+// StateEvents lists the node's online/stale/offline transitions within ?range= (default 7d).
+func (h *NodeHandler) StateEvents(c *gin.Context) {
+	id, err := parseUintParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid node id"})
+		return
+	}
+	span := 7 * 24 * time.Hour
+	if val := c.Query("range"); val != "" {
+		if span, err = parseRangeParam(val); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	events, err := h.states.ListEvents(id, time.Now().Add(-span))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	c.JSON(http.StatusOK, gin.H{"data": events})
+}
+
+// Availability returns the node's current state and availability over 24h, 7d and 30d.
+func (h *NodeHandler) Availability(c *gin.Context) {
+	id, err := parseUintParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid node id"})
+		return
+	}
+	availability, err := h.states.GetAvailability(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": availability})
+}
+
+// AvailabilityOverview returns the availability of every node.
+func (h *NodeHandler) AvailabilityOverview(c *gin.Context) {
+	availability, err := h.states.ListAvailability()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": availability})
+}
+
+// NetworkTargets lists recommended probe targets for the node agent.
+func (h *NodeHandler) NetworkTargets(c *gin.Context) {
+	id, err := parseUintParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid node id"})
+		return
+	}
 
-	result := make([]gin.H, 0, len(nodes))
-	for _, node := range nodes {
-		result = append(result, gin.H{
-			"id":         node.ID,
-			"name":       node.Name,
-			"role":       node.Role,
-			"subnet_ip":  node.SubnetIP,
-			"public_ip":  node.PublicIP,
-			"status":     node.Status,
-		})
+	targets, err := h.service.ListNetworkTargets(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"data": result})
+	c.JSON(http.StatusOK, gin.H{"data": targets})
+}
+
+func nodeAuditTarget(id uint, name string) string {
+	if name == "" {
+		return fmt.Sprintf("node#%d", id)
+	}
+	return fmt.Sprintf("node#%d (%s)", id, name)
 }
 
 func parseUintParam(val string) (uint, error) {
@@ -251,15 +455,90 @@ func parseUintParam(val string) (uint, error) {
 	return uint(parsed), nil
 }
 
-func parseRangeParam(val string) time.Duration {
-	switch val {
-	case "6h":
-		return 6 * time.Hour
-	case "24h":
-		return 24 * time.Hour
-	case "1h", "":
-		return time.Hour
-	default:
-		return time.Hour
+// parseRangeParam parses look-back ranges such as "1h", "90m" or "7d"; empty means one hour.
+func parseRangeParam(val string) (time.Duration, error) {
+	if val == "" {
+		return time.Hour, nil
+	}
+	span, err := parseDurationParam(val)
+	if err != nil {
+		return 0, fmt.Errorf("invalid range %q: use a duration such as 1h, 6h, 24h or 7d", val)
+	}
+	return span, nil
+}
+
+// parseDurationParam accepts Go durations, whole days ("7d") and bare seconds.
+func parseDurationParam(val string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(val, "d"); ok {
+		count, err := strconv.Atoi(days)
+		if err != nil || count <= 0 {
+			return 0, errors.New("invalid duration")
+		}
+		return time.Duration(count) * 24 * time.Hour, nil
+	}
+	if seconds, err := strconv.Atoi(val); err == nil {
+		if seconds <= 0 {
+			return 0, errors.New("invalid duration")
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+	parsed, err := time.ParseDuration(val)
+	if err != nil || parsed <= 0 {
+		return 0, errors.New("invalid duration")
+	}
+	return parsed, nil
+}
+
+// parseTimeParam accepts RFC3339 timestamps and unix seconds.
+func parseTimeParam(val string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(val, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Parse(time.RFC3339, val)
+}
+
+// parseSeriesQuery reads from/to/step, falling back to range (relative to to) when from is absent.
+func parseSeriesQuery(c *gin.Context) (services.NetworkSeriesQuery, error) {
+	var query services.NetworkSeriesQuery
+	if val := c.Query("to"); val != "" {
+		to, err := parseTimeParam(val)
+		if err != nil {
+			return query, fmt.Errorf("invalid to %q: use RFC3339 or unix seconds", val)
+		}
+		query.To = to
+	} else {
+		query.To = time.Now()
+	}
+	if val := c.Query("from"); val != "" {
+		from, err := parseTimeParam(val)
+		if err != nil {
+			return query, fmt.Errorf("invalid from %q: use RFC3339 or unix seconds", val)
+		}
+		query.From = from
+	} else {
+		span, err := parseRangeParam(c.Query("range"))
+		if err != nil {
+			return query, err
+		}
+		query.From = query.To.Add(-span)
+	}
+	if val := c.Query("step"); val != "" {
+		step, err := parseDurationParam(val)
+		if err != nil {
+			return query, fmt.Errorf("invalid step %q: use a duration such as 1m, 5m or 1h", val)
+		}
+		query.Step = step
+	}
+	query.Path = c.Query("path")
+	return query, nil
+}
+
+// writeReportError answers a failed agent upload. A deleted node gets 404, which tells the agent to
+// drop the data instead of retrying it forever.
+func writeReportError(c *gin.Context, err error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
 	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 }
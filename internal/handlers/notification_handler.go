@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"nebula_manager/internal/services"
+)
+
+// NotificationHandler manages notification channels and exposes the delivery log.
+type NotificationHandler struct {
+	service *services.NotificationService
+	audit   *services.AuditService
+}
+
+// NewNotificationHandler constructs a NotificationHandler.
+func NewNotificationHandler(service *services.NotificationService, audit *services.AuditService) *NotificationHandler {
+	return &NotificationHandler{service: service, audit: audit}
+}
+
+// ListChannels returns all channels with credentials masked.
+func (h *NotificationHandler) ListChannels(c *gin.Context) {
+	channels, err := h.service.ListChannels()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": channels})
+}
+
+// CreateChannel adds a channel.
+func (h *NotificationHandler) CreateChannel(c *gin.Context) {
+	var req services.NotificationChannelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	channel, err := h.service.CreateChannel(req)
+	if err != nil {
+		recordAudit(h.audit, c, services.AuditActionChannelUpsert, req.Name, err.Error(), false)
+		writeNotificationError(c, err)
+		return
+	}
+	recordAudit(h.audit, c, services.AuditActionChannelUpsert, channelAuditTarget(channel.ID, channel.Name), "created "+channel.Type, true)
+	c.JSON(http.StatusCreated, gin.H{"data": channel})
+}
+
+// UpdateChannel replaces a channel's definition.
+func (h *NotificationHandler) UpdateChannel(c *gin.Context) {
+	id, err := parseUintParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid channel id"})
+		return
+	}
+	var req services.NotificationChannelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	before, err := h.service.GetChannel(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	// Settings carry credentials and are left out of the change summary.
+	snapshot := *before
+	snapshot.Settings = ""
+	channel, err := h.service.UpdateChannel(id, req)
+	if err != nil {
+		writeNotificationError(c, err)
+		return
+	}
+	after := channel.NotificationChannel
+	after.Settings = ""
+	recordAudit(h.audit, c, services.AuditActionChannelUpsert, channelAuditTarget(channel.ID, channel.Name), services.SummarizeChanges(snapshot, after), true)
+	c.JSON(http.StatusOK, gin.H{"data": channel})
+}
+
+// DeleteChannel removes a channel.
+func (h *NotificationHandler) DeleteChannel(c *gin.Context) {
+	id, err := parseUintParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid channel id"})
+		return
+	}
+	channel, err := h.service.GetChannel(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.service.DeleteChannel(id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(h.audit, c, services.AuditActionChannelDelete, channelAuditTarget(channel.ID, channel.Name), "", true)
+	c.JSON(http.StatusOK, gin.H{"data": "ok"})
+}
+
+// TestChannel sends a test message and returns the resulting delivery record.
+func (h *NotificationHandler) TestChannel(c *gin.Context) {
+	id, err := parseUintParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid channel id"})
+		return
+	}
+	delivery, err := h.service.TestChannel(c.Request.Context(), id)
+	if delivery == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error(), "data": delivery})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": delivery})
+}
+
+// ListDeliveries returns the delivery log, newest first.
+func (h *NotificationHandler) ListDeliveries(c *gin.Context) {
+	filter := services.DeliveryFilter{Status: c.Query("status")}
+	for param, dst := range map[string]*uint{"channel_id": &filter.ChannelID, "alert_id": &filter.AlertID} {
+		if val := c.Query(param); val != "" {
+			id, err := parseUintParam(val)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + param})
+				return
+			}
+			*dst = id
+		}
+	}
+	deliveries, err := h.service.ListDeliveries(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": deliveries})
+}
+
+func channelAuditTarget(id uint, name string) string {
+	return fmt.Sprintf("channel#%d %s", id, name)
+}
+
+func writeNotificationError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrNotificationInvalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"nebula_manager/internal/services"
+)
+
+// oidcStateCookie ties a started login to the browser that started it, so a callback URL carrying
+// someone else's code cannot sign this browser in (login CSRF).
+const oidcStateCookie = "nebula_oidc_state"
+
+// OIDCHandler exposes OpenID Connect single sign-on endpoints.
+type OIDCHandler struct {
+	oidc  *services.OIDCService
+	auth  *services.AuthService
+	users *services.UserService
+	audit *services.AuditService
+}
+
+// NewOIDCHandler constructs an OIDCHandler.
+func NewOIDCHandler(oidc *services.OIDCService, auth *services.AuthService, users *services.UserService, audit *services.AuditService) *OIDCHandler {
+	return &OIDCHandler{oidc: oidc, auth: auth, users: users, audit: audit}
+}
+
+// Config tells the login page whether SSO is available.
+func (h *OIDCHandler) Config(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"enabled": h.oidc.Enabled(), "login_url": "/api/oidc/login"}})
+}
+
+// Login redirects the browser to the identity provider.
+func (h *OIDCHandler) Login(c *gin.Context) {
+	if !h.oidc.Enabled() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "single sign-on is not configured"})
+		return
+	}
+	target, state, err := h.oidc.AuthorizationURL(c.Request.Context(), safeReturnPath(c.Query("redirect")))
+	if err != nil {
+		log.Printf("oidc: start login failed: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	setOIDCStateCookie(c, state, h.oidc.StateTTL(), h.auth.SecureCookies())
+	c.Redirect(http.StatusFound, target)
+}
+
+// Callback completes the authorization-code flow and issues a console session.
+func (h *OIDCHandler) Callback(c *gin.Context) {
+	// The state cookie is good for one callback, whatever its outcome.
+	browserState, _ := c.Cookie(oidcStateCookie)
+	setOIDCStateCookie(c, "", 0, h.auth.SecureCookies())
+
+	if idpErr := c.Query("error"); idpErr != "" {
+		recordAuditAs(h.audit, c, "", services.AuditActionLogin, "", "sso rejected by identity provider: "+idpErr, false)
+		redirectLoginError(c, idpErr)
+		return
+	}
+
+	state := c.Query("state")
+	if browserState == "" || subtle.ConstantTimeCompare([]byte(browserState), []byte(state)) != 1 {
+		recordAuditAs(h.audit, c, "", services.AuditActionLogin, "", "sso: login was not started by this browser", false)
+		redirectLoginError(c, "sso_failed")
+		return
+	}
+
+	identity, err := h.oidc.Exchange(c.Request.Context(), state, c.Query("code"))
+	if err != nil {
+		actor := ""
+		if identity != nil {
+			actor = identity.Username
+		}
+		recordAuditAs(h.audit, c, actor, services.AuditActionLogin, actor, "sso: "+err.Error(), false)
+		if errors.Is(err, services.ErrOIDCAccessDenied) {
+			redirectLoginError(c, "access_denied")
+			return
+		}
+		log.Printf("oidc: callback failed: %v", err)
+		redirectLoginError(c, "sso_failed")
+		return
+	}
+
+	user, err := h.users.EnsureOIDCUser(identity, h.auth.AdminUsername())
+	if errors.Is(err, services.ErrOIDCUsernameTaken) {
+		recordAuditAs(h.audit, c, identity.Username, services.AuditActionLogin, identity.Username, "sso: "+err.Error(), false)
+		redirectLoginError(c, "account_conflict")
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	returnTo := identity.ReturnTo
+	if returnTo == "" {
+		returnTo = "/"
+	}
+	if !h.oidc.TrustIdPMFA() {
+		// The role's TOTP requirement applies to SSO accounts too; the login page finishes the challenge
+		// like a password login's. The token travels in the fragment, which never reaches a server.
+		stage, mfaToken, err := h.users.BeginLoginChallenge(user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if stage != "" {
+			recordAuditAs(h.audit, c, user.Username, services.AuditActionLogin, user.Username, "sso accepted, second factor pending ("+stage+")", true)
+			fragment := url.Values{"mfa_stage": {stage}, "mfa_token": {mfaToken}}
+			c.Redirect(http.StatusFound, "/login?redirect="+url.QueryEscape(returnTo)+"#"+fragment.Encode())
+			return
+		}
+	}
+
+	token, session, err := h.auth.IssueToken(user.Username, user.Role, services.SessionMeta{
+		SourceIP:  c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to issue session"})
+		return
+	}
+	if err := h.users.MarkLogin(user); err != nil {
+		log.Printf("oidc: failed to record login time for %s: %v", user.Username, err)
+	}
+
+	setSessionCookie(c, h.auth.CookieName(), token, session.MaxExpiresAt, h.auth.SecureCookies())
+	recordAuditAs(h.audit, c, user.Username, services.AuditActionLogin, user.Username, "sso as "+user.Role+" (groups: "+strings.Join(identity.Groups, ",")+")", true)
+	c.Redirect(http.StatusFound, returnTo)
+}
+
+// setOIDCStateCookie stores the state of a started login; an empty state clears the cookie. SameSite=Lax
+// still sends it on the top-level redirect back from the identity provider.
+func setOIDCStateCookie(c *gin.Context, state string, ttl time.Duration, secure bool) {
+	cookie := &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/oidc/",
+		MaxAge:   int(ttl.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Secure:   secure,
+	}
+	if state == "" {
+		cookie.MaxAge = -1
+	}
+	http.SetCookie(c.Writer, cookie)
+}
+
+// safeReturnPath only allows same-origin relative paths to avoid open redirects.
+func safeReturnPath(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.HasPrefix(path, "/\\") {
+		return ""
+	}
+	return path
+}
+
+func redirectLoginError(c *gin.Context, reason string) {
+	c.Redirect(http.StatusFound, "/login?sso_error="+url.QueryEscape(reason))
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"nebula_manager/internal/services"
+)
+
+func newTestOIDCRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	var idp *httptest.Server
+	idp = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.URL,
+			"authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint":         idp.URL + "/token",
+			"jwks_uri":               idp.URL + "/jwks",
+		})
+	}))
+	t.Cleanup(idp.Close)
+
+	oidc := services.NewOIDCService(services.OIDCConfig{
+		Issuer:      idp.URL,
+		ClientID:    "nebula-manager",
+		RedirectURL: "http://manager.test/api/oidc/callback",
+		DefaultRole: "viewer",
+	}, idp.Client())
+	auth := services.NewAuthService(nil, "admin", "secret", "", 0, 0, true, "")
+	handler := NewOIDCHandler(oidc, auth, nil, nil)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/api/oidc/login", handler.Login)
+	router.GET("/api/oidc/callback", handler.Callback)
+	return router
+}
+
+func stateCookie(resp *http.Response) *http.Cookie {
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == oidcStateCookie {
+			return cookie
+		}
+	}
+	return nil
+}
+
+func TestOIDCLoginBindsStateToBrowser(t *testing.T) {
+	router := newTestOIDCRouter(t)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/oidc/login?redirect=/nodes", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("status = %d, want 302", rec.Code)
+	}
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := stateCookie(rec.Result())
+	if cookie == nil || cookie.Value == "" || cookie.Value != location.Query().Get("state") {
+		t.Fatalf("state cookie = %+v, want the state of %s", cookie, location)
+	}
+	if !cookie.HttpOnly || !cookie.Secure || cookie.SameSite != http.SameSiteLaxMode || cookie.MaxAge <= 0 {
+		t.Fatalf("state cookie = %+v, want a short-lived HttpOnly, Secure, SameSite=Lax cookie", cookie)
+	}
+}
+
+func TestOIDCCallbackRejectsForeignState(t *testing.T) {
+	router := newTestOIDCRouter(t)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/oidc/login", nil))
+	started := stateCookie(rec.Result())
+	if started == nil {
+		t.Fatal("login set no state cookie")
+	}
+
+	tests := map[string]*http.Cookie{
+		"no cookie":     nil,
+		"another login": {Name: oidcStateCookie, Value: "attacker-started-login"},
+		"empty cookie":  {Name: oidcStateCookie, Value: ""},
+	}
+	for name, cookie := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/oidc/callback?state="+url.QueryEscape(started.Value)+"&code=stolen", nil)
+			if cookie != nil {
+				req.AddCookie(cookie)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if got := rec.Header().Get("Location"); rec.Code != http.StatusFound || got != "/login?sso_error=sso_failed" {
+				t.Fatalf("got %d to %q, want a redirect to the login error", rec.Code, got)
+			}
+			if cleared := stateCookie(rec.Result()); cleared == nil || cleared.MaxAge >= 0 {
+				t.Fatalf("state cookie = %+v, want it cleared", cleared)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"nebula_manager/internal/services"
+)
+
+// ProbeHandler exposes probe definitions and the results agents report for them.
+type ProbeHandler struct {
+	service *services.ProbeService
+	audit   *services.AuditService
+}
+
+// NewProbeHandler constructs a ProbeHandler.
+func NewProbeHandler(service *services.ProbeService, audit *services.AuditService) *ProbeHandler {
+	return &ProbeHandler{service: service, audit: audit}
+}
+
+// List returns all probe definitions.
+func (h *ProbeHandler) List(c *gin.Context) {
+	probes, err := h.service.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": probes})
+}
+
+// Create adds a probe definition.
+func (h *ProbeHandler) Create(c *gin.Context) {
+	var req services.ProbeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	probe, err := h.service.Create(req)
+	if err != nil {
+		recordAudit(h.audit, c, services.AuditActionProbeUpsert, req.Name, err.Error(), false)
+		writeProbeError(c, err)
+		return
+	}
+	recordAudit(h.audit, c, services.AuditActionProbeUpsert, probeAuditTarget(probe.ID, probe.Name), "created", true)
+	c.JSON(http.StatusCreated, gin.H{"data": probe})
+}
+
+// Update replaces a probe definition.
+func (h *ProbeHandler) Update(c *gin.Context) {
+	id, err := parseUintParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid probe id"})
+		return
+	}
+	var req services.ProbeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	before, err := h.service.Get(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	snapshot := *before
+	probe, err := h.service.Update(id, req)
+	if err != nil {
+		writeProbeError(c, err)
+		return
+	}
+	recordAudit(h.audit, c, services.AuditActionProbeUpsert, probeAuditTarget(probe.ID, probe.Name), services.SummarizeChanges(snapshot, *probe), true)
+	c.JSON(http.StatusOK, gin.H{"data": probe})
+}
+
+// Delete removes a probe definition and its results.
+func (h *ProbeHandler) Delete(c *gin.Context) {
+	id, err := parseUintParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid probe id"})
+		return
+	}
+	probe, err := h.service.Get(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.service.Delete(id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(h.audit, c, services.AuditActionProbeDelete, probeAuditTarget(probe.ID, probe.Name), "", true)
+	c.JSON(http.StatusOK, gin.H{"data": "ok"})
+}
+
+// Results returns the node's probe results within ?range= (default 1h), optionally narrowed with
+// ?probe_id= and ?peer_id=.
+func (h *ProbeHandler) Results(c *gin.Context) {
+	id, err := parseUintParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid node id"})
+		return
+	}
+	span, err := parseRangeParam(c.Query("range"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var filter services.ProbeResultFilter
+	if val := c.Query("probe_id"); val != "" {
+		if filter.ProbeID, err = parseUintParam(val); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid probe id"})
+			return
+		}
+	}
+	if val := c.Query("peer_id"); val != "" {
+		if filter.PeerID, err = parseUintParam(val); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid peer id"})
+			return
+		}
+	}
+	results, err := h.service.ListResults(id, time.Now().Add(-span), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": results})
+}
+
+func probeAuditTarget(id uint, name string) string {
+	return fmt.Sprintf("probe#%d %s", id, name)
+}
+
+func writeProbeError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrInvalidProbe) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
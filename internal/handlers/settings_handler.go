@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -11,11 +12,12 @@ import (
 // SettingsHandler exposes network settings endpoints.
 type SettingsHandler struct {
 	service *services.SettingsService
+	audit   *services.AuditService
 }
 
 // NewSettingsHandler constructs a handler instance.
-func NewSettingsHandler(service *services.SettingsService) *SettingsHandler {
-	return &SettingsHandler{service: service}
+func NewSettingsHandler(service *services.SettingsService, audit *services.AuditService) *SettingsHandler {
+	return &SettingsHandler{service: service, audit: audit}
 }
 
 // Get returns the singleton network settings object.
@@ -35,10 +37,22 @@ func (h *SettingsHandler) Update(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	settings, err := h.service.Update(req)
+	before, err := h.service.Get()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	previous := *before
+	settings, err := h.service.Update(req)
+	if err != nil {
+		recordAudit(h.audit, c, services.AuditActionSettingsUpdate, "network", err.Error(), false)
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrInvalidSettings) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(h.audit, c, services.AuditActionSettingsUpdate, "network", services.SummarizeChanges(previous, *settings), true)
 	c.JSON(http.StatusOK, gin.H{"data": settings})
 }
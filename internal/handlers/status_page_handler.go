@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"nebula_manager/internal/middleware"
+	"nebula_manager/internal/services"
+)
+
+// StatusPageHandler serves the public status page and its configuration.
+type StatusPageHandler struct {
+	service *services.StatusPageService
+	audit   *services.AuditService
+}
+
+// NewStatusPageHandler constructs a StatusPageHandler.
+func NewStatusPageHandler(service *services.StatusPageService, audit *services.AuditService) *StatusPageHandler {
+	return &StatusPageHandler{service: service, audit: audit}
+}
+
+// PublicStatus exposes the published nodes for unauthenticated visitors.
+func (h *StatusPageHandler) PublicStatus(c *gin.Context) {
+	nodes, page, err := h.service.PublicStatus()
+	if err != nil {
+		writeStatusPageError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": nodes, "page": page})
+}
+
+// PublicNetworkStatus exposes the latency series of a published node without authentication.
+func (h *StatusPageHandler) PublicNetworkStatus(c *gin.Context) {
+	id, err := parseUintParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid node id"})
+		return
+	}
+	query, err := parseSeriesQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	series, err := h.service.PublicNetworkSeries(id, query)
+	if err != nil {
+		writeStatusPageError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": series})
+}
+
+// Get returns the status page configuration.
+func (h *StatusPageHandler) Get(c *gin.Context) {
+	config, err := h.service.Get()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": config})
+}
+
+// Update replaces the status page configuration.
+func (h *StatusPageHandler) Update(c *gin.Context) {
+	var req services.StatusPageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	before, err := h.service.Get()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	config, err := h.service.Update(req)
+	if err != nil {
+		recordAudit(h.audit, c, services.AuditActionStatusPageUpdate, "status page", err.Error(), false)
+		writeStatusPageError(c, err)
+		return
+	}
+	summary := services.SummarizeChanges(before.StatusPageSetting, config.StatusPageSetting)
+	if len(before.Nodes) != 0 || len(config.Nodes) != 0 {
+		summary = fmt.Sprintf("%s; %d node overrides", summary, len(config.Nodes))
+	}
+	recordAudit(h.audit, c, services.AuditActionStatusPageUpdate, "status page", summary, true)
+	c.JSON(http.StatusOK, gin.H{"data": config})
+}
+
+// ListIncidents returns all incident notes.
+func (h *StatusPageHandler) ListIncidents(c *gin.Context) {
+	incidents, err := h.service.ListIncidents()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": incidents})
+}
+
+// CreateIncident posts an incident note to the status page.
+func (h *StatusPageHandler) CreateIncident(c *gin.Context) {
+	var req services.StatusIncidentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	incident, err := h.service.CreateIncident(req, c.GetString(middleware.ContextUserKey))
+	if err != nil {
+		recordAudit(h.audit, c, services.AuditActionIncidentUpsert, req.Title, err.Error(), false)
+		writeStatusPageError(c, err)
+		return
+	}
+	recordAudit(h.audit, c, services.AuditActionIncidentUpsert, incidentAuditTarget(incident.ID, incident.Title), "created, "+incident.Status, true)
+	c.JSON(http.StatusCreated, gin.H{"data": incident})
+}
+
+// UpdateIncident replaces an incident note, e.g. to post progress or resolve it.
+func (h *StatusPageHandler) UpdateIncident(c *gin.Context) {
+	id, err := parseUintParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid incident id"})
+		return
+	}
+	var req services.StatusIncidentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	before, err := h.service.GetIncident(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	incident, err := h.service.UpdateIncident(id, req)
+	if err != nil {
+		writeStatusPageError(c, err)
+		return
+	}
+	summary := "updated"
+	if before.Status != incident.Status {
+		summary = fmt.Sprintf("status: %s -> %s", before.Status, incident.Status)
+	}
+	recordAudit(h.audit, c, services.AuditActionIncidentUpsert, incidentAuditTarget(incident.ID, incident.Title), summary, true)
+	c.JSON(http.StatusOK, gin.H{"data": incident})
+}
+
+// DeleteIncident removes an incident note.
+func (h *StatusPageHandler) DeleteIncident(c *gin.Context) {
+	id, err := parseUintParam(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid incident id"})
+		return
+	}
+	incident, err := h.service.GetIncident(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.service.DeleteIncident(id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(h.audit, c, services.AuditActionIncidentDelete, incidentAuditTarget(incident.ID, incident.Title), "", true)
+	c.JSON(http.StatusOK, gin.H{"data": "ok"})
+}
+
+func incidentAuditTarget(id uint, title string) string {
+	return fmt.Sprintf("incident#%d (%s)", id, title)
+}
+
+func writeStatusPageError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrStatusPageInvalid), errors.Is(err, services.ErrInvalidSeriesQuery):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrStatusPageDisabled), errors.Is(err, services.ErrStatusPageNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
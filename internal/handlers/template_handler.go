@@ -13,11 +13,12 @@ import (
 // TemplateHandler exposes CRUD endpoints for config templates.
 type TemplateHandler struct {
 	service *services.TemplateService
+	audit   *services.AuditService
 }
 
 // NewTemplateHandler constructs a handler.
-func NewTemplateHandler(service *services.TemplateService) *TemplateHandler {
-	return &TemplateHandler{service: service}
+func NewTemplateHandler(service *services.TemplateService, audit *services.AuditService) *TemplateHandler {
+	return &TemplateHandler{service: service, audit: audit}
 }
 
 // List returns all templates.
@@ -41,10 +42,16 @@ func (h *TemplateHandler) Upsert(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "template name required"})
 		return
 	}
+	summary := fmt.Sprintf("created, %d bytes", len(payload.Content))
+	if existing, err := h.service.GetByName(payload.Name); err == nil {
+		summary = fmt.Sprintf("content %d -> %d bytes", len(existing.Content), len(payload.Content))
+	}
 	if err := h.service.Upsert(&payload); err != nil {
+		recordAudit(h.audit, c, services.AuditActionTemplateUpsert, payload.Name, err.Error(), false)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	recordAudit(h.audit, c, services.AuditActionTemplateUpsert, payload.Name, summary, true)
 	c.JSON(http.StatusOK, gin.H{"data": payload})
 }
 
@@ -56,9 +63,12 @@ func (h *TemplateHandler) Delete(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
 		return
 	}
+	target := fmt.Sprintf("template#%d", id)
 	if err := h.service.Delete(id); err != nil {
+		recordAudit(h.audit, c, services.AuditActionTemplateDelete, target, err.Error(), false)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	recordAudit(h.audit, c, services.AuditActionTemplateDelete, target, "", true)
 	c.Status(http.StatusNoContent)
 }
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"nebula_manager/internal/middleware"
+	"nebula_manager/internal/services"
+)
+
+// UserHandler exposes two-factor self-service and console security policy endpoints.
+type UserHandler struct {
+	service *services.UserService
+	audit   *services.AuditService
+}
+
+// NewUserHandler constructs a UserHandler.
+func NewUserHandler(service *services.UserService, audit *services.AuditService) *UserHandler {
+	return &UserHandler{service: service, audit: audit}
+}
+
+type twoFactorCodeRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// List returns all console users.
+func (h *UserHandler) List(c *gin.Context) {
+	users, err := h.service.ListUsers()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": users})
+}
+
+// TwoFactorStatus reports the current user's 2FA state.
+func (h *UserHandler) TwoFactorStatus(c *gin.Context) {
+	status, err := h.service.TwoFactorStatus(c.GetString(middleware.ContextUserKey))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": status})
+}
+
+// TwoFactorEnroll starts TOTP enrollment for the current user.
+func (h *UserHandler) TwoFactorEnroll(c *gin.Context) {
+	enrollment, err := h.service.StartEnrollment(c.GetString(middleware.ContextUserKey))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": enrollment})
+}
+
+// TwoFactorConfirm activates TOTP for the current user and returns recovery codes.
+func (h *UserHandler) TwoFactorConfirm(c *gin.Context) {
+	var req twoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	username := c.GetString(middleware.ContextUserKey)
+	codes, err := h.service.ConfirmEnrollment(username, req.Code)
+	if err != nil {
+		writeTwoFactorError(c, err)
+		return
+	}
+	recordAudit(h.audit, c, services.AuditActionTwoFactorChange, username, "enabled", true)
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"recovery_codes": codes}})
+}
+
+// TwoFactorDisable turns TOTP off for the current user after verifying a code.
+func (h *UserHandler) TwoFactorDisable(c *gin.Context) {
+	var req twoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	username := c.GetString(middleware.ContextUserKey)
+	if err := h.service.DisableTwoFactor(username, req.Code, req.RecoveryCode); err != nil {
+		writeTwoFactorError(c, err)
+		return
+	}
+	recordAudit(h.audit, c, services.AuditActionTwoFactorChange, username, "disabled", true)
+	c.JSON(http.StatusOK, gin.H{"data": "ok"})
+}
+
+// TwoFactorRecoveryCodes regenerates the current user's recovery codes.
+func (h *UserHandler) TwoFactorRecoveryCodes(c *gin.Context) {
+	var req twoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	username := c.GetString(middleware.ContextUserKey)
+	codes, err := h.service.RegenerateRecoveryCodes(username, req.Code)
+	if err != nil {
+		writeTwoFactorError(c, err)
+		return
+	}
+	recordAudit(h.audit, c, services.AuditActionTwoFactorChange, username, "recovery codes regenerated", true)
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"recovery_codes": codes}})
+}
+
+// ResetTwoFactor lets an admin remove another user's second factor.
+func (h *UserHandler) ResetTwoFactor(c *gin.Context) {
+	username := c.Param("username")
+	if err := h.service.ResetTwoFactor(username); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(h.audit, c, services.AuditActionTwoFactorChange, username, "reset by admin", true)
+	c.JSON(http.StatusOK, gin.H{"data": "ok"})
+}
+
+// GetSecurityPolicy returns the console security policy.
+func (h *UserHandler) GetSecurityPolicy(c *gin.Context) {
+	setting, err := h.service.GetSecuritySettings()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": presentSecurityPolicy(setting.TwoFactorRequiredRoles)})
+}
+
+// UpdateSecurityPolicy replaces the console security policy.
+func (h *UserHandler) UpdateSecurityPolicy(c *gin.Context) {
+	var req services.UpdateSecuritySettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	setting, err := h.service.UpdateSecuritySettings(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(h.audit, c, services.AuditActionSecurityPolicy, "2fa", "required roles: "+setting.TwoFactorRequiredRoles, true)
+	c.JSON(http.StatusOK, gin.H{"data": presentSecurityPolicy(setting.TwoFactorRequiredRoles)})
+}
+
+func presentSecurityPolicy(requiredRoles string) gin.H {
+	roles := make([]string, 0)
+	for _, role := range strings.Split(requiredRoles, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			roles = append(roles, role)
+		}
+	}
+	return gin.H{"two_factor_required_roles": roles}
+}
+
+func writeTwoFactorError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidTwoFactorCode):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrTwoFactorRequired):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,135 @@
+package metrics
+
+import (
+	"errors"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// HTTPCollector counts controller HTTP requests and their latency per route.
+type HTTPCollector struct {
+	requests  *CounterVec
+	durations *HistogramVec
+}
+
+// NewHTTPCollector constructs an HTTPCollector.
+func NewHTTPCollector() *HTTPCollector {
+	return &HTTPCollector{
+		requests:  NewCounterVec("method", "route", "status"),
+		durations: NewHistogramVec(nil, "method", "route"),
+	}
+}
+
+// Middleware records every request. Routes are labelled by their pattern, never the raw path,
+// so that IDs and unmatched URLs do not explode the series count.
+func (h *HTTPCollector) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		h.requests.Add(1, c.Request.Method, route, strconv.Itoa(c.Writer.Status()))
+		h.durations.Observe(time.Since(start).Seconds(), c.Request.Method, route)
+	}
+}
+
+// Write emits the HTTP metric families.
+func (h *HTTPCollector) Write(w *Writer) {
+	h.requests.Write(w, "nebula_http_requests_total", "HTTP requests handled by the controller.")
+	h.durations.Write(w, "nebula_http_request_duration_seconds", "HTTP request latency.")
+}
+
+const dbStartKey = "metrics:start"
+
+// DBCollector times GORM operations and reports connection pool statistics.
+type DBCollector struct {
+	db        *gorm.DB
+	durations *HistogramVec
+	errors    *CounterVec
+}
+
+// NewDBCollector registers GORM callbacks on db and returns the collector.
+func NewDBCollector(db *gorm.DB) (*DBCollector, error) {
+	collector := &DBCollector{
+		db:        db,
+		durations: NewHistogramVec(nil, "operation"),
+		errors:    NewCounterVec("operation"),
+	}
+	callbacks := db.Callback()
+	register := []struct {
+		operation string
+		before    func(name string, fn func(*gorm.DB)) error
+		after     func(name string, fn func(*gorm.DB)) error
+	}{
+		{"create", callbacks.Create().Before("gorm:create").Register, callbacks.Create().After("gorm:create").Register},
+		{"query", callbacks.Query().Before("gorm:query").Register, callbacks.Query().After("gorm:query").Register},
+		{"update", callbacks.Update().Before("gorm:update").Register, callbacks.Update().After("gorm:update").Register},
+		{"delete", callbacks.Delete().Before("gorm:delete").Register, callbacks.Delete().After("gorm:delete").Register},
+		{"row", callbacks.Row().Before("gorm:row").Register, callbacks.Row().After("gorm:row").Register},
+		{"raw", callbacks.Raw().Before("gorm:raw").Register, callbacks.Raw().After("gorm:raw").Register},
+	}
+	for _, entry := range register {
+		operation := entry.operation
+		if err := entry.before("metrics:before_"+operation, func(tx *gorm.DB) {
+			tx.InstanceSet(dbStartKey, time.Now())
+		}); err != nil {
+			return nil, err
+		}
+		if err := entry.after("metrics:after_"+operation, func(tx *gorm.DB) {
+			if start, ok := tx.InstanceGet(dbStartKey); ok {
+				collector.durations.Observe(time.Since(start.(time.Time)).Seconds(), operation)
+			}
+			if tx.Error != nil && !errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+				collector.errors.Add(1, operation)
+			}
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return collector, nil
+}
+
+// Write emits the database metric families.
+func (d *DBCollector) Write(w *Writer) {
+	d.durations.Write(w, "nebula_db_query_duration_seconds", "Database operation latency by GORM operation.")
+	d.errors.Write(w, "nebula_db_errors_total", "Database operations that returned an error.")
+
+	sqlDB, err := d.db.DB()
+	if err != nil {
+		return
+	}
+	stats := sqlDB.Stats()
+	w.Family("nebula_db_open_connections", "gauge", "Open database connections.")
+	w.Sample("nebula_db_open_connections", float64(stats.InUse), "state", "in_use")
+	w.Sample("nebula_db_open_connections", float64(stats.Idle), "state", "idle")
+	w.Family("nebula_db_max_open_connections", "gauge", "Configured limit of open database connections (0 is unlimited).")
+	w.Sample("nebula_db_max_open_connections", float64(stats.MaxOpenConnections))
+	w.Family("nebula_db_wait_count_total", "counter", "Connections waited for because the pool was exhausted.")
+	w.Sample("nebula_db_wait_count_total", float64(stats.WaitCount))
+	w.Family("nebula_db_wait_duration_seconds_total", "counter", "Time spent waiting for a free connection.")
+	w.Sample("nebula_db_wait_duration_seconds_total", stats.WaitDuration.Seconds())
+}
+
+var processStart = time.Now()
+
+// WriteRuntime emits Go runtime and process metrics of the controller.
+func WriteRuntime(w *Writer) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	w.Family("go_goroutines", "gauge", "Number of goroutines.")
+	w.Sample("go_goroutines", float64(runtime.NumGoroutine()))
+	w.Family("go_memstats_alloc_bytes", "gauge", "Bytes of allocated heap objects.")
+	w.Sample("go_memstats_alloc_bytes", float64(mem.Alloc))
+	w.Family("go_memstats_sys_bytes", "gauge", "Bytes of memory obtained from the OS.")
+	w.Sample("go_memstats_sys_bytes", float64(mem.Sys))
+	w.Family("go_gc_cycles_total", "counter", "Completed GC cycles.")
+	w.Sample("go_gc_cycles_total", float64(mem.NumGC))
+	w.Family("process_start_time_seconds", "gauge", "Start time of the controller process since the unix epoch.")
+	w.Sample("process_start_time_seconds", float64(processStart.Unix()))
+}
@@ -0,0 +1,208 @@
+// Package metrics renders the Prometheus text exposition format and collects controller metrics.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the media type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are latency histogram bounds in seconds.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Writer emits metric families in the text exposition format. The first write error is kept and
+// later writes become no-ops.
+type Writer struct {
+	w   io.Writer
+	err error
+}
+
+// NewWriter wraps w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Err returns the first write error.
+func (w *Writer) Err() error {
+	return w.err
+}
+
+// Family writes the HELP and TYPE lines of a metric family.
+func (w *Writer) Family(name, metricType, help string) {
+	w.printf("# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(help), name, metricType)
+}
+
+// Sample writes one sample. labels alternate between names and values.
+func (w *Writer) Sample(name string, value float64, labels ...string) {
+	w.printf("%s%s %s\n", name, formatLabels(labels), formatValue(value))
+}
+
+func (w *Writer) printf(format string, args ...interface{}) {
+	if w.err != nil {
+		return
+	}
+	_, w.err = fmt.Fprintf(w.w, format, args...)
+}
+
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(labels[i])
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(labels[i+1]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+
+// CounterVec is a set of counters keyed by label values.
+type CounterVec struct {
+	mu     sync.Mutex
+	labels []string
+	values map[string]*counterEntry
+}
+
+type counterEntry struct {
+	labelValues []string
+	value       float64
+}
+
+// NewCounterVec constructs a CounterVec with the given label names.
+func NewCounterVec(labels ...string) *CounterVec {
+	return &CounterVec{labels: labels, values: make(map[string]*counterEntry)}
+}
+
+// Add increments the counter identified by labelValues.
+func (v *CounterVec) Add(delta float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	entry, ok := v.values[key]
+	if !ok {
+		entry = &counterEntry{labelValues: append([]string(nil), labelValues...)}
+		v.values[key] = entry
+	}
+	entry.value += delta
+}
+
+// Write emits the family in a stable order.
+func (v *CounterVec) Write(w *Writer, name, help string) {
+	w.Family(name, "counter", help)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, key := range sortedKeys(v.values) {
+		entry := v.values[key]
+		w.Sample(name, entry.value, zipLabels(v.labels, entry.labelValues)...)
+	}
+}
+
+// HistogramVec is a set of histograms sharing bucket bounds, keyed by label values.
+type HistogramVec struct {
+	mu      sync.Mutex
+	labels  []string
+	buckets []float64
+	values  map[string]*histogramEntry
+}
+
+type histogramEntry struct {
+	labelValues []string
+	counts      []uint64
+	count       uint64
+	sum         float64
+}
+
+// NewHistogramVec constructs a HistogramVec; nil buckets use DefaultBuckets.
+func NewHistogramVec(buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	return &HistogramVec{labels: labels, buckets: buckets, values: make(map[string]*histogramEntry)}
+}
+
+// Observe records value in the histogram identified by labelValues.
+func (v *HistogramVec) Observe(value float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	entry, ok := v.values[key]
+	if !ok {
+		entry = &histogramEntry{labelValues: append([]string(nil), labelValues...), counts: make([]uint64, len(v.buckets))}
+		v.values[key] = entry
+	}
+	for i, bound := range v.buckets {
+		if value <= bound {
+			entry.counts[i]++
+		}
+	}
+	entry.count++
+	entry.sum += value
+}
+
+// Write emits the family with cumulative buckets in a stable order.
+func (v *HistogramVec) Write(w *Writer, name, help string) {
+	w.Family(name, "histogram", help)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, key := range sortedKeys(v.values) {
+		entry := v.values[key]
+		labels := zipLabels(v.labels, entry.labelValues)
+		for i, bound := range v.buckets {
+			w.Sample(name+"_bucket", float64(entry.counts[i]), append(labels, "le", formatValue(bound))...)
+		}
+		w.Sample(name+"_bucket", float64(entry.count), append(labels, "le", "+Inf")...)
+		w.Sample(name+"_sum", entry.sum, labels...)
+		w.Sample(name+"_count", float64(entry.count), labels...)
+	}
+}
+
+func zipLabels(names, values []string) []string {
+	labels := make([]string, 0, len(names)*2)
+	for i, name := range names {
+		labels = append(labels, name, values[i])
+	}
+	return labels
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -7,34 +7,59 @@ import (
 
 	"github.com/gin-gonic/gin"
 
+	"nebula_manager/internal/models"
 	"nebula_manager/internal/services"
 )
 
 // ContextUserKey is used to store the authenticated username in Gin context.
 const ContextUserKey = "authUser"
 
+// ContextRoleKey stores the authenticated user's role in Gin context.
+const ContextRoleKey = "authRole"
+
+// ContextSessionKey stores the ID of the server-side session backing the request (absent for the static token).
+const ContextSessionKey = "authSession"
+
 // RequireAuth ensures the request carries a valid session token.
 func RequireAuth(authService *services.AuthService) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		token := extractToken(c, authService.CookieName())
+		token := ExtractToken(c, authService.CookieName())
 		if token != "" {
 			if static := authService.StaticToken(); static != "" && subtle.ConstantTimeCompare([]byte(token), []byte(static)) == 1 {
 				c.Set(ContextUserKey, authService.AdminUsername())
+				c.Set(ContextRoleKey, models.UserRoleAdmin)
 				c.Next()
 				return
 			}
 		}
-		username, _, err := authService.ValidateToken(token)
+		session, err := authService.ValidateToken(token)
 		if err != nil {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 			return
 		}
-		c.Set(ContextUserKey, username)
+		c.Set(ContextUserKey, session.Username)
+		c.Set(ContextRoleKey, session.Role)
+		c.Set(ContextSessionKey, session.ID)
 		c.Next()
 	}
 }
 
-func extractToken(c *gin.Context, cookieName string) string {
+// RequireRole rejects authenticated requests whose role is not one of the allowed roles.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := c.GetString(ContextRoleKey)
+		for _, allowed := range roles {
+			if role == allowed {
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+	}
+}
+
+// ExtractToken returns the session token carried by the request cookie, bearer header or query string.
+func ExtractToken(c *gin.Context, cookieName string) string {
 	if cookie, err := c.Cookie(cookieName); err == nil && cookie != "" {
 		return cookie
 	}
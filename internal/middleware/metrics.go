@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"nebula_manager/internal/services"
+)
+
+// RequireMetricsAccess guards the Prometheus endpoint. A public endpoint is open to anyone; with a
+// dedicated token only that bearer token is accepted; otherwise regular console credentials apply.
+func RequireMetricsAccess(authService *services.AuthService, token string, public bool) gin.HandlerFunc {
+	if public {
+		return func(c *gin.Context) { c.Next() }
+	}
+	if token == "" {
+		return RequireAuth(authService)
+	}
+	return func(c *gin.Context) {
+		presented := ExtractToken(c, authService.CookieName())
+		if presented == "" || subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+			c.Header("WWW-Authenticate", `Bearer realm="metrics"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		c.Next()
+	}
+}
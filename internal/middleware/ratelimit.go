@@ -0,0 +1,135 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// bucketIdleTTL controls how long an untouched bucket is kept before being evicted.
+const bucketIdleTTL = 10 * time.Minute
+
+// RateLimiter is an in-memory token bucket limiter keyed by arbitrary strings.
+type RateLimiter struct {
+	mu        sync.Mutex
+	rate      float64 // tokens per second
+	burst     float64
+	buckets   map[string]*tokenBucket
+	now       func() time.Time
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// NewRateLimiter allows `requests` per `per` with an equal burst. Returns nil (no limiting) when disabled.
+func NewRateLimiter(requests int, per time.Duration) *RateLimiter {
+	if requests <= 0 || per <= 0 {
+		return nil
+	}
+	return &RateLimiter{
+		rate:    float64(requests) / per.Seconds(),
+		burst:   float64(requests),
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+	}
+}
+
+// WithClock replaces the limiter's time source, allowing deterministic in-process tests.
+func (l *RateLimiter) WithClock(now func() time.Time) *RateLimiter {
+	if l != nil && now != nil {
+		l.now = now
+	}
+	return l
+}
+
+// Allow consumes a token for key. When the bucket is empty it reports how long until a token is available.
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, lastSeen: now}
+		l.buckets[key] = bucket
+	} else {
+		elapsed := now.Sub(bucket.lastSeen).Seconds()
+		if elapsed > 0 {
+			bucket.tokens = math.Min(l.burst, bucket.tokens+elapsed*l.rate)
+		}
+		bucket.lastSeen = now
+	}
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < bucketIdleTTL {
+		return
+	}
+	l.lastSweep = now
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.lastSeen) > bucketIdleTTL {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// RateLimitKeyFunc derives the bucket key for a request.
+type RateLimitKeyFunc func(c *gin.Context) string
+
+// ClientIPKey buckets requests by client IP address.
+func ClientIPKey(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// UserKey buckets requests by authenticated user, falling back to client IP.
+func UserKey(c *gin.Context) string {
+	if user := c.GetString(ContextUserKey); user != "" {
+		return "user:" + user
+	}
+	return ClientIPKey(c)
+}
+
+// RateLimit rejects requests exceeding the limiter's budget with 429 and a Retry-After header.
+func RateLimit(limiter *RateLimiter, key RateLimitKeyFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if limiter == nil {
+			c.Next()
+			return
+		}
+		allowed, retryAfter := limiter.Allow(key(c))
+		if !allowed {
+			AbortTooManyRequests(c, retryAfter)
+			return
+		}
+		c.Next()
+	}
+}
+
+// AbortTooManyRequests writes a 429 response with a Retry-After header rounded up to whole seconds.
+func AbortTooManyRequests(c *gin.Context, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "too many requests", "retry_after": seconds})
+}
@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRateLimiterRefills(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	limiter := NewRateLimiter(2, time.Minute).WithClock(func() time.Time { return now })
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := limiter.Allow("ip:10.0.0.1"); !ok {
+			t.Fatalf("request %d within the burst was rejected", i+1)
+		}
+	}
+	ok, wait := limiter.Allow("ip:10.0.0.1")
+	if ok || wait != 30*time.Second {
+		t.Fatalf("over budget: allowed=%v wait=%s, want rejected with 30s", ok, wait)
+	}
+	if ok, _ := limiter.Allow("ip:10.0.0.2"); !ok {
+		t.Fatal("buckets must be independent per key")
+	}
+
+	now = now.Add(30 * time.Second)
+	if ok, _ := limiter.Allow("ip:10.0.0.1"); !ok {
+		t.Fatal("a token should be available after refilling")
+	}
+}
+
+func TestRateLimitMiddlewareIgnoresSpoofedForwardedFor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	if err := router.SetTrustedProxies(nil); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1_700_000_000, 0)
+	limiter := NewRateLimiter(1, time.Minute).WithClock(func() time.Time { return now })
+	router.GET("/", RateLimit(limiter, ClientIPKey), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	request := func(forwardedFor string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "203.0.113.7:40000"
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := request("10.0.0.1"); rec.Code != http.StatusOK {
+		t.Fatalf("first request: status %d", rec.Code)
+	}
+	rec := request("10.0.0.2")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request with a new X-Forwarded-For: status %d, want 429", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "60" {
+		t.Fatalf("Retry-After = %q, want 60", got)
+	}
+}
@@ -0,0 +1,94 @@
+package models
+
+import "time"
+
+// Alert rule kinds. The meaning of AlertRule.Threshold depends on the kind.
+const (
+	AlertKindNodeOffline = "node_offline" // minutes since the last status report
+	AlertKindCPUHigh     = "cpu_high"     // CPU usage percent
+	AlertKindDiskHigh    = "disk_high"    // disk usage percent
+	AlertKindLinkLoss    = "link_loss"    // packet loss percent towards a peer
+	AlertKindLinkLatency = "link_latency" // mean latency in ms towards a peer
+	AlertKindCertExpiry  = "cert_expiry"  // days until a node or CA certificate expires
+)
+
+// Alert states.
+const (
+	AlertStatePending  = "pending"
+	AlertStateFiring   = "firing"
+	AlertStateResolved = "resolved"
+)
+
+// Alert severities.
+const (
+	AlertSeverityInfo     = "info"
+	AlertSeverityWarning  = "warning"
+	AlertSeverityCritical = "critical"
+)
+
+// AlertRule is a condition evaluated periodically against node status, ping and certificate data.
+type AlertRule struct {
+	ID        uint    `gorm:"primaryKey" json:"id"`
+	Name      string  `gorm:"size:100;not null;unique" json:"name"`
+	Kind      string  `gorm:"size:32;not null" json:"kind"`
+	Threshold float64 `gorm:"type:double" json:"threshold"`
+	// ForSeconds is how long the condition must hold before a pending alert fires.
+	ForSeconds int `json:"for_seconds"`
+	// WindowSeconds is the sample window averaged by link rules.
+	WindowSeconds int    `json:"window_seconds"`
+	Severity      string `gorm:"size:16;not null" json:"severity"`
+	// NodeID and Tag optionally restrict the rule to one node or to nodes carrying a tag.
+	NodeID      uint      `json:"node_id"`
+	Tag         string    `gorm:"size:64" json:"tag"`
+	Enabled     bool      `json:"enabled"`
+	Description string    `gorm:"size:255" json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Alert is one occurrence of a rule matching a subject (a node, a node pair or a certificate).
+type Alert struct {
+	ID              uint       `gorm:"primaryKey" json:"id"`
+	RuleID          uint       `gorm:"not null;index" json:"rule_id"`
+	Fingerprint     string     `gorm:"size:128;not null;index" json:"fingerprint"`
+	Kind            string     `gorm:"size:32;not null" json:"kind"`
+	Severity        string     `gorm:"size:16;not null" json:"severity"`
+	State           string     `gorm:"size:16;not null;index" json:"state"`
+	NodeID          uint       `gorm:"index" json:"node_id"`
+	PeerNodeID      uint       `json:"peer_node_id"`
+	Subject         string     `gorm:"size:255" json:"subject"`
+	Summary         string     `gorm:"size:512" json:"summary"`
+	Value           float64    `gorm:"type:double" json:"value"`
+	StartedAt       time.Time  `json:"started_at"`
+	FiredAt         *time.Time `json:"fired_at"`
+	ResolvedAt      *time.Time `json:"resolved_at"`
+	LastEvaluatedAt time.Time  `json:"last_evaluated_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+// AlertSilence mutes matching alerts between StartsAt and EndsAt. Zero RuleID/NodeID match any.
+type AlertSilence struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	RuleID    uint      `json:"rule_id"`
+	NodeID    uint      `json:"node_id"`
+	Comment   string    `gorm:"size:255" json:"comment"`
+	CreatedBy string    `gorm:"size:100" json:"created_by"`
+	StartsAt  time.Time `json:"starts_at"`
+	EndsAt    time.Time `gorm:"index" json:"ends_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Matches reports whether the silence covers the alert at time t.
+func (s AlertSilence) Matches(alert Alert, t time.Time) bool {
+	if t.Before(s.StartsAt) || !t.Before(s.EndsAt) {
+		return false
+	}
+	if s.RuleID != 0 && s.RuleID != alert.RuleID {
+		return false
+	}
+	if s.NodeID != 0 && s.NodeID != alert.NodeID && s.NodeID != alert.PeerNodeID {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrAuditLogImmutable is returned when code attempts to modify or remove an audit entry.
+var ErrAuditLogImmutable = errors.New("audit log entries are append-only")
+
+// AuditLog records an administrative or security-relevant action performed through the API.
+type AuditLog struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Actor     string    `gorm:"size:100;index" json:"actor"`
+	SourceIP  string    `gorm:"size:64" json:"source_ip"`
+	Action    string    `gorm:"size:64;index" json:"action"`
+	Target    string    `gorm:"size:255" json:"target"`
+	Summary   string    `gorm:"type:text" json:"summary"`
+	Success   bool      `json:"success"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
+
+// BeforeUpdate prevents existing audit entries from being rewritten.
+func (AuditLog) BeforeUpdate(*gorm.DB) error {
+	return ErrAuditLogImmutable
+}
+
+// BeforeDelete prevents audit entries from being removed through the ORM.
+func (AuditLog) BeforeDelete(*gorm.DB) error {
+	return ErrAuditLogImmutable
+}
@@ -0,0 +1,51 @@
+package models
+
+import "time"
+
+// Diagnostic job types. Traceroute and mtr trace the route from the node to its peer; throughput
+// measures TCP bandwidth in both directions between the two nodes' agents.
+const (
+	DiagnosticTypeTraceroute = "traceroute"
+	DiagnosticTypeMTR        = "mtr"
+	DiagnosticTypeThroughput = "throughput"
+)
+
+// Diagnostic job states. A job is queued until its agent claims it, then running until the agent
+// uploads a result. Jobs nobody picks up or finishes in time end as timed_out.
+const (
+	DiagnosticStatusQueued    = "queued"
+	DiagnosticStatusRunning   = "running"
+	DiagnosticStatusSucceeded = "succeeded"
+	DiagnosticStatusFailed    = "failed"
+	DiagnosticStatusTimedOut  = "timed_out"
+	DiagnosticStatusCancelled = "cancelled"
+)
+
+// DiagnosticJob is an on-demand diagnostic run by a node's agent against a peer.
+type DiagnosticJob struct {
+	ID         uint   `gorm:"primaryKey" json:"id"`
+	Type       string `gorm:"size:16;not null" json:"type"`
+	NodeID     uint   `gorm:"not null;index:idx_diagnostic_pair" json:"node_id"`
+	PeerNodeID uint   `gorm:"not null;index:idx_diagnostic_pair" json:"peer_node_id"`
+	// Path is PingPathOverlay or PingPathUnderlay, selecting the peer address the job targets.
+	Path string `gorm:"size:16;not null" json:"path"`
+	// Count is the number of probes per hop for traceroute and mtr.
+	Count int `json:"count,omitempty"`
+	// DurationSeconds is how long throughput is measured in each direction.
+	DurationSeconds int    `json:"duration_seconds,omitempty"`
+	TimeoutSeconds  int    `json:"timeout_seconds"`
+	Status          string `gorm:"size:16;not null;index" json:"status"`
+	Error           string `gorm:"size:255" json:"error,omitempty"`
+	// Result holds the agent's structured result as JSON.
+	Result      string `gorm:"type:text" json:"-"`
+	RequestedBy string `gorm:"size:100" json:"requested_by"`
+	// Token authenticates the source agent to the peer's throughput listener.
+	Token string `gorm:"size:64" json:"-"`
+	// ServerPort and ServerReadyAt are set once the peer's agent listens for a throughput test.
+	ServerPort    int        `json:"server_port,omitempty"`
+	ServerReadyAt *time.Time `json:"server_ready_at,omitempty"`
+	CreatedAt     time.Time  `gorm:"index:idx_diagnostic_pair" json:"created_at"`
+	StartedAt     *time.Time `json:"started_at,omitempty"`
+	FinishedAt    *time.Time `json:"finished_at,omitempty"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
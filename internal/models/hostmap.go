@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// Tunnel paths reported from a node's hostmap.
+const (
+	TunnelPathDirect  = "direct"
+	TunnelPathRelayed = "relayed"
+	TunnelPathPending = "pending"
+)
+
+// NodeHostmap summarises the tunnel state nebula itself reported on a node, as last scraped by its agent.
+type NodeHostmap struct {
+	NodeID              uint `gorm:"primaryKey"`
+	Tunnels             int
+	PendingHandshakes   int
+	RelayedTunnels      int
+	RelayingTunnels     int
+	HandshakesInitiated *uint64
+	HandshakesTimedOut  *uint64
+	// Sources lists the endpoints the data came from ("sshd", "stats"), comma separated.
+	Sources    string `gorm:"size:32"`
+	ReportedAt time.Time
+	UpdatedAt  time.Time
+}
+
+// NodeTunnel is a node's tunnel towards a managed peer: whether nebula reaches it directly or via
+// relays, and over which underlay address.
+type NodeTunnel struct {
+	ID         uint   `gorm:"primaryKey"`
+	NodeID     uint   `gorm:"not null;uniqueIndex:idx_node_tunnel_peer"`
+	PeerNodeID uint   `gorm:"not null;uniqueIndex:idx_node_tunnel_peer"`
+	VpnIP      string `gorm:"size:64"`
+	Path       string `gorm:"size:16"`
+	Remote     string `gorm:"size:128"`
+	// Relays holds the overlay IPs of the relays in use, comma separated.
+	Relays    string `gorm:"size:255"`
+	UpdatedAt time.Time
+}
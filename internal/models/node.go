@@ -23,6 +23,14 @@ type Node struct {
 	CertificatePEM    string `gorm:"type:longtext"`
 	PrivateKeyPEM     string `gorm:"type:longtext"`
 	ConfigContent     string `gorm:"type:longtext"`
-	CreatedAt         time.Time
-	UpdatedAt         time.Time
+	// Config sync state as last reported by the node agent.
+	AppliedConfigVersion string `gorm:"size:64"`
+	ConfigAppliedAt      *time.Time
+	ConfigApplyError     string `gorm:"size:512"`
+	// Registered by the node agent: the public key it logs in to nebula's debug sshd with and the path
+	// of the sshd host key it generated. The sshd section is only rendered once both are known.
+	AgentSSHKey     string `gorm:"size:255"`
+	AgentSSHHostKey string `gorm:"size:255"`
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
 }
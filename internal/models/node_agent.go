@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// NodeAgent is what the agent of a node last reported about itself. Checksum is the hex SHA-256 of
+// the agent binary (or probe script) running on the host; UpdateError explains the last failed
+// self-update.
+type NodeAgent struct {
+	NodeID      uint   `gorm:"primaryKey"`
+	Version     string `gorm:"size:64"`
+	Platform    string `gorm:"size:32"`
+	Checksum    string `gorm:"size:64"`
+	UpdateError string `gorm:"size:255"`
+	ReportedAt  time.Time
+	UpdatedAt   time.Time
+}
@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// NodeDeployment is what the node agent last reported about the nebula files and binary on its host.
+// Hashes are hex SHA-256 digests of the files as found on disk; empty means the file was missing.
+type NodeDeployment struct {
+	NodeID        uint   `gorm:"primaryKey"`
+	ConfigHash    string `gorm:"size:64"`
+	CertHash      string `gorm:"size:64"`
+	CAHash        string `gorm:"size:64"`
+	NebulaVersion string `gorm:"size:32"`
+	ReportedAt    time.Time
+	UpdatedAt     time.Time
+}
+
+// NodeCertificate records a certificate issued to a node. Certificates are reissued with every bundle
+// download, so any of them may be the one deployed; the log lets the controller recognise them all.
+type NodeCertificate struct {
+	ID            uint   `gorm:"primaryKey"`
+	NodeID        uint   `gorm:"index;not null"`
+	Fingerprint   string `gorm:"size:64;not null;uniqueIndex"`
+	CAFingerprint string `gorm:"size:64"`
+	NotAfter      time.Time
+	CreatedAt     time.Time
+}
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// Node liveness states derived from the age of the last status report.
+const (
+	NodeStateOnline        = "online"
+	NodeStateStale         = "stale"
+	NodeStateOffline       = "offline"
+	NodeStateNeverReported = "never_reported"
+)
+
+// NodeStateEvent records a liveness transition. At is when the transition happened (a report
+// arriving, or a report becoming too old), not when the controller noticed it.
+type NodeStateEvent struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	NodeID    uint      `gorm:"not null;index:idx_state_event_node_at" json:"node_id"`
+	FromState string    `gorm:"size:16" json:"from_state"`
+	ToState   string    `gorm:"size:16;not null" json:"to_state"`
+	At        time.Time `gorm:"not null;index:idx_state_event_node_at" json:"at"`
+	CreatedAt time.Time `json:"created_at"`
+}
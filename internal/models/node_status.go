@@ -24,3 +24,27 @@ type NodeStatus struct {
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
 }
+
+// NodeStatusSample keeps every metrics report of a node as a time series.
+type NodeStatusSample struct {
+	ID          uint `gorm:"primaryKey"`
+	NodeID      uint `gorm:"not null;index:idx_status_sample_node_reported;uniqueIndex:idx_status_sample_report"`
+	CPUUsage    float64
+	Load1       float64
+	Load5       float64
+	Load15      float64
+	MemoryTotal uint64
+	MemoryUsed  uint64
+	SwapTotal   uint64
+	SwapUsed    uint64
+	DiskTotal   uint64
+	DiskUsed    uint64
+	NetRxBytes  uint64
+	NetTxBytes  uint64
+	Processes   int
+	Uptime      uint64
+	ReportedAt  time.Time `gorm:"not null;index:idx_status_sample_node_reported"`
+	// ReportID is the agent-assigned ID that makes replayed uploads idempotent; older agents send none.
+	ReportID  *string `gorm:"size:64;uniqueIndex:idx_status_sample_report"`
+	CreatedAt time.Time
+}
@@ -0,0 +1,61 @@
+package models
+
+import "time"
+
+// Notification channel types.
+const (
+	NotificationChannelWebhook  = "webhook"
+	NotificationChannelEmail    = "email"
+	NotificationChannelTelegram = "telegram"
+	NotificationChannelDingTalk = "dingtalk"
+	NotificationChannelFeishu   = "feishu"
+)
+
+// Notification events.
+const (
+	NotificationEventFiring   = "firing"
+	NotificationEventResolved = "resolved"
+	NotificationEventTest     = "test"
+)
+
+// Notification delivery states.
+const (
+	DeliveryStatusPending = "pending"
+	DeliveryStatusSent    = "sent"
+	DeliveryStatusFailed  = "failed"
+)
+
+// NotificationChannel is a destination alert notifications are routed to.
+type NotificationChannel struct {
+	ID      uint   `gorm:"primaryKey" json:"id"`
+	Name    string `gorm:"size:100;not null;unique" json:"name"`
+	Type    string `gorm:"size:16;not null" json:"type"`
+	Enabled bool   `json:"enabled"`
+	// Settings holds the type specific JSON settings (URLs, credentials, recipients).
+	Settings string `gorm:"type:text" json:"-"`
+	// MinSeverity and Tags route alerts: lower severities and nodes without one of the tags are skipped.
+	MinSeverity    string `gorm:"size:16" json:"min_severity"`
+	Tags           string `gorm:"size:255" json:"tags"`
+	NotifyResolved bool   `json:"notify_resolved"`
+	// Template is a Go text/template for the message body; empty uses the built-in one.
+	Template  string    `gorm:"type:text" json:"template"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// NotificationDelivery records one message sent, or being retried, to a channel.
+type NotificationDelivery struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	ChannelID     uint       `gorm:"not null;index" json:"channel_id"`
+	AlertID       uint       `gorm:"index" json:"alert_id"`
+	Event         string     `gorm:"size:16;not null" json:"event"`
+	Status        string     `gorm:"size:16;not null;index:idx_delivery_status_next" json:"status"`
+	Attempts      int        `json:"attempts"`
+	Title         string     `gorm:"size:255" json:"title"`
+	Body          string     `gorm:"type:text" json:"body"`
+	LastError     string     `gorm:"size:512" json:"last_error"`
+	NextAttemptAt time.Time  `gorm:"index:idx_delivery_status_next" json:"next_attempt_at"`
+	DeliveredAt   *time.Time `json:"delivered_at"`
+	CreatedAt     time.Time  `gorm:"index" json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
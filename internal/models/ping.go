@@ -2,12 +2,72 @@ package models
 
 import "time"
 
-// NodePing stores the measured latency between two managed nodes.
+// Rollup resolutions for NodePingRollup, in seconds.
+const (
+	PingResolution5m = 300
+	PingResolution1h = 3600
+)
+
+// Network paths a latency sample was measured over: through the nebula tunnel to the peer's overlay
+// address, or over the internet to its public address.
+const (
+	PingPathOverlay  = "overlay"
+	PingPathUnderlay = "underlay"
+)
+
+// NodePing stores the measured latency between two managed nodes. A sample may cover several echoes:
+// LatencyMs is then their mean and Sent/Received count them. Samples from probes that only report
+// latency and success have zero packet counts and count as a single echo.
 type NodePing struct {
-	ID         uint      `gorm:"primaryKey"`
-	NodeID     uint      `gorm:"not null;index:idx_node_peer_created"`
-	PeerNodeID uint      `gorm:"not null;index:idx_node_peer_created"`
-	LatencyMs  float64   `gorm:"type:double"`
-	Success    bool      `gorm:"not null"`
-	CreatedAt  time.Time `gorm:"index:idx_node_peer_created"`
+	ID         uint    `gorm:"primaryKey"`
+	NodeID     uint    `gorm:"not null;index:idx_node_peer_created;uniqueIndex:idx_node_ping_sample"`
+	PeerNodeID uint    `gorm:"not null;index:idx_node_peer_created"`
+	Path       string  `gorm:"size:16;not null;default:overlay"`
+	LatencyMs  float64 `gorm:"type:double"`
+	Success    bool    `gorm:"not null"`
+	Sent       int
+	Received   int
+	MinMs      float64 `gorm:"type:double"`
+	MaxMs      float64 `gorm:"type:double"`
+	MdevMs     float64 `gorm:"type:double"`
+	// JitterMs is the mean difference between consecutive round trips of the sample's echoes.
+	JitterMs  float64   `gorm:"type:double"`
+	CreatedAt time.Time `gorm:"index:idx_node_peer_created;index"`
+	// SampleID is the agent-assigned ID that makes replayed uploads idempotent; older agents send none.
+	SampleID *string `gorm:"size:64;uniqueIndex:idx_node_ping_sample"`
+}
+
+// NodePingRollup aggregates raw NodePing samples of one node pair over a fixed bucket.
+// Latency statistics only cover successful samples; LossRatio is the share of lost echoes.
+type NodePingRollup struct {
+	ID          uint      `gorm:"primaryKey"`
+	NodeID      uint      `gorm:"not null;uniqueIndex:idx_rollup_pair_path_bucket"`
+	PeerNodeID  uint      `gorm:"not null;uniqueIndex:idx_rollup_pair_path_bucket"`
+	Path        string    `gorm:"size:16;not null;default:overlay;uniqueIndex:idx_rollup_pair_path_bucket"`
+	Resolution  int       `gorm:"not null;uniqueIndex:idx_rollup_pair_path_bucket"`
+	BucketStart time.Time `gorm:"not null;uniqueIndex:idx_rollup_pair_path_bucket;index"`
+	Samples     int
+	Failures    int
+	MinMs       float64 `gorm:"type:double"`
+	AvgMs       float64 `gorm:"type:double"`
+	MaxMs       float64 `gorm:"type:double"`
+	P50Ms       float64 `gorm:"type:double"`
+	P95Ms       float64 `gorm:"type:double"`
+	P99Ms       float64 `gorm:"type:double"`
+	LossRatio   float64 `gorm:"type:double"`
+	// PacketsSent and PacketsReceived count echoes; both are zero for buckets rolled up before
+	// samples carried packet counts.
+	PacketsSent     int
+	PacketsReceived int
+	JitterMs        float64 `gorm:"type:double"`
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+// PingRollupCursor is the single row recording the newest raw sample the rollup job has seen, so
+// that samples inserted after it with older timestamps reopen their buckets across restarts.
+type PingRollupCursor struct {
+	ID         uint `gorm:"primaryKey"`
+	LastPingID uint `gorm:"not null"`
+	UpdatedAt  time.Time
 }
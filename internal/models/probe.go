@@ -0,0 +1,55 @@
+package models
+
+import "time"
+
+// Probe types. ICMP samples without a probe definition are the built-in ping series stored as NodePing.
+const (
+	ProbeTypeICMP       = "icmp"
+	ProbeTypeTCP        = "tcp"
+	ProbeTypeNebulaPort = "nebula_port"
+	ProbeTypeHTTP       = "http"
+)
+
+// Addresses a probe can target on a peer: its overlay address or its public underlay address.
+const (
+	ProbeAddressOverlay = "overlay"
+	ProbeAddressPublic  = "public"
+)
+
+// ProbeDefinition is an additional check agents run against matching peers alongside the built-in ping.
+type ProbeDefinition struct {
+	ID   uint   `gorm:"primaryKey" json:"id"`
+	Name string `gorm:"size:100;not null;unique" json:"name"`
+	Type string `gorm:"size:16;not null" json:"type"`
+	// Port is the TCP port for tcp probes; nebula_port probes use the peer's listen port.
+	Port int `json:"port"`
+	// URL is the http probe's target; "{address}" is replaced with the peer address.
+	URL string `gorm:"size:255" json:"url"`
+	// ExpectStatus is the HTTP status that counts as success; 0 accepts any 2xx or 3xx.
+	ExpectStatus  int    `json:"expect_status"`
+	SkipTLSVerify bool   `json:"skip_tls_verify"`
+	TimeoutMs     int    `json:"timeout_ms"`
+	Address       string `gorm:"size:16" json:"address"`
+	// PeerNodeID and Tag optionally restrict the probe to one target node or to targets carrying a tag.
+	PeerNodeID  uint      `json:"peer_node_id"`
+	Tag         string    `gorm:"size:64" json:"tag"`
+	Enabled     bool      `json:"enabled"`
+	Description string    `gorm:"size:255" json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// ProbeResult is one outcome of a probe definition run by a node against a peer.
+type ProbeResult struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	NodeID     uint      `gorm:"not null;index:idx_probe_result_pair;uniqueIndex:idx_probe_result_sample" json:"node_id"`
+	PeerNodeID uint      `gorm:"not null;index:idx_probe_result_pair" json:"peer_node_id"`
+	ProbeID    uint      `gorm:"not null;index:idx_probe_result_pair" json:"probe_id"`
+	ProbeType  string    `gorm:"size:16;not null" json:"probe_type"`
+	LatencyMs  float64   `gorm:"type:double" json:"latency_ms"`
+	Success    bool      `gorm:"not null" json:"success"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `gorm:"size:255" json:"error,omitempty"`
+	CreatedAt  time.Time `gorm:"index:idx_probe_result_pair;index" json:"created_at"`
+	SampleID   *string   `gorm:"size:64;uniqueIndex:idx_probe_result_sample" json:"-"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// Session tracks a console login so that it can be listed, renewed and revoked server-side.
+type Session struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	TokenHash    string     `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	Username     string     `gorm:"size:100;not null;index" json:"username"`
+	Role         string     `gorm:"size:20" json:"role"`
+	SourceIP     string     `gorm:"size:64" json:"source_ip"`
+	UserAgent    string     `gorm:"size:255" json:"user_agent"`
+	LastSeenAt   time.Time  `json:"last_seen_at"`
+	ExpiresAt    time.Time  `gorm:"index" json:"expires_at"`
+	MaxExpiresAt time.Time  `json:"max_expires_at"`
+	RevokedAt    *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
@@ -0,0 +1,71 @@
+package models
+
+import "time"
+
+// Status page node visibility modes.
+const (
+	StatusPageVisibilityAll      = "all"
+	StatusPageVisibilitySelected = "selected"
+)
+
+// Status page grouping modes.
+const (
+	StatusPageGroupNone   = "none"
+	StatusPageGroupRole   = "role"
+	StatusPageGroupTag    = "tag"
+	StatusPageGroupCustom = "custom"
+)
+
+// Incident impact levels and lifecycle states.
+const (
+	IncidentImpactNone     = "none"
+	IncidentImpactMinor    = "minor"
+	IncidentImpactMajor    = "major"
+	IncidentImpactCritical = "critical"
+
+	IncidentStatusInvestigating = "investigating"
+	IncidentStatusIdentified    = "identified"
+	IncidentStatusMonitoring    = "monitoring"
+	IncidentStatusResolved      = "resolved"
+)
+
+// StatusPageSetting configures the anonymous status page. It is a singleton row.
+type StatusPageSetting struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	Enabled       bool      `json:"enabled"`
+	Title         string    `gorm:"size:128" json:"title"`
+	Description   string    `gorm:"size:512" json:"description"`
+	Visibility    string    `gorm:"size:16" json:"visibility"`
+	NodeIDs       string    `gorm:"type:text" json:"-"`
+	Tags          string    `gorm:"size:255" json:"-"`
+	HideSubnetIP  bool      `json:"hide_subnet_ip"`
+	HidePublicIP  bool      `json:"hide_public_ip"`
+	HideResources bool      `json:"hide_resources"`
+	HideLatency   bool      `json:"hide_latency"`
+	GroupBy       string    `gorm:"size:16" json:"group_by"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// StatusPageNode overrides how one node is presented on the status page.
+type StatusPageNode struct {
+	NodeID    uint   `gorm:"primaryKey;autoIncrement:false" json:"node_id"`
+	Alias     string `gorm:"size:128" json:"alias"`
+	Group     string `gorm:"column:group_name;size:64" json:"group"`
+	SortOrder int    `json:"sort_order"`
+}
+
+// StatusIncident is an operator-written note shown on the status page.
+type StatusIncident struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	Title      string     `gorm:"size:255;not null" json:"title"`
+	Body       string     `gorm:"type:text" json:"body"`
+	Impact     string     `gorm:"size:16" json:"impact"`
+	Status     string     `gorm:"size:16;index" json:"status"`
+	NodeIDs    string     `gorm:"type:text" json:"-"`
+	CreatedBy  string     `gorm:"size:100" json:"created_by"`
+	StartedAt  time.Time  `json:"started_at"`
+	ResolvedAt *time.Time `gorm:"index" json:"resolved_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
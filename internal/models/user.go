@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// User roles recognised by the console.
+const (
+	UserRoleAdmin  = "admin"
+	UserRoleViewer = "viewer"
+)
+
+// User stores per-account role and second-factor state for console users. OIDCSubject binds a
+// single sign-on account to its identity provider subject (a digest of issuer and sub); it is nil
+// for the local account.
+type User struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	Username      string     `gorm:"size:100;not null;unique" json:"username"`
+	Role          string     `gorm:"size:20;not null" json:"role"`
+	OIDCSubject   *string    `gorm:"column:oidc_subject;size:64;uniqueIndex" json:"-"`
+	TOTPSecret    string     `gorm:"size:64" json:"-"`
+	TOTPEnabled   bool       `json:"totp_enabled"`
+	TOTPLastStep  int64      `json:"-"`
+	RecoveryCodes string     `gorm:"type:text" json:"-"`
+	LastLoginAt   *time.Time `json:"last_login_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// SecuritySetting stores console-wide security policy.
+type SecuritySetting struct {
+	ID                     uint      `gorm:"primaryKey" json:"id"`
+	TwoFactorRequiredRoles string    `gorm:"size:255" json:"two_factor_required_roles"`
+	UpdatedAt              time.Time `json:"updated_at"`
+	CreatedAt              time.Time `json:"created_at"`
+}
@@ -1,6 +1,7 @@
 package routes
 
 import (
+	"log"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -8,7 +9,9 @@ import (
 	"time"
 
 	"nebula_manager/internal/handlers"
+	"nebula_manager/internal/metrics"
 	"nebula_manager/internal/middleware"
+	"nebula_manager/internal/models"
 	"nebula_manager/internal/services"
 
 	"github.com/gin-contrib/cors"
@@ -22,12 +25,47 @@ type Dependencies struct {
 	Templates *handlers.TemplateHandler
 	Nodes     *handlers.NodeHandler
 	Auth      *handlers.AuthHandler
+	Audit     *handlers.AuditHandler
+	Users     *handlers.UserHandler
+	OIDC      *handlers.OIDCHandler
+	Alerts    *handlers.AlertHandler
+	Notify    *handlers.NotificationHandler
+	Metrics   *handlers.MetricsHandler
+	Events    *handlers.EventHandler
+	Status    *handlers.StatusPageHandler
+	Agent     *handlers.AgentHandler
+	Probes    *handlers.ProbeHandler
+	Diagnose  *handlers.DiagnosticHandler
+	HTTPStats *metrics.HTTPCollector
 	AuthSvc   *services.AuthService
+	Limits    RateLimiters
+	Scrape    ScrapeAccess
+	// TrustedProxies lists the proxies whose X-Forwarded-For is used for ClientIP; nil trusts none.
+	TrustedProxies []string
+}
+
+// ScrapeAccess controls who may scrape /metrics.
+type ScrapeAccess struct {
+	Token  string
+	Public bool
+}
+
+// RateLimiters holds the request budget of each route group; nil entries disable limiting.
+type RateLimiters struct {
+	Login  *middleware.RateLimiter
+	Public *middleware.RateLimiter
+	Agent  *middleware.RateLimiter
+	API    *middleware.RateLimiter
 }
 
 // New constructs the Gin router with all application routes.
 func New(deps Dependencies, staticDir string) *gin.Engine {
 	router := gin.Default()
+	// Rate limits and login lockouts key on ClientIP, which must not come from a spoofable header.
+	if err := router.SetTrustedProxies(deps.TrustedProxies); err != nil {
+		log.Printf("router: ignore trusted proxies: %v", err)
+		_ = router.SetTrustedProxies(nil)
+	}
 
 	router.Use(cors.New(cors.Config{
 		AllowOrigins: []string{"*"},
@@ -35,42 +73,132 @@ func New(deps Dependencies, staticDir string) *gin.Engine {
 		AllowHeaders: []string{"Origin", "Content-Type", "Accept", "Authorization"},
 		MaxAge:       12 * time.Hour,
 	}))
+	if deps.HTTPStats != nil {
+		router.Use(deps.HTTPStats.Middleware())
+	}
 
 	router.GET("/api/health", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"status": "ok"})
 	})
+	router.GET("/metrics", middleware.RequireMetricsAccess(deps.AuthSvc, deps.Scrape.Token, deps.Scrape.Public), deps.Metrics.Metrics)
 
-	router.POST("/api/login", deps.Auth.Login)
+	loginLimit := middleware.RateLimit(deps.Limits.Login, middleware.ClientIPKey)
+	router.POST("/api/login", loginLimit, deps.Auth.Login)
+	router.POST("/api/login/2fa", loginLimit, deps.Auth.LoginTwoFactor)
+	router.POST("/api/login/2fa/enroll", loginLimit, deps.Auth.LoginTwoFactorEnroll)
 	router.POST("/api/logout", deps.Auth.Logout)
-	router.GET("/api/public/status", deps.Nodes.PublicStatus)
-	router.GET("/api/public/nodes/:id/network", deps.Nodes.PublicNetworkStatus)
+
+	router.GET("/api/oidc/config", deps.OIDC.Config)
+	router.GET("/api/oidc/login", loginLimit, deps.OIDC.Login)
+	router.GET("/api/oidc/callback", loginLimit, deps.OIDC.Callback)
+
+	public := router.Group("/api/public")
+	public.Use(middleware.RateLimit(deps.Limits.Public, middleware.ClientIPKey))
+	public.GET("/status", deps.Status.PublicStatus)
+	public.GET("/nodes/:id/network", deps.Status.PublicNetworkStatus)
+	public.GET("/events/stream", deps.Events.PublicStream)
+
+	// Agent ingestion routes are budgeted per source host rather than per (shared) token user.
+	agent := router.Group("/api")
+	agent.Use(middleware.RequireAuth(deps.AuthSvc), middleware.RequireRole(models.UserRoleAdmin), middleware.RateLimit(deps.Limits.Agent, middleware.ClientIPKey))
+	agent.POST("/nodes/:id/status", deps.Nodes.SubmitStatus)
+	agent.GET("/nodes/:id/network/targets", deps.Nodes.NetworkTargets)
+	agent.POST("/nodes/:id/network/samples", deps.Nodes.SubmitNetworkSamples)
+	agent.GET("/nodes/:id/config/version", deps.Nodes.ConfigVersion)
+	agent.POST("/nodes/:id/config/applied", deps.Nodes.ConfigApplied)
+	agent.POST("/nodes/:id/hostmap", deps.Nodes.SubmitHostmap)
+	agent.GET("/nodes/:id/diagnostics/pending", deps.Diagnose.Pending)
+	agent.POST("/nodes/:id/diagnostics/:job/start", deps.Diagnose.Start)
+	agent.POST("/nodes/:id/diagnostics/:job/result", deps.Diagnose.Complete)
+	agent.GET("/agent/binary/:platform", deps.Agent.Binary)
+	agent.GET("/agent/release/:platform", deps.Agent.Release)
+	agent.GET("/agent/script", deps.Agent.Script)
 
 	protected := router.Group("/api")
-	protected.Use(middleware.RequireAuth(deps.AuthSvc))
+	protected.Use(middleware.RequireAuth(deps.AuthSvc), middleware.RateLimit(deps.Limits.API, middleware.UserKey))
 
+	// Read-only views are open to every console role; changes and secret-bearing downloads need admin.
 	protected.GET("/ca", deps.CA.Get)
-	protected.POST("/ca", deps.CA.Generate)
 	protected.GET("/ca/certificate", deps.CA.Certificate)
-
 	protected.GET("/settings", deps.Settings.Get)
-	protected.PUT("/settings", deps.Settings.Update)
-
 	protected.GET("/templates", deps.Templates.List)
-	protected.POST("/templates", deps.Templates.Upsert)
-	protected.DELETE("/templates/:id", deps.Templates.Delete)
-
 	protected.GET("/nodes", deps.Nodes.List)
-	protected.POST("/nodes", deps.Nodes.Create)
-	protected.GET("/nodes/:id/artifacts", deps.Nodes.Artifacts)
 	protected.GET("/nodes/:id/config", deps.Nodes.Config)
-	protected.GET("/nodes/:id/install-script", deps.Nodes.InstallScript)
-	protected.GET("/nodes/:id/bundle", deps.Nodes.Bundle)
 	protected.GET("/nodes/:id/network", deps.Nodes.NetworkStatus)
-	protected.POST("/nodes/:id/status", deps.Nodes.SubmitStatus)
-	protected.GET("/nodes/:id/network/targets", deps.Nodes.NetworkTargets)
-	protected.POST("/nodes/:id/network/samples", deps.Nodes.SubmitNetworkSamples)
-	protected.DELETE("/nodes/:id", deps.Nodes.Delete)
+	protected.GET("/nodes/:id/status/history", deps.Nodes.StatusHistory)
+	protected.GET("/nodes/:id/state/events", deps.Nodes.StateEvents)
+	protected.GET("/nodes/:id/availability", deps.Nodes.Availability)
+	protected.GET("/nodes/availability", deps.Nodes.AvailabilityOverview)
+	protected.GET("/nodes/drift", deps.Nodes.Drift)
+	protected.GET("/agent/releases", deps.Agent.Releases)
+	protected.GET("/agent/fleet", deps.Agent.Fleet)
+	protected.GET("/network/matrix", deps.Nodes.NetworkMatrix)
+	protected.GET("/network/topology", deps.Nodes.NetworkTopology)
+	protected.GET("/network/tunnels", deps.Nodes.NetworkTunnels)
+	protected.GET("/nodes/:id/probes", deps.Probes.Results)
+	protected.GET("/probes", deps.Probes.List)
+	protected.GET("/diagnostics", deps.Diagnose.List)
+	protected.GET("/diagnostics/:id", deps.Diagnose.Get)
+	protected.GET("/alerts", deps.Alerts.List)
+	protected.GET("/alerts/rules", deps.Alerts.ListRules)
+	protected.GET("/alerts/silences", deps.Alerts.ListSilences)
+	protected.GET("/events/stream", deps.Events.Stream)
+	protected.GET("/status-page", deps.Status.Get)
+	protected.GET("/status-page/incidents", deps.Status.ListIncidents)
+
 	protected.GET("/me", deps.Auth.Profile)
+	protected.GET("/sessions", deps.Auth.Sessions)
+	protected.DELETE("/sessions/:id", deps.Auth.RevokeSession)
+	protected.POST("/sessions/revoke-all", deps.Auth.RevokeAllSessions)
+
+	protected.GET("/me/2fa", deps.Users.TwoFactorStatus)
+	protected.POST("/me/2fa/enroll", deps.Users.TwoFactorEnroll)
+	protected.POST("/me/2fa/confirm", deps.Users.TwoFactorConfirm)
+	protected.POST("/me/2fa/disable", deps.Users.TwoFactorDisable)
+	protected.POST("/me/2fa/recovery-codes", deps.Users.TwoFactorRecoveryCodes)
+
+	admin := protected.Group("")
+	admin.Use(middleware.RequireRole(models.UserRoleAdmin))
+	admin.POST("/ca", deps.CA.Generate)
+	admin.PUT("/settings", deps.Settings.Update)
+	admin.POST("/templates", deps.Templates.Upsert)
+	admin.DELETE("/templates/:id", deps.Templates.Delete)
+	admin.POST("/nodes", deps.Nodes.Create)
+	admin.GET("/nodes/:id/artifacts", deps.Nodes.Artifacts)
+	admin.GET("/nodes/:id/install-script", deps.Nodes.InstallScript)
+	admin.GET("/nodes/:id/bundle", deps.Nodes.Bundle)
+	admin.DELETE("/nodes/:id", deps.Nodes.Delete)
+
+	admin.POST("/probes", deps.Probes.Create)
+	admin.PUT("/probes/:id", deps.Probes.Update)
+	admin.DELETE("/probes/:id", deps.Probes.Delete)
+	admin.POST("/diagnostics", deps.Diagnose.Create)
+	admin.POST("/diagnostics/:id/cancel", deps.Diagnose.Cancel)
+	admin.POST("/alerts/rules", deps.Alerts.CreateRule)
+	admin.PUT("/alerts/rules/:id", deps.Alerts.UpdateRule)
+	admin.DELETE("/alerts/rules/:id", deps.Alerts.DeleteRule)
+	admin.POST("/alerts/silences", deps.Alerts.CreateSilence)
+	admin.DELETE("/alerts/silences/:id", deps.Alerts.ExpireSilence)
+	admin.PUT("/status-page", deps.Status.Update)
+	admin.POST("/status-page/incidents", deps.Status.CreateIncident)
+	admin.PUT("/status-page/incidents/:id", deps.Status.UpdateIncident)
+	admin.DELETE("/status-page/incidents/:id", deps.Status.DeleteIncident)
+
+	// Channel settings hold credentials, so even reads are admin only.
+	admin.GET("/notifications/channels", deps.Notify.ListChannels)
+	admin.POST("/notifications/channels", deps.Notify.CreateChannel)
+	admin.PUT("/notifications/channels/:id", deps.Notify.UpdateChannel)
+	admin.DELETE("/notifications/channels/:id", deps.Notify.DeleteChannel)
+	admin.POST("/notifications/channels/:id/test", deps.Notify.TestChannel)
+	admin.GET("/notifications/deliveries", deps.Notify.ListDeliveries)
+
+	admin.GET("/users", deps.Users.List)
+	admin.DELETE("/users/:username/2fa", deps.Users.ResetTwoFactor)
+	admin.GET("/security/policy", deps.Users.GetSecurityPolicy)
+	admin.PUT("/security/policy", deps.Users.UpdateSecurityPolicy)
+
+	admin.GET("/audit", deps.Audit.List)
+	admin.GET("/audit/export", deps.Audit.Export)
 
 	if staticDir != "" {
 		indexFile := filepath.Join(staticDir, "index.html")
@@ -0,0 +1,258 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"nebula_manager/internal/models"
+	"nebula_manager/internal/utils"
+	agentassets "nebula_manager/scripts"
+)
+
+// AgentPlatformScript is the release name of the shell probe, which nodes without an agent build run.
+const AgentPlatformScript = "script"
+
+const (
+	agentBinaryPrefix = "nebula-agent-"
+	// agentVersionFile holds the build version of the binaries in the agent directory; the build
+	// scripts write it next to them. Without it releases are identified by checksum only.
+	agentVersionFile = "VERSION"
+)
+
+// agentPlatformPattern matches GOOS-GOARCH pairs such as linux-amd64; it also keeps the file name
+// from escaping the binary directory.
+var agentPlatformPattern = regexp.MustCompile(`^[a-z0-9]+-[a-z0-9]+$`)
+
+var (
+	// ErrInvalidAgentPlatform is returned for platform names that are not GOOS-GOARCH pairs.
+	ErrInvalidAgentPlatform = errors.New("invalid platform")
+	// ErrAgentReleaseNotFound is returned when the controller has no agent build for a platform.
+	ErrAgentReleaseNotFound = errors.New("agent release not found")
+)
+
+// AgentService publishes the node agent builds and tracks which build each node runs.
+type AgentService struct {
+	db  *gorm.DB
+	dir string
+
+	mu        sync.Mutex
+	checksums map[string]agentChecksum
+}
+
+// agentChecksum caches the digest of a binary until it is replaced.
+type agentChecksum struct {
+	modTime time.Time
+	size    int64
+	sum     string
+}
+
+// NewAgentService constructs an AgentService serving binaries named nebula-agent-<os>-<arch> from dir.
+func NewAgentService(db *gorm.DB, dir string) *AgentService {
+	return &AgentService{db: db, dir: dir, checksums: make(map[string]agentChecksum)}
+}
+
+// AgentRelease describes the build agents of a platform should run. Agents compare SHA256 with their
+// own binary to decide whether to update.
+type AgentRelease struct {
+	Platform  string `json:"platform"`
+	Version   string `json:"version,omitempty"`
+	SHA256    string `json:"sha256"`
+	Size      int64  `json:"size"`
+	UpdatedAt string `json:"updated_at,omitempty"`
+}
+
+// NodeAgentInput identifies the agent build running on a node, as reported with its status.
+type NodeAgentInput struct {
+	Version     string `json:"version" binding:"max=64"`
+	Platform    string `json:"platform" binding:"max=32"`
+	Checksum    string `json:"checksum" binding:"max=64"`
+	UpdateError string `json:"update_error"`
+}
+
+// AgentFleetEntry is the agent build a node last reported, compared with the current release of its
+// platform. UpToDate is false when the node did not report or no release exists for its platform.
+type AgentFleetEntry struct {
+	Node           NodeSummary `json:"node"`
+	Reported       bool        `json:"reported"`
+	ReportedAt     string      `json:"reported_at,omitempty"`
+	Version        string      `json:"version,omitempty"`
+	Platform       string      `json:"platform,omitempty"`
+	Checksum       string      `json:"checksum,omitempty"`
+	UpdateError    string      `json:"update_error,omitempty"`
+	LatestVersion  string      `json:"latest_version,omitempty"`
+	LatestChecksum string      `json:"latest_checksum,omitempty"`
+	UpToDate       bool        `json:"up_to_date"`
+}
+
+// BinaryPath returns the file of the agent build for platform.
+func (s *AgentService) BinaryPath(platform string) (string, error) {
+	if !agentPlatformPattern.MatchString(platform) {
+		return "", ErrInvalidAgentPlatform
+	}
+	path := filepath.Join(s.dir, agentBinaryPrefix+platform)
+	if info, err := os.Stat(path); err != nil || info.IsDir() {
+		return "", fmt.Errorf("%w for %s", ErrAgentReleaseNotFound, platform)
+	}
+	return path, nil
+}
+
+// Script returns the shell probe as served to nodes.
+func (s *AgentService) Script() []byte {
+	return []byte(agentassets.NetworkAgentScript)
+}
+
+// Release describes the current build for platform, which may be AgentPlatformScript.
+func (s *AgentService) Release(platform string) (*AgentRelease, error) {
+	if platform == AgentPlatformScript {
+		sum := utils.ArtifactHash(s.Script())
+		// The script carries no version of its own; a prefix of its digest tells builds apart.
+		return &AgentRelease{Platform: platform, Version: sum[:12], SHA256: sum, Size: int64(len(s.Script()))}, nil
+	}
+	path, err := s.BinaryPath(platform)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	sum, err := s.checksum(path, info)
+	if err != nil {
+		return nil, err
+	}
+	return &AgentRelease{
+		Platform:  platform,
+		Version:   s.version(),
+		SHA256:    sum,
+		Size:      info.Size(),
+		UpdatedAt: info.ModTime().UTC().Format(time.RFC3339),
+	}, nil
+}
+
+// Releases lists the current build of every platform the controller serves, the shell probe last.
+func (s *AgentService) Releases() ([]AgentRelease, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	var platforms []string
+	for _, entry := range entries {
+		platform, ok := strings.CutPrefix(entry.Name(), agentBinaryPrefix)
+		if ok && !entry.IsDir() && agentPlatformPattern.MatchString(platform) {
+			platforms = append(platforms, platform)
+		}
+	}
+	sort.Strings(platforms)
+	releases := make([]AgentRelease, 0, len(platforms)+1)
+	for _, platform := range append(platforms, AgentPlatformScript) {
+		release, err := s.Release(platform)
+		if errors.Is(err, ErrAgentReleaseNotFound) {
+			// Removed since the directory was read.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		releases = append(releases, *release)
+	}
+	return releases, nil
+}
+
+// Fleet compares the agent build of every node with the current release of its platform, optionally
+// returning only the reporting nodes that run another build.
+func (s *AgentService) Fleet(outdatedOnly bool) ([]AgentFleetEntry, error) {
+	var nodes []models.Node
+	if err := s.db.Order("name asc").Find(&nodes).Error; err != nil {
+		return nil, err
+	}
+	var agents []models.NodeAgent
+	if err := s.db.Find(&agents).Error; err != nil {
+		return nil, err
+	}
+	byNode := make(map[uint]models.NodeAgent, len(agents))
+	for _, agent := range agents {
+		byNode[agent.NodeID] = agent
+	}
+	releases, err := s.Releases()
+	if err != nil {
+		return nil, err
+	}
+	byPlatform := make(map[string]AgentRelease, len(releases))
+	for _, release := range releases {
+		byPlatform[release.Platform] = release
+	}
+
+	res := make([]AgentFleetEntry, 0, len(nodes))
+	for _, node := range nodes {
+		entry := AgentFleetEntry{Node: toNodeSummary(node)}
+		agent, ok := byNode[node.ID]
+		if ok {
+			entry.Reported = true
+			entry.ReportedAt = agent.ReportedAt.Format(time.RFC3339)
+			entry.Version = agent.Version
+			entry.Platform = agent.Platform
+			entry.Checksum = agent.Checksum
+			entry.UpdateError = agent.UpdateError
+			if release, ok := byPlatform[agent.Platform]; ok {
+				entry.LatestVersion = release.Version
+				entry.LatestChecksum = release.SHA256
+				entry.UpToDate = agent.Checksum == release.SHA256
+			}
+		}
+		if outdatedOnly && (!entry.Reported || entry.UpToDate) {
+			continue
+		}
+		res = append(res, entry)
+	}
+	return res, nil
+}
+
+// checksum returns the digest of the binary at path, hashing it again only after it changed.
+func (s *AgentService) checksum(path string, info os.FileInfo) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cached, ok := s.checksums[path]; ok && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.sum, nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := utils.ArtifactHash(content)
+	s.checksums[path] = agentChecksum{modTime: info.ModTime(), size: info.Size(), sum: sum}
+	return sum, nil
+}
+
+func (s *AgentService) version() string {
+	content, err := os.ReadFile(filepath.Join(s.dir, agentVersionFile))
+	if err != nil {
+		return ""
+	}
+	return truncate(strings.TrimSpace(string(content)), 64)
+}
+
+// upsertNodeAgent stores the agent build of a status report.
+func upsertNodeAgent(tx *gorm.DB, nodeID uint, input NodeAgentInput, reportedAt time.Time) error {
+	agent := models.NodeAgent{
+		NodeID:      nodeID,
+		Version:     strings.TrimSpace(input.Version),
+		Platform:    strings.TrimSpace(input.Platform),
+		Checksum:    strings.ToLower(strings.TrimSpace(input.Checksum)),
+		UpdateError: truncate(strings.TrimSpace(input.UpdateError), 255),
+		ReportedAt:  reportedAt,
+	}
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "node_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"version", "platform", "checksum", "update_error", "reported_at", "updated_at"}),
+	}).Create(&agent).Error
+}
@@ -0,0 +1,745 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"nebula_manager/internal/models"
+	"nebula_manager/internal/utils"
+)
+
+const (
+	defaultAlertInterval   = time.Minute
+	defaultLinkRuleWindow  = 5 * time.Minute
+	alertStatusFreshness   = 10 * time.Minute
+	resolvedAlertsListSize = 200
+	// neverReportedGrace is how long a node that never reported is left alone by node_offline rules,
+	// time enough to install the agent after creating the node.
+	neverReportedGrace = time.Hour
+)
+
+// ErrAlertRuleInvalid is returned for rule or silence payloads that fail validation.
+var ErrAlertRuleInvalid = errors.New("invalid alert rule")
+
+// AlertNotifier is told about alerts that start firing or get resolved.
+type AlertNotifier interface {
+	NotifyAlert(event string, alert models.Alert, rule models.AlertRule) error
+}
+
+// AlertService evaluates alert rules and tracks alert state and silences.
+type AlertService struct {
+	db       *gorm.DB
+	interval time.Duration
+	notifier AlertNotifier
+	events   *EventHub
+	now      func() time.Time
+}
+
+// NewAlertService constructs an AlertService evaluating every interval. notifier and events may be nil.
+func NewAlertService(db *gorm.DB, interval time.Duration, notifier AlertNotifier, events *EventHub) *AlertService {
+	if interval <= 0 {
+		interval = defaultAlertInterval
+	}
+	return &AlertService{db: db, interval: interval, notifier: notifier, events: events, now: time.Now}
+}
+
+// AlertEvent is published on the event hub when an alert changes state. Cleared marks a pending
+// alert that was dropped because its condition went away before it fired.
+type AlertEvent struct {
+	models.Alert
+	RuleName string `json:"rule_name"`
+	Cleared  bool   `json:"cleared,omitempty"`
+}
+
+// AlertRuleRequest carries the payload for creating or updating a rule.
+type AlertRuleRequest struct {
+	Name          string  `json:"name" binding:"required"`
+	Kind          string  `json:"kind" binding:"required"`
+	Threshold     float64 `json:"threshold"`
+	ForSeconds    int     `json:"for_seconds"`
+	WindowSeconds int     `json:"window_seconds"`
+	Severity      string  `json:"severity"`
+	NodeID        uint    `json:"node_id"`
+	Tag           string  `json:"tag"`
+	Enabled       *bool   `json:"enabled"`
+	Description   string  `json:"description"`
+}
+
+// AlertSilenceRequest carries the payload for creating a silence.
+type AlertSilenceRequest struct {
+	RuleID   uint   `json:"rule_id"`
+	NodeID   uint   `json:"node_id"`
+	Comment  string `json:"comment"`
+	StartsAt string `json:"starts_at"`
+	EndsAt   string `json:"ends_at"`
+	Duration string `json:"duration"`
+}
+
+// AlertView is an alert as returned by the API.
+type AlertView struct {
+	models.Alert
+	RuleName string `json:"rule_name"`
+	Silenced bool   `json:"silenced"`
+}
+
+// AlertFilter narrows ListAlerts; State may be active (pending+firing), pending, firing, resolved or all.
+type AlertFilter struct {
+	State  string
+	NodeID uint
+}
+
+// Start evaluates rules in the background until ctx is cancelled.
+func (s *AlertService) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			if err := s.Evaluate(); err != nil {
+				// Failures of several rules are joined one per line.
+				for _, line := range strings.Split(err.Error(), "\n") {
+					log.Printf("alerts: %s", line)
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// EnsureDefaultRules seeds a starter rule set when no rules exist yet.
+func (s *AlertService) EnsureDefaultRules() error {
+	var count int64
+	if err := s.db.Model(&models.AlertRule{}).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	defaults := []models.AlertRule{
+		{Name: "节点离线", Kind: models.AlertKindNodeOffline, Threshold: 5, Severity: models.AlertSeverityCritical, Enabled: true, Description: "超过 5 分钟未上报运行状态"},
+		{Name: "磁盘空间不足", Kind: models.AlertKindDiskHigh, Threshold: 90, ForSeconds: 600, Severity: models.AlertSeverityWarning, Enabled: true, Description: "磁盘使用率持续 10 分钟高于 90%"},
+		{Name: "链路丢包", Kind: models.AlertKindLinkLoss, Threshold: 20, ForSeconds: 300, WindowSeconds: 300, Severity: models.AlertSeverityWarning, Enabled: true, Description: "节点间丢包率持续 5 分钟高于 20%"},
+		{Name: "证书即将过期", Kind: models.AlertKindCertExpiry, Threshold: 30, Severity: models.AlertSeverityWarning, Enabled: true, Description: "节点或 CA 证书 30 天内过期"},
+	}
+	return s.db.Create(&defaults).Error
+}
+
+// ListRules returns all alert rules.
+func (s *AlertService) ListRules() ([]models.AlertRule, error) {
+	var rules []models.AlertRule
+	if err := s.db.Order("id asc").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// GetRule loads a rule by ID.
+func (s *AlertService) GetRule(id uint) (*models.AlertRule, error) {
+	var rule models.AlertRule
+	if err := s.db.First(&rule, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("alert rule %d not found", id)
+		}
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// CreateRule validates and stores a new rule.
+func (s *AlertService) CreateRule(req AlertRuleRequest) (*models.AlertRule, error) {
+	rule := models.AlertRule{Enabled: true}
+	if err := applyAlertRuleRequest(&rule, req); err != nil {
+		return nil, err
+	}
+	if err := s.db.Create(&rule).Error; err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// UpdateRule replaces a rule's definition. Open alerts are re-evaluated on the next cycle.
+func (s *AlertService) UpdateRule(id uint, req AlertRuleRequest) (*models.AlertRule, error) {
+	rule, err := s.GetRule(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyAlertRuleRequest(rule, req); err != nil {
+		return nil, err
+	}
+	if err := s.db.Save(rule).Error; err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+// DeleteRule removes a rule and resolves its open alerts.
+func (s *AlertService) DeleteRule(id uint) error {
+	rule, err := s.GetRule(id)
+	if err != nil {
+		return err
+	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		now := s.now()
+		if err := tx.Model(&models.Alert{}).
+			Where("rule_id = ? AND state IN ?", rule.ID, []string{models.AlertStatePending, models.AlertStateFiring}).
+			Updates(map[string]any{"state": models.AlertStateResolved, "resolved_at": now}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(rule).Error
+	})
+}
+
+func applyAlertRuleRequest(rule *models.AlertRule, req AlertRuleRequest) error {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrAlertRuleInvalid)
+	}
+	switch req.Kind {
+	case models.AlertKindNodeOffline, models.AlertKindCPUHigh, models.AlertKindDiskHigh,
+		models.AlertKindLinkLoss, models.AlertKindLinkLatency, models.AlertKindCertExpiry:
+	default:
+		return fmt.Errorf("%w: unknown kind %q", ErrAlertRuleInvalid, req.Kind)
+	}
+	if req.Threshold <= 0 {
+		return fmt.Errorf("%w: threshold must be positive", ErrAlertRuleInvalid)
+	}
+	if req.ForSeconds < 0 || req.WindowSeconds < 0 {
+		return fmt.Errorf("%w: durations must not be negative", ErrAlertRuleInvalid)
+	}
+	switch req.Severity {
+	case "":
+		req.Severity = models.AlertSeverityWarning
+	case models.AlertSeverityInfo, models.AlertSeverityWarning, models.AlertSeverityCritical:
+	default:
+		return fmt.Errorf("%w: unknown severity %q", ErrAlertRuleInvalid, req.Severity)
+	}
+
+	rule.Name = req.Name
+	rule.Kind = req.Kind
+	rule.Threshold = req.Threshold
+	rule.ForSeconds = req.ForSeconds
+	rule.WindowSeconds = req.WindowSeconds
+	rule.Severity = req.Severity
+	rule.NodeID = req.NodeID
+	rule.Tag = strings.TrimSpace(req.Tag)
+	rule.Description = strings.TrimSpace(req.Description)
+	if req.Enabled != nil {
+		rule.Enabled = *req.Enabled
+	}
+	return nil
+}
+
+// ListAlerts returns alerts matching the filter, newest first, with their silence state.
+func (s *AlertService) ListAlerts(filter AlertFilter) ([]AlertView, error) {
+	query := s.db.Model(&models.Alert{})
+	switch filter.State {
+	case "", "active":
+		query = query.Where("state IN ?", []string{models.AlertStatePending, models.AlertStateFiring})
+	case models.AlertStatePending, models.AlertStateFiring, models.AlertStateResolved:
+		query = query.Where("state = ?", filter.State)
+	case "all":
+	default:
+		return nil, fmt.Errorf("%w: unknown state %q", ErrAlertRuleInvalid, filter.State)
+	}
+	if filter.NodeID != 0 {
+		query = query.Where("node_id = ? OR peer_node_id = ?", filter.NodeID, filter.NodeID)
+	}
+	var alerts []models.Alert
+	if err := query.Order("started_at desc").Limit(resolvedAlertsListSize).Find(&alerts).Error; err != nil {
+		return nil, err
+	}
+
+	rules, err := s.ListRules()
+	if err != nil {
+		return nil, err
+	}
+	ruleNames := make(map[uint]string, len(rules))
+	for _, rule := range rules {
+		ruleNames[rule.ID] = rule.Name
+	}
+	silences, err := s.activeSilences()
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+	views := make([]AlertView, 0, len(alerts))
+	for _, alert := range alerts {
+		views = append(views, AlertView{Alert: alert, RuleName: ruleNames[alert.RuleID], Silenced: silenced(silences, alert, now)})
+	}
+	return views, nil
+}
+
+// ListSilences returns silences; expired ones are included only on request.
+func (s *AlertService) ListSilences(includeExpired bool) ([]models.AlertSilence, error) {
+	query := s.db.Order("ends_at desc")
+	if !includeExpired {
+		query = query.Where("ends_at > ?", s.now())
+	}
+	var silences []models.AlertSilence
+	if err := query.Find(&silences).Error; err != nil {
+		return nil, err
+	}
+	return silences, nil
+}
+
+// CreateSilence stores a silence. Either ends_at or duration must be given.
+func (s *AlertService) CreateSilence(req AlertSilenceRequest, actor string) (*models.AlertSilence, error) {
+	now := s.now()
+	silence := models.AlertSilence{
+		RuleID:    req.RuleID,
+		NodeID:    req.NodeID,
+		Comment:   strings.TrimSpace(req.Comment),
+		CreatedBy: actor,
+		StartsAt:  now,
+	}
+	if req.StartsAt != "" {
+		parsed, err := time.Parse(time.RFC3339, req.StartsAt)
+		if err != nil {
+			return nil, fmt.Errorf("%w: starts_at must be RFC3339", ErrAlertRuleInvalid)
+		}
+		silence.StartsAt = parsed
+	}
+	switch {
+	case req.EndsAt != "":
+		parsed, err := time.Parse(time.RFC3339, req.EndsAt)
+		if err != nil {
+			return nil, fmt.Errorf("%w: ends_at must be RFC3339", ErrAlertRuleInvalid)
+		}
+		silence.EndsAt = parsed
+	case req.Duration != "":
+		duration, err := time.ParseDuration(req.Duration)
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("%w: duration must be a positive duration such as 2h", ErrAlertRuleInvalid)
+		}
+		silence.EndsAt = silence.StartsAt.Add(duration)
+	default:
+		return nil, fmt.Errorf("%w: ends_at or duration is required", ErrAlertRuleInvalid)
+	}
+	if !silence.EndsAt.After(silence.StartsAt) {
+		return nil, fmt.Errorf("%w: silence must end after it starts", ErrAlertRuleInvalid)
+	}
+	if silence.RuleID != 0 {
+		if _, err := s.GetRule(silence.RuleID); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.db.Create(&silence).Error; err != nil {
+		return nil, err
+	}
+	return &silence, nil
+}
+
+// ExpireSilence ends a silence immediately.
+func (s *AlertService) ExpireSilence(id uint) error {
+	result := s.db.Model(&models.AlertSilence{}).Where("id = ? AND ends_at > ?", id, s.now()).Update("ends_at", s.now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("active silence %d not found", id)
+	}
+	return nil
+}
+
+func (s *AlertService) activeSilences() ([]models.AlertSilence, error) {
+	now := s.now()
+	var silences []models.AlertSilence
+	if err := s.db.Where("starts_at <= ? AND ends_at > ?", now, now).Find(&silences).Error; err != nil {
+		return nil, err
+	}
+	return silences, nil
+}
+
+// IsSilenced reports whether any active silence covers the alert.
+func (s *AlertService) IsSilenced(alert models.Alert) (bool, error) {
+	silences, err := s.activeSilences()
+	if err != nil {
+		return false, err
+	}
+	return silenced(silences, alert, s.now()), nil
+}
+
+func silenced(silences []models.AlertSilence, alert models.Alert, t time.Time) bool {
+	for _, silence := range silences {
+		if silence.Matches(alert, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// alertObservation is a subject for which a rule's condition currently holds.
+type alertObservation struct {
+	fingerprint string
+	nodeID      uint
+	peerID      uint
+	subject     string
+	summary     string
+	value       float64
+}
+
+// alertSnapshot is the data a single evaluation cycle reads once and shares across rules.
+type alertSnapshot struct {
+	now      time.Time
+	nodes    []models.Node
+	names    map[uint]string
+	statuses map[uint]models.NodeStatus
+	cas      []models.CA
+	links    map[time.Duration]map[pairKey]PingPoint
+}
+
+// Evaluate runs every enabled rule once and advances alert states. A failing rule does not keep the
+// others from being evaluated; the failures are returned together.
+func (s *AlertService) Evaluate() error {
+	rules, err := s.ListRules()
+	if err != nil {
+		return err
+	}
+	snapshot, err := s.loadSnapshot()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, rule := range rules {
+		if err := s.evaluateRule(rule, snapshot); err != nil {
+			errs = append(errs, fmt.Errorf("rule %s: %w", rule.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *AlertService) evaluateRule(rule models.AlertRule, snapshot *alertSnapshot) error {
+	if !rule.Enabled {
+		// Disabling a rule resolves whatever it had raised.
+		return s.apply(rule, nil, snapshot)
+	}
+	observations, err := s.observe(rule, snapshot)
+	if err != nil {
+		return err
+	}
+	return s.apply(rule, observations, snapshot)
+}
+
+func (s *AlertService) loadSnapshot() (*alertSnapshot, error) {
+	snapshot := &alertSnapshot{
+		now:      s.now(),
+		names:    make(map[uint]string),
+		statuses: make(map[uint]models.NodeStatus),
+		links:    make(map[time.Duration]map[pairKey]PingPoint),
+	}
+	if err := s.db.Find(&snapshot.nodes).Error; err != nil {
+		return nil, err
+	}
+	for _, node := range snapshot.nodes {
+		snapshot.names[node.ID] = node.Name
+	}
+	var statuses []models.NodeStatus
+	if err := s.db.Find(&statuses).Error; err != nil {
+		return nil, err
+	}
+	for _, status := range statuses {
+		snapshot.statuses[status.NodeID] = status
+	}
+	if err := s.db.Find(&snapshot.cas).Error; err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// nodeDeleted reports whether id names a node that no longer exists; zero names none.
+func (snapshot *alertSnapshot) nodeDeleted(id uint) bool {
+	if id == 0 {
+		return false
+	}
+	_, ok := snapshot.names[id]
+	return !ok
+}
+
+// linkWindow aggregates raw samples per ordered pair over the window, cached per cycle.
+func (s *AlertService) linkWindow(snapshot *alertSnapshot, window time.Duration) (map[pairKey]PingPoint, error) {
+	if cached, ok := snapshot.links[window]; ok {
+		return cached, nil
+	}
+	from := snapshot.now.Add(-window)
+	var records []models.NodePing
+	if err := s.db.Where("created_at >= ? AND path = ?", from, models.PingPathOverlay).Order("created_at asc").Find(&records).Error; err != nil {
+		return nil, err
+	}
+	accumulators := make(map[pairKey]*pingAccumulator)
+	for _, rec := range records {
+		key := pairKey{source: rec.NodeID, target: rec.PeerNodeID}
+		if accumulators[key] == nil {
+			accumulators[key] = &pingAccumulator{}
+		}
+		accumulators[key].addRaw(rec)
+	}
+	points := make(map[pairKey]PingPoint, len(accumulators))
+	for key, acc := range accumulators {
+		points[key] = acc.point(from)
+	}
+	snapshot.links[window] = points
+	return points, nil
+}
+
+func ruleMatchesNode(rule models.AlertRule, node models.Node) bool {
+	if rule.NodeID != 0 && rule.NodeID != node.ID {
+		return false
+	}
+	if rule.Tag == "" {
+		return true
+	}
+	for _, tag := range strings.Split(node.Tags, ",") {
+		if strings.TrimSpace(tag) == rule.Tag {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *AlertService) observe(rule models.AlertRule, snapshot *alertSnapshot) ([]alertObservation, error) {
+	var observations []alertObservation
+	nodeObservation := func(node models.Node, value float64, summary string) alertObservation {
+		return alertObservation{
+			fingerprint: fmt.Sprintf("%d:node:%d", rule.ID, node.ID),
+			nodeID:      node.ID,
+			subject:     node.Name,
+			summary:     summary,
+			value:       value,
+		}
+	}
+
+	switch rule.Kind {
+	case models.AlertKindNodeOffline, models.AlertKindCPUHigh, models.AlertKindDiskHigh:
+		for _, node := range snapshot.nodes {
+			if !ruleMatchesNode(rule, node) {
+				continue
+			}
+			status, ok := snapshot.statuses[node.ID]
+			if !ok || status.ReportedAt.IsZero() {
+				if rule.Kind != models.AlertKindNodeOffline {
+					continue
+				}
+				age := snapshot.now.Sub(node.CreatedAt)
+				if minutes := age.Minutes(); age >= neverReportedGrace && minutes >= rule.Threshold {
+					observations = append(observations, nodeObservation(node, minutes,
+						fmt.Sprintf("%s 创建 %.0f 分钟后仍未上报状态", node.Name, minutes)))
+				}
+				continue
+			}
+			age := snapshot.now.Sub(status.ReportedAt)
+			switch rule.Kind {
+			case models.AlertKindNodeOffline:
+				minutes := age.Minutes()
+				if minutes >= rule.Threshold {
+					observations = append(observations, nodeObservation(node, minutes,
+						fmt.Sprintf("%s 已 %.0f 分钟未上报状态", node.Name, minutes)))
+				}
+			case models.AlertKindCPUHigh:
+				if age <= alertStatusFreshness && status.CPUUsage >= rule.Threshold {
+					observations = append(observations, nodeObservation(node, status.CPUUsage,
+						fmt.Sprintf("%s CPU 使用率 %.1f%%（阈值 %.0f%%）", node.Name, status.CPUUsage, rule.Threshold)))
+				}
+			case models.AlertKindDiskHigh:
+				if age <= alertStatusFreshness && status.DiskTotal > 0 {
+					usage := float64(status.DiskUsed) / float64(status.DiskTotal) * 100
+					if usage >= rule.Threshold {
+						observations = append(observations, nodeObservation(node, usage,
+							fmt.Sprintf("%s 磁盘使用率 %.1f%%（阈值 %.0f%%）", node.Name, usage, rule.Threshold)))
+					}
+				}
+			}
+		}
+
+	case models.AlertKindLinkLoss, models.AlertKindLinkLatency:
+		window := time.Duration(rule.WindowSeconds) * time.Second
+		if window <= 0 {
+			window = defaultLinkRuleWindow
+		}
+		links, err := s.linkWindow(snapshot, window)
+		if err != nil {
+			return nil, err
+		}
+		for _, node := range snapshot.nodes {
+			if !ruleMatchesNode(rule, node) {
+				continue
+			}
+			for key, point := range links {
+				if key.source != node.ID {
+					continue
+				}
+				peerName, ok := snapshot.names[key.target]
+				if !ok {
+					continue
+				}
+				var value float64
+				var summary string
+				if rule.Kind == models.AlertKindLinkLoss {
+					value = point.LossPercent
+					summary = fmt.Sprintf("%s → %s 丢包率 %.1f%%（阈值 %.0f%%）", node.Name, peerName, value, rule.Threshold)
+				} else {
+					if !point.Success {
+						continue
+					}
+					value = point.LatencyMs
+					summary = fmt.Sprintf("%s → %s 平均延迟 %.1fms（阈值 %.0fms）", node.Name, peerName, value, rule.Threshold)
+				}
+				if value < rule.Threshold {
+					continue
+				}
+				observations = append(observations, alertObservation{
+					fingerprint: fmt.Sprintf("%d:link:%d:%d", rule.ID, key.source, key.target),
+					nodeID:      key.source,
+					peerID:      key.target,
+					subject:     node.Name + " → " + peerName,
+					summary:     summary,
+					value:       value,
+				})
+			}
+		}
+
+	case models.AlertKindCertExpiry:
+		for _, node := range snapshot.nodes {
+			if !ruleMatchesNode(rule, node) || node.CertificatePEM == "" {
+				continue
+			}
+			info, err := utils.ParseNebulaCertificate(node.CertificatePEM)
+			if err != nil {
+				continue
+			}
+			days := info.NotAfter.Sub(snapshot.now).Hours() / 24
+			if days <= rule.Threshold {
+				observations = append(observations, nodeObservation(node, days,
+					fmt.Sprintf("%s 的节点证书将于 %s 过期（剩余 %.0f 天）", node.Name, info.NotAfter.Format("2006-01-02"), days)))
+			}
+		}
+		if rule.NodeID == 0 && rule.Tag == "" {
+			for _, ca := range snapshot.cas {
+				info, err := utils.ParseNebulaCertificate(ca.CertificatePEM)
+				if err != nil {
+					continue
+				}
+				days := info.NotAfter.Sub(snapshot.now).Hours() / 24
+				if days <= rule.Threshold {
+					observations = append(observations, alertObservation{
+						fingerprint: fmt.Sprintf("%d:ca:%d", rule.ID, ca.ID),
+						subject:     "CA " + ca.Name,
+						summary:     fmt.Sprintf("CA %s 将于 %s 过期（剩余 %.0f 天）", ca.Name, info.NotAfter.Format("2006-01-02"), days),
+						value:       days,
+					})
+				}
+			}
+		}
+	}
+	return observations, nil
+}
+
+// apply moves the rule's alerts through pending → firing → resolved based on the observations.
+// Alerts of nodes deleted since are resolved without a notification.
+func (s *AlertService) apply(rule models.AlertRule, observations []alertObservation, snapshot *alertSnapshot) error {
+	now := snapshot.now
+	var open []models.Alert
+	if err := s.db.Where("rule_id = ? AND state IN ?", rule.ID, []string{models.AlertStatePending, models.AlertStateFiring}).
+		Find(&open).Error; err != nil {
+		return err
+	}
+	openByFingerprint := make(map[string]models.Alert, len(open))
+	for _, alert := range open {
+		openByFingerprint[alert.Fingerprint] = alert
+	}
+
+	holdFor := time.Duration(rule.ForSeconds) * time.Second
+	seen := make(map[string]bool, len(observations))
+	for _, obs := range observations {
+		seen[obs.fingerprint] = true
+		alert, exists := openByFingerprint[obs.fingerprint]
+		if !exists {
+			alert = models.Alert{
+				RuleID:      rule.ID,
+				Fingerprint: obs.fingerprint,
+				Kind:        rule.Kind,
+				State:       models.AlertStatePending,
+				NodeID:      obs.nodeID,
+				PeerNodeID:  obs.peerID,
+				StartedAt:   now,
+			}
+		}
+		alert.Severity = rule.Severity
+		alert.Subject = truncate(obs.subject, 255)
+		alert.Summary = truncate(obs.summary, 512)
+		alert.Value = obs.value
+		alert.LastEvaluatedAt = now
+		fired := false
+		created := !exists
+		if alert.State == models.AlertStatePending && now.Sub(alert.StartedAt) >= holdFor {
+			firedAt := now
+			alert.State = models.AlertStateFiring
+			alert.FiredAt = &firedAt
+			fired = true
+		}
+		if err := s.db.Save(&alert).Error; err != nil {
+			return err
+		}
+		if created || fired {
+			s.events.Publish(EventTopicAlert+"."+alert.State, AlertEvent{Alert: alert, RuleName: rule.Name}, nil)
+		}
+		if fired {
+			s.notify(models.NotificationEventFiring, alert, rule)
+		}
+	}
+
+	for fingerprint, alert := range openByFingerprint {
+		if seen[fingerprint] {
+			continue
+		}
+		// A pending alert whose condition cleared before firing leaves no trace.
+		if alert.State == models.AlertStatePending {
+			if err := s.db.Delete(&alert).Error; err != nil {
+				return err
+			}
+			s.events.Publish(EventTopicAlert+".cleared", AlertEvent{Alert: alert, RuleName: rule.Name, Cleared: true}, nil)
+			continue
+		}
+		resolved := now
+		alert.State = models.AlertStateResolved
+		alert.ResolvedAt = &resolved
+		alert.LastEvaluatedAt = now
+		if err := s.db.Save(&alert).Error; err != nil {
+			return err
+		}
+		s.events.Publish(EventTopicAlert+"."+alert.State, AlertEvent{Alert: alert, RuleName: rule.Name}, nil)
+		if snapshot.nodeDeleted(alert.NodeID) || snapshot.nodeDeleted(alert.PeerNodeID) {
+			continue
+		}
+		s.notify(models.NotificationEventResolved, alert, rule)
+	}
+	return nil
+}
+
+// notify hands a transition to the notifier unless the alert is silenced. Failures are logged so
+// that a broken channel never stalls evaluation.
+func (s *AlertService) notify(event string, alert models.Alert, rule models.AlertRule) {
+	if s.notifier == nil {
+		return
+	}
+	muted, err := s.IsSilenced(alert)
+	if err != nil {
+		log.Printf("alerts: silence lookup for alert %d: %v", alert.ID, err)
+		return
+	}
+	if muted {
+		return
+	}
+	if err := s.notifier.NotifyAlert(event, alert, rule); err != nil {
+		log.Printf("alerts: notify alert %d: %v", alert.ID, err)
+	}
+}
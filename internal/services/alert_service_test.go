@@ -0,0 +1,53 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"nebula_manager/internal/models"
+)
+
+func TestObserveNodeOffline(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	node := func(id uint, created time.Duration) models.Node {
+		return models.Node{ID: id, Name: "node", CreatedAt: now.Add(-created)}
+	}
+	snapshot := &alertSnapshot{
+		now: now,
+		nodes: []models.Node{
+			node(1, 24*time.Hour),
+			node(2, 24*time.Hour),
+			node(3, 30*time.Minute),
+			node(4, 3*time.Hour),
+		},
+		statuses: map[uint]models.NodeStatus{
+			1: {NodeID: 1, ReportedAt: now.Add(-2 * time.Minute)},
+			2: {NodeID: 2, ReportedAt: now.Add(-20 * time.Minute)},
+		},
+	}
+	rule := models.AlertRule{ID: 9, Kind: models.AlertKindNodeOffline, Threshold: 5}
+
+	observations, err := (&AlertService{}).observe(rule, snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Node 3 never reported but is still within the grace period after its creation.
+	want := map[uint]float64{2: 20, 4: 180}
+	if len(observations) != len(want) {
+		t.Fatalf("observations = %+v, want nodes %v", observations, want)
+	}
+	for _, obs := range observations {
+		if minutes, ok := want[obs.nodeID]; !ok || obs.value != minutes {
+			t.Errorf("node %d offline for %.0f minutes, want %v", obs.nodeID, obs.value, want)
+		}
+	}
+}
+
+func TestAlertSnapshotNodeDeleted(t *testing.T) {
+	snapshot := &alertSnapshot{names: map[uint]string{1: "a"}}
+	for id, want := range map[uint]bool{0: false, 1: false, 2: true} {
+		if got := snapshot.nodeDeleted(id); got != want {
+			t.Errorf("nodeDeleted(%d) = %v, want %v", id, got, want)
+		}
+	}
+}
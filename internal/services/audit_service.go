@@ -0,0 +1,212 @@
+package services
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+
+	"nebula_manager/internal/models"
+)
+
+// Audit action identifiers recorded by handlers.
+const (
+	AuditActionLogin             = "auth.login"
+	AuditActionLoginTwoFactor    = "auth.login_2fa"
+	AuditActionLogout            = "auth.logout"
+	AuditActionTwoFactorChange   = "auth.2fa_change"
+	AuditActionSecurityPolicy    = "security.policy_update"
+	AuditActionSessionRevoke     = "auth.session_revoke"
+	AuditActionSessionRevokeAll  = "auth.session_revoke_all"
+	AuditActionCAGenerate        = "ca.generate"
+	AuditActionSettingsUpdate    = "settings.update"
+	AuditActionTemplateUpsert    = "template.upsert"
+	AuditActionTemplateDelete    = "template.delete"
+	AuditActionNodeCreate        = "node.create"
+	AuditActionNodeDelete        = "node.delete"
+	AuditActionNodeArtifacts     = "node.artifacts"
+	AuditActionNodeBundle        = "node.bundle"
+	AuditActionNodeInstallScript = "node.install_script"
+	AuditActionAuditExport       = "audit.export"
+	AuditActionAlertRuleUpsert   = "alert.rule_upsert"
+	AuditActionAlertRuleDelete   = "alert.rule_delete"
+	AuditActionAlertSilence      = "alert.silence"
+	AuditActionAlertUnsilence    = "alert.unsilence"
+	AuditActionChannelUpsert     = "notification.channel_upsert"
+	AuditActionChannelDelete     = "notification.channel_delete"
+	AuditActionStatusPageUpdate  = "status_page.update"
+	AuditActionIncidentUpsert    = "status_page.incident_upsert"
+	AuditActionIncidentDelete    = "status_page.incident_delete"
+	AuditActionProbeUpsert       = "probe.upsert"
+	AuditActionProbeDelete       = "probe.delete"
+	AuditActionDiagnosticRun     = "diagnostic.run"
+	AuditActionDiagnosticCancel  = "diagnostic.cancel"
+)
+
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 500
+	maxAuditExportRows   = 10000
+)
+
+// AuditService persists and queries the append-only audit log.
+type AuditService struct {
+	db *gorm.DB
+}
+
+// NewAuditService constructs an AuditService.
+func NewAuditService(db *gorm.DB) *AuditService {
+	return &AuditService{db: db}
+}
+
+// AuditEntry describes a single action to be recorded.
+type AuditEntry struct {
+	Actor    string
+	SourceIP string
+	Action   string
+	Target   string
+	Summary  string
+	Success  bool
+}
+
+// AuditFilter narrows audit log queries.
+type AuditFilter struct {
+	Actor    string
+	Action   string
+	Target   string
+	From     time.Time
+	To       time.Time
+	Page     int
+	PageSize int
+}
+
+// Record appends an entry to the audit log.
+func (s *AuditService) Record(entry AuditEntry) error {
+	log := models.AuditLog{
+		Actor:    truncate(entry.Actor, 100),
+		SourceIP: truncate(entry.SourceIP, 64),
+		Action:   truncate(entry.Action, 64),
+		Target:   truncate(entry.Target, 255),
+		Summary:  entry.Summary,
+		Success:  entry.Success,
+	}
+	return s.db.Create(&log).Error
+}
+
+// AuditPage is one page of audit entries with the paging actually applied.
+type AuditPage struct {
+	Logs     []models.AuditLog
+	Total    int64
+	Page     int
+	PageSize int
+}
+
+// List returns a page of audit entries matching the filter, newest first, with the total match count.
+// Out-of-range page numbers and sizes are clamped; the page reports the values used.
+func (s *AuditService) List(filter AuditFilter) (*AuditPage, error) {
+	page := filter.Page
+	if page < 1 {
+		page = 1
+	}
+	size := filter.PageSize
+	if size <= 0 {
+		size = defaultAuditPageSize
+	}
+	if size > maxAuditPageSize {
+		size = maxAuditPageSize
+	}
+
+	query := s.applyFilter(filter)
+	result := &AuditPage{Page: page, PageSize: size}
+	if err := query.Count(&result.Total).Error; err != nil {
+		return nil, err
+	}
+	if err := query.Order("created_at desc, id desc").Offset((page - 1) * size).Limit(size).Find(&result.Logs).Error; err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Export returns all entries matching the filter (capped) for CSV/JSON download.
+func (s *AuditService) Export(filter AuditFilter) ([]models.AuditLog, error) {
+	var logs []models.AuditLog
+	if err := s.applyFilter(filter).Order("created_at desc, id desc").Limit(maxAuditExportRows).Find(&logs).Error; err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+
+func (s *AuditService) applyFilter(filter AuditFilter) *gorm.DB {
+	query := s.db.Model(&models.AuditLog{})
+	if actor := strings.TrimSpace(filter.Actor); actor != "" {
+		query = query.Where("actor = ?", actor)
+	}
+	if action := strings.TrimSpace(filter.Action); action != "" {
+		if strings.HasSuffix(action, ".*") {
+			query = query.Where("action LIKE ?", escapeLike(strings.TrimSuffix(action, "*"))+"%")
+		} else {
+			query = query.Where("action = ?", action)
+		}
+	}
+	if target := strings.TrimSpace(filter.Target); target != "" {
+		query = query.Where("target LIKE ?", "%"+escapeLike(target)+"%")
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("created_at <= ?", filter.To)
+	}
+	return query
+}
+
+// likeEscaper escapes LIKE wildcards so that user input matches literally (backslash is MySQL's
+// default escape character).
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func escapeLike(val string) string {
+	return likeEscaper.Replace(val)
+}
+
+// SummarizeChanges describes the differences between two values of the same struct type
+// as "field: old -> new" pairs, using JSON tag names where present.
+func SummarizeChanges(before, after any) string {
+	bv := reflect.Indirect(reflect.ValueOf(before))
+	av := reflect.Indirect(reflect.ValueOf(after))
+	if !bv.IsValid() || !av.IsValid() || bv.Type() != av.Type() || bv.Kind() != reflect.Struct {
+		return ""
+	}
+
+	changes := make([]string, 0)
+	for i := 0; i < bv.NumField(); i++ {
+		field := bv.Type().Field(i)
+		if !field.IsExported() || field.Type == reflect.TypeOf(time.Time{}) {
+			continue
+		}
+		name := field.Name
+		if tag := strings.Split(field.Tag.Get("json"), ",")[0]; tag != "" && tag != "-" {
+			name = tag
+		}
+		oldVal := bv.Field(i).Interface()
+		newVal := av.Field(i).Interface()
+		if reflect.DeepEqual(oldVal, newVal) {
+			continue
+		}
+		changes = append(changes, fmt.Sprintf("%s: %v -> %v", name, oldVal, newVal))
+	}
+	return strings.Join(changes, "; ")
+}
+
+// truncate shortens val to at most limit bytes without splitting a multi-byte rune.
+func truncate(val string, limit int) string {
+	if len(val) <= limit {
+		return val
+	}
+	for limit > 0 && !utf8.RuneStart(val[limit]) {
+		limit--
+	}
+	return val[:limit]
+}
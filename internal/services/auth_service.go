@@ -2,40 +2,61 @@ package services
 
 import (
 	"crypto/hmac"
-	"crypto/rand"
 	"crypto/sha256"
 	"crypto/subtle"
-	"encoding/base64"
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"strconv"
-	"strings"
 	"time"
+
+	"gorm.io/gorm"
+
+	"nebula_manager/internal/models"
 )
 
-// AuthService manages simple username/password verification and signed session tokens.
+// sessionTouchInterval limits how often a session's last-seen timestamp is written back.
+const sessionTouchInterval = time.Minute
+
+// AuthService manages username/password verification and server-side console sessions.
 type AuthService struct {
+	db            *gorm.DB
 	username      string
 	password      string
 	secret        []byte
-	sessionTTL    time.Duration
+	idleTimeout   time.Duration
+	maxLifetime   time.Duration
 	cookieName    string
 	secureCookies bool
 	staticToken   string
 }
 
+// SessionMeta carries request details stored alongside a new session.
+type SessionMeta struct {
+	SourceIP  string
+	UserAgent string
+}
+
 // NewAuthService constructs an AuthService.
-func NewAuthService(username, password, secret string, secureCookie bool, staticToken string) *AuthService {
-	ttl := 24 * time.Hour
+func NewAuthService(db *gorm.DB, username, password, secret string, idleTimeout, maxLifetime time.Duration, secureCookie bool, staticToken string) *AuthService {
 	if len(secret) == 0 {
 		secret = "nebula-session-secret"
 	}
+	if idleTimeout <= 0 {
+		idleTimeout = 12 * time.Hour
+	}
+	if maxLifetime <= 0 {
+		maxLifetime = 7 * 24 * time.Hour
+	}
+	if idleTimeout > maxLifetime {
+		idleTimeout = maxLifetime
+	}
 	return &AuthService{
+		db:            db,
 		username:      username,
 		password:      password,
 		secret:        []byte(secret),
-		sessionTTL:    ttl,
+		idleTimeout:   idleTimeout,
+		maxLifetime:   maxLifetime,
 		cookieName:    "nebula_session",
 		secureCookies: secureCookie,
 		staticToken:   staticToken,
@@ -67,62 +88,155 @@ func (s *AuthService) ValidateCredentials(username, password string) bool {
 	return subtleConstantCompare(username, s.username) && subtleConstantCompare(password, s.password)
 }
 
-// IssueToken creates a signed session token for the specified username.
-func (s *AuthService) IssueToken(username string) (token string, expiresAt time.Time, err error) {
-	expiresAt = time.Now().Add(s.sessionTTL)
-	nonce := make([]byte, 16)
-	if _, err = rand.Read(nonce); err != nil {
-		return "", time.Time{}, fmt.Errorf("generate nonce: %w", err)
+// IssueToken creates a new server-side session for the specified user and returns its bearer token.
+func (s *AuthService) IssueToken(username, role string, meta SessionMeta) (string, *models.Session, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", nil, err
 	}
 
-	payload := fmt.Sprintf("%s|%d|%s", username, expiresAt.Unix(), hex.EncodeToString(nonce))
-	signature := signPayload(payload, s.secret)
-
-	token = base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + base64.RawURLEncoding.EncodeToString(signature)
-	return token, expiresAt, nil
+	now := time.Now()
+	session := &models.Session{
+		TokenHash:    s.hashToken(token),
+		Username:     username,
+		Role:         role,
+		SourceIP:     truncate(meta.SourceIP, 64),
+		UserAgent:    truncate(meta.UserAgent, 255),
+		LastSeenAt:   now,
+		ExpiresAt:    now.Add(s.idleTimeout),
+		MaxExpiresAt: now.Add(s.maxLifetime),
+	}
+	if err := s.db.Create(session).Error; err != nil {
+		return "", nil, err
+	}
+	s.purgeExpiredSessions(now)
+	return token, session, nil
 }
 
-// ValidateToken parses and validates a signed session token.
-func (s *AuthService) ValidateToken(token string) (username string, expiresAt time.Time, err error) {
+// ValidateToken resolves a bearer token to its active session, sliding the idle expiry forward.
+func (s *AuthService) ValidateToken(token string) (*models.Session, error) {
 	if token == "" {
-		return "", time.Time{}, errors.New("empty token")
+		return nil, errors.New("empty token")
+	}
+
+	var session models.Session
+	if err := s.db.Where("token_hash = ?", s.hashToken(token)).First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("session not found")
+		}
+		return nil, err
 	}
-	parts := strings.Split(token, ".")
-	if len(parts) != 2 {
-		return "", time.Time{}, errors.New("token format invalid")
+	if session.RevokedAt != nil {
+		return nil, errors.New("session revoked")
 	}
 
-	payloadBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
-	if err != nil {
-		return "", time.Time{}, errors.New("token payload decode failed")
+	now := time.Now()
+	if now.After(session.ExpiresAt) || now.After(session.MaxExpiresAt) {
+		return nil, errors.New("session expired")
+	}
+	if session.Role == "" {
+		if err := s.resolveSessionRole(&session); err != nil {
+			return nil, err
+		}
 	}
 
-	providedSig, err := base64.RawURLEncoding.DecodeString(parts[1])
-	if err != nil {
-		return "", time.Time{}, errors.New("token signature decode failed")
+	if now.Sub(session.LastSeenAt) >= sessionTouchInterval {
+		expiresAt := now.Add(s.idleTimeout)
+		if expiresAt.After(session.MaxExpiresAt) {
+			expiresAt = session.MaxExpiresAt
+		}
+		session.LastSeenAt = now
+		session.ExpiresAt = expiresAt
+		if err := s.db.Model(&session).Updates(map[string]any{
+			"last_seen_at": now,
+			"expires_at":   expiresAt,
+		}).Error; err != nil {
+			return nil, err
+		}
 	}
 
-	expectedSig := signPayload(string(payloadBytes), s.secret)
-	if !hmac.Equal(providedSig, expectedSig) {
-		return "", time.Time{}, errors.New("token signature mismatch")
+	return &session, nil
+}
+
+// resolveSessionRole fills in the role of a session issued before sessions carried one, from the
+// user row or, for the configured admin who logged in before user rows existed, as admin.
+func (s *AuthService) resolveSessionRole(session *models.Session) error {
+	var user models.User
+	err := s.db.Where("username = ?", session.Username).First(&user).Error
+	switch {
+	case err == nil:
+		session.Role = user.Role
+	case errors.Is(err, gorm.ErrRecordNotFound) && session.Username == s.username:
+		session.Role = models.UserRoleAdmin
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return errors.New("session user not found")
+	default:
+		return err
 	}
+	return s.db.Model(session).Update("role", session.Role).Error
+}
 
-	segments := strings.Split(string(payloadBytes), "|")
-	if len(segments) < 3 {
-		return "", time.Time{}, errors.New("token payload invalid")
+// RevokeToken revokes the session identified by the bearer token, if it exists.
+func (s *AuthService) RevokeToken(token string) (*models.Session, error) {
+	if token == "" {
+		return nil, nil
 	}
+	var session models.Session
+	if err := s.db.Where("token_hash = ? AND revoked_at IS NULL", s.hashToken(token)).First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	now := time.Now()
+	if err := s.db.Model(&session).Update("revoked_at", now).Error; err != nil {
+		return nil, err
+	}
+	session.RevokedAt = &now
+	return &session, nil
+}
 
-	username = segments[0]
-	unixTs, err := strconv.ParseInt(segments[1], 10, 64)
-	if err != nil {
-		return "", time.Time{}, errors.New("token expiry invalid")
+// ListSessions returns the active sessions belonging to a user, most recently used first.
+func (s *AuthService) ListSessions(username string) ([]models.Session, error) {
+	now := time.Now()
+	var sessions []models.Session
+	err := s.db.Where("username = ? AND revoked_at IS NULL AND expires_at > ? AND max_expires_at > ?", username, now, now).
+		Order("last_seen_at desc").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// RevokeSession revokes a single session owned by the given user.
+func (s *AuthService) RevokeSession(username string, id uint) error {
+	res := s.db.Model(&models.Session{}).
+		Where("id = ? AND username = ? AND revoked_at IS NULL", id, username).
+		Update("revoked_at", time.Now())
+	if res.Error != nil {
+		return res.Error
 	}
-	expiresAt = time.Unix(unixTs, 0)
-	if time.Now().After(expiresAt) {
-		return "", time.Time{}, errors.New("token expired")
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("session %d not found", id)
+	}
+	return nil
+}
+
+// RevokeAllSessions revokes every active session of a user except keepID (pass 0 to revoke all).
+func (s *AuthService) RevokeAllSessions(username string, keepID uint) (int64, error) {
+	query := s.db.Model(&models.Session{}).Where("username = ? AND revoked_at IS NULL", username)
+	if keepID != 0 {
+		query = query.Where("id <> ?", keepID)
 	}
+	res := query.Update("revoked_at", time.Now())
+	return res.RowsAffected, res.Error
+}
+
+// purgeExpiredSessions drops sessions that can no longer be used. Failures are ignored.
+func (s *AuthService) purgeExpiredSessions(now time.Time) {
+	s.db.Where("max_expires_at < ? OR expires_at < ?", now, now).Delete(&models.Session{})
+}
 
-	return username, expiresAt, nil
+func (s *AuthService) hashToken(token string) string {
+	return hex.EncodeToString(signPayload(token, s.secret))
 }
 
 func signPayload(payload string, secret []byte) []byte {
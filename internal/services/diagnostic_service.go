@@ -0,0 +1,585 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"nebula_manager/internal/models"
+)
+
+const (
+	defaultTracerouteCount   = 3
+	defaultMTRCount          = 10
+	maxTraceCount            = 100
+	defaultThroughputSeconds = 10
+	maxThroughputSeconds     = 60
+	minDiagnosticTimeout     = 10
+	maxDiagnosticTimeout     = 600
+	// diagnosticQueueTimeout is how long a job waits for its agents before it times out.
+	diagnosticQueueTimeout = 10 * time.Minute
+	// diagnosticResultGrace allows for the upload of a result after the job's own timeout.
+	diagnosticResultGrace = 30 * time.Second
+	defaultDiagnosticList = 50
+	maxDiagnosticList     = 500
+)
+
+// Roles of the agents taking part in a job: the client runs it, the server is the peer's agent
+// accepting a throughput test.
+const (
+	DiagnosticRoleClient = "client"
+	DiagnosticRoleServer = "server"
+)
+
+// EventTopicDiagnostic is published with the job's status appended, e.g. diagnostic.running.
+const EventTopicDiagnostic = "diagnostic"
+
+var (
+	// ErrInvalidDiagnostic is returned for job requests that fail validation.
+	ErrInvalidDiagnostic = errors.New("invalid diagnostic job")
+	// ErrDiagnosticConflict is returned when a job is not in the state an operation needs, e.g. a
+	// result for a job that already timed out.
+	ErrDiagnosticConflict = errors.New("diagnostic job state conflict")
+	// ErrDiagnosticNotFound is returned for unknown job IDs.
+	ErrDiagnosticNotFound = errors.New("diagnostic job not found")
+)
+
+// DiagnosticService queues diagnostic jobs for node agents and keeps their results.
+type DiagnosticService struct {
+	db     *gorm.DB
+	events *EventHub
+	now    func() time.Time
+}
+
+// NewDiagnosticService constructs a DiagnosticService.
+func NewDiagnosticService(db *gorm.DB, events *EventHub) *DiagnosticService {
+	return &DiagnosticService{db: db, events: events, now: time.Now}
+}
+
+// DiagnosticJobRequest carries the payload for requesting a job. Count applies to traceroute and mtr,
+// DurationSeconds to throughput; zero values pick the defaults.
+type DiagnosticJobRequest struct {
+	Type            string `json:"type" binding:"required"`
+	SourceID        uint   `json:"source_id" binding:"required"`
+	TargetID        uint   `json:"target_id" binding:"required"`
+	Path            string `json:"path"`
+	Count           int    `json:"count"`
+	DurationSeconds int    `json:"duration_seconds"`
+	TimeoutSeconds  int    `json:"timeout_seconds"`
+}
+
+// DiagnosticJobView is a job as returned by the API.
+type DiagnosticJobView struct {
+	models.DiagnosticJob
+	Source NodeSummary       `json:"source"`
+	Target NodeSummary       `json:"target"`
+	Result *DiagnosticResult `json:"result,omitempty"`
+}
+
+// DiagnosticResult is the structured outcome of a job. Address is the peer address that was tested.
+type DiagnosticResult struct {
+	Address    string            `json:"address,omitempty"`
+	Reached    bool              `json:"reached"`
+	Hops       []TraceHop        `json:"hops,omitempty"`
+	Throughput *ThroughputResult `json:"throughput,omitempty"`
+}
+
+// TraceHop summarises the probes sent with one TTL. Address is empty when no router answered.
+type TraceHop struct {
+	TTL         int     `json:"ttl" binding:"min=1,max=64"`
+	Address     string  `json:"address" binding:"max=64"`
+	Sent        int     `json:"sent" binding:"min=0"`
+	Received    int     `json:"received" binding:"min=0"`
+	LossPercent float64 `json:"loss_percent"`
+	LastMs      float64 `json:"last_ms"`
+	AvgMs       float64 `json:"avg_ms"`
+	BestMs      float64 `json:"best_ms"`
+	WorstMs     float64 `json:"worst_ms"`
+	StdevMs     float64 `json:"stdev_ms"`
+}
+
+// ThroughputResult is a TCP bandwidth test in both directions. Upload is from the job's node to the
+// peer; the rates are computed by the controller from the bytes and seconds measured by the agent.
+type ThroughputResult struct {
+	UploadBytes        int64   `json:"upload_bytes" binding:"min=0"`
+	UploadSeconds      float64 `json:"upload_seconds" binding:"min=0"`
+	UploadBitsPerSec   float64 `json:"upload_bits_per_second"`
+	DownloadBytes      int64   `json:"download_bytes" binding:"min=0"`
+	DownloadSeconds    float64 `json:"download_seconds" binding:"min=0"`
+	DownloadBitsPerSec float64 `json:"download_bits_per_second"`
+}
+
+// DiagnosticJobFilter narrows List. NodeID matches jobs on either side; SourceID and TargetID match
+// one direction of a node pair.
+type DiagnosticJobFilter struct {
+	NodeID   uint
+	SourceID uint
+	TargetID uint
+	Type     string
+	Status   string
+	Limit    int
+}
+
+// DiagnosticTask is a job handed to an agent polling for work.
+type DiagnosticTask struct {
+	JobID           uint   `json:"job_id"`
+	Type            string `json:"type"`
+	Role            string `json:"role"`
+	Address         string `json:"address,omitempty"`
+	Port            int    `json:"port,omitempty"`
+	Count           int    `json:"count,omitempty"`
+	DurationSeconds int    `json:"duration_seconds,omitempty"`
+	TimeoutSeconds  int    `json:"timeout_seconds"`
+	Token           string `json:"token,omitempty"`
+}
+
+// DiagnosticStartInput is how an agent claims a task. Port is the listener of a throughput server.
+type DiagnosticStartInput struct {
+	Role string `json:"role" binding:"required,oneof=client server"`
+	Port int    `json:"port" binding:"omitempty,min=1,max=65535"`
+}
+
+// DiagnosticResultInput is what an agent uploads when a job ends. A throughput server only reports
+// failures, e.g. when it cannot listen.
+type DiagnosticResultInput struct {
+	Role       string            `json:"role" binding:"omitempty,oneof=client server"`
+	Success    bool              `json:"success"`
+	Error      string            `json:"error"`
+	Address    string            `json:"address" binding:"max=128"`
+	Reached    bool              `json:"reached"`
+	Hops       []TraceHop        `json:"hops" binding:"max=64,dive"`
+	Throughput *ThroughputResult `json:"throughput"`
+}
+
+// Create validates and queues a job. The source node's agent picks it up with its next poll.
+func (s *DiagnosticService) Create(req DiagnosticJobRequest, actor string) (*DiagnosticJobView, error) {
+	job := models.DiagnosticJob{
+		Type:        req.Type,
+		NodeID:      req.SourceID,
+		PeerNodeID:  req.TargetID,
+		Path:        req.Path,
+		Status:      models.DiagnosticStatusQueued,
+		RequestedBy: actor,
+	}
+	if job.NodeID == job.PeerNodeID {
+		return nil, fmt.Errorf("%w: source and target must differ", ErrInvalidDiagnostic)
+	}
+	switch job.Path {
+	case "":
+		job.Path = models.PingPathOverlay
+	case models.PingPathOverlay, models.PingPathUnderlay:
+	default:
+		return nil, fmt.Errorf("%w: unknown path %q (use %s or %s)", ErrInvalidDiagnostic, job.Path, models.PingPathOverlay, models.PingPathUnderlay)
+	}
+	// The default timeout leaves room for one round of probes per second plus the final wait.
+	defaultTimeout := 0
+	switch job.Type {
+	case models.DiagnosticTypeTraceroute, models.DiagnosticTypeMTR:
+		job.Count = req.Count
+		if job.Count == 0 {
+			job.Count = defaultTracerouteCount
+			if job.Type == models.DiagnosticTypeMTR {
+				job.Count = defaultMTRCount
+			}
+		}
+		if job.Count < 1 || job.Count > maxTraceCount {
+			return nil, fmt.Errorf("%w: count must be between 1 and %d", ErrInvalidDiagnostic, maxTraceCount)
+		}
+		defaultTimeout = job.Count + 30
+	case models.DiagnosticTypeThroughput:
+		job.DurationSeconds = req.DurationSeconds
+		if job.DurationSeconds == 0 {
+			job.DurationSeconds = defaultThroughputSeconds
+		}
+		if job.DurationSeconds < 1 || job.DurationSeconds > maxThroughputSeconds {
+			return nil, fmt.Errorf("%w: duration_seconds must be between 1 and %d", ErrInvalidDiagnostic, maxThroughputSeconds)
+		}
+		defaultTimeout = 2*job.DurationSeconds + 30
+		token, err := randomToken(24)
+		if err != nil {
+			return nil, err
+		}
+		job.Token = token
+	default:
+		return nil, fmt.Errorf("%w: unknown type %q", ErrInvalidDiagnostic, job.Type)
+	}
+	job.TimeoutSeconds = req.TimeoutSeconds
+	if job.TimeoutSeconds == 0 {
+		job.TimeoutSeconds = max(defaultTimeout, 60)
+	}
+	if job.TimeoutSeconds < minDiagnosticTimeout || job.TimeoutSeconds > maxDiagnosticTimeout {
+		return nil, fmt.Errorf("%w: timeout_seconds must be between %d and %d", ErrInvalidDiagnostic, minDiagnosticTimeout, maxDiagnosticTimeout)
+	}
+
+	nodes, err := s.nodes(job.NodeID, job.PeerNodeID)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := nodes[job.NodeID]; !ok {
+		return nil, fmt.Errorf("%w: node %d not found", ErrInvalidDiagnostic, job.NodeID)
+	}
+	peer, ok := nodes[job.PeerNodeID]
+	if !ok {
+		return nil, fmt.Errorf("%w: node %d not found", ErrInvalidDiagnostic, job.PeerNodeID)
+	}
+	if diagnosticAddress(peer, job.Path) == "" {
+		return nil, fmt.Errorf("%w: node %s has no %s address", ErrInvalidDiagnostic, peer.Name, job.Path)
+	}
+
+	if err := s.db.Create(&job).Error; err != nil {
+		return nil, err
+	}
+	view := s.view(job, nodes)
+	s.publish(view)
+	return &view, nil
+}
+
+// List returns jobs newest first.
+func (s *DiagnosticService) List(filter DiagnosticJobFilter) ([]DiagnosticJobView, error) {
+	if err := s.expire(); err != nil {
+		return nil, err
+	}
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultDiagnosticList
+	}
+	query := s.db.Order("id desc").Limit(min(limit, maxDiagnosticList))
+	if filter.NodeID != 0 {
+		query = query.Where("node_id = ? OR peer_node_id = ?", filter.NodeID, filter.NodeID)
+	}
+	if filter.SourceID != 0 {
+		query = query.Where("node_id = ?", filter.SourceID)
+	}
+	if filter.TargetID != 0 {
+		query = query.Where("peer_node_id = ?", filter.TargetID)
+	}
+	if filter.Type != "" {
+		query = query.Where("type = ?", filter.Type)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	var jobs []models.DiagnosticJob
+	if err := query.Find(&jobs).Error; err != nil {
+		return nil, err
+	}
+	ids := make([]uint, 0, 2*len(jobs))
+	for _, job := range jobs {
+		ids = append(ids, job.NodeID, job.PeerNodeID)
+	}
+	nodes, err := s.nodes(ids...)
+	if err != nil {
+		return nil, err
+	}
+	views := make([]DiagnosticJobView, 0, len(jobs))
+	for _, job := range jobs {
+		views = append(views, s.view(job, nodes))
+	}
+	return views, nil
+}
+
+// Get loads one job.
+func (s *DiagnosticService) Get(id uint) (*DiagnosticJobView, error) {
+	if err := s.expire(); err != nil {
+		return nil, err
+	}
+	job, err := s.getJob(id)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := s.nodes(job.NodeID, job.PeerNodeID)
+	if err != nil {
+		return nil, err
+	}
+	view := s.view(*job, nodes)
+	return &view, nil
+}
+
+// Cancel stops a queued or running job. Agents still running it have their result rejected.
+func (s *DiagnosticService) Cancel(id uint) (*DiagnosticJobView, error) {
+	job, err := s.getJob(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.finish(job, []string{models.DiagnosticStatusQueued, models.DiagnosticStatusRunning}, models.DiagnosticStatusCancelled, "", ""); err != nil {
+		return nil, err
+	}
+	return s.Get(id)
+}
+
+// Pending returns the tasks waiting for a node's agent: jobs it runs and, for throughput jobs
+// towards it, the listener it has to open first. Throughput jobs are only handed to their source
+// once the peer is listening.
+func (s *DiagnosticService) Pending(nodeID uint) ([]DiagnosticTask, error) {
+	if err := s.expire(); err != nil {
+		return nil, err
+	}
+	var jobs []models.DiagnosticJob
+	err := s.db.Where("status = ?", models.DiagnosticStatusQueued).
+		Where(s.db.Where("node_id = ? AND (type <> ? OR server_ready_at IS NOT NULL)", nodeID, models.DiagnosticTypeThroughput).
+			Or("peer_node_id = ? AND type = ? AND server_ready_at IS NULL", nodeID, models.DiagnosticTypeThroughput)).
+		Order("id asc").Find(&jobs).Error
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]uint, 0, len(jobs))
+	for _, job := range jobs {
+		ids = append(ids, job.PeerNodeID)
+	}
+	nodes, err := s.nodes(ids...)
+	if err != nil {
+		return nil, err
+	}
+	tasks := make([]DiagnosticTask, 0, len(jobs))
+	for _, job := range jobs {
+		task := DiagnosticTask{
+			JobID:           job.ID,
+			Type:            job.Type,
+			Role:            DiagnosticRoleClient,
+			Count:           job.Count,
+			DurationSeconds: job.DurationSeconds,
+			TimeoutSeconds:  job.TimeoutSeconds,
+			Token:           job.Token,
+		}
+		if job.PeerNodeID == nodeID {
+			task.Role = DiagnosticRoleServer
+		} else {
+			peer, ok := nodes[job.PeerNodeID]
+			if !ok {
+				continue
+			}
+			task.Address = diagnosticAddress(peer, job.Path)
+			task.Port = job.ServerPort
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, nil
+}
+
+// Start records that an agent took up its part of a job. A client moves the job to running; a
+// throughput server records its port so the client can be handed the job.
+func (s *DiagnosticService) Start(nodeID, jobID uint, input DiagnosticStartInput) error {
+	job, err := s.getJob(jobID)
+	if err != nil {
+		return err
+	}
+	now := s.now()
+	query := s.db.Model(&models.DiagnosticJob{}).Where("id = ? AND status = ?", job.ID, models.DiagnosticStatusQueued)
+	var updates map[string]any
+	switch {
+	case input.Role == DiagnosticRoleServer && job.PeerNodeID == nodeID && job.Type == models.DiagnosticTypeThroughput:
+		if input.Port == 0 {
+			return fmt.Errorf("%w: port is required", ErrInvalidDiagnostic)
+		}
+		query = query.Where("server_ready_at IS NULL")
+		updates = map[string]any{"server_port": input.Port, "server_ready_at": now}
+	case input.Role == DiagnosticRoleClient && job.NodeID == nodeID:
+		if job.Type == models.DiagnosticTypeThroughput {
+			query = query.Where("server_ready_at IS NOT NULL")
+		}
+		updates = map[string]any{"status": models.DiagnosticStatusRunning, "started_at": now}
+	default:
+		return fmt.Errorf("%w: job %d is not assigned to node %d as %s", ErrDiagnosticConflict, job.ID, nodeID, input.Role)
+	}
+	// The conditional update keeps two pollers (or a late cancel) from both winning the job.
+	res := query.Updates(updates)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("%w: job %d is no longer waiting for its %s", ErrDiagnosticConflict, job.ID, input.Role)
+	}
+	if input.Role == DiagnosticRoleClient {
+		job.Status, job.StartedAt = models.DiagnosticStatusRunning, &now
+	} else {
+		job.ServerPort, job.ServerReadyAt = input.Port, &now
+	}
+	s.publishJob(*job)
+	return nil
+}
+
+// Complete stores the result of a running job. The peer of a throughput job may fail it, e.g. when
+// its listener could not be opened, but only the job's own node reports results.
+func (s *DiagnosticService) Complete(nodeID, jobID uint, input DiagnosticResultInput) error {
+	job, err := s.getJob(jobID)
+	if err != nil {
+		return err
+	}
+	message := truncate(strings.TrimSpace(input.Error), 255)
+	if input.Role == DiagnosticRoleServer {
+		if job.PeerNodeID != nodeID || job.Type != models.DiagnosticTypeThroughput || input.Success {
+			return fmt.Errorf("%w: job %d does not take server results from node %d", ErrDiagnosticConflict, job.ID, nodeID)
+		}
+		if message == "" {
+			message = "peer agent failed"
+		}
+		return s.finish(job, []string{models.DiagnosticStatusQueued, models.DiagnosticStatusRunning}, models.DiagnosticStatusFailed, "peer: "+message, "")
+	}
+	if job.NodeID != nodeID {
+		return fmt.Errorf("%w: job %d is not assigned to node %d", ErrDiagnosticConflict, job.ID, nodeID)
+	}
+
+	result := DiagnosticResult{Address: strings.TrimSpace(input.Address), Reached: input.Reached}
+	switch job.Type {
+	case models.DiagnosticTypeTraceroute, models.DiagnosticTypeMTR:
+		result.Hops = input.Hops
+		for i := range result.Hops {
+			hop := &result.Hops[i]
+			hop.Received = min(hop.Received, hop.Sent)
+			hop.LossPercent = 0
+			if hop.Sent > 0 {
+				hop.LossPercent = float64(hop.Sent-hop.Received) / float64(hop.Sent) * 100
+			}
+		}
+	case models.DiagnosticTypeThroughput:
+		if input.Throughput != nil {
+			throughput := *input.Throughput
+			throughput.UploadBitsPerSec = bitsPerSecond(throughput.UploadBytes, throughput.UploadSeconds)
+			throughput.DownloadBitsPerSec = bitsPerSecond(throughput.DownloadBytes, throughput.DownloadSeconds)
+			result.Throughput = &throughput
+		}
+	}
+	raw, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	status := models.DiagnosticStatusSucceeded
+	if !input.Success {
+		status = models.DiagnosticStatusFailed
+		if message == "" {
+			message = "agent reported a failure"
+		}
+	}
+	return s.finish(job, []string{models.DiagnosticStatusRunning}, status, message, string(raw))
+}
+
+// finish moves job from one of the from states to status. It fails with ErrDiagnosticConflict when
+// the job already left those states, e.g. when a result arrives after the job timed out.
+func (s *DiagnosticService) finish(job *models.DiagnosticJob, from []string, status, message, result string) error {
+	now := s.now()
+	updates := map[string]any{"status": status, "error": message, "finished_at": now}
+	if result != "" {
+		updates["result"] = result
+	}
+	res := s.db.Model(&models.DiagnosticJob{}).Where("id = ? AND status IN ?", job.ID, from).Updates(updates)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("%w: job %d is no longer %s", ErrDiagnosticConflict, job.ID, strings.Join(from, " or "))
+	}
+	job.Status, job.Error, job.FinishedAt = status, message, &now
+	if result != "" {
+		job.Result = result
+	}
+	s.publishJob(*job)
+	return nil
+}
+
+// expire times out jobs that were not picked up within diagnosticQueueTimeout and running jobs whose
+// agent did not report back within the job's timeout.
+func (s *DiagnosticService) expire() error {
+	now := s.now()
+	var jobs []models.DiagnosticJob
+	err := s.db.Where("(status = ? AND created_at < ?) OR status = ?",
+		models.DiagnosticStatusQueued, now.Add(-diagnosticQueueTimeout), models.DiagnosticStatusRunning).
+		Find(&jobs).Error
+	if err != nil {
+		return err
+	}
+	for i := range jobs {
+		job := &jobs[i]
+		message := "not picked up by the agent"
+		if job.Status == models.DiagnosticStatusRunning {
+			if job.StartedAt == nil || now.Sub(*job.StartedAt) <= time.Duration(job.TimeoutSeconds)*time.Second+diagnosticResultGrace {
+				continue
+			}
+			message = "no result from the agent"
+		} else if job.ServerReadyAt == nil && job.Type == models.DiagnosticTypeThroughput {
+			message = "peer agent did not open its listener"
+		}
+		if err := s.finish(job, []string{job.Status}, models.DiagnosticStatusTimedOut, message, ""); err != nil && !errors.Is(err, ErrDiagnosticConflict) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *DiagnosticService) getJob(id uint) (*models.DiagnosticJob, error) {
+	var job models.DiagnosticJob
+	if err := s.db.First(&job, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: %d", ErrDiagnosticNotFound, id)
+		}
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (s *DiagnosticService) nodes(ids ...uint) (map[uint]models.Node, error) {
+	nodes := make(map[uint]models.Node, len(ids))
+	if len(ids) == 0 {
+		return nodes, nil
+	}
+	var list []models.Node
+	if err := s.db.Where("id IN ?", ids).Find(&list).Error; err != nil {
+		return nil, err
+	}
+	for _, node := range list {
+		nodes[node.ID] = node
+	}
+	return nodes, nil
+}
+
+func (s *DiagnosticService) view(job models.DiagnosticJob, nodes map[uint]models.Node) DiagnosticJobView {
+	view := DiagnosticJobView{
+		DiagnosticJob: job,
+		Source:        NodeSummary{ID: job.NodeID},
+		Target:        NodeSummary{ID: job.PeerNodeID},
+	}
+	if node, ok := nodes[job.NodeID]; ok {
+		view.Source = toNodeSummary(node)
+	}
+	if node, ok := nodes[job.PeerNodeID]; ok {
+		view.Target = toNodeSummary(node)
+	}
+	if job.Result != "" {
+		var result DiagnosticResult
+		if json.Unmarshal([]byte(job.Result), &result) == nil {
+			view.Result = &result
+		}
+	}
+	return view
+}
+
+func (s *DiagnosticService) publishJob(job models.DiagnosticJob) {
+	nodes, err := s.nodes(job.NodeID, job.PeerNodeID)
+	if err != nil {
+		nodes = nil
+	}
+	s.publish(s.view(job, nodes))
+}
+
+func (s *DiagnosticService) publish(view DiagnosticJobView) {
+	s.events.Publish(EventTopicDiagnostic+"."+view.Status, view, nil)
+}
+
+// diagnosticAddress is the peer address a job on path targets.
+func diagnosticAddress(peer models.Node, path string) string {
+	if path == models.PingPathUnderlay {
+		return strings.TrimSpace(peer.PublicIP)
+	}
+	return stripMask(overlayAddress(peer))
+}
+
+func bitsPerSecond(bytes int64, seconds float64) float64 {
+	if seconds <= 0 {
+		return 0
+	}
+	return math.Round(float64(bytes) * 8 / seconds)
+}
@@ -0,0 +1,190 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event topics published on the hub.
+const (
+	EventTopicNodeStatus  = "node.status"
+	EventTopicNodeNetwork = "node.network"
+	EventTopicNodeState   = "node.state"
+	EventTopicNodeCreated = "node.created"
+	EventTopicNodeDeleted = "node.deleted"
+	EventTopicAlert       = "alert"
+	// EventTopicReset tells a resuming client that events were missed and it must reload its state.
+	EventTopicReset = "reset"
+)
+
+const (
+	eventHubBacklog      = 1024
+	eventSubscriberQueue = 256
+)
+
+// Event is one message on the hub. Data is what authenticated subscribers receive; Public is the
+// reduced payload for the public stream, or nil when the event is not public.
+type Event struct {
+	ID     string
+	Topic  string
+	Time   time.Time
+	Data   json.RawMessage
+	Public json.RawMessage
+	seq    uint64
+}
+
+// EventHub is an in-process pub/sub hub with a bounded backlog for resuming streams.
+type EventHub struct {
+	mu          sync.Mutex
+	epoch       string
+	seq         uint64
+	backlog     []Event
+	subscribers map[*Subscription]struct{}
+}
+
+// NewEventHub constructs an EventHub. Event IDs are prefixed with the start time so that IDs from a
+// previous process are recognised as unknown rather than confused with new ones.
+func NewEventHub() *EventHub {
+	return &EventHub{
+		epoch:       strconv.FormatInt(time.Now().UnixNano(), 36),
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish sends an event to every matching subscriber. public may be nil to keep the event off the
+// public stream. A nil hub discards events, so publishers need not check for one.
+func (h *EventHub) Publish(topic string, data interface{}, public interface{}) {
+	if h == nil {
+		return
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	var publicEncoded json.RawMessage
+	if public != nil {
+		if publicEncoded, err = json.Marshal(public); err != nil {
+			return
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.seq++
+	event := Event{
+		ID:     fmt.Sprintf("%s-%d", h.epoch, h.seq),
+		Topic:  topic,
+		Time:   time.Now(),
+		Data:   encoded,
+		Public: publicEncoded,
+		seq:    h.seq,
+	}
+	if len(h.backlog) == eventHubBacklog {
+		h.backlog = append(h.backlog[:0], h.backlog[1:]...)
+	}
+	h.backlog = append(h.backlog, event)
+
+	for sub := range h.subscribers {
+		if !sub.wants(event) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			// A subscriber that cannot keep up is cut off; it resumes from its last event ID.
+			h.dropLocked(sub)
+		}
+	}
+}
+
+// Subscription receives the events matching its topic filter until it is closed.
+type Subscription struct {
+	events     chan Event
+	topics     []string
+	publicOnly bool
+	hub        *EventHub
+}
+
+// Events delivers matching events; the channel is closed when the subscription ends.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Close ends the subscription.
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.dropLocked(s)
+}
+
+// wants matches exact topics and dotted prefixes, so "node" selects every node.* topic.
+func (s *Subscription) wants(event Event) bool {
+	if s.publicOnly && event.Public == nil {
+		return false
+	}
+	if len(s.topics) == 0 {
+		return true
+	}
+	for _, topic := range s.topics {
+		if event.Topic == topic || strings.HasPrefix(event.Topic, topic+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *EventHub) dropLocked(sub *Subscription) {
+	if _, ok := h.subscribers[sub]; ok {
+		delete(h.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// Subscribers returns the number of open subscriptions.
+func (h *EventHub) Subscribers() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subscribers)
+}
+
+// Subscribe registers a subscriber for topics (all when empty). With a lastEventID the backlog after
+// it is returned for replay; resumed is false when that ID is unknown or has aged out of the backlog,
+// in which case the client missed events and should reload.
+func (h *EventHub) Subscribe(topics []string, publicOnly bool, lastEventID string) (sub *Subscription, replay []Event, resumed bool) {
+	sub = &Subscription{
+		events:     make(chan Event, eventSubscriberQueue),
+		topics:     topics,
+		publicOnly: publicOnly,
+		hub:        h,
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	resumed = true
+	if lastEventID != "" {
+		resumed = false
+		if epoch, seqText, ok := strings.Cut(lastEventID, "-"); ok && epoch == h.epoch {
+			if seq, err := strconv.ParseUint(seqText, 10, 64); err == nil && seq <= h.seq {
+				oldest := h.seq + 1
+				if len(h.backlog) > 0 {
+					oldest = h.backlog[0].seq
+				}
+				// Everything after seq must still be in the backlog.
+				if seq+1 >= oldest {
+					resumed = true
+					for _, event := range h.backlog {
+						if event.seq > seq && sub.wants(event) {
+							replay = append(replay, event)
+						}
+					}
+				}
+			}
+		}
+	}
+	h.subscribers[sub] = struct{}{}
+	return sub, replay, resumed
+}
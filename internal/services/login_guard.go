@@ -0,0 +1,154 @@
+package services
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// LoginGuard tracks failed logins and applies progressive lockouts. Failures are counted per
+// (source IP, username) pair: after maxFailures consecutive failures the pair is locked for
+// baseLockout, and every further lockout of the same pair doubles the duration up to maxLockout.
+// A second, slower counter per username catches guessing spread over many addresses; it trips only
+// after userMaxFailures and locks for baseLockout without escalating, so that no single client can
+// keep an account locked out for long. A successful login clears both counters.
+type LoginGuard struct {
+	mu      sync.Mutex
+	pair    lockoutPolicy
+	user    lockoutPolicy
+	window  time.Duration
+	entries map[string]*loginAttempts
+	now     func() time.Time
+}
+
+// lockoutPolicy describes when a counter locks and for how long.
+type lockoutPolicy struct {
+	maxFailures int
+	baseLockout time.Duration
+	maxLockout  time.Duration
+}
+
+type loginAttempts struct {
+	failures    int
+	lockouts    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// NewLoginGuard constructs a LoginGuard. A non-positive maxFailures disables lockouts; a
+// non-positive userMaxFailures disables only the per-username counter.
+func NewLoginGuard(maxFailures, userMaxFailures int, baseLockout, maxLockout time.Duration) *LoginGuard {
+	if baseLockout <= 0 {
+		baseLockout = time.Minute
+	}
+	if maxLockout < baseLockout {
+		maxLockout = baseLockout
+	}
+	return &LoginGuard{
+		pair:    lockoutPolicy{maxFailures: maxFailures, baseLockout: baseLockout, maxLockout: maxLockout},
+		user:    lockoutPolicy{maxFailures: userMaxFailures, baseLockout: baseLockout, maxLockout: baseLockout},
+		window:  maxLockout * 2,
+		entries: make(map[string]*loginAttempts),
+		now:     time.Now,
+	}
+}
+
+// WithClock replaces the guard's time source, allowing deterministic in-process tests.
+func (g *LoginGuard) WithClock(now func() time.Time) *LoginGuard {
+	if now != nil {
+		g.now = now
+	}
+	return g
+}
+
+// Check reports whether the username is currently locked out for the IP and for how long.
+func (g *LoginGuard) Check(username, ip string) (bool, time.Duration) {
+	if g == nil || g.pair.maxFailures <= 0 {
+		return false, 0
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	var wait time.Duration
+	for _, key := range g.keys(username, ip) {
+		if entry, ok := g.entries[key.name]; ok && entry.lockedUntil.After(now) {
+			if remaining := entry.lockedUntil.Sub(now); remaining > wait {
+				wait = remaining
+			}
+		}
+	}
+	return wait > 0, wait
+}
+
+// RecordFailure registers a failed attempt and returns the lockout now in effect, if any.
+func (g *LoginGuard) RecordFailure(username, ip string) time.Duration {
+	if g == nil || g.pair.maxFailures <= 0 {
+		return 0
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	g.sweep(now)
+	var wait time.Duration
+	for _, key := range g.keys(username, ip) {
+		entry, ok := g.entries[key.name]
+		if !ok {
+			entry = &loginAttempts{}
+			g.entries[key.name] = entry
+		}
+		entry.failures++
+		entry.lastFailure = now
+		if entry.failures >= key.policy.maxFailures {
+			lockout := key.policy.baseLockout << entry.lockouts
+			if lockout > key.policy.maxLockout || lockout <= 0 {
+				lockout = key.policy.maxLockout
+			}
+			entry.lockouts++
+			entry.failures = 0
+			entry.lockedUntil = now.Add(lockout)
+		}
+		if entry.lockedUntil.After(now) {
+			if remaining := entry.lockedUntil.Sub(now); remaining > wait {
+				wait = remaining
+			}
+		}
+	}
+	return wait
+}
+
+// RecordSuccess clears the failure history of the username and of the pair.
+func (g *LoginGuard) RecordSuccess(username, ip string) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, key := range g.keys(username, ip) {
+		delete(g.entries, key.name)
+	}
+}
+
+func (g *LoginGuard) sweep(now time.Time) {
+	for key, entry := range g.entries {
+		if now.Sub(entry.lastFailure) > g.window && !entry.lockedUntil.After(now) {
+			delete(g.entries, key)
+		}
+	}
+}
+
+type loginGuardKey struct {
+	name   string
+	policy lockoutPolicy
+}
+
+func (g *LoginGuard) keys(username, ip string) []loginGuardKey {
+	user := strings.ToLower(strings.TrimSpace(username))
+	keys := make([]loginGuardKey, 0, 2)
+	keys = append(keys, loginGuardKey{name: "pair:" + ip + "|" + user, policy: g.pair})
+	if user != "" && g.user.maxFailures > 0 {
+		keys = append(keys, loginGuardKey{name: "user:" + user, policy: g.user})
+	}
+	return keys
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) Now() time.Time          { return c.now }
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func newTestGuard(clock *fakeClock) *LoginGuard {
+	return NewLoginGuard(3, 6, time.Minute, 4*time.Minute).WithClock(clock.Now)
+}
+
+func TestLoginGuardLocksPairProgressively(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1_700_000_000, 0)}
+	guard := newTestGuard(clock)
+
+	for i := 0; i < 2; i++ {
+		if wait := guard.RecordFailure("admin", "10.0.0.1"); wait != 0 {
+			t.Fatalf("failure %d: unexpected lockout %s", i+1, wait)
+		}
+	}
+	if wait := guard.RecordFailure("admin", "10.0.0.1"); wait != time.Minute {
+		t.Fatalf("third failure: lockout = %s, want 1m", wait)
+	}
+	if locked, _ := guard.Check("ADMIN", "10.0.0.1"); !locked {
+		t.Fatal("pair should be locked regardless of username case")
+	}
+	if locked, _ := guard.Check("admin", "10.0.0.2"); locked {
+		t.Fatal("another address must not be locked out by the pair lockout")
+	}
+
+	clock.Advance(time.Minute)
+	if locked, _ := guard.Check("admin", "10.0.0.1"); locked {
+		t.Fatal("lockout should expire")
+	}
+	for i := 0; i < 3; i++ {
+		guard.RecordFailure("admin", "10.0.0.1")
+	}
+	if locked, wait := guard.Check("admin", "10.0.0.1"); !locked || wait != 2*time.Minute {
+		t.Fatalf("second lockout = %v %s, want doubled to 2m", locked, wait)
+	}
+}
+
+func TestLoginGuardUsernameCounterIsSlower(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1_700_000_000, 0)}
+	guard := newTestGuard(clock)
+
+	// Spread over addresses, no pair reaches its threshold but the username counter does.
+	for i, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.5"} {
+		if wait := guard.RecordFailure("admin", ip); wait != 0 {
+			t.Fatalf("failure %d: unexpected lockout %s", i+1, wait)
+		}
+	}
+	if wait := guard.RecordFailure("admin", "10.0.0.6"); wait != time.Minute {
+		t.Fatalf("username lockout = %s, want 1m", wait)
+	}
+	if locked, _ := guard.Check("admin", "192.168.1.1"); !locked {
+		t.Fatal("username lockout should apply to every address")
+	}
+	if locked, _ := guard.Check("operator", "10.0.0.1"); locked {
+		t.Fatal("other usernames must not be locked")
+	}
+
+	// The username lockout does not escalate.
+	clock.Advance(time.Minute)
+	for i := 0; i < 6; i++ {
+		guard.RecordFailure("admin", fmt.Sprintf("172.16.0.%d", i+1))
+	}
+	if _, wait := guard.Check("admin", "192.168.1.1"); wait != time.Minute {
+		t.Fatalf("repeated username lockout = %s, want 1m", wait)
+	}
+}
+
+func TestLoginGuardSuccessClearsCounters(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1_700_000_000, 0)}
+	guard := newTestGuard(clock)
+
+	guard.RecordFailure("admin", "10.0.0.1")
+	guard.RecordFailure("admin", "10.0.0.1")
+	guard.RecordSuccess("admin", "10.0.0.1")
+	if wait := guard.RecordFailure("admin", "10.0.0.1"); wait != 0 {
+		t.Fatalf("failures should restart after a success, got lockout %s", wait)
+	}
+}
+
+func TestLoginGuardDisabled(t *testing.T) {
+	guard := NewLoginGuard(0, 0, time.Minute, time.Minute)
+	for i := 0; i < 10; i++ {
+		if wait := guard.RecordFailure("admin", "10.0.0.1"); wait != 0 {
+			t.Fatalf("disabled guard locked out for %s", wait)
+		}
+	}
+}
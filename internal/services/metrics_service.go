@@ -0,0 +1,257 @@
+package services
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+
+	"nebula_manager/internal/metrics"
+	"nebula_manager/internal/models"
+	"nebula_manager/internal/utils"
+)
+
+const defaultMetricsLinkWindow = 5 * time.Minute
+
+// MetricsService renders reported node data and controller metrics for Prometheus.
+type MetricsService struct {
+	db         *gorm.DB
+	linkWindow time.Duration
+	http       *metrics.HTTPCollector
+	database   *metrics.DBCollector
+	now        func() time.Time
+}
+
+// NewMetricsService constructs a MetricsService. Link metrics aggregate the raw samples of the
+// last linkWindow; either collector may be nil.
+func NewMetricsService(db *gorm.DB, linkWindow time.Duration, http *metrics.HTTPCollector, database *metrics.DBCollector) *MetricsService {
+	if linkWindow <= 0 {
+		linkWindow = defaultMetricsLinkWindow
+	}
+	return &MetricsService{db: db, linkWindow: linkWindow, http: http, database: database, now: time.Now}
+}
+
+// nodeGauge is a per-node metric family read from the latest NodeStatus.
+type nodeGauge struct {
+	name  string
+	help  string
+	kind  string
+	value func(status models.NodeStatus) float64
+}
+
+var nodeStatusGauges = []nodeGauge{
+	{"nebula_node_cpu_usage_percent", "CPU usage reported by the node agent.", "gauge", func(s models.NodeStatus) float64 { return s.CPUUsage }},
+	{"nebula_node_load1", "1 minute load average.", "gauge", func(s models.NodeStatus) float64 { return s.Load1 }},
+	{"nebula_node_load5", "5 minute load average.", "gauge", func(s models.NodeStatus) float64 { return s.Load5 }},
+	{"nebula_node_load15", "15 minute load average.", "gauge", func(s models.NodeStatus) float64 { return s.Load15 }},
+	{"nebula_node_memory_total_bytes", "Total memory.", "gauge", func(s models.NodeStatus) float64 { return float64(s.MemoryTotal) }},
+	{"nebula_node_memory_used_bytes", "Used memory.", "gauge", func(s models.NodeStatus) float64 { return float64(s.MemoryUsed) }},
+	{"nebula_node_swap_total_bytes", "Total swap.", "gauge", func(s models.NodeStatus) float64 { return float64(s.SwapTotal) }},
+	{"nebula_node_swap_used_bytes", "Used swap.", "gauge", func(s models.NodeStatus) float64 { return float64(s.SwapUsed) }},
+	{"nebula_node_disk_total_bytes", "Total disk space.", "gauge", func(s models.NodeStatus) float64 { return float64(s.DiskTotal) }},
+	{"nebula_node_disk_used_bytes", "Used disk space.", "gauge", func(s models.NodeStatus) float64 { return float64(s.DiskUsed) }},
+	{"nebula_node_network_receive_bytes_total", "Bytes received on the node's interfaces.", "counter", func(s models.NodeStatus) float64 { return float64(s.NetRxBytes) }},
+	{"nebula_node_network_transmit_bytes_total", "Bytes transmitted on the node's interfaces.", "counter", func(s models.NodeStatus) float64 { return float64(s.NetTxBytes) }},
+	{"nebula_node_processes", "Number of processes.", "gauge", func(s models.NodeStatus) float64 { return float64(s.Processes) }},
+	{"nebula_node_uptime_seconds", "Node uptime.", "gauge", func(s models.NodeStatus) float64 { return float64(s.Uptime) }},
+	{"nebula_node_last_report_timestamp_seconds", "Time of the last status report since the unix epoch.", "gauge", func(s models.NodeStatus) float64 { return float64(s.ReportedAt.Unix()) }},
+}
+
+// WriteMetrics renders all metric families in the text exposition format.
+func (s *MetricsService) WriteMetrics(out io.Writer) error {
+	// Render into a buffer so that a database error yields a clean failure instead of a truncated scrape.
+	var buf bytes.Buffer
+	w := metrics.NewWriter(&buf)
+	now := s.now()
+
+	var nodes []models.Node
+	if err := s.db.Select("id", "name", "role", "subnet_ip", "tags", "certificate_pem").
+		Order("id asc").Find(&nodes).Error; err != nil {
+		return err
+	}
+	names := make(map[uint]string, len(nodes))
+	for _, node := range nodes {
+		names[node.ID] = node.Name
+	}
+
+	w.Family("nebula_node_info", "gauge", "Node metadata; always 1.")
+	for _, node := range nodes {
+		w.Sample("nebula_node_info", 1, "node_id", uintLabel(node.ID), "node", node.Name, "role", node.Role, "subnet_ip", node.SubnetIP, "tags", node.Tags)
+	}
+
+	var statuses []models.NodeStatus
+	if err := s.db.Order("node_id asc").Find(&statuses).Error; err != nil {
+		return err
+	}
+	reported := statuses[:0]
+	for _, status := range statuses {
+		if _, ok := names[status.NodeID]; ok {
+			reported = append(reported, status)
+		}
+	}
+	for _, gauge := range nodeStatusGauges {
+		w.Family(gauge.name, gauge.kind, gauge.help)
+		for _, status := range reported {
+			w.Sample(gauge.name, gauge.value(status), "node_id", uintLabel(status.NodeID), "node", names[status.NodeID])
+		}
+	}
+	w.Family("nebula_node_last_report_age_seconds", "gauge", "Seconds since the node last reported its status.")
+	for _, status := range reported {
+		w.Sample("nebula_node_last_report_age_seconds", now.Sub(status.ReportedAt).Seconds(), "node_id", uintLabel(status.NodeID), "node", names[status.NodeID])
+	}
+
+	if err := s.writeLinkMetrics(w, names, now); err != nil {
+		return err
+	}
+
+	w.Family("nebula_node_certificate_expiry_timestamp_seconds", "gauge", "Expiry of the node certificate since the unix epoch.")
+	for _, node := range nodes {
+		if node.CertificatePEM == "" {
+			continue
+		}
+		if info, err := utils.ParseNebulaCertificate(node.CertificatePEM); err == nil {
+			w.Sample("nebula_node_certificate_expiry_timestamp_seconds", float64(info.NotAfter.Unix()), "node_id", uintLabel(node.ID), "node", node.Name)
+		}
+	}
+	var cas []models.CA
+	if err := s.db.Select("id", "name", "certificate_pem").Find(&cas).Error; err != nil {
+		return err
+	}
+	w.Family("nebula_ca_certificate_expiry_timestamp_seconds", "gauge", "Expiry of the CA certificate since the unix epoch.")
+	for _, ca := range cas {
+		if info, err := utils.ParseNebulaCertificate(ca.CertificatePEM); err == nil {
+			w.Sample("nebula_ca_certificate_expiry_timestamp_seconds", float64(info.NotAfter.Unix()), "ca", ca.Name)
+		}
+	}
+
+	if err := s.writeAlertMetrics(w); err != nil {
+		return err
+	}
+
+	if s.http != nil {
+		s.http.Write(w)
+	}
+	if s.database != nil {
+		s.database.Write(w)
+	}
+	metrics.WriteRuntime(w)
+
+	if err := w.Err(); err != nil {
+		return err
+	}
+	_, err := buf.WriteTo(out)
+	return err
+}
+
+func (s *MetricsService) writeLinkMetrics(w *metrics.Writer, names map[uint]string, now time.Time) error {
+	var records []models.NodePing
+	if err := s.db.Where("created_at >= ? AND path = ?", now.Add(-s.linkWindow), models.PingPathOverlay).Order("created_at asc").Find(&records).Error; err != nil {
+		return err
+	}
+	accumulators := make(map[pairKey]*pingAccumulator)
+	lastSample := make(map[pairKey]time.Time)
+	for _, rec := range records {
+		key := pairKey{source: rec.NodeID, target: rec.PeerNodeID}
+		if _, ok := names[key.source]; !ok {
+			continue
+		}
+		if _, ok := names[key.target]; !ok {
+			continue
+		}
+		if accumulators[key] == nil {
+			accumulators[key] = &pingAccumulator{}
+		}
+		accumulators[key].addRaw(rec)
+		if rec.CreatedAt.After(lastSample[key]) {
+			lastSample[key] = rec.CreatedAt
+		}
+	}
+	keys := make([]pairKey, 0, len(accumulators))
+	points := make(map[pairKey]PingPoint, len(accumulators))
+	for key, acc := range accumulators {
+		keys = append(keys, key)
+		points[key] = acc.point(now)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].source != keys[j].source {
+			return keys[i].source < keys[j].source
+		}
+		return keys[i].target < keys[j].target
+	})
+	labels := func(key pairKey, extra ...string) []string {
+		return append([]string{
+			"source_id", uintLabel(key.source), "source", names[key.source],
+			"target_id", uintLabel(key.target), "target", names[key.target],
+		}, extra...)
+	}
+
+	window := formatSpan(s.linkWindow)
+	w.Family("nebula_link_latency_avg_milliseconds", "gauge", "Mean round trip latency from source to target over the last "+window+".")
+	for _, key := range keys {
+		if point := points[key]; point.Success {
+			w.Sample("nebula_link_latency_avg_milliseconds", point.LatencyMs, labels(key)...)
+		}
+	}
+	w.Family("nebula_link_latency_milliseconds", "gauge", "Round trip latency quantiles from source to target over the last "+window+".")
+	for _, key := range keys {
+		point := points[key]
+		if !point.Success {
+			continue
+		}
+		w.Sample("nebula_link_latency_milliseconds", point.P50Ms, labels(key, "quantile", "0.5")...)
+		w.Sample("nebula_link_latency_milliseconds", point.P95Ms, labels(key, "quantile", "0.95")...)
+		w.Sample("nebula_link_latency_milliseconds", point.P99Ms, labels(key, "quantile", "0.99")...)
+	}
+	w.Family("nebula_link_jitter_milliseconds", "gauge", "Mean variation between consecutive round trips from source to target over the last "+window+".")
+	for _, key := range keys {
+		if point := points[key]; point.Success {
+			w.Sample("nebula_link_jitter_milliseconds", point.JitterMs, labels(key)...)
+		}
+	}
+	w.Family("nebula_link_loss_ratio", "gauge", "Share of lost echoes from source to target over the last "+window+" (0-1).")
+	for _, key := range keys {
+		w.Sample("nebula_link_loss_ratio", points[key].LossPercent/100, labels(key)...)
+	}
+	w.Family("nebula_link_samples", "gauge", "Probes from source to target over the last "+window+".")
+	for _, key := range keys {
+		w.Sample("nebula_link_samples", float64(points[key].Samples), labels(key)...)
+	}
+	w.Family("nebula_link_last_sample_timestamp_seconds", "gauge", "Time of the latest probe from source to target since the unix epoch.")
+	for _, key := range keys {
+		w.Sample("nebula_link_last_sample_timestamp_seconds", float64(lastSample[key].Unix()), labels(key)...)
+	}
+	return nil
+}
+
+func (s *MetricsService) writeAlertMetrics(w *metrics.Writer) error {
+	var counts []struct {
+		State    string
+		Severity string
+		Count    int64
+	}
+	if err := s.db.Model(&models.Alert{}).Select("state, severity, COUNT(*) AS count").
+		Where("state IN ?", []string{models.AlertStatePending, models.AlertStateFiring}).
+		Group("state, severity").Order("state, severity").Scan(&counts).Error; err != nil {
+		return err
+	}
+	w.Family("nebula_alerts", "gauge", "Open alerts by state and severity.")
+	for _, row := range counts {
+		w.Sample("nebula_alerts", float64(row.Count), "state", row.State, "severity", row.Severity)
+	}
+
+	var queued int64
+	if err := s.db.Model(&models.NotificationDelivery{}).Where("status = ?", models.DeliveryStatusPending).
+		Count(&queued).Error; err != nil {
+		return err
+	}
+	w.Family("nebula_notification_queue_length", "gauge", "Notifications waiting for delivery or retry.")
+	w.Sample("nebula_notification_queue_length", float64(queued))
+	return nil
+}
+
+func uintLabel(v uint) string {
+	return strconv.FormatUint(uint64(v), 10)
+}
@@ -0,0 +1,385 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"nebula_manager/internal/models"
+)
+
+const (
+	defaultMatrixWindow = 15 * time.Minute
+	// matrixLastSeenSpan bounds the search for a pair's last sample when raw samples are kept forever.
+	matrixLastSeenSpan = 7 * 24 * time.Hour
+	// Directions of a pair count as asymmetric when their mean latencies differ by at least
+	// asymmetryMinDiffMs and asymmetryMinRatio of the faster one, or their loss by asymmetryLossPoints.
+	asymmetryMinDiffMs  = 10.0
+	asymmetryMinRatio   = 0.3
+	asymmetryLossPoints = 10.0
+)
+
+// Link quality levels used by the matrix and topology export.
+const (
+	LinkQualityGood     = "good"
+	LinkQualityDegraded = "degraded"
+	LinkQualityPoor     = "poor"
+	LinkQualityDown     = "down"
+	LinkQualityUnknown  = "unknown"
+)
+
+var linkQualityColors = map[string]string{
+	LinkQualityGood:     "#16a34a",
+	LinkQualityDegraded: "#f59e0b",
+	LinkQualityPoor:     "#dc2626",
+	LinkQualityDown:     "#7f1d1d",
+	LinkQualityUnknown:  "#9ca3af",
+}
+
+// NetworkMatrix holds the latest and windowed measurements of every ordered node pair.
+type NetworkMatrix struct {
+	GeneratedAt   time.Time        `json:"generated_at"`
+	WindowSeconds int64            `json:"window_seconds"`
+	Nodes         []NodeSummary    `json:"nodes"`
+	Pairs         []NetworkPair    `json:"pairs"`
+	Asymmetries   []PairAsymmetry  `json:"asymmetries"`
+	StalePairs    []NetworkPairRef `json:"stale_pairs"`
+}
+
+// NetworkPair describes the path from SourceID to TargetID.
+type NetworkPair struct {
+	SourceID uint        `json:"source_id"`
+	TargetID uint        `json:"target_id"`
+	Latest   *PingSample `json:"latest,omitempty"`
+	Window   *PingPoint  `json:"window,omitempty"`
+	// Underlay is the window over the public path; comparing it with Window separates internet
+	// latency from what nebula adds, e.g. through relays.
+	Underlay     *PingPoint `json:"underlay,omitempty"`
+	LastSampleAt *time.Time `json:"last_sample_at,omitempty"`
+	Stale        bool       `json:"stale"`
+	Asymmetric   bool       `json:"asymmetric"`
+	Quality      string     `json:"quality"`
+	// Path is "direct", "relayed" or "pending" as reported by the source's nebula hostmap; empty when
+	// the source's agent does not report it or its report is stale.
+	Path string `json:"path,omitempty"`
+}
+
+// PingSample is a single raw latency measurement; packet counts and jitter are zero for single-echo
+// samples.
+type PingSample struct {
+	Timestamp time.Time `json:"timestamp"`
+	LatencyMs float64   `json:"latency_ms"`
+	Success   bool      `json:"success"`
+	Sent      int       `json:"sent,omitempty"`
+	Received  int       `json:"received,omitempty"`
+	JitterMs  float64   `json:"jitter_ms,omitempty"`
+}
+
+// NetworkPairRef identifies an ordered node pair.
+type NetworkPairRef struct {
+	SourceID     uint       `json:"source_id"`
+	TargetID     uint       `json:"target_id"`
+	LastSampleAt *time.Time `json:"last_sample_at,omitempty"`
+}
+
+// PairAsymmetry reports two directions of a node pair that behave noticeably differently.
+type PairAsymmetry struct {
+	NodeA              uint    `json:"node_a"`
+	NodeB              uint    `json:"node_b"`
+	ForwardLatencyMs   float64 `json:"forward_latency_ms"`
+	ReverseLatencyMs   float64 `json:"reverse_latency_ms"`
+	LatencyDiffMs      float64 `json:"latency_diff_ms"`
+	ForwardLossPercent float64 `json:"forward_loss_percent"`
+	ReverseLossPercent float64 `json:"reverse_loss_percent"`
+}
+
+type pairKey struct {
+	source, target uint
+}
+
+// GetNetworkMatrix aggregates the raw samples of the last window for every ordered node pair.
+func (s *NodeService) GetNetworkMatrix(window time.Duration) (*NetworkMatrix, error) {
+	if window <= 0 {
+		window = defaultMatrixWindow
+	}
+	if window > seriesMaxRawSpan || (s.retention.RawPings > 0 && window > s.retention.RawPings) {
+		return nil, fmt.Errorf("%w: matrix window %s exceeds the raw sample range", ErrInvalidSeriesQuery, formatSpan(window))
+	}
+
+	var nodes []models.Node
+	if err := s.db.Order("name asc").Find(&nodes).Error; err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var records []models.NodePing
+	if err := s.db.Where("created_at >= ?", now.Add(-window)).Order("created_at asc").Find(&records).Error; err != nil {
+		return nil, err
+	}
+
+	// Raw samples older than their retention are purged anyway, so the search stops there instead of
+	// scanning the whole table.
+	lastSeenSpan := s.retention.RawPings
+	if lastSeenSpan <= 0 {
+		lastSeenSpan = matrixLastSeenSpan
+	}
+	var lastSeen []struct {
+		NodeID     uint
+		PeerNodeID uint
+		Last       time.Time
+	}
+	if err := s.db.Model(&models.NodePing{}).Select("node_id, peer_node_id, MAX(created_at) AS last").
+		Where("path = ? AND created_at >= ?", models.PingPathOverlay, now.Add(-lastSeenSpan)).
+		Group("node_id, peer_node_id").Scan(&lastSeen).Error; err != nil {
+		return nil, err
+	}
+
+	accumulators := make(map[pairKey]*pingAccumulator)
+	underlay := make(map[pairKey]*pingAccumulator)
+	latest := make(map[pairKey]models.NodePing)
+	for _, rec := range records {
+		key := pairKey{source: rec.NodeID, target: rec.PeerNodeID}
+		if rec.Path == models.PingPathUnderlay {
+			if underlay[key] == nil {
+				underlay[key] = &pingAccumulator{}
+			}
+			underlay[key].addRaw(rec)
+			continue
+		}
+		acc, ok := accumulators[key]
+		if !ok {
+			acc = &pingAccumulator{}
+			accumulators[key] = acc
+		}
+		acc.addRaw(rec)
+		latest[key] = rec
+	}
+	paths, err := s.tunnelPaths(now)
+	if err != nil {
+		return nil, err
+	}
+	lastSampleAt := make(map[pairKey]time.Time, len(lastSeen))
+	for _, row := range lastSeen {
+		lastSampleAt[pairKey{source: row.NodeID, target: row.PeerNodeID}] = row.Last
+	}
+
+	matrix := &NetworkMatrix{
+		GeneratedAt:   now,
+		WindowSeconds: int64(window / time.Second),
+		Nodes:         make([]NodeSummary, 0, len(nodes)),
+		Pairs:         make([]NetworkPair, 0, len(nodes)*len(nodes)),
+		Asymmetries:   make([]PairAsymmetry, 0),
+		StalePairs:    make([]NetworkPairRef, 0),
+	}
+	pairs := make(map[pairKey]*NetworkPair)
+	for _, node := range nodes {
+		matrix.Nodes = append(matrix.Nodes, toNodeSummary(node))
+	}
+	for _, source := range nodes {
+		for _, target := range nodes {
+			if source.ID == target.ID {
+				continue
+			}
+			key := pairKey{source: source.ID, target: target.ID}
+			pair := NetworkPair{SourceID: source.ID, TargetID: target.ID, Quality: LinkQualityUnknown, Path: paths[key]}
+			if last, ok := lastSampleAt[key]; ok {
+				pair.LastSampleAt = &last
+			}
+			if acc, ok := underlay[key]; ok {
+				point := acc.point(now.Add(-window))
+				pair.Underlay = &point
+			}
+			if acc, ok := accumulators[key]; ok {
+				point := acc.point(now.Add(-window))
+				pair.Window = &point
+				pair.Quality = linkQuality(point)
+				rec := latest[key]
+				pair.Latest = &PingSample{Timestamp: rec.CreatedAt, LatencyMs: rec.LatencyMs, Success: rec.Success, Sent: rec.Sent, Received: rec.Received, JitterMs: rec.JitterMs}
+			} else {
+				pair.Stale = true
+				matrix.StalePairs = append(matrix.StalePairs, NetworkPairRef{SourceID: source.ID, TargetID: target.ID, LastSampleAt: pair.LastSampleAt})
+			}
+			matrix.Pairs = append(matrix.Pairs, pair)
+			pairs[key] = &matrix.Pairs[len(matrix.Pairs)-1]
+		}
+	}
+
+	for key, forward := range pairs {
+		if key.source > key.target || forward.Window == nil {
+			continue
+		}
+		reverse := pairs[pairKey{source: key.target, target: key.source}]
+		if reverse == nil || reverse.Window == nil {
+			continue
+		}
+		if asym, ok := detectAsymmetry(key, *forward.Window, *reverse.Window); ok {
+			forward.Asymmetric, reverse.Asymmetric = true, true
+			matrix.Asymmetries = append(matrix.Asymmetries, asym)
+		}
+	}
+	sort.Slice(matrix.Asymmetries, func(i, j int) bool {
+		return matrix.Asymmetries[i].LatencyDiffMs > matrix.Asymmetries[j].LatencyDiffMs
+	})
+
+	return matrix, nil
+}
+
+func detectAsymmetry(key pairKey, forward, reverse PingPoint) (PairAsymmetry, bool) {
+	asym := PairAsymmetry{
+		NodeA:              key.source,
+		NodeB:              key.target,
+		ForwardLossPercent: forward.LossPercent,
+		ReverseLossPercent: reverse.LossPercent,
+	}
+	latencyAsymmetric := false
+	if forward.Success && reverse.Success {
+		asym.ForwardLatencyMs = forward.LatencyMs
+		asym.ReverseLatencyMs = reverse.LatencyMs
+		diff := forward.LatencyMs - reverse.LatencyMs
+		if diff < 0 {
+			diff = -diff
+		}
+		asym.LatencyDiffMs = diff
+		faster := forward.LatencyMs
+		if reverse.LatencyMs < faster {
+			faster = reverse.LatencyMs
+		}
+		latencyAsymmetric = diff >= asymmetryMinDiffMs && diff >= faster*asymmetryMinRatio
+	}
+	lossDiff := forward.LossPercent - reverse.LossPercent
+	if lossDiff < 0 {
+		lossDiff = -lossDiff
+	}
+	return asym, latencyAsymmetric || lossDiff >= asymmetryLossPoints
+}
+
+// linkQuality grades a windowed measurement by loss first and latency second.
+func linkQuality(point PingPoint) string {
+	switch {
+	case point.Samples == 0:
+		return LinkQualityUnknown
+	case !point.Success:
+		return LinkQualityDown
+	case point.LossPercent < 1 && point.LatencyMs < 50:
+		return LinkQualityGood
+	case point.LossPercent < 5 && point.LatencyMs < 150:
+		return LinkQualityDegraded
+	default:
+		return LinkQualityPoor
+	}
+}
+
+// TopologyGraph is a node/edge export of the matrix.
+type TopologyGraph struct {
+	GeneratedAt time.Time      `json:"generated_at"`
+	Nodes       []TopologyNode `json:"nodes"`
+	Edges       []TopologyEdge `json:"edges"`
+}
+
+// TopologyNode is a vertex of the topology graph.
+type TopologyNode struct {
+	ID       uint   `json:"id"`
+	Name     string `json:"name"`
+	SubnetIP string `json:"subnet_ip"`
+}
+
+// TopologyEdge is a directed, quality-colored link of the topology graph.
+type TopologyEdge struct {
+	Source      uint     `json:"source"`
+	Target      uint     `json:"target"`
+	LatencyMs   *float64 `json:"latency_ms"`
+	LossPercent *float64 `json:"loss_percent"`
+	JitterMs    *float64 `json:"jitter_ms"`
+	Quality     string   `json:"quality"`
+	Color       string   `json:"color"`
+	Asymmetric  bool     `json:"asymmetric"`
+	Path        string   `json:"path,omitempty"`
+	// UnderlayLatencyMs is the mean latency over the public path, when agents measure it.
+	UnderlayLatencyMs *float64 `json:"underlay_latency_ms,omitempty"`
+}
+
+// BuildTopology converts a matrix into a graph; stale pairs are only kept when includeStale is set.
+func BuildTopology(matrix *NetworkMatrix, includeStale bool) *TopologyGraph {
+	graph := &TopologyGraph{
+		GeneratedAt: matrix.GeneratedAt,
+		Nodes:       make([]TopologyNode, 0, len(matrix.Nodes)),
+		Edges:       make([]TopologyEdge, 0, len(matrix.Pairs)),
+	}
+	for _, node := range matrix.Nodes {
+		graph.Nodes = append(graph.Nodes, TopologyNode{ID: node.ID, Name: node.Name, SubnetIP: node.SubnetIP})
+	}
+	for _, pair := range matrix.Pairs {
+		if pair.Stale && !includeStale {
+			continue
+		}
+		edge := TopologyEdge{
+			Source:     pair.SourceID,
+			Target:     pair.TargetID,
+			Quality:    pair.Quality,
+			Color:      linkQualityColors[pair.Quality],
+			Asymmetric: pair.Asymmetric,
+			Path:       pair.Path,
+		}
+		if pair.Window != nil {
+			loss := pair.Window.LossPercent
+			edge.LossPercent = &loss
+			if pair.Window.Success {
+				latency, jitter := pair.Window.LatencyMs, pair.Window.JitterMs
+				edge.LatencyMs, edge.JitterMs = &latency, &jitter
+			}
+		}
+		if pair.Underlay != nil && pair.Underlay.Success {
+			latency := pair.Underlay.LatencyMs
+			edge.UnderlayLatencyMs = &latency
+		}
+		graph.Edges = append(graph.Edges, edge)
+	}
+	return graph
+}
+
+// DOT renders the graph in Graphviz format.
+func (g *TopologyGraph) DOT() string {
+	var b strings.Builder
+	b.WriteString("digraph nebula {\n")
+	b.WriteString("  graph [overlap=false, splines=true];\n")
+	b.WriteString("  node [shape=box, style=rounded, fontname=\"Helvetica\"];\n")
+	b.WriteString("  edge [fontname=\"Helvetica\", fontsize=10];\n")
+	for _, node := range g.Nodes {
+		label := dotEscape(node.Name)
+		if node.SubnetIP != "" {
+			label += `\n` + dotEscape(node.SubnetIP)
+		}
+		fmt.Fprintf(&b, "  n%d [label=\"%s\"];\n", node.ID, label)
+	}
+	for _, edge := range g.Edges {
+		label := edge.Quality
+		if edge.LatencyMs != nil {
+			label = fmt.Sprintf("%.1fms", *edge.LatencyMs)
+		}
+		if edge.LossPercent != nil && *edge.LossPercent > 0 {
+			label += fmt.Sprintf(" %.0f%% loss", *edge.LossPercent)
+		}
+		if edge.Path == models.TunnelPathRelayed {
+			label += " (relayed)"
+		}
+		style := "solid"
+		if edge.Quality == LinkQualityUnknown {
+			style = "dotted"
+		} else if edge.Asymmetric {
+			style = "dashed"
+		}
+		fmt.Fprintf(&b, "  n%d -> n%d [color=%s, fontcolor=%s, style=%s, label=%s];\n",
+			edge.Source, edge.Target, dotQuote(edge.Color), dotQuote(edge.Color), style, dotQuote(label))
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func dotEscape(val string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(val)
+}
+
+func dotQuote(val string) string {
+	return `"` + dotEscape(val) + `"`
+}
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"nebula_manager/internal/models"
+	"nebula_manager/internal/utils"
+)
+
+// maxConfigApplyErrorLength matches the size of Node.ConfigApplyError.
+const maxConfigApplyErrorLength = 512
+
+// NodeConfigVersion compares the config a node should run with the one its agent last applied.
+type NodeConfigVersion struct {
+	NodeID         uint   `json:"node_id"`
+	Version        string `json:"version"`
+	AppliedVersion string `json:"applied_version,omitempty"`
+	AppliedAt      string `json:"applied_at,omitempty"`
+	ApplyError     string `json:"apply_error,omitempty"`
+	UpToDate       bool   `json:"up_to_date"`
+}
+
+// ConfigAppliedInput is the agent's report after a config sync attempt. Version is the config the node
+// runs afterwards, which is the previous one when applying failed.
+type ConfigAppliedInput struct {
+	Version string `json:"version" binding:"required,max=64"`
+	Error   string `json:"error"`
+}
+
+// ConfigVersion returns the current config version of a node (see utils.ConfigVersion). It renders
+// the config from the live template, settings and lighthouse list, so any change to those that
+// affects the node yields a new version.
+func (s *NodeService) ConfigVersion(id uint) (*NodeConfigVersion, error) {
+	node, err := s.getNode(id)
+	if err != nil {
+		return nil, err
+	}
+	ca, err := s.caService.GetCA()
+	if err != nil {
+		return nil, err
+	}
+	if ca == nil {
+		return nil, errors.New("no CA present")
+	}
+	settings, err := s.settingsService.Get()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.ensureNodeSubnet(node, settings); err != nil {
+		return nil, err
+	}
+	rendered, err := s.renderNodeConfig(node, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	version := utils.ConfigVersion([]byte(rendered), []byte(ca.CertificatePEM))
+	res := &NodeConfigVersion{
+		NodeID:         node.ID,
+		Version:        version,
+		AppliedVersion: node.AppliedConfigVersion,
+		ApplyError:     node.ConfigApplyError,
+		UpToDate:       node.AppliedConfigVersion == version,
+	}
+	if node.ConfigAppliedAt != nil {
+		res.AppliedAt = node.ConfigAppliedAt.Format(time.RFC3339)
+	}
+	return res, nil
+}
+
+// RecordConfigApplied stores the outcome of an agent's config sync.
+func (s *NodeService) RecordConfigApplied(id uint, input ConfigAppliedInput) error {
+	if _, err := s.getNode(id); err != nil {
+		return err
+	}
+	applyError := truncate(strings.TrimSpace(input.Error), maxConfigApplyErrorLength)
+	// UpdateColumns leaves updated_at alone; it tracks changes made in the console.
+	return s.db.Model(&models.Node{}).Where("id = ?", id).UpdateColumns(map[string]any{
+		"applied_config_version": strings.TrimSpace(input.Version),
+		"config_applied_at":      time.Now(),
+		"config_apply_error":     applyError,
+	}).Error
+}
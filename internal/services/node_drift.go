@@ -0,0 +1,249 @@
+package services
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"nebula_manager/internal/models"
+	"nebula_manager/internal/utils"
+)
+
+// Artifacts compared by drift detection.
+const (
+	DriftArtifactConfig      = "config"
+	DriftArtifactCertificate = "certificate"
+	DriftArtifactCA          = "ca"
+	DriftArtifactNebula      = "nebula_version"
+)
+
+const (
+	defaultNebulaVersion = "1.9.3"
+	// issuedCertificateGrace keeps records of expired certificates around for a while, so a node still
+	// running one is reported as expired rather than as running an unknown certificate.
+	issuedCertificateGrace = 30 * 24 * time.Hour
+	driftMissingFile       = "file not found on the node"
+)
+
+// DriftItem is one artifact whose deployed state differs from the controller's.
+type DriftItem struct {
+	Artifact string `json:"artifact"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+	Reason   string `json:"reason"`
+}
+
+// NodeDrift compares what a node's agent last reported with the artifacts the controller renders now.
+type NodeDrift struct {
+	Node          NodeSummary `json:"node"`
+	Reported      bool        `json:"reported"`
+	ReportedAt    string      `json:"reported_at,omitempty"`
+	NebulaVersion string      `json:"nebula_version,omitempty"`
+	Drifted       bool        `json:"drifted"`
+	Items         []DriftItem `json:"items"`
+}
+
+// DriftReport checks every node for drift, optionally returning only the drifted ones.
+func (s *NodeService) DriftReport(driftedOnly bool) ([]NodeDrift, error) {
+	var nodes []models.Node
+	if err := s.db.Order("name asc").Find(&nodes).Error; err != nil {
+		return nil, err
+	}
+	drifts, err := s.checkDrift(nodes)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]NodeDrift, 0, len(nodes))
+	for _, node := range nodes {
+		drift, ok := drifts[node.ID]
+		if !ok {
+			drift = NodeDrift{Node: toNodeSummary(node), Items: []DriftItem{}}
+		}
+		if driftedOnly && !drift.Drifted {
+			continue
+		}
+		res = append(res, drift)
+	}
+	return res, nil
+}
+
+// checkDrift compares the reported deployments of nodes with their current artifacts. The template,
+// lighthouses and issued certificates are loaded once for all nodes. Without a CA nothing can be
+// compared and the result is empty.
+func (s *NodeService) checkDrift(nodes []models.Node) (map[uint]NodeDrift, error) {
+	res := make(map[uint]NodeDrift, len(nodes))
+	if len(nodes) == 0 {
+		return res, nil
+	}
+	ca, err := s.caService.GetCA()
+	if err != nil || ca == nil {
+		return res, err
+	}
+	ids := make([]uint, len(nodes))
+	for i, node := range nodes {
+		ids[i] = node.ID
+	}
+	var deployments []models.NodeDeployment
+	if err := s.db.Where("node_id IN ?", ids).Find(&deployments).Error; err != nil {
+		return nil, err
+	}
+	if len(deployments) == 0 {
+		return res, nil
+	}
+	byNode := make(map[uint]models.NodeDeployment, len(deployments))
+	for _, deployment := range deployments {
+		byNode[deployment.NodeID] = deployment
+	}
+	var certificates []models.NodeCertificate
+	if err := s.db.Where("node_id IN ?", ids).Find(&certificates).Error; err != nil {
+		return nil, err
+	}
+	issued := make(map[string]models.NodeCertificate, len(certificates))
+	for _, cert := range certificates {
+		issued[cert.Fingerprint] = cert
+	}
+	settings, err := s.settingsService.Get()
+	if err != nil {
+		return nil, err
+	}
+	tpl, err := s.templateService.EnsureDefault()
+	if err != nil {
+		return nil, err
+	}
+	lighthouses, err := s.listLighthouseNodes()
+	if err != nil {
+		return nil, err
+	}
+
+	caHash := utils.ArtifactHash([]byte(ca.CertificatePEM))
+	expectedVersion := normalizeNebulaVersion(s.targetNebulaVersion())
+	now := time.Now()
+	for i := range nodes {
+		node := &nodes[i]
+		deployment, ok := byNode[node.ID]
+		if !ok {
+			continue
+		}
+		drift := NodeDrift{
+			Node:          toNodeSummary(*node),
+			Reported:      true,
+			ReportedAt:    deployment.ReportedAt.Format(time.RFC3339),
+			NebulaVersion: deployment.NebulaVersion,
+			Items:         []DriftItem{},
+		}
+		add := func(artifact, expected, actual, reason string) {
+			drift.Items = append(drift.Items, DriftItem{Artifact: artifact, Expected: expected, Actual: actual, Reason: reason})
+		}
+
+		if err := s.ensureNodeSubnet(node, settings); err != nil {
+			log.Printf("node drift: node %d: %v", node.ID, err)
+		} else if rendered, err := renderNodeConfigWith(node, settings, tpl.Content, lighthouses); err != nil {
+			log.Printf("node drift: render config of node %d: %v", node.ID, err)
+		} else if expected := utils.ArtifactHash([]byte(rendered)); deployment.ConfigHash != expected {
+			add(DriftArtifactConfig, expected, deployment.ConfigHash, missingOr(deployment.ConfigHash, "config.yml differs from the rendered config"))
+		}
+
+		if deployment.CAHash != caHash {
+			add(DriftArtifactCA, caHash, deployment.CAHash, missingOr(deployment.CAHash, "ca.crt is not the current CA certificate"))
+		}
+
+		if reason := certificateDrift(*node, deployment.CertHash, issued, caHash, now); reason != "" {
+			add(DriftArtifactCertificate, "", deployment.CertHash, reason)
+		}
+
+		if actual := normalizeNebulaVersion(deployment.NebulaVersion); actual != expectedVersion {
+			reason := "nebula " + deployment.NebulaVersion + " is installed instead of " + expectedVersion
+			if actual == "" {
+				reason = "nebula binary not found or did not report a version"
+			}
+			add(DriftArtifactNebula, expectedVersion, deployment.NebulaVersion, reason)
+		}
+
+		sort.Slice(drift.Items, func(a, b int) bool { return drift.Items[a].Artifact < drift.Items[b].Artifact })
+		drift.Drifted = len(drift.Items) > 0
+		res[node.ID] = drift
+	}
+	return res, nil
+}
+
+// certificateDrift explains why the deployed certificate is not acceptable, or returns "" when it is
+// one the controller issued to the node under the current CA and it has not expired.
+func certificateDrift(node models.Node, hash string, issued map[string]models.NodeCertificate, caHash string, now time.Time) string {
+	if hash == "" {
+		return driftMissingFile
+	}
+	cert, ok := issued[hash]
+	if !ok && hash == utils.ArtifactHash([]byte(node.CertificatePEM)) {
+		// Issued before certificates were logged; the CA it was signed with is unknown.
+		cert, ok = models.NodeCertificate{NodeID: node.ID, Fingerprint: hash}, true
+		if info, err := utils.ParseNebulaCertificate(node.CertificatePEM); err == nil {
+			cert.NotAfter = info.NotAfter
+		}
+	}
+	switch {
+	case !ok || cert.NodeID != node.ID:
+		return "certificate was not issued to this node by the controller"
+	case cert.CAFingerprint != "" && cert.CAFingerprint != caHash:
+		return "certificate was signed by a previous CA"
+	case !cert.NotAfter.IsZero() && now.After(cert.NotAfter):
+		return "certificate expired at " + cert.NotAfter.Format(time.RFC3339)
+	}
+	return ""
+}
+
+// recordIssuedCertificate logs the node's freshly issued certificate and forgets long expired ones.
+func (s *NodeService) recordIssuedCertificate(node *models.Node, ca *models.CA, validityDays int) error {
+	record := models.NodeCertificate{
+		NodeID:        node.ID,
+		Fingerprint:   utils.ArtifactHash([]byte(node.CertificatePEM)),
+		CAFingerprint: utils.ArtifactHash([]byte(ca.CertificatePEM)),
+		NotAfter:      time.Now().AddDate(0, 0, validityDays),
+	}
+	if info, err := utils.ParseNebulaCertificate(node.CertificatePEM); err == nil {
+		record.NotAfter = info.NotAfter
+	}
+	if err := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&record).Error; err != nil {
+		return err
+	}
+	return s.db.Where("node_id = ? AND not_after < ?", node.ID, time.Now().Add(-issuedCertificateGrace)).
+		Delete(&models.NodeCertificate{}).Error
+}
+
+// upsertDeployment stores the deployment fingerprints of a status report.
+func upsertDeployment(tx *gorm.DB, nodeID uint, input NodeDeploymentInput, reportedAt time.Time) error {
+	deployment := models.NodeDeployment{
+		NodeID:        nodeID,
+		ConfigHash:    strings.ToLower(strings.TrimSpace(input.ConfigHash)),
+		CertHash:      strings.ToLower(strings.TrimSpace(input.CertHash)),
+		CAHash:        strings.ToLower(strings.TrimSpace(input.CAHash)),
+		NebulaVersion: strings.TrimSpace(input.NebulaVersion),
+		ReportedAt:    reportedAt,
+	}
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "node_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"config_hash", "cert_hash", "ca_hash", "nebula_version", "reported_at", "updated_at"}),
+	}).Create(&deployment).Error
+}
+
+// targetNebulaVersion is the nebula release the install script deploys.
+func (s *NodeService) targetNebulaVersion() string {
+	if s.nebulaVersion == "" {
+		return defaultNebulaVersion
+	}
+	return s.nebulaVersion
+}
+
+func normalizeNebulaVersion(version string) string {
+	return strings.TrimPrefix(strings.TrimSpace(version), "v")
+}
+
+func missingOr(hash, reason string) string {
+	if hash == "" {
+		return driftMissingFile
+	}
+	return reason
+}
@@ -0,0 +1,379 @@
+package services
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"nebula_manager/internal/models"
+	"nebula_manager/internal/utils"
+)
+
+// ErrInvalidHostmapReport reports a hostmap upload the controller cannot use.
+var ErrInvalidHostmapReport = errors.New("invalid hostmap report")
+
+var (
+	topLevelKeyPattern = regexp.MustCompile(`(?m)^([A-Za-z_]+):`)
+	agentSSHKeyTypes   = map[string]bool{"ssh-ed25519": true, "ecdsa-sha2-nistp256": true, "ssh-rsa": true}
+)
+
+// HostmapReportInput is what a node agent scraped from nebula's debug sshd and stats endpoints. The
+// SSH fields register the agent's login key and the host key it generated for nebula's sshd; they are
+// sent even when neither endpoint is enabled yet, since the sshd section is only rendered once known.
+type HostmapReportInput struct {
+	SSHPublicKey        string             `json:"ssh_public_key" binding:"max=255"`
+	SSHHostKey          string             `json:"ssh_host_key" binding:"max=255"`
+	Sources             []string           `json:"sources" binding:"max=2,dive,oneof=sshd stats"`
+	Tunnels             int                `json:"tunnels" binding:"min=0"`
+	PendingHandshakes   int                `json:"pending_handshakes" binding:"min=0"`
+	RelayingTunnels     int                `json:"relaying_tunnels" binding:"min=0"`
+	HandshakesInitiated *uint64            `json:"handshakes_initiated"`
+	HandshakesTimedOut  *uint64            `json:"handshakes_timed_out"`
+	Peers               []HostmapPeerInput `json:"peers" binding:"max=10000,dive"`
+	ReportedAt          string             `json:"reported_at"`
+}
+
+// HostmapPeerInput is one hostmap entry. Remote is the underlay address nebula currently talks to the
+// peer over; it is empty when the tunnel runs through the listed relays or is still being established.
+type HostmapPeerInput struct {
+	VpnIP  string   `json:"vpn_ip" binding:"required,max=64"`
+	Remote string   `json:"remote" binding:"max=128"`
+	Relays []string `json:"relays" binding:"max=16,dive,max=64"`
+}
+
+// NodeHostmapDTO is the tunnel summary nebula reported on a node.
+type NodeHostmapDTO struct {
+	NodeID              uint      `json:"node_id"`
+	Tunnels             int       `json:"tunnels"`
+	PendingHandshakes   int       `json:"pending_handshakes"`
+	RelayedTunnels      int       `json:"relayed_tunnels"`
+	RelayingTunnels     int       `json:"relaying_tunnels"`
+	HandshakesInitiated *uint64   `json:"handshakes_initiated,omitempty"`
+	HandshakesTimedOut  *uint64   `json:"handshakes_timed_out,omitempty"`
+	Sources             []string  `json:"sources"`
+	ReportedAt          time.Time `json:"reported_at"`
+	Stale               bool      `json:"stale"`
+}
+
+// TunnelPair tells whether SourceID reaches TargetID over a direct or a relayed tunnel.
+type TunnelPair struct {
+	SourceID   uint          `json:"source_id"`
+	TargetID   uint          `json:"target_id"`
+	Path       string        `json:"path"`
+	Remote     string        `json:"remote,omitempty"`
+	Relays     []NodeSummary `json:"relays,omitempty"`
+	ReportedAt time.Time     `json:"reported_at"`
+	Stale      bool          `json:"stale"`
+}
+
+// NetworkTunnels is the tunnel view of the whole network.
+type NetworkTunnels struct {
+	GeneratedAt time.Time        `json:"generated_at"`
+	Nodes       []NodeSummary    `json:"nodes"`
+	Hostmaps    []NodeHostmapDTO `json:"hostmaps"`
+	Pairs       []TunnelPair     `json:"pairs"`
+}
+
+// RecordHostmap registers the agent's SSH keys and replaces the node's tunnel state with the report.
+// Peers that are not managed nodes are counted but not stored.
+func (s *NodeService) RecordHostmap(nodeID uint, input HostmapReportInput) error {
+	node, err := s.getNode(nodeID)
+	if err != nil {
+		return err
+	}
+	if err := s.registerAgentSSHKey(node, input.SSHPublicKey, input.SSHHostKey); err != nil {
+		return err
+	}
+	if len(input.Sources) == 0 {
+		return nil
+	}
+
+	reportedAt := reportTime(input.ReportedAt, time.Now())
+	byIP, err := s.nodesByOverlayIP()
+	if err != nil {
+		return err
+	}
+
+	hostmap := models.NodeHostmap{
+		NodeID:              nodeID,
+		Tunnels:             input.Tunnels,
+		PendingHandshakes:   input.PendingHandshakes,
+		RelayingTunnels:     input.RelayingTunnels,
+		HandshakesInitiated: input.HandshakesInitiated,
+		HandshakesTimedOut:  input.HandshakesTimedOut,
+		Sources:             strings.Join(input.Sources, ","),
+		ReportedAt:          reportedAt,
+	}
+	tunnels := make([]models.NodeTunnel, 0, len(input.Peers))
+	seen := make(map[uint]bool, len(input.Peers))
+	for _, peer := range input.Peers {
+		path := tunnelPath(peer)
+		if path == models.TunnelPathRelayed {
+			hostmap.RelayedTunnels++
+		}
+		vpnIP := stripMask(peer.VpnIP)
+		peerID, ok := byIP[vpnIP]
+		if !ok || peerID == nodeID || seen[peerID] {
+			continue
+		}
+		seen[peerID] = true
+		relays := make([]string, 0, len(peer.Relays))
+		for _, relay := range peer.Relays {
+			relays = append(relays, stripMask(relay))
+		}
+		tunnels = append(tunnels, models.NodeTunnel{
+			NodeID:     nodeID,
+			PeerNodeID: peerID,
+			VpnIP:      vpnIP,
+			Path:       path,
+			Remote:     strings.TrimSpace(peer.Remote),
+			Relays:     strings.Join(relays, ","),
+		})
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var current models.NodeHostmap
+		err := tx.Select("reported_at").Where("node_id = ?", nodeID).Take(&current).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if err == nil && reportedAt.Before(current.ReportedAt) {
+			// A delayed report must not replace newer tunnel state.
+			return nil
+		}
+		if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&hostmap).Error; err != nil {
+			return err
+		}
+		stale := tx.Where("node_id = ?", nodeID)
+		if len(seen) > 0 {
+			ids := make([]uint, 0, len(seen))
+			for id := range seen {
+				ids = append(ids, id)
+			}
+			stale = stale.Where("peer_node_id NOT IN ?", ids)
+		}
+		if err := stale.Delete(&models.NodeTunnel{}).Error; err != nil {
+			return err
+		}
+		if len(tunnels) == 0 {
+			return nil
+		}
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "node_id"}, {Name: "peer_node_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"vpn_ip", "path", "remote", "relays", "updated_at"}),
+		}).Create(&tunnels).Error
+	})
+}
+
+// GetNetworkTunnels returns the hostmap summary of every node and the path of every reported tunnel.
+func (s *NodeService) GetNetworkTunnels() (*NetworkTunnels, error) {
+	var nodes []models.Node
+	if err := s.db.Order("name asc").Find(&nodes).Error; err != nil {
+		return nil, err
+	}
+	var hostmaps []models.NodeHostmap
+	if err := s.db.Find(&hostmaps).Error; err != nil {
+		return nil, err
+	}
+	var tunnels []models.NodeTunnel
+	if err := s.db.Order("node_id asc, peer_node_id asc").Find(&tunnels).Error; err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	res := &NetworkTunnels{
+		GeneratedAt: now,
+		Nodes:       make([]NodeSummary, 0, len(nodes)),
+		Hostmaps:    make([]NodeHostmapDTO, 0, len(hostmaps)),
+		Pairs:       make([]TunnelPair, 0, len(tunnels)),
+	}
+	byIP := make(map[string]NodeSummary, len(nodes))
+	for _, node := range nodes {
+		summary := toNodeSummary(node)
+		res.Nodes = append(res.Nodes, summary)
+		byIP[stripMask(summary.SubnetIP)] = summary
+	}
+	reported := make(map[uint]NodeHostmapDTO, len(hostmaps))
+	for _, hostmap := range hostmaps {
+		dto := s.toNodeHostmapDTO(hostmap, now)
+		reported[hostmap.NodeID] = dto
+		res.Hostmaps = append(res.Hostmaps, dto)
+	}
+	sort.Slice(res.Hostmaps, func(i, j int) bool { return res.Hostmaps[i].NodeID < res.Hostmaps[j].NodeID })
+
+	for _, tunnel := range tunnels {
+		hostmap, ok := reported[tunnel.NodeID]
+		if !ok {
+			continue
+		}
+		pair := TunnelPair{
+			SourceID:   tunnel.NodeID,
+			TargetID:   tunnel.PeerNodeID,
+			Path:       tunnel.Path,
+			Remote:     tunnel.Remote,
+			ReportedAt: hostmap.ReportedAt,
+			Stale:      hostmap.Stale,
+		}
+		for _, relay := range splitList(tunnel.Relays) {
+			summary, ok := byIP[relay]
+			if !ok {
+				summary = NodeSummary{SubnetIP: relay}
+			}
+			pair.Relays = append(pair.Relays, summary)
+		}
+		res.Pairs = append(res.Pairs, pair)
+	}
+	return res, nil
+}
+
+// tunnelPaths returns the current path of every tunnel whose source reported recently, for the matrix.
+func (s *NodeService) tunnelPaths(now time.Time) (map[pairKey]string, error) {
+	var rows []struct {
+		NodeID     uint
+		PeerNodeID uint
+		Path       string
+		ReportedAt time.Time
+	}
+	err := s.db.Model(&models.NodeTunnel{}).
+		Select("node_tunnels.node_id, node_tunnels.peer_node_id, node_tunnels.path, node_hostmaps.reported_at").
+		Joins("JOIN node_hostmaps ON node_hostmaps.node_id = node_tunnels.node_id").
+		Where("node_hostmaps.reported_at >= ?", now.Add(-s.states.StaleAfter())).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	paths := make(map[pairKey]string, len(rows))
+	for _, row := range rows {
+		paths[pairKey{source: row.NodeID, target: row.PeerNodeID}] = row.Path
+	}
+	return paths, nil
+}
+
+func (s *NodeService) toNodeHostmapDTO(hostmap models.NodeHostmap, now time.Time) NodeHostmapDTO {
+	return NodeHostmapDTO{
+		NodeID:              hostmap.NodeID,
+		Tunnels:             hostmap.Tunnels,
+		PendingHandshakes:   hostmap.PendingHandshakes,
+		RelayedTunnels:      hostmap.RelayedTunnels,
+		RelayingTunnels:     hostmap.RelayingTunnels,
+		HandshakesInitiated: hostmap.HandshakesInitiated,
+		HandshakesTimedOut:  hostmap.HandshakesTimedOut,
+		Sources:             splitList(hostmap.Sources),
+		ReportedAt:          hostmap.ReportedAt,
+		Stale:               now.Sub(hostmap.ReportedAt) > s.states.StaleAfter(),
+	}
+}
+
+// registerAgentSSHKey stores the agent's SSH keys when they changed. That changes the node's rendered
+// config, which the agent then pulls through config sync.
+func (s *NodeService) registerAgentSSHKey(node *models.Node, publicKey, hostKey string) error {
+	publicKey, hostKey = strings.TrimSpace(publicKey), strings.TrimSpace(hostKey)
+	if publicKey == "" && hostKey == "" {
+		return nil
+	}
+	fields := strings.Fields(publicKey)
+	if len(fields) < 2 || !agentSSHKeyTypes[fields[0]] {
+		return fmt.Errorf("%w: unsupported ssh public key", ErrInvalidHostmapReport)
+	}
+	if _, err := base64.StdEncoding.DecodeString(fields[1]); err != nil {
+		return fmt.Errorf("%w: malformed ssh public key", ErrInvalidHostmapReport)
+	}
+	if !filepath.IsAbs(hostKey) || filepath.Clean(hostKey) != hostKey || strings.ContainsAny(hostKey, "\"'\\:#\r\n\t ") {
+		return fmt.Errorf("%w: ssh host key must be a plain absolute path", ErrInvalidHostmapReport)
+	}
+	// The comment is dropped; only the key itself ends up in the rendered config.
+	publicKey = fields[0] + " " + fields[1]
+	if node.AgentSSHKey == publicKey && node.AgentSSHHostKey == hostKey {
+		return nil
+	}
+	return s.db.Model(&models.Node{}).Where("id = ?", node.ID).UpdateColumns(map[string]any{
+		"agent_ssh_key":      publicKey,
+		"agent_ssh_host_key": hostKey,
+	}).Error
+}
+
+// nodesByOverlayIP maps each node's overlay address to its ID.
+func (s *NodeService) nodesByOverlayIP() (map[string]uint, error) {
+	var nodes []models.Node
+	if err := s.db.Select("id, subnet_ip, subnet_host").Find(&nodes).Error; err != nil {
+		return nil, err
+	}
+	byIP := make(map[string]uint, len(nodes))
+	for _, node := range nodes {
+		for _, addr := range []string{node.SubnetHost, node.SubnetIP} {
+			if ip := stripMask(addr); ip != "" {
+				byIP[ip] = node.ID
+			}
+		}
+	}
+	return byIP, nil
+}
+
+// appendDebugSections adds the stats and sshd sections enabled in the network settings to a rendered
+// config, unless the template defines them itself. The sshd section needs the keys registered by the
+// node's agent (node is nil for a node that is not stored yet), since nebula fails to start without
+// its host key.
+func appendDebugSections(rendered string, node *models.Node, settings *models.NetworkSetting) string {
+	if settings == nil || (settings.StatsListen == "" && settings.DebugSSHListen == "") {
+		return rendered
+	}
+	defined := map[string]bool{}
+	for _, match := range topLevelKeyPattern.FindAllStringSubmatch(rendered, -1) {
+		defined[match[1]] = true
+	}
+	var b strings.Builder
+	b.WriteString(rendered)
+	if !strings.HasSuffix(rendered, "\n") {
+		b.WriteString("\n")
+	}
+	if settings.StatsListen != "" && !defined["stats"] {
+		b.WriteString("stats:\n")
+		b.WriteString("  type: prometheus\n")
+		fmt.Fprintf(&b, "  listen: %q\n", settings.StatsListen)
+		b.WriteString("  path: /metrics\n")
+		b.WriteString("  namespace: nebula\n")
+		b.WriteString("  interval: 10s\n")
+	}
+	if settings.DebugSSHListen != "" && !defined["sshd"] && node != nil && node.AgentSSHKey != "" && node.AgentSSHHostKey != "" {
+		b.WriteString("sshd:\n")
+		b.WriteString("  enabled: true\n")
+		fmt.Fprintf(&b, "  listen: %q\n", settings.DebugSSHListen)
+		fmt.Fprintf(&b, "  host_key: %q\n", node.AgentSSHHostKey)
+		b.WriteString("  authorized_users:\n")
+		fmt.Fprintf(&b, "    - user: %s\n", utils.AgentSSHUser)
+		b.WriteString("      keys:\n")
+		fmt.Fprintf(&b, "        - %q\n", node.AgentSSHKey)
+	}
+	return b.String()
+}
+
+func tunnelPath(peer HostmapPeerInput) string {
+	switch {
+	case strings.TrimSpace(peer.Remote) != "":
+		return models.TunnelPathDirect
+	case len(peer.Relays) > 0:
+		return models.TunnelPathRelayed
+	default:
+		return models.TunnelPathPending
+	}
+}
+
+func stripMask(addr string) string {
+	addr = strings.TrimSpace(addr)
+	if ip, _, err := net.ParseCIDR(addr); err == nil {
+		return ip.String()
+	}
+	if ip := net.ParseIP(addr); ip != nil {
+		return ip.String()
+	}
+	return addr
+}
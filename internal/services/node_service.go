@@ -6,6 +6,7 @@ import (
 	"compress/gzip"
 	"errors"
 	"fmt"
+	"log"
 	"net"
 	"os"
 	"path/filepath"
@@ -20,7 +21,6 @@ import (
 
 	"nebula_manager/internal/models"
 	"nebula_manager/internal/utils"
-	agentassets "nebula_manager/scripts"
 )
 
 const (
@@ -45,10 +45,13 @@ type NodeService struct {
 	nebulaBaseURL   string
 	nebulaProxyPref string
 	staticToken     string
+	retention       RetentionPolicy
+	states          *NodeStateService
+	events          *EventHub
 }
 
 // NewNodeService constructs a NodeService.
-func NewNodeService(db *gorm.DB, caSvc *CAService, tplSvc *TemplateService, settingsSvc *SettingsService, dataDir string, apiBaseURL string, nebulaVersion string, nebulaBaseURL string, nebulaProxyPrefix string, staticToken string) *NodeService {
+func NewNodeService(db *gorm.DB, caSvc *CAService, tplSvc *TemplateService, settingsSvc *SettingsService, dataDir string, apiBaseURL string, nebulaVersion string, nebulaBaseURL string, nebulaProxyPrefix string, staticToken string, retention RetentionPolicy, states *NodeStateService, events *EventHub) *NodeService {
 	return &NodeService{
 		db:              db,
 		caService:       caSvc,
@@ -60,6 +63,9 @@ func NewNodeService(db *gorm.DB, caSvc *CAService, tplSvc *TemplateService, sett
 		nebulaBaseURL:   strings.TrimRight(nebulaBaseURL, "/"),
 		nebulaProxyPref: nebulaProxyPrefix,
 		staticToken:     staticToken,
+		retention:       retention,
+		states:          states,
+		events:          events,
 	}
 }
 
@@ -105,7 +111,17 @@ type NodeDTO struct {
 	ProxyMode      string         `json:"proxy_mode"`
 	InstallCommand string         `json:"install_command"`
 	CreatedAt      string         `json:"created_at"`
+	State          string         `json:"state"`
+	StateSince     string         `json:"state_since,omitempty"`
 	Status         *NodeStatusDTO `json:"status,omitempty"`
+	// Config sync state reported by the agent; compare with GET /nodes/:id/config/version.
+	AppliedConfigVersion string `json:"applied_config_version,omitempty"`
+	ConfigAppliedAt      string `json:"config_applied_at,omitempty"`
+	ConfigApplyError     string `json:"config_apply_error,omitempty"`
+	// Drift between the artifacts the controller renders and what the agent last found on the host;
+	// GET /nodes/drift explains each difference.
+	Drifted          bool     `json:"drifted"`
+	DriftedArtifacts []string `json:"drifted_artifacts,omitempty"`
 }
 
 // NodeStatusInput captures runtime metrics reported by a node agent.
@@ -125,6 +141,21 @@ type NodeStatusInput struct {
 	Processes   int     `json:"processes"`
 	Uptime      uint64  `json:"uptime"`
 	ReportedAt  string  `json:"reported_at"`
+	// ID is optional; a report resent with an ID that was already stored is ignored.
+	ID string `json:"id" binding:"max=64"`
+	// Deployment is optional; nodes whose agent never sent it are not checked for drift.
+	Deployment *NodeDeploymentInput `json:"deployment"`
+	// Agent is optional; it identifies the agent build for the fleet view (GET /agent/fleet).
+	Agent *NodeAgentInput `json:"agent"`
+}
+
+// NodeDeploymentInput fingerprints the nebula files and binary on a node: hex SHA-256 digests of
+// config.yml, the node certificate and ca.crt (empty when missing) and the output of `nebula -version`.
+type NodeDeploymentInput struct {
+	ConfigHash    string `json:"config_hash" binding:"max=64"`
+	CertHash      string `json:"cert_hash" binding:"max=64"`
+	CAHash        string `json:"ca_hash" binding:"max=64"`
+	NebulaVersion string `json:"nebula_version" binding:"max=32"`
 }
 
 // List returns all stored nodes.
@@ -148,9 +179,34 @@ func (s *NodeService) List() ([]NodeDTO, error) {
 		for i := range res {
 			lookup[res[i].ID] = &res[i]
 		}
+		reported := make(map[uint]time.Time, len(statuses))
 		for _, st := range statuses {
 			if dto := lookup[st.NodeID]; dto != nil {
 				dto.Status = toNodeStatusDTO(st)
+				reported[st.NodeID] = st.ReportedAt
+			}
+		}
+		latest, err := s.states.LatestEvents(ids)
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		for i := range res {
+			res[i].State = s.states.StateOf(reported[res[i].ID], now)
+			if event, ok := latest[res[i].ID]; ok && event.ToState == res[i].State {
+				res[i].StateSince = event.At.Format(time.RFC3339)
+			}
+		}
+		drifts, err := s.checkDrift(nodes)
+		if err != nil {
+			return nil, err
+		}
+		for i := range res {
+			if drift, ok := drifts[res[i].ID]; ok && drift.Drifted {
+				res[i].Drifted = true
+				for _, item := range drift.Items {
+					res[i].DriftedArtifacts = append(res[i].DriftedArtifacts, item.Artifact)
+				}
 			}
 		}
 	}
@@ -234,6 +290,7 @@ func (s *NodeService) Create(req CreateNodeRequest) (*NodeDTO, error) {
 	if err != nil {
 		return nil, err
 	}
+	rendered = appendDebugSections(rendered, nil, settings)
 
 	node := &models.Node{
 		Name:              req.Name,
@@ -259,9 +316,49 @@ func (s *NodeService) Create(req CreateNodeRequest) (*NodeDTO, error) {
 	}
 
 	dto := s.toNodeDTO(*node)
+	s.events.Publish(EventTopicNodeCreated, dto, PublicNodeEvent{
+		ID:       dto.ID,
+		Name:     dto.Name,
+		Role:     dto.Role,
+		SubnetIP: dto.SubnetIP,
+		PublicIP: dto.PublicIP,
+		State:    dto.State,
+	})
 	return &dto, nil
 }
 
+// PublicNodeEvent is the part of a new node announced on the public event stream, matching the
+// fields of the public status page.
+type PublicNodeEvent struct {
+	ID       uint   `json:"id"`
+	Name     string `json:"name"`
+	Role     string `json:"role"`
+	SubnetIP string `json:"subnet_ip"`
+	PublicIP string `json:"public_ip"`
+	State    string `json:"state"`
+}
+
+// NodeStatusEvent is published whenever a node reports its status.
+type NodeStatusEvent struct {
+	NodeID uint           `json:"node_id"`
+	Status *NodeStatusDTO `json:"status"`
+}
+
+// NodeNetworkEvent carries the latency samples a node just reported.
+type NodeNetworkEvent struct {
+	NodeID  uint                 `json:"node_id"`
+	Samples []NetworkSampleEvent `json:"samples"`
+}
+
+// NetworkSampleEvent is one stored latency sample.
+type NetworkSampleEvent struct {
+	PeerID    uint      `json:"peer_id"`
+	Path      string    `json:"path"`
+	LatencyMs float64   `json:"latency_ms"`
+	Success   bool      `json:"success"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
 // Delete removes a node and its generated artifacts.
 func (s *NodeService) Delete(id uint) error {
 	node, err := s.getNode(id)
@@ -271,6 +368,38 @@ func (s *NodeService) Delete(id uint) error {
 	if err := s.db.Delete(&models.Node{}, id).Error; err != nil {
 		return err
 	}
+	if err := s.db.Where("node_id = ?", id).Delete(&models.NodeStatusSample{}).Error; err != nil {
+		return err
+	}
+	if err := s.db.Where("node_id = ?", id).Delete(&models.StatusPageNode{}).Error; err != nil {
+		return err
+	}
+	if err := s.db.Where("node_id = ?", id).Delete(&models.NodeDeployment{}).Error; err != nil {
+		return err
+	}
+	if err := s.db.Where("node_id = ?", id).Delete(&models.NodeAgent{}).Error; err != nil {
+		return err
+	}
+	if err := s.db.Where("node_id = ?", id).Delete(&models.NodeCertificate{}).Error; err != nil {
+		return err
+	}
+	if err := s.db.Where("node_id = ?", id).Delete(&models.NodeHostmap{}).Error; err != nil {
+		return err
+	}
+	if err := s.db.Where("node_id = ? OR peer_node_id = ?", id, id).Delete(&models.NodeTunnel{}).Error; err != nil {
+		return err
+	}
+	if err := s.db.Where("node_id = ? OR peer_node_id = ?", id, id).Delete(&models.ProbeResult{}).Error; err != nil {
+		return err
+	}
+	if err := s.db.Where("node_id = ? OR peer_node_id = ?", id, id).Delete(&models.DiagnosticJob{}).Error; err != nil {
+		return err
+	}
+	if err := s.states.DeleteNode(id); err != nil {
+		return err
+	}
+	deleted := map[string]uint{"id": id}
+	s.events.Publish(EventTopicNodeDeleted, deleted, deleted)
 	nodeDir := filepath.Join(s.dataDir, "nodes", node.Name)
 	if err := os.RemoveAll(nodeDir); err != nil && !os.IsNotExist(err) {
 		return err
@@ -372,10 +501,7 @@ func (s *NodeService) GenerateInstallScript(id uint) (string, error) {
 		nebulaBase = "https://github.com/slackhq/nebula/releases/download"
 	}
 	nebulaBase = strings.TrimRight(nebulaBase, "/")
-	nebulaVersion := s.nebulaVersion
-	if nebulaVersion == "" {
-		nebulaVersion = "1.9.3"
-	}
+	nebulaVersion := s.targetNebulaVersion()
 	proxyPrefix := s.proxyPrefixForNode(node)
 	peerListEscaped := escapeForDoubleQuotes(peerList)
 
@@ -461,10 +587,10 @@ func (s *NodeService) GenerateInstallScript(id uint) (string, error) {
 	b.WriteString("UNIT\n")
 	b.WriteString("AGENT_SCRIPT=/usr/local/bin/nebula-network-agent.sh\n")
 	b.WriteString("AGENT_ENV=/etc/nebula/nebula-network-agent.env\n")
-	b.WriteString("sudo tee \"$AGENT_SCRIPT\" >/dev/null <<'AGENT'\n")
-	b.WriteString(agentassets.NetworkAgentScript)
-	b.WriteString("\nAGENT\n")
-	b.WriteString("sudo chmod +x \"$AGENT_SCRIPT\"\n")
+	// The probe script is downloaded rather than inlined, so it is byte for byte the release it later
+	// updates itself from.
+	b.WriteString("curl -fsSL \"${CURL_AUTH[@]}\" \"$API_BASE/api/agent/script\" -o \"$TMP_DIR/nebula-network-agent.sh\"\n")
+	b.WriteString("sudo install -m 755 \"$TMP_DIR/nebula-network-agent.sh\" \"$AGENT_SCRIPT\"\n")
 	if staticToken != "" {
 		b.WriteString("sudo tee \"$AGENT_ENV\" >/dev/null <<ENV\n")
 		b.WriteString(fmt.Sprintf("NEBULA_MANAGER_API=\"%s\"\n", agentAPIEsc))
@@ -485,6 +611,33 @@ func (s *NodeService) GenerateInstallScript(id uint) (string, error) {
 		b.WriteString("ENV\n")
 		b.WriteString("fi\n")
 	}
+	// Prefer the native agent daemon; controllers without a build for this platform keep the shell probe.
+	b.WriteString("AGENT_ARCH=$ARCH\n")
+	b.WriteString("if [ \"$AGENT_ARCH\" = \"arm6\" ]; then\n")
+	b.WriteString("  AGENT_ARCH=arm\n")
+	b.WriteString("fi\n")
+	b.WriteString("AGENT_BINARY_INSTALLED=0\n")
+	b.WriteString("if curl -fsSL \"${CURL_AUTH[@]}\" \"$API_BASE/api/agent/binary/linux-$AGENT_ARCH\" -o \"$TMP_DIR/nebula-agent\" 2>/dev/null; then\n")
+	b.WriteString("  sudo install -m 755 \"$TMP_DIR/nebula-agent\" /usr/local/bin/nebula-agent\n")
+	b.WriteString("  AGENT_BINARY_INSTALLED=1\n")
+	b.WriteString("else\n")
+	b.WriteString("  echo '控制端未提供 nebula-agent 二进制，改用脚本探针 (nebula-net-probe.timer)' >&2\n")
+	b.WriteString("fi\n")
+	b.WriteString("sudo tee /etc/systemd/system/nebula-agent.service >/dev/null <<'UNIT'\n")
+	b.WriteString("[Unit]\n")
+	b.WriteString("Description=Nebula node agent\n")
+	b.WriteString("After=network-online.target\n")
+	b.WriteString("Wants=network-online.target\n\n")
+	b.WriteString("[Service]\n")
+	b.WriteString("EnvironmentFile=-/etc/nebula/nebula-network-agent.env\n")
+	b.WriteString("ExecStart=/usr/local/bin/nebula-agent -config /etc/nebula/nebula-network-agent.env\n")
+	b.WriteString("Restart=always\n")
+	b.WriteString("RestartSec=10\n")
+	b.WriteString("User=root\n")
+	b.WriteString("StateDirectory=nebula-agent\n\n")
+	b.WriteString("[Install]\n")
+	b.WriteString("WantedBy=multi-user.target\n")
+	b.WriteString("UNIT\n")
 	b.WriteString("sudo tee /etc/systemd/system/nebula-net-probe.service >/dev/null <<'UNIT'\n")
 	b.WriteString("[Unit]\n")
 	b.WriteString("Description=Nebula node latency reporter\n")
@@ -508,11 +661,17 @@ func (s *NodeService) GenerateInstallScript(id uint) (string, error) {
 	b.WriteString("UNIT\n")
 	b.WriteString("sudo systemctl daemon-reload\n")
 	b.WriteString("sudo systemctl enable --now nebula.service\n")
-	b.WriteString("if [[ -f \"$AGENT_ENV\" ]]; then\n")
+	b.WriteString("if [[ ! -f \"$AGENT_ENV\" ]]; then\n")
+	b.WriteString("  echo '未写入网络探针配置，可在设置 NEBULA_ACCESS_TOKEN 后运行 sudo systemctl enable --now nebula-agent.service（或 nebula-net-probe.timer）'\n")
+	b.WriteString("elif [[ \"$AGENT_BINARY_INSTALLED\" == 1 ]]; then\n")
+	b.WriteString("  sudo systemctl disable --now nebula-net-probe.timer >/dev/null 2>&1 || true\n")
+	b.WriteString("  sudo systemctl enable nebula-agent.service\n")
+	b.WriteString("  sudo systemctl restart nebula-agent.service\n")
+	b.WriteString("  echo 'Nebula 节点代理已安装并启用 (nebula-agent.service)'\n")
+	b.WriteString("else\n")
+	b.WriteString("  sudo systemctl disable --now nebula-agent.service >/dev/null 2>&1 || true\n")
 	b.WriteString("  sudo systemctl enable --now nebula-net-probe.timer\n")
 	b.WriteString("  echo 'Nebula 网络探针已安装并启用 (nebula-net-probe.timer)'\n")
-	b.WriteString("else\n")
-	b.WriteString("  echo '未写入网络探针配置，可在设置 NEBULA_ACCESS_TOKEN 后运行 sudo systemctl enable --now nebula-net-probe.timer'\n")
 	b.WriteString("fi\n")
 	b.WriteString("echo \"Nebula 节点已部署并以 systemd 服务运行\"\n")
 	b.WriteString("sudo systemctl status nebula.service --no-pager\n")
@@ -613,33 +772,71 @@ func (s *NodeService) regenerateNodeArtifacts(node *models.Node, ca *models.CA)
 		validity = settings.CertificateValidity
 	}
 
-	listenPort := node.Port
-	if settings != nil && settings.HandshakePort != 0 {
-		if listenPort == 0 {
-			listenPort = settings.HandshakePort
-		}
+	cert, key, err := utils.GenerateNodeCertificate(ca.CertificatePEM, ca.PrivateKeyPEM, node.Name, node.SubnetCIDR, validity)
+	if err != nil {
+		return err
 	}
-	if listenPort == 0 {
-		listenPort = 4242
+	rendered, err := s.renderNodeConfig(node, settings)
+	if err != nil {
+		return err
 	}
-	if node.Port != listenPort {
-		node.Port = listenPort
+
+	node.CertificatePEM = cert
+	node.PrivateKeyPEM = key
+	node.ConfigContent = rendered
+
+	// The sync and SSH key columns belong to the agent, which may report while this node was loaded.
+	if err := s.db.Omit("applied_config_version", "config_applied_at", "config_apply_error", "agent_ssh_key", "agent_ssh_host_key").Save(node).Error; err != nil {
+		return err
+	}
+	if err := s.recordIssuedCertificate(node, ca, validity); err != nil {
+		return err
 	}
 
-	cert, key, err := utils.GenerateNodeCertificate(ca.CertificatePEM, ca.PrivateKeyPEM, node.Name, node.SubnetCIDR, validity)
-	if err != nil {
+	if err := s.writeArtifacts(node, ca.CertificatePEM); err != nil {
 		return err
 	}
 
+	return nil
+}
+
+// renderNodeConfig renders the node's config.yml from the current template, settings and lighthouse
+// list. It fills in the node's listen port but does not persist anything.
+func (s *NodeService) renderNodeConfig(node *models.Node, settings *models.NetworkSetting) (string, error) {
+	listenPort := nodeListenPort(node, settings)
+	if node.Port != listenPort {
+		node.Port = listenPort
+	}
+
 	tpl, err := s.templateService.EnsureDefault()
 	if err != nil {
-		return err
+		return "", err
 	}
 	lighthouses, err := s.listLighthouseNodes()
 	if err != nil {
-		return err
+		return "", err
 	}
+	return renderNodeConfigWith(node, settings, tpl.Content, lighthouses)
+}
 
+// nodeListenPort is the node's own port, else the network's handshake port, else nebula's default.
+func nodeListenPort(node *models.Node, settings *models.NetworkSetting) int {
+	listenPort := node.Port
+	if settings != nil && settings.HandshakePort != 0 {
+		if listenPort == 0 {
+			listenPort = settings.HandshakePort
+		}
+	}
+	if listenPort == 0 {
+		listenPort = 4242
+	}
+	return listenPort
+}
+
+// renderNodeConfigWith renders the node's config.yml from an already loaded template and lighthouse
+// list, so callers rendering many nodes load them once.
+func renderNodeConfigWith(node *models.Node, settings *models.NetworkSetting, template string, lighthouses []map[string]any) (string, error) {
+	listenPort := nodeListenPort(node, settings)
 	data := map[string]any{
 		"Name":         node.Name,
 		"CACertPath":   "ca.crt",
@@ -654,24 +851,11 @@ func (s *NodeService) regenerateNodeArtifacts(node *models.Node, ca *models.CA)
 		"DeviceID":     node.Name,
 	}
 
-	rendered, err := renderTemplate(tpl.Content, data)
+	rendered, err := renderTemplate(template, data)
 	if err != nil {
-		return err
-	}
-
-	node.CertificatePEM = cert
-	node.PrivateKeyPEM = key
-	node.ConfigContent = rendered
-
-	if err := s.db.Save(node).Error; err != nil {
-		return err
-	}
-
-	if err := s.writeArtifacts(node, ca.CertificatePEM); err != nil {
-		return err
+		return "", err
 	}
-
-	return nil
+	return appendDebugSections(rendered, node, settings), nil
 }
 
 func escapeForDoubleQuotes(val string) string {
@@ -683,7 +867,7 @@ func (s *NodeService) getNode(id uint) (*models.Node, error) {
 	var node models.Node
 	if err := s.db.First(&node, id).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, fmt.Errorf("node %d not found", id)
+			return nil, fmt.Errorf("node %d not found: %w", id, err)
 		}
 		return nil, err
 	}
@@ -763,39 +947,83 @@ type NetworkTarget struct {
 	PeerID  uint   `json:"peer_id"`
 	Name    string `json:"name"`
 	Address string `json:"address"`
+	// OverlayAddress and UnderlayAddress are the peer's nebula and public addresses; agents ping both
+	// and tag the samples with their path. Address is kept for probes that only ping one address.
+	OverlayAddress  string `json:"overlay_address,omitempty"`
+	UnderlayAddress string `json:"underlay_address,omitempty"`
+	// Probes are the configured checks to run against the peer in addition to the ping of Address.
+	Probes []ProbeSpec `json:"probes"`
 }
 
-// PingPoint represents a single latency sample between two nodes.
+// PingPoint aggregates the latency samples between two nodes within one series step.
+// LatencyMs is the mean of successful samples; LossPercent is the share of lost echoes and JitterMs
+// the mean variation between consecutive round trips.
 type PingPoint struct {
-	Timestamp time.Time `json:"timestamp"`
-	LatencyMs float64   `json:"latency_ms"`
-	Success   bool      `json:"success"`
+	Timestamp   time.Time `json:"timestamp"`
+	LatencyMs   float64   `json:"latency_ms"`
+	Success     bool      `json:"success"`
+	MinMs       float64   `json:"min_ms"`
+	MaxMs       float64   `json:"max_ms"`
+	P50Ms       float64   `json:"p50_ms"`
+	P95Ms       float64   `json:"p95_ms"`
+	P99Ms       float64   `json:"p99_ms"`
+	LossPercent float64   `json:"loss_percent"`
+	JitterMs    float64   `json:"jitter_ms"`
+	Samples     int       `json:"samples"`
+	// PacketsSent and PacketsReceived count echoes across the samples.
+	PacketsSent     int `json:"packets_sent"`
+	PacketsReceived int `json:"packets_received"`
 }
 
-// NodePeerSeries aggregates samples for a given peer node.
+// NodePeerSeries aggregates samples for a given peer node. Underlay is only set for path=both.
 type NodePeerSeries struct {
-	Peer   NodeSummary `json:"peer"`
-	Points []PingPoint `json:"points"`
+	Peer     NodeSummary `json:"peer"`
+	Points   []PingPoint `json:"points"`
+	Underlay []PingPoint `json:"underlay,omitempty"`
 }
 
 // NodeNetworkSeries contains all peer series for a source node.
 type NodeNetworkSeries struct {
-	Node  NodeSummary      `json:"node"`
-	Peers []NodePeerSeries `json:"peers"`
+	Node        NodeSummary      `json:"node"`
+	From        time.Time        `json:"from"`
+	To          time.Time        `json:"to"`
+	Resolution  string           `json:"resolution"`
+	StepSeconds int64            `json:"step_seconds"`
+	Path        string           `json:"path"`
+	Peers       []NodePeerSeries `json:"peers"`
 }
 
 // NetworkSampleInput captures metrics reported by an agent running on a node.
 type NetworkSampleInput struct {
+	// ID is optional; samples resent with an ID that was already stored are ignored.
+	ID        string  `json:"id" binding:"max=64"`
 	PeerID    uint    `json:"peer_id" binding:"required"`
 	LatencyMs float64 `json:"latency_ms"`
 	Success   bool    `json:"success"`
 	Timestamp string  `json:"timestamp"`
+	// Path is the network path a ping was measured over; empty means the overlay.
+	Path string `json:"path" binding:"omitempty,oneof=overlay underlay"`
+	// Sent and Received count the echoes of a multi-packet ping; LatencyMs (or AvgMs) is then the mean
+	// round trip of the answered ones. Without counts a sample is a single echo.
+	Sent     int     `json:"sent" binding:"min=0"`
+	Received int     `json:"received" binding:"min=0"`
+	AvgMs    float64 `json:"avg_ms"`
+	MinMs    float64 `json:"min_ms"`
+	MaxMs    float64 `json:"max_ms"`
+	MdevMs   float64 `json:"mdev_ms"`
+	JitterMs float64 `json:"jitter_ms"`
+	// ProbeID names the probe definition the sample belongs to; 0 is the built-in ping.
+	ProbeID    uint   `json:"probe_id"`
+	Type       string `json:"type" binding:"omitempty,oneof=icmp tcp nebula_port http"`
+	StatusCode int    `json:"status_code"`
+	Error      string `json:"error"`
 }
 
-// GetNetworkSeries returns latency samples for the given node ID and time span.
-func (s *NodeService) GetNetworkSeries(nodeID uint, span time.Duration) (*NodeNetworkSeries, error) {
-	if span <= 0 {
-		span = time.Hour
+// GetNetworkSeries returns the node's latency series towards every peer, aggregated per step.
+func (s *NodeService) GetNetworkSeries(nodeID uint, query NetworkSeriesQuery) (*NodeNetworkSeries, error) {
+	query, tier, err := s.planSeriesQuery(query)
+	if err != nil {
+		return nil, err
 	}
 
 	node, err := s.getNode(nodeID)
@@ -808,26 +1036,27 @@ func (s *NodeService) GetNetworkSeries(nodeID uint, span time.Duration) (*NodeNe
 		return nil, err
 	}
 
-	from := time.Now().Add(-span)
-	var records []models.NodePing
-	if err := s.db.Where("node_id = ? AND created_at >= ?", nodeID, from).Order("created_at asc").Find(&records).Error; err != nil {
+	primary := query.Path
+	if primary == SeriesPathBoth {
+		primary = SeriesPathOverlay
+	}
+	grouped, err := s.loadPingPoints(nodeID, primary, query, tier)
+	if err != nil {
 		return nil, err
 	}
-
-	grouped := make(map[uint][]PingPoint)
-	for _, rec := range records {
-		grouped[rec.PeerNodeID] = append(grouped[rec.PeerNodeID], PingPoint{
-			Timestamp: rec.CreatedAt,
-			LatencyMs: rec.LatencyMs,
-			Success:   rec.Success,
-		})
+	var underlay map[uint][]PingPoint
+	if query.Path == SeriesPathBoth {
+		if underlay, err = s.loadPingPoints(nodeID, SeriesPathUnderlay, query, tier); err != nil {
+			return nil, err
+		}
 	}
 
 	series := make([]NodePeerSeries, 0, len(peers))
 	for _, peer := range peers {
 		series = append(series, NodePeerSeries{
-			Peer:   toNodeSummary(peer),
-			Points: grouped[peer.ID],
+			Peer:     toNodeSummary(peer),
+			Points:   grouped[peer.ID],
+			Underlay: underlay[peer.ID],
 		})
 	}
 
@@ -836,11 +1065,34 @@ func (s *NodeService) GetNetworkSeries(nodeID uint, span time.Duration) (*NodeNe
 	})
 
 	return &NodeNetworkSeries{
-		Node:  toNodeSummary(*node),
-		Peers: series,
+		Node:        toNodeSummary(*node),
+		From:        query.From,
+		To:          query.To,
+		Resolution:  pingResolutionLabel(tier.resolution),
+		StepSeconds: int64(query.Step / time.Second),
+		Path:        query.Path,
+		Peers:       series,
 	}, nil
 }
 
+// reportClockSkew is how far ahead of the controller's clock an agent timestamp may be.
+const reportClockSkew = time.Minute
+
+// reportTime parses the RFC 3339 timestamp of an agent report. A missing or unparsable one means now,
+// and one further ahead than reportClockSkew is clamped to now: a node with a fast clock would
+// otherwise pin the latest status snapshot and land samples in rollup buckets that have not happened.
+func reportTime(raw string, now time.Time) time.Time {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return now
+	}
+	parsed, err := time.Parse(time.RFC3339, raw)
+	if err != nil || parsed.After(now.Add(reportClockSkew)) {
+		return now
+	}
+	return parsed
+}
+
 // RecordNetworkSamples persists latency samples reported by a node agent.
 func (s *NodeService) RecordNetworkSamples(nodeID uint, samples []NetworkSampleInput) error {
 	if len(samples) == 0 {
@@ -878,7 +1130,18 @@ func (s *NodeService) RecordNetworkSamples(nodeID uint, samples []NetworkSampleI
 		exists[peer.ID] = true
 	}
 
+	seen, err := s.storedSampleIDs(nodeID, samples)
+	if err != nil {
+		return err
+	}
+
+	probeIDs, err := s.probeIDs(samples)
+	if err != nil {
+		return err
+	}
+
 	entries := make([]models.NodePing, 0, len(samples))
+	results := make([]models.ProbeResult, 0)
 	now := time.Now()
 
 	for _, sample := range samples {
@@ -886,43 +1149,170 @@ func (s *NodeService) RecordNetworkSamples(nodeID uint, samples []NetworkSampleI
 			continue
 		}
 		if !exists[sample.PeerID] {
-			return fmt.Errorf("peer node %d not found", sample.PeerID)
+			// Replayed backlogs can name peers deleted since; their samples have nowhere to go.
+			continue
+		}
+		if sample.ProbeID != 0 && !probeIDs[sample.ProbeID] {
+			// The probe was deleted while the sample sat in the agent's queue.
+			continue
 		}
-		ts := now
-		if sample.Timestamp != "" {
-			if parsed, err := time.Parse(time.RFC3339, sample.Timestamp); err == nil {
-				ts = parsed
+		var sampleID *string
+		if id := strings.TrimSpace(sample.ID); id != "" {
+			if seen[id] {
+				continue
 			}
+			seen[id] = true
+			sampleID = &id
 		}
-		entries = append(entries, models.NodePing{
-			NodeID:     nodeID,
-			PeerNodeID: sample.PeerID,
-			LatencyMs:  sample.LatencyMs,
-			Success:    sample.Success,
-			CreatedAt:  ts,
-		})
+		ts := reportTime(sample.Timestamp, now)
+		if s.retention.RawPings > 0 && ts.Before(now.Add(-s.retention.RawPings)) {
+			// Past raw retention: the next purge would delete it, and its rollup bucket is no longer rebuilt.
+			continue
+		}
+		if sample.ProbeID != 0 {
+			probeType := sample.Type
+			if probeType == "" {
+				probeType = models.ProbeTypeICMP
+			}
+			results = append(results, models.ProbeResult{
+				NodeID:     nodeID,
+				PeerNodeID: sample.PeerID,
+				ProbeID:    sample.ProbeID,
+				ProbeType:  probeType,
+				LatencyMs:  sample.LatencyMs,
+				Success:    sample.Success,
+				StatusCode: sample.StatusCode,
+				Error:      truncate(strings.TrimSpace(sample.Error), 255),
+				CreatedAt:  ts,
+				SampleID:   sampleID,
+			})
+			continue
+		}
+		entries = append(entries, pingEntry(nodeID, sample, ts, sampleID))
 	}
 
+	// The unique indexes settle the race with a concurrent retry of the same upload.
+	if len(results) > 0 {
+		if err := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&results).Error; err != nil {
+			return err
+		}
+	}
 	if len(entries) == 0 {
 		return nil
 	}
+	if err := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&entries).Error; err != nil {
+		return err
+	}
+	event := NodeNetworkEvent{NodeID: nodeID, Samples: make([]NetworkSampleEvent, len(entries))}
+	for i, entry := range entries {
+		event.Samples[i] = NetworkSampleEvent{PeerID: entry.PeerNodeID, Path: entry.Path, LatencyMs: entry.LatencyMs, Success: entry.Success, Timestamp: entry.CreatedAt}
+	}
+	// The same latency data is served by the public network endpoint.
+	s.events.Publish(EventTopicNodeNetwork, event, event)
+	return nil
+}
+
+// pingEntry converts a ping sample. Packet counts, when present, decide success; the RTT statistics
+// of failed pings are dropped.
+func pingEntry(nodeID uint, sample NetworkSampleInput, ts time.Time, sampleID *string) models.NodePing {
+	entry := models.NodePing{
+		NodeID:     nodeID,
+		PeerNodeID: sample.PeerID,
+		Path:       pingPath(sample.Path),
+		LatencyMs:  sample.LatencyMs,
+		Success:    sample.Success,
+		CreatedAt:  ts,
+		SampleID:   sampleID,
+	}
+	if entry.LatencyMs == 0 {
+		entry.LatencyMs = sample.AvgMs
+	}
+	if sample.Sent > 0 {
+		entry.Sent = sample.Sent
+		entry.Received = min(sample.Received, sample.Sent)
+		entry.Success = entry.Received > 0
+	}
+	if entry.Success {
+		entry.MinMs, entry.MaxMs = sample.MinMs, sample.MaxMs
+		entry.MdevMs, entry.JitterMs = sample.MdevMs, sample.JitterMs
+		if entry.MaxMs < entry.MinMs {
+			entry.MinMs, entry.MaxMs = entry.LatencyMs, entry.LatencyMs
+		}
+	} else {
+		entry.LatencyMs = 0
+	}
+	return entry
+}
+
+// storedSampleIDs returns which of the samples' IDs are already stored for the node.
+func (s *NodeService) storedSampleIDs(nodeID uint, samples []NetworkSampleInput) (map[string]bool, error) {
+	ids := make([]string, 0, len(samples))
+	for _, sample := range samples {
+		if id := strings.TrimSpace(sample.ID); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	seen := make(map[string]bool, len(ids))
+	if len(ids) == 0 {
+		return seen, nil
+	}
+	for _, model := range []any{&models.NodePing{}, &models.ProbeResult{}} {
+		var stored []string
+		if err := s.db.Model(model).Where("node_id = ? AND sample_id IN ?", nodeID, ids).
+			Pluck("sample_id", &stored).Error; err != nil {
+			return nil, err
+		}
+		for _, id := range stored {
+			seen[id] = true
+		}
+	}
+	return seen, nil
+}
 
-	return s.db.Create(&entries).Error
+// probeIDs returns which of the probe definitions named by the samples still exist.
+func (s *NodeService) probeIDs(samples []NetworkSampleInput) (map[uint]bool, error) {
+	ids := make([]uint, 0)
+	for _, sample := range samples {
+		if sample.ProbeID != 0 {
+			ids = append(ids, sample.ProbeID)
+		}
+	}
+	known := make(map[uint]bool)
+	if len(ids) == 0 {
+		return known, nil
+	}
+	var stored []uint
+	if err := s.db.Model(&models.ProbeDefinition{}).Where("id IN ?", ids).Pluck("id", &stored).Error; err != nil {
+		return nil, err
+	}
+	for _, id := range stored {
+		known[id] = true
+	}
+	return known, nil
 }
 
-// RecordStatus upserts the runtime metrics for the given node.
+// RecordStatus upserts the latest runtime metrics for the given node and appends them to its history.
+// Replayed reports are added to the history but never replace a newer latest snapshot.
 func (s *NodeService) RecordStatus(nodeID uint, input NodeStatusInput) error {
 	if _, err := s.getNode(nodeID); err != nil {
 		return err
 	}
 
-	reportedAt := time.Now()
-	if strings.TrimSpace(input.ReportedAt) != "" {
-		if parsed, err := time.Parse(time.RFC3339, input.ReportedAt); err == nil {
-			reportedAt = parsed
+	var reportID *string
+	if id := strings.TrimSpace(input.ID); id != "" {
+		var count int64
+		if err := s.db.Model(&models.NodeStatusSample{}).Where("node_id = ? AND report_id = ?", nodeID, id).
+			Count(&count).Error; err != nil {
+			return err
 		}
+		if count > 0 {
+			return nil
+		}
+		reportID = &id
 	}
 
+	reportedAt := reportTime(input.ReportedAt, time.Now())
+
 	status := models.NodeStatus{
 		NodeID:      nodeID,
 		CPUUsage:    input.CPUUsage,
@@ -961,10 +1351,66 @@ func (s *NodeService) RecordStatus(nodeID uint, input NodeStatusInput) error {
 		"updated_at":   time.Now(),
 	}
 
-	return s.db.Clauses(clause.OnConflict{
-		Columns:   []clause.Column{{Name: "node_id"}},
-		DoUpdates: clause.Assignments(assignments),
-	}).Create(&status).Error
+	sample := models.NodeStatusSample{
+		NodeID:      nodeID,
+		CPUUsage:    input.CPUUsage,
+		Load1:       input.Load1,
+		Load5:       input.Load5,
+		Load15:      input.Load15,
+		MemoryTotal: input.MemoryTotal,
+		MemoryUsed:  input.MemoryUsed,
+		SwapTotal:   input.SwapTotal,
+		SwapUsed:    input.SwapUsed,
+		DiskTotal:   input.DiskTotal,
+		DiskUsed:    input.DiskUsed,
+		NetRxBytes:  input.NetRxBytes,
+		NetTxBytes:  input.NetTxBytes,
+		Processes:   input.Processes,
+		Uptime:      input.Uptime,
+		ReportedAt:  reportedAt,
+		ReportID:    reportID,
+	}
+
+	latest := true
+	if err := s.db.Transaction(func(tx *gorm.DB) error {
+		var current models.NodeStatus
+		err := tx.Select("reported_at").Where("node_id = ?", nodeID).Take(&current).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		latest = err != nil || !reportedAt.Before(current.ReportedAt)
+		if latest {
+			if err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "node_id"}},
+				DoUpdates: clause.Assignments(assignments),
+			}).Create(&status).Error; err != nil {
+				return err
+			}
+			if input.Deployment != nil {
+				if err := upsertDeployment(tx, nodeID, *input.Deployment, reportedAt); err != nil {
+					return err
+				}
+			}
+			if input.Agent != nil {
+				if err := upsertNodeAgent(tx, nodeID, *input.Agent, reportedAt); err != nil {
+					return err
+				}
+			}
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&sample).Error
+	}); err != nil {
+		return err
+	}
+	if !latest {
+		return nil
+	}
+	statusEvent := NodeStatusEvent{NodeID: nodeID, Status: toNodeStatusDTO(status)}
+	s.events.Publish(EventTopicNodeStatus, statusEvent, statusEvent)
+	// The report itself is stored; a failure to log the transition must not make the agent retry it.
+	if err := s.states.Observe(nodeID); err != nil {
+		log.Printf("node state: node %d: %v", nodeID, err)
+	}
+	return nil
 }
 
 func toNodeSummary(node models.Node) NodeSummary {
@@ -1007,6 +1453,15 @@ func (s *NodeService) ListNetworkTargets(nodeID uint) ([]NetworkTarget, error) {
 		return nil, err
 	}
 
+	var probes []models.ProbeDefinition
+	if err := s.db.Where("enabled = ?", true).Order("id asc").Find(&probes).Error; err != nil {
+		return nil, err
+	}
+	settings, err := s.settingsService.Get()
+	if err != nil {
+		return nil, err
+	}
+
 	targets := make([]NetworkTarget, 0, len(peers))
 	for _, peer := range peers {
 		addr := preferredAddress(peer)
@@ -1014,9 +1469,12 @@ func (s *NodeService) ListNetworkTargets(nodeID uint) ([]NetworkTarget, error) {
 			continue
 		}
 		targets = append(targets, NetworkTarget{
-			PeerID:  peer.ID,
-			Name:    peer.Name,
-			Address: addr,
+			PeerID:          peer.ID,
+			Name:            peer.Name,
+			Address:         addr,
+			OverlayAddress:  overlayAddress(peer),
+			UnderlayAddress: strings.TrimSpace(peer.PublicIP),
+			Probes:          probeSpecs(probes, peer, settings),
 		})
 	}
 
@@ -1075,6 +1533,10 @@ func (s *NodeService) toNodeDTO(node models.Node) NodeDTO {
 	if subnetCIDR == "" {
 		subnetCIDR = subnetHost
 	}
+	configAppliedAt := ""
+	if node.ConfigAppliedAt != nil {
+		configAppliedAt = node.ConfigAppliedAt.Format(time.RFC3339)
+	}
 	return NodeDTO{
 		ID:             node.ID,
 		Name:           node.Name,
@@ -1087,6 +1549,11 @@ func (s *NodeService) toNodeDTO(node models.Node) NodeDTO {
 		ProxyMode:      node.DownloadProxyMode,
 		InstallCommand: s.installCommand(node),
 		CreatedAt:      node.CreatedAt.Format(time.RFC3339),
+		State:          models.NodeStateNeverReported,
+
+		AppliedConfigVersion: node.AppliedConfigVersion,
+		ConfigAppliedAt:      configAppliedAt,
+		ConfigApplyError:     node.ConfigApplyError,
 	}
 }
 
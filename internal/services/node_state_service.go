@@ -0,0 +1,439 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"nebula_manager/internal/models"
+)
+
+const (
+	defaultHeartbeatInterval = time.Minute
+	// A node is stale once it missed staleAfterHeartbeats reports and offline after offlineAfterHeartbeats.
+	staleAfterHeartbeats   = 2
+	offlineAfterHeartbeats = 5
+	stateEventListLimit    = 1000
+)
+
+// availabilityWindows are the periods availability is reported for.
+var availabilityWindows = []struct {
+	label string
+	span  time.Duration
+}{
+	{"24h", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+	{"30d", 30 * 24 * time.Hour},
+}
+
+// NodeStateService derives node liveness from report age and records state transitions.
+type NodeStateService struct {
+	db        *gorm.DB
+	heartbeat time.Duration
+	events    *EventHub
+	now       func() time.Time
+	// mu serialises transition detection so that a report and the periodic sync never record the same change twice.
+	mu sync.Mutex
+}
+
+// NewNodeStateService constructs a NodeStateService for agents reporting every heartbeat.
+func NewNodeStateService(db *gorm.DB, heartbeat time.Duration, events *EventHub) *NodeStateService {
+	if heartbeat <= 0 {
+		heartbeat = defaultHeartbeatInterval
+	}
+	return &NodeStateService{db: db, heartbeat: heartbeat, events: events, now: time.Now}
+}
+
+// StaleAfter is the report age after which a node is considered stale.
+func (s *NodeStateService) StaleAfter() time.Duration {
+	return staleAfterHeartbeats * s.heartbeat
+}
+
+// OfflineAfter is the report age after which a node is considered offline.
+func (s *NodeStateService) OfflineAfter() time.Duration {
+	return offlineAfterHeartbeats * s.heartbeat
+}
+
+// StateOf returns the state of a node whose last report is reportedAt; a zero time means it never reported.
+func (s *NodeStateService) StateOf(reportedAt, now time.Time) string {
+	if reportedAt.IsZero() {
+		return models.NodeStateNeverReported
+	}
+	age := now.Sub(reportedAt)
+	switch {
+	case age <= s.StaleAfter():
+		return models.NodeStateOnline
+	case age <= s.OfflineAfter():
+		return models.NodeStateStale
+	default:
+		return models.NodeStateOffline
+	}
+}
+
+// Start records transitions in the background until ctx is cancelled. Reports are handled as they
+// arrive; the loop catches nodes going stale or offline.
+func (s *NodeStateService) Start(ctx context.Context) {
+	interval := s.heartbeat / 2
+	if interval < 5*time.Second {
+		interval = 5 * time.Second
+	} else if interval > time.Minute {
+		interval = time.Minute
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := s.Sync(); err != nil {
+				log.Printf("node state: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// nodeLiveness is what transition detection needs to know about a node.
+type nodeLiveness struct {
+	id         uint
+	createdAt  time.Time
+	reportedAt time.Time
+	last       *models.NodeStateEvent
+}
+
+// Sync records pending transitions of every node.
+func (s *NodeStateService) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	nodes, err := s.loadLiveness(nil)
+	if err != nil {
+		return err
+	}
+	now := s.now()
+	for _, node := range nodes {
+		if err := s.record(node, now); err != nil {
+			return fmt.Errorf("node %d: %w", node.id, err)
+		}
+	}
+	return nil
+}
+
+// Observe records pending transitions of one node, typically right after it reported.
+func (s *NodeStateService) Observe(nodeID uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	nodes, err := s.loadLiveness([]uint{nodeID})
+	if err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		if err := s.record(node, s.now()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadLiveness loads the given nodes (all when ids is nil) with their last report and event.
+func (s *NodeStateService) loadLiveness(ids []uint) ([]nodeLiveness, error) {
+	var nodes []models.Node
+	query := s.db.Select("id", "created_at").Order("id asc")
+	if ids != nil {
+		query = query.Where("id IN ?", ids)
+	}
+	if err := query.Find(&nodes).Error; err != nil {
+		return nil, err
+	}
+	if len(nodes) == 0 {
+		return nil, nil
+	}
+	nodeIDs := make([]uint, len(nodes))
+	for i, node := range nodes {
+		nodeIDs[i] = node.ID
+	}
+
+	var statuses []models.NodeStatus
+	if err := s.db.Select("node_id", "reported_at").Where("node_id IN ?", nodeIDs).Find(&statuses).Error; err != nil {
+		return nil, err
+	}
+	reported := make(map[uint]time.Time, len(statuses))
+	for _, status := range statuses {
+		reported[status.NodeID] = status.ReportedAt
+	}
+	latest, err := s.LatestEvents(nodeIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]nodeLiveness, len(nodes))
+	for i, node := range nodes {
+		result[i] = nodeLiveness{id: node.ID, createdAt: node.CreatedAt, reportedAt: reported[node.ID]}
+		if event, ok := latest[node.ID]; ok {
+			result[i].last = &event
+		}
+	}
+	return result, nil
+}
+
+// LatestEvents returns the most recent transition of each node.
+func (s *NodeStateService) LatestEvents(nodeIDs []uint) (map[uint]models.NodeStateEvent, error) {
+	result := make(map[uint]models.NodeStateEvent, len(nodeIDs))
+	if len(nodeIDs) == 0 {
+		return result, nil
+	}
+	var events []models.NodeStateEvent
+	latestIDs := s.db.Model(&models.NodeStateEvent{}).Select("MAX(id)").Where("node_id IN ?", nodeIDs).Group("node_id")
+	if err := s.db.Where("id IN (?)", latestIDs).Find(&events).Error; err != nil {
+		return nil, err
+	}
+	for _, event := range events {
+		result[event.NodeID] = event
+	}
+	return result, nil
+}
+
+// stateStep is a state a node enters at a given time.
+type stateStep struct {
+	state string
+	at    time.Time
+}
+
+// record appends the transitions between the node's last recorded state and its current state.
+// A node that missed several checks gets every intermediate step (online → stale → offline), each
+// dated when it actually happened.
+func (s *NodeStateService) record(node nodeLiveness, now time.Time) error {
+	current := s.StateOf(node.reportedAt, now)
+	prevState, prevAt := "", time.Time{}
+	if node.last != nil {
+		prevState, prevAt = node.last.ToState, node.last.At
+	}
+	if prevState == current {
+		return nil
+	}
+
+	var steps []stateStep
+	if current == models.NodeStateNeverReported {
+		steps = []stateStep{{models.NodeStateNeverReported, node.createdAt}}
+	} else {
+		steps = []stateStep{
+			{models.NodeStateOnline, node.reportedAt},
+			{models.NodeStateStale, node.reportedAt.Add(s.StaleAfter())},
+			{models.NodeStateOffline, node.reportedAt.Add(s.OfflineAfter())},
+		}
+		for i, step := range steps {
+			if step.state == current {
+				steps = steps[:i+1]
+				break
+			}
+		}
+	}
+
+	for i, step := range steps {
+		last := i == len(steps)-1
+		// Intermediate steps already covered by the last recorded event are skipped; the current
+		// state is always recorded, clamped so that events never go back in time.
+		if step.state == prevState || (!last && !step.at.After(prevAt)) {
+			continue
+		}
+		at := step.at
+		if at.Before(prevAt) {
+			at = prevAt
+		}
+		if at.After(now) {
+			at = now
+		}
+		event := models.NodeStateEvent{NodeID: node.id, FromState: prevState, ToState: step.state, At: at}
+		if err := s.db.Create(&event).Error; err != nil {
+			return err
+		}
+		s.events.Publish(EventTopicNodeState, event, event)
+		prevState, prevAt = step.state, at
+	}
+	return nil
+}
+
+// ListEvents returns the node's transitions since the given time, newest first.
+func (s *NodeStateService) ListEvents(nodeID uint, since time.Time) ([]models.NodeStateEvent, error) {
+	var events []models.NodeStateEvent
+	if err := s.db.Where("node_id = ? AND at >= ?", nodeID, since).
+		Order("at desc, id desc").Limit(stateEventListLimit).Find(&events).Error; err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// DeleteNode removes a node's transition history.
+func (s *NodeStateService) DeleteNode(nodeID uint) error {
+	return s.db.Where("node_id = ?", nodeID).Delete(&models.NodeStateEvent{}).Error
+}
+
+// NodeAvailability summarises a node's liveness over the standard windows.
+type NodeAvailability struct {
+	NodeID       uint                 `json:"node_id"`
+	Node         string               `json:"node"`
+	State        string               `json:"state"`
+	Since        *time.Time           `json:"since,omitempty"`
+	LastReportAt *time.Time           `json:"last_report_at,omitempty"`
+	Windows      []AvailabilityWindow `json:"windows"`
+}
+
+// AvailabilityWindow is the time a node spent in each state during one window. Stale time counts
+// as available: the node was late, not known to be down. Time before the node was created, before it
+// first reported or before history was recorded is untracked and excluded from the percentage.
+type AvailabilityWindow struct {
+	Window              string    `json:"window"`
+	From                time.Time `json:"from"`
+	To                  time.Time `json:"to"`
+	AvailabilityPercent *float64  `json:"availability_percent"`
+	OnlineSeconds       float64   `json:"online_seconds"`
+	StaleSeconds        float64   `json:"stale_seconds"`
+	OfflineSeconds      float64   `json:"offline_seconds"`
+	UntrackedSeconds    float64   `json:"untracked_seconds"`
+	Transitions         int       `json:"transitions"`
+}
+
+// GetAvailability returns the availability of one node.
+func (s *NodeStateService) GetAvailability(nodeID uint) (*NodeAvailability, error) {
+	var node models.Node
+	if err := s.db.Select("id", "name").First(&node, nodeID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("node %d not found", nodeID)
+		}
+		return nil, err
+	}
+	all, err := s.availability([]models.Node{node})
+	if err != nil {
+		return nil, err
+	}
+	return &all[0], nil
+}
+
+// ListAvailability returns the availability of every node.
+func (s *NodeStateService) ListAvailability() ([]NodeAvailability, error) {
+	var nodes []models.Node
+	if err := s.db.Select("id", "name").Order("id asc").Find(&nodes).Error; err != nil {
+		return nil, err
+	}
+	return s.availability(nodes)
+}
+
+func (s *NodeStateService) availability(nodes []models.Node) ([]NodeAvailability, error) {
+	// Bring the history up to date so that the current state is reflected in the windows.
+	if err := s.Sync(); err != nil {
+		return nil, err
+	}
+	now := s.now()
+	longest := availabilityWindows[len(availabilityWindows)-1].span
+	ids := make([]uint, len(nodes))
+	for i, node := range nodes {
+		ids[i] = node.ID
+	}
+
+	var events []models.NodeStateEvent
+	if len(ids) > 0 {
+		if err := s.db.Where("node_id IN ? AND at >= ?", ids, now.Add(-longest)).
+			Order("at asc, id asc").Find(&events).Error; err != nil {
+			return nil, err
+		}
+	}
+	byNode := make(map[uint][]models.NodeStateEvent, len(nodes))
+	for _, event := range events {
+		byNode[event.NodeID] = append(byNode[event.NodeID], event)
+	}
+	// The state at the start of the longest window comes from the last event before it, fetched for
+	// all nodes at once; events sharing that instant are ordered by ID.
+	before := make(map[uint]string, len(nodes))
+	if len(ids) > 0 {
+		lastBefore := s.db.Model(&models.NodeStateEvent{}).Select("node_id, MAX(at)").
+			Where("node_id IN ? AND at < ?", ids, now.Add(-longest)).Group("node_id")
+		var previous []models.NodeStateEvent
+		if err := s.db.Where("(node_id, at) IN (?)", lastBefore).Order("id asc").Find(&previous).Error; err != nil {
+			return nil, err
+		}
+		for _, event := range previous {
+			before[event.NodeID] = event.ToState
+		}
+	}
+	var statuses []models.NodeStatus
+	if len(ids) > 0 {
+		if err := s.db.Select("node_id", "reported_at").Where("node_id IN ?", ids).Find(&statuses).Error; err != nil {
+			return nil, err
+		}
+	}
+	reported := make(map[uint]time.Time, len(statuses))
+	for _, status := range statuses {
+		reported[status.NodeID] = status.ReportedAt
+	}
+	latest, err := s.LatestEvents(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]NodeAvailability, len(nodes))
+	for i, node := range nodes {
+		item := NodeAvailability{NodeID: node.ID, Node: node.Name, State: s.StateOf(reported[node.ID], now)}
+		if at, ok := reported[node.ID]; ok && !at.IsZero() {
+			item.LastReportAt = &at
+		}
+		if last, ok := latest[node.ID]; ok && last.ToState == item.State {
+			since := last.At
+			item.Since = &since
+		}
+		for _, window := range availabilityWindows {
+			item.Windows = append(item.Windows, summariseWindow(window.label, window.span, now, before[node.ID], byNode[node.ID]))
+		}
+		result[i] = item
+	}
+	return result, nil
+}
+
+// summariseWindow walks the ordered timeline from initial, the state in force before the timeline starts.
+func summariseWindow(label string, window time.Duration, now time.Time, initial string, timeline []models.NodeStateEvent) AvailabilityWindow {
+	from := now.Add(-window)
+	summary := AvailabilityWindow{Window: label, From: from, To: now}
+	state, cursor := initial, from
+	add := func(until time.Time) {
+		if !until.After(cursor) {
+			return
+		}
+		seconds := until.Sub(cursor).Seconds()
+		switch state {
+		case models.NodeStateOnline:
+			summary.OnlineSeconds += seconds
+		case models.NodeStateStale:
+			summary.StaleSeconds += seconds
+		case models.NodeStateOffline:
+			summary.OfflineSeconds += seconds
+		default:
+			summary.UntrackedSeconds += seconds
+		}
+		cursor = until
+	}
+	for _, event := range timeline {
+		if event.At.Before(from) {
+			state = event.ToState
+			continue
+		}
+		add(event.At)
+		if event.FromState != "" && event.FromState != models.NodeStateNeverReported {
+			summary.Transitions++
+		}
+		state = event.ToState
+	}
+	add(now)
+
+	if tracked := summary.OnlineSeconds + summary.StaleSeconds + summary.OfflineSeconds; tracked > 0 {
+		percent := (summary.OnlineSeconds + summary.StaleSeconds) / tracked * 100
+		summary.AvailabilityPercent = &percent
+	}
+	return summary
+}
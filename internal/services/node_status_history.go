@@ -0,0 +1,190 @@
+package services
+
+import (
+	"time"
+
+	"nebula_manager/internal/models"
+)
+
+// statusHistoryPoints is the number of buckets a history query aims for regardless of its span.
+const statusHistoryPoints = 120
+
+// statusHistorySteps lists the bucket widths a history query may use, smallest first.
+var statusHistorySteps = []time.Duration{
+	time.Minute,
+	2 * time.Minute,
+	5 * time.Minute,
+	10 * time.Minute,
+	15 * time.Minute,
+	30 * time.Minute,
+	time.Hour,
+	2 * time.Hour,
+	6 * time.Hour,
+	12 * time.Hour,
+	24 * time.Hour,
+}
+
+// NodeStatusHistory is a bucketed time series of a node's runtime metrics.
+type NodeStatusHistory struct {
+	Node        NodeSummary              `json:"node"`
+	From        time.Time                `json:"from"`
+	To          time.Time                `json:"to"`
+	StepSeconds int64                    `json:"step_seconds"`
+	Points      []NodeStatusHistoryPoint `json:"points"`
+}
+
+// NodeStatusHistoryPoint aggregates the reports that fall into one bucket.
+// Gauges are averaged, CPU also carries its peak, and network counters are turned into byte rates.
+type NodeStatusHistoryPoint struct {
+	Timestamp        time.Time `json:"timestamp"`
+	Samples          int       `json:"samples"`
+	CPUUsage         float64   `json:"cpu_usage"`
+	CPUUsageMax      float64   `json:"cpu_usage_max"`
+	Load1            float64   `json:"load1"`
+	Load5            float64   `json:"load5"`
+	Load15           float64   `json:"load15"`
+	MemoryTotal      uint64    `json:"memory_total"`
+	MemoryUsed       uint64    `json:"memory_used"`
+	SwapTotal        uint64    `json:"swap_total"`
+	SwapUsed         uint64    `json:"swap_used"`
+	DiskTotal        uint64    `json:"disk_total"`
+	DiskUsed         uint64    `json:"disk_used"`
+	Processes        int       `json:"processes"`
+	NetRxBytesPerSec *float64  `json:"net_rx_bytes_per_sec"`
+	NetTxBytesPerSec *float64  `json:"net_tx_bytes_per_sec"`
+}
+
+// statusBucket accumulates the reports of one history bucket.
+type statusBucket struct {
+	start, count                           int64
+	cpu, cpuMax, load1, load5, load15      float64
+	memTotal, memUsed, swapTotal, swapUsed float64
+	diskTotal, diskUsed, processes         float64
+	rxBytes, txBytes, rateSeconds          float64
+}
+
+// GetStatusHistory returns the node's metrics over the given span, bucketed server side.
+func (s *NodeService) GetStatusHistory(nodeID uint, span time.Duration) (*NodeStatusHistory, error) {
+	if span <= 0 {
+		span = time.Hour
+	}
+	node, err := s.getNode(nodeID)
+	if err != nil {
+		return nil, err
+	}
+
+	to := time.Now()
+	from := to.Add(-span)
+	step := statusHistoryStep(span)
+
+	// One extra sample before the window lets the first bucket compute a rate.
+	var previous models.NodeStatusSample
+	res := s.db.Where("node_id = ? AND reported_at < ?", nodeID, from).
+		Order("reported_at desc").Limit(1).Find(&previous)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	hasPrevious := res.RowsAffected > 0
+
+	var samples []models.NodeStatusSample
+	if err := s.db.Where("node_id = ? AND reported_at >= ? AND reported_at <= ?", nodeID, from, to).
+		Order("reported_at asc").Find(&samples).Error; err != nil {
+		return nil, err
+	}
+
+	points := make([]NodeStatusHistoryPoint, 0, statusHistoryPoints)
+	var current *statusBucket
+	for _, sample := range samples {
+		start := sample.ReportedAt.Truncate(step).Unix()
+		if current == nil || current.start != start {
+			if current != nil {
+				points = append(points, current.point())
+			}
+			current = &statusBucket{start: start}
+		}
+		current.add(sample)
+		if hasPrevious {
+			current.addRate(previous, sample)
+		}
+		previous, hasPrevious = sample, true
+	}
+	if current != nil {
+		points = append(points, current.point())
+	}
+
+	return &NodeStatusHistory{
+		Node:        toNodeSummary(*node),
+		From:        from,
+		To:          to,
+		StepSeconds: int64(step / time.Second),
+		Points:      points,
+	}, nil
+}
+
+// statusHistoryStep picks the smallest standard bucket width that keeps the series near statusHistoryPoints.
+func statusHistoryStep(span time.Duration) time.Duration {
+	target := span / statusHistoryPoints
+	for _, step := range statusHistorySteps {
+		if step >= target {
+			return step
+		}
+	}
+	return statusHistorySteps[len(statusHistorySteps)-1]
+}
+
+func (b *statusBucket) add(sample models.NodeStatusSample) {
+	b.count++
+	b.cpu += sample.CPUUsage
+	b.load1 += sample.Load1
+	b.load5 += sample.Load5
+	b.load15 += sample.Load15
+	b.memTotal += float64(sample.MemoryTotal)
+	b.memUsed += float64(sample.MemoryUsed)
+	b.swapTotal += float64(sample.SwapTotal)
+	b.swapUsed += float64(sample.SwapUsed)
+	b.diskTotal += float64(sample.DiskTotal)
+	b.diskUsed += float64(sample.DiskUsed)
+	b.processes += float64(sample.Processes)
+	if sample.CPUUsage > b.cpuMax {
+		b.cpuMax = sample.CPUUsage
+	}
+}
+
+// addRate accumulates the counter deltas between two consecutive reports.
+// Intervals where a counter went backwards (agent restart, reboot, wrap) are skipped.
+func (b *statusBucket) addRate(prev, cur models.NodeStatusSample) {
+	elapsed := cur.ReportedAt.Sub(prev.ReportedAt).Seconds()
+	if elapsed <= 0 || cur.NetRxBytes < prev.NetRxBytes || cur.NetTxBytes < prev.NetTxBytes {
+		return
+	}
+	b.rxBytes += float64(cur.NetRxBytes - prev.NetRxBytes)
+	b.txBytes += float64(cur.NetTxBytes - prev.NetTxBytes)
+	b.rateSeconds += elapsed
+}
+
+func (b *statusBucket) point() NodeStatusHistoryPoint {
+	n := float64(b.count)
+	point := NodeStatusHistoryPoint{
+		Timestamp:   time.Unix(b.start, 0).UTC(),
+		Samples:     int(b.count),
+		CPUUsage:    b.cpu / n,
+		CPUUsageMax: b.cpuMax,
+		Load1:       b.load1 / n,
+		Load5:       b.load5 / n,
+		Load15:      b.load15 / n,
+		MemoryTotal: uint64(b.memTotal / n),
+		MemoryUsed:  uint64(b.memUsed / n),
+		SwapTotal:   uint64(b.swapTotal / n),
+		SwapUsed:    uint64(b.swapUsed / n),
+		DiskTotal:   uint64(b.diskTotal / n),
+		DiskUsed:    uint64(b.diskUsed / n),
+		Processes:   int(b.processes/n + 0.5),
+	}
+	if b.rateSeconds > 0 {
+		rx := b.rxBytes / b.rateSeconds
+		tx := b.txBytes / b.rateSeconds
+		point.NetRxBytesPerSec = &rx
+		point.NetTxBytesPerSec = &tx
+	}
+	return point
+}
@@ -0,0 +1,264 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultTelegramAPIBase = "https://api.telegram.org"
+
+// sendWebhook posts the JSON payload. With a secret, X-Nebula-Signature carries
+// "sha256=" + hex(HMAC-SHA256(secret, timestamp + "." + body)) and X-Nebula-Timestamp the unix timestamp.
+func (s *NotificationService) sendWebhook(ctx context.Context, settings NotificationChannelSettings, body []byte) error {
+	headers := map[string]string{"Content-Type": "application/json"}
+	for key, val := range settings.Headers {
+		headers[key] = val
+	}
+	if settings.Secret != "" {
+		timestamp := strconv.FormatInt(s.now().Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(settings.Secret))
+		mac.Write([]byte(timestamp + "." + string(body)))
+		headers["X-Nebula-Timestamp"] = timestamp
+		headers["X-Nebula-Signature"] = "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+	_, err := s.postJSON(ctx, settings.URL, headers, body)
+	return err
+}
+
+func (s *NotificationService) sendTelegram(ctx context.Context, settings NotificationChannelSettings, text string) error {
+	base := settings.APIBase
+	if base == "" {
+		base = defaultTelegramAPIBase
+	}
+	body, _ := json.Marshal(map[string]interface{}{
+		"chat_id":                  settings.ChatID,
+		"text":                     text,
+		"disable_web_page_preview": true,
+	})
+	resp, err := s.postJSON(ctx, base+"/bot"+settings.BotToken+"/sendMessage", nil, body)
+	if err != nil {
+		return err
+	}
+	var result struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return fmt.Errorf("decode telegram response: %w", err)
+	}
+	if !result.OK {
+		return fmt.Errorf("telegram: %s", result.Description)
+	}
+	return nil
+}
+
+// sendDingTalk posts to a DingTalk robot, signing the request when the robot uses "加签".
+func (s *NotificationService) sendDingTalk(ctx context.Context, settings NotificationChannelSettings, text string) error {
+	target := settings.URL
+	if settings.Secret != "" {
+		timestamp := strconv.FormatInt(s.now().UnixMilli(), 10)
+		mac := hmac.New(sha256.New, []byte(settings.Secret))
+		mac.Write([]byte(timestamp + "\n" + settings.Secret))
+		sign := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+		separator := "?"
+		if strings.Contains(target, "?") {
+			separator = "&"
+		}
+		target += separator + "timestamp=" + timestamp + "&sign=" + url.QueryEscape(sign)
+	}
+	body, _ := json.Marshal(map[string]interface{}{
+		"msgtype": "text",
+		"text":    map[string]string{"content": text},
+	})
+	resp, err := s.postJSON(ctx, target, nil, body)
+	if err != nil {
+		return err
+	}
+	var result struct {
+		ErrCode int    `json:"errcode"`
+		ErrMsg  string `json:"errmsg"`
+	}
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return fmt.Errorf("decode dingtalk response: %w", err)
+	}
+	if result.ErrCode != 0 {
+		return fmt.Errorf("dingtalk: %d %s", result.ErrCode, result.ErrMsg)
+	}
+	return nil
+}
+
+// sendFeishu posts to a Feishu/Lark custom bot, signing the request when a secret is configured.
+func (s *NotificationService) sendFeishu(ctx context.Context, settings NotificationChannelSettings, text string) error {
+	payload := map[string]interface{}{
+		"msg_type": "text",
+		"content":  map[string]string{"text": text},
+	}
+	if settings.Secret != "" {
+		timestamp := strconv.FormatInt(s.now().Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(timestamp+"\n"+settings.Secret))
+		payload["timestamp"] = timestamp
+		payload["sign"] = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	}
+	body, _ := json.Marshal(payload)
+	resp, err := s.postJSON(ctx, settings.URL, nil, body)
+	if err != nil {
+		return err
+	}
+	var result struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+	}
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return fmt.Errorf("decode feishu response: %w", err)
+	}
+	if result.Code != 0 {
+		return fmt.Errorf("feishu: %d %s", result.Code, result.Msg)
+	}
+	return nil
+}
+
+// postJSON posts body and returns the response body, failing on non-2xx statuses. Bot URLs carry
+// their credentials (DingTalk's access_token, Feishu's hook ID, Telegram's bot token), so errors
+// name only the scheme and host; they end up in logs and the delivery record.
+func (s *NotificationService) postJSON(ctx context.Context, target string, headers map[string]string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, redactURLError(err, target)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, val := range headers {
+		req.Header.Set(key, val)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, redactURLError(err, target)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, truncate(strings.TrimSpace(string(respBody)), 200))
+	}
+	return respBody, nil
+}
+
+// redactURLError replaces the request URL in err, as reported by net/http, with its origin.
+func redactURLError(err error, target string) error {
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		return err
+	}
+	redacted := *urlErr
+	redacted.URL = "<invalid url>"
+	if parsed, parseErr := url.Parse(target); parseErr == nil && parsed.Host != "" {
+		redacted.URL = parsed.Scheme + "://" + parsed.Host
+	}
+	return &redacted
+}
+
+// sendEmail delivers a plain text message over SMTP, dated sentAt. SMTPTLS selects implicit TLS
+// (port 465); otherwise STARTTLS is used whenever the server offers it.
+func sendEmail(ctx context.Context, settings NotificationChannelSettings, subject, body string, sentAt time.Time) error {
+	addr := net.JoinHostPort(settings.SMTPHost, strconv.Itoa(settings.SMTPPort))
+	dialer := &net.Dialer{}
+	var conn net.Conn
+	var err error
+	if settings.SMTPTLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: settings.SMTPHost}}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	client, err := smtp.NewClient(conn, settings.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if !settings.SMTPTLS {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(&tls.Config{ServerName: settings.SMTPHost}); err != nil {
+				return fmt.Errorf("starttls: %w", err)
+			}
+		}
+	}
+	if settings.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", settings.Username, settings.Password, settings.SMTPHost)); err != nil {
+			return fmt.Errorf("smtp auth: %w", err)
+		}
+	}
+	if err := client.Mail(emailAddress(settings.From)); err != nil {
+		return err
+	}
+	for _, rcpt := range settings.To {
+		if err := client.Rcpt(emailAddress(rcpt)); err != nil {
+			return fmt.Errorf("recipient %s: %w", rcpt, err)
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(buildEmail(settings.From, settings.To, subject, body, sentAt)); err != nil {
+		writer.Close()
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// emailAddress extracts the bare address from "Name <addr>" forms.
+func emailAddress(raw string) string {
+	raw = strings.TrimSpace(raw)
+	if start := strings.LastIndex(raw, "<"); start >= 0 {
+		if end := strings.LastIndex(raw, ">"); end > start {
+			return raw[start+1 : end]
+		}
+	}
+	return raw
+}
+
+func buildEmail(from string, to []string, subject, body string, date time.Time) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+	encoded := base64.StdEncoding.EncodeToString([]byte(body))
+	for len(encoded) > 76 {
+		buf.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	buf.WriteString(encoded + "\r\n")
+	return buf.Bytes()
+}
@@ -0,0 +1,310 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"nebula_manager/internal/models"
+)
+
+var testSendTime = time.Unix(1_700_000_000, 0)
+
+func newTestNotificationService(client *http.Client) *NotificationService {
+	svc := NewNotificationService(nil, client)
+	svc.now = func() time.Time { return testSendTime }
+	return svc
+}
+
+func testChannel(channelType string, settings NotificationChannelSettings) models.NotificationChannel {
+	encoded, _ := json.Marshal(settings)
+	return models.NotificationChannel{Name: "test", Type: channelType, Settings: string(encoded)}
+}
+
+// captureServer records the last request and answers every request with status and body.
+func captureServer(t *testing.T, status int, body string) (*httptest.Server, *http.Request, *[]byte) {
+	t.Helper()
+	var (
+		last    http.Request
+		payload []byte
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload, _ = io.ReadAll(r.Body)
+		last = *r.Clone(context.Background())
+		w.WriteHeader(status)
+		_, _ = io.WriteString(w, body)
+	}))
+	t.Cleanup(server.Close)
+	return server, &last, &payload
+}
+
+func TestSendWebhookSignsPayload(t *testing.T) {
+	server, req, payload := captureServer(t, http.StatusOK, "")
+	svc := newTestNotificationService(server.Client())
+	channel := testChannel(models.NotificationChannelWebhook, NotificationChannelSettings{
+		URL:     server.URL + "/hook",
+		Secret:  "s3cret",
+		Headers: map[string]string{"Authorization": "Bearer token"},
+	})
+
+	if err := svc.send(context.Background(), channel, models.NotificationDelivery{Body: `{"alert":"down"}`}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if string(*payload) != `{"alert":"down"}` || req.URL.Path != "/hook" {
+		t.Fatalf("got %s %q", req.URL.Path, *payload)
+	}
+	if req.Header.Get("Authorization") != "Bearer token" {
+		t.Fatalf("custom header missing: %v", req.Header)
+	}
+	timestamp := strconv.FormatInt(testSendTime.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(timestamp + "." + `{"alert":"down"}`))
+	if got := req.Header.Get("X-Nebula-Signature"); got != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+		t.Fatalf("signature = %q", got)
+	}
+	if req.Header.Get("X-Nebula-Timestamp") != timestamp {
+		t.Fatalf("timestamp = %q", req.Header.Get("X-Nebula-Timestamp"))
+	}
+}
+
+func TestSendDingTalkSignsURL(t *testing.T) {
+	server, req, payload := captureServer(t, http.StatusOK, `{"errcode":0,"errmsg":"ok"}`)
+	svc := newTestNotificationService(server.Client())
+	channel := testChannel(models.NotificationChannelDingTalk, NotificationChannelSettings{
+		URL:    server.URL + "/robot/send?access_token=abc",
+		Secret: "SEC123",
+	})
+
+	if err := svc.send(context.Background(), channel, models.NotificationDelivery{Body: "node down"}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	query := req.URL.Query()
+	timestamp := strconv.FormatInt(testSendTime.UnixMilli(), 10)
+	mac := hmac.New(sha256.New, []byte("SEC123"))
+	mac.Write([]byte(timestamp + "\n" + "SEC123"))
+	if query.Get("access_token") != "abc" || query.Get("timestamp") != timestamp ||
+		query.Get("sign") != base64.StdEncoding.EncodeToString(mac.Sum(nil)) {
+		t.Fatalf("query = %v", query)
+	}
+	if !strings.Contains(string(*payload), "node down") {
+		t.Fatalf("payload = %s", *payload)
+	}
+}
+
+func TestSendDingTalkReportsRobotError(t *testing.T) {
+	server, _, _ := captureServer(t, http.StatusOK, `{"errcode":310000,"errmsg":"sign not match"}`)
+	svc := newTestNotificationService(server.Client())
+	channel := testChannel(models.NotificationChannelDingTalk, NotificationChannelSettings{URL: server.URL})
+
+	err := svc.send(context.Background(), channel, models.NotificationDelivery{Body: "x"})
+	if err == nil || !strings.Contains(err.Error(), "310000") {
+		t.Fatalf("err = %v, want the robot's error code", err)
+	}
+}
+
+func TestSendFeishuSignsBody(t *testing.T) {
+	server, _, payload := captureServer(t, http.StatusOK, `{"code":0}`)
+	svc := newTestNotificationService(server.Client())
+	channel := testChannel(models.NotificationChannelFeishu, NotificationChannelSettings{
+		URL:    server.URL + "/open-apis/bot/v2/hook/abc",
+		Secret: "feishu",
+	})
+
+	if err := svc.send(context.Background(), channel, models.NotificationDelivery{Body: "node down"}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	var body struct {
+		Timestamp string `json:"timestamp"`
+		Sign      string `json:"sign"`
+	}
+	if err := json.Unmarshal(*payload, &body); err != nil {
+		t.Fatal(err)
+	}
+	mac := hmac.New(sha256.New, []byte(body.Timestamp+"\n"+"feishu"))
+	if body.Timestamp != strconv.FormatInt(testSendTime.Unix(), 10) || body.Sign != base64.StdEncoding.EncodeToString(mac.Sum(nil)) {
+		t.Fatalf("payload = %s", *payload)
+	}
+}
+
+func TestSendTelegram(t *testing.T) {
+	server, req, payload := captureServer(t, http.StatusOK, `{"ok":true}`)
+	svc := newTestNotificationService(server.Client())
+	channel := testChannel(models.NotificationChannelTelegram, NotificationChannelSettings{
+		APIBase:  server.URL,
+		BotToken: "123:abc",
+		ChatID:   "-100",
+	})
+
+	if err := svc.send(context.Background(), channel, models.NotificationDelivery{Body: "node down"}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if req.URL.Path != "/bot123:abc/sendMessage" || !strings.Contains(string(*payload), `"chat_id":"-100"`) {
+		t.Fatalf("got %s %s", req.URL.Path, *payload)
+	}
+}
+
+func TestSendErrorsDoNotLeakURLSecrets(t *testing.T) {
+	// A listener that is closed at once gives a connection error quoting the request URL.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := "http://" + listener.Addr().String()
+	listener.Close()
+	svc := newTestNotificationService(nil)
+
+	channels := map[string]models.NotificationChannel{
+		"dingtalk": testChannel(models.NotificationChannelDingTalk, NotificationChannelSettings{URL: base + "/robot/send?access_token=leaked-token"}),
+		"feishu":   testChannel(models.NotificationChannelFeishu, NotificationChannelSettings{URL: base + "/open-apis/bot/v2/hook/leaked-token"}),
+		"telegram": testChannel(models.NotificationChannelTelegram, NotificationChannelSettings{APIBase: base, BotToken: "leaked-token", ChatID: "1"}),
+	}
+	for name, channel := range channels {
+		err := svc.send(context.Background(), channel, models.NotificationDelivery{Body: "x"})
+		if err == nil {
+			t.Fatalf("%s: send to a closed port succeeded", name)
+		}
+		if strings.Contains(err.Error(), "leaked-token") || !strings.Contains(err.Error(), base) {
+			t.Errorf("%s: err = %v, want the origin without the token", name, err)
+		}
+	}
+}
+
+func TestSendEmailOverSMTP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	received := make(chan []string, 1)
+	go serveFakeSMTP(listener, received)
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+	settings := NotificationChannelSettings{
+		SMTPHost: host,
+		SMTPPort: portNum,
+		From:     "Nebula <alerts@example.com>",
+		To:       []string{"ops@example.com"},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	sentAt := time.Date(2024, 3, 1, 8, 30, 0, 0, time.UTC)
+	if err := sendEmail(ctx, settings, "节点离线", "node-1 is down", sentAt); err != nil {
+		t.Fatalf("sendEmail: %v", err)
+	}
+
+	lines := <-received
+	transcript := strings.Join(lines, "\n")
+	for _, want := range []string{
+		"MAIL FROM:<alerts@example.com>",
+		"RCPT TO:<ops@example.com>",
+		"Subject: =?utf-8?q?",
+		"Date: Fri, 01 Mar 2024 08:30:00 +0000",
+		base64.StdEncoding.EncodeToString([]byte("node-1 is down")),
+	} {
+		if !strings.Contains(transcript, want) {
+			t.Errorf("transcript lacks %q:\n%s", want, transcript)
+		}
+	}
+}
+
+// serveFakeSMTP accepts one connection, speaks just enough SMTP for a plain delivery and sends
+// the lines it received.
+func serveFakeSMTP(listener net.Listener, received chan<- []string) {
+	conn, err := listener.Accept()
+	if err != nil {
+		received <- nil
+		return
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { _, _ = io.WriteString(conn, line+"\r\n") }
+	var lines []string
+	reply("220 fake.test ESMTP")
+	inData := false
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			break
+		}
+		line = strings.TrimRight(line, "\r\n")
+		lines = append(lines, line)
+		if inData {
+			if line == "." {
+				inData = false
+				reply("250 queued")
+			}
+			continue
+		}
+		switch command := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); command {
+		case "EHLO", "HELO":
+			reply("250 fake.test")
+		case "DATA":
+			inData = true
+			reply("354 go ahead")
+		case "QUIT":
+			reply("221 bye")
+			received <- lines
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+	received <- lines
+}
+
+func TestChannelViewMasksHeaders(t *testing.T) {
+	stored := NotificationChannelSettings{
+		URL:     "https://hooks.example.com/x",
+		Secret:  "s3cret",
+		Headers: map[string]string{"Authorization": "Bearer token", "X-Team": "ops"},
+	}
+	channel := testChannel(models.NotificationChannelWebhook, stored)
+	view := channelView(channel)
+	for name, value := range view.Settings.Headers {
+		if value != maskedSecret {
+			t.Fatalf("header %s returned in clear: %q", name, value)
+		}
+	}
+	if stored.Headers["Authorization"] != "Bearer token" {
+		t.Fatal("masking must not modify the stored settings")
+	}
+
+	// Sending the view back keeps masked values and applies edited ones.
+	edited := view.Settings
+	edited.Headers = map[string]string{"authorization": maskedSecret, "X-Team": "platform", "X-New": "1"}
+	var updated models.NotificationChannel
+	err := applyChannelRequest(&updated, stored, NotificationChannelRequest{
+		Name:     "hook",
+		Type:     models.NotificationChannelWebhook,
+		Settings: edited,
+	})
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	var saved NotificationChannelSettings
+	if err := json.Unmarshal([]byte(updated.Settings), &saved); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"authorization": "Bearer token", "X-Team": "platform", "X-New": "1"}
+	for name, value := range want {
+		if saved.Headers[name] != value {
+			t.Errorf("header %s = %q, want %q", name, saved.Headers[name], value)
+		}
+	}
+	if saved.Secret != "s3cret" {
+		t.Errorf("secret = %q, want the stored one", saved.Secret)
+	}
+}
@@ -0,0 +1,652 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"gorm.io/gorm"
+
+	"nebula_manager/internal/models"
+)
+
+const (
+	notificationPollInterval  = 15 * time.Second
+	notificationBatchSize     = 50
+	notificationMaxAttempts   = 6
+	notificationRetryBase     = 30 * time.Second
+	notificationRetryMax      = 30 * time.Minute
+	notificationSendTimeout   = 15 * time.Second
+	notificationLogRetention  = 30 * 24 * time.Hour
+	notificationDeliveryLimit = 200
+	// maskedSecret replaces credentials in API responses; sending it back keeps the stored value.
+	maskedSecret = "******"
+)
+
+// ErrNotificationInvalid is returned for channel payloads that fail validation.
+var ErrNotificationInvalid = errors.New("invalid notification channel")
+
+var severityRank = map[string]int{
+	models.AlertSeverityInfo:     0,
+	models.AlertSeverityWarning:  1,
+	models.AlertSeverityCritical: 2,
+}
+
+// NotificationChannelSettings are the type specific settings of a channel.
+type NotificationChannelSettings struct {
+	// webhook, dingtalk, feishu
+	URL     string            `json:"url,omitempty"`
+	Secret  string            `json:"secret,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// email
+	SMTPHost string   `json:"smtp_host,omitempty"`
+	SMTPPort int      `json:"smtp_port,omitempty"`
+	SMTPTLS  bool     `json:"smtp_tls,omitempty"`
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+	From     string   `json:"from,omitempty"`
+	To       []string `json:"to,omitempty"`
+	// telegram
+	BotToken string `json:"bot_token,omitempty"`
+	ChatID   string `json:"chat_id,omitempty"`
+	APIBase  string `json:"api_base,omitempty"`
+}
+
+func (s NotificationChannelSettings) masked() NotificationChannelSettings {
+	if s.Secret != "" {
+		s.Secret = maskedSecret
+	}
+	if s.Password != "" {
+		s.Password = maskedSecret
+	}
+	if s.BotToken != "" {
+		s.BotToken = maskedSecret
+	}
+	if len(s.Headers) > 0 {
+		// Webhook headers usually carry Authorization tokens; names stay visible so they can be edited.
+		headers := make(map[string]string, len(s.Headers))
+		for name := range s.Headers {
+			headers[name] = maskedSecret
+		}
+		s.Headers = headers
+	}
+	return s
+}
+
+// NotificationChannelRequest carries the payload for creating or updating a channel.
+type NotificationChannelRequest struct {
+	Name           string                      `json:"name" binding:"required"`
+	Type           string                      `json:"type" binding:"required"`
+	Enabled        *bool                       `json:"enabled"`
+	Settings       NotificationChannelSettings `json:"settings"`
+	MinSeverity    string                      `json:"min_severity"`
+	Tags           string                      `json:"tags"`
+	NotifyResolved bool                        `json:"notify_resolved"`
+	Template       string                      `json:"template"`
+}
+
+// NotificationChannelView is a channel as returned by the API, with credentials masked.
+type NotificationChannelView struct {
+	models.NotificationChannel
+	Settings NotificationChannelSettings `json:"settings"`
+}
+
+// DeliveryFilter narrows the delivery log.
+type DeliveryFilter struct {
+	ChannelID uint
+	AlertID   uint
+	Status    string
+}
+
+// NotificationMessage is the data notification templates are rendered with.
+type NotificationMessage struct {
+	Event      string
+	Title      string
+	AlertID    uint
+	Rule       string
+	Kind       string
+	Severity   string
+	Subject    string
+	Summary    string
+	Value      float64
+	Node       string
+	NodeID     uint
+	PeerNodeID uint
+	StartedAt  time.Time
+	FiredAt    *time.Time
+	ResolvedAt *time.Time
+}
+
+const defaultNotificationTemplate = `{{.Title}}
+{{.Summary}}
+开始时间：{{.StartedAt.Format "2006-01-02 15:04:05 MST"}}{{if .ResolvedAt}}
+恢复时间：{{.ResolvedAt.Format "2006-01-02 15:04:05 MST"}}{{end}}`
+
+// NotificationService routes alert transitions to channels and delivers them with retries.
+type NotificationService struct {
+	db       *gorm.DB
+	client   *http.Client
+	interval time.Duration
+	now      func() time.Time
+	wake     chan struct{}
+}
+
+// NewNotificationService constructs a NotificationService; a nil client uses a default one.
+func NewNotificationService(db *gorm.DB, client *http.Client) *NotificationService {
+	if client == nil {
+		client = &http.Client{Timeout: notificationSendTimeout}
+	}
+	return &NotificationService{
+		db:       db,
+		client:   client,
+		interval: notificationPollInterval,
+		now:      time.Now,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Start delivers queued notifications in the background until ctx is cancelled.
+func (s *NotificationService) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			if err := s.DeliverDue(ctx); err != nil {
+				log.Printf("notifications: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-s.wake:
+			}
+		}
+	}()
+}
+
+func (s *NotificationService) kick() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// ListChannels returns all channels.
+func (s *NotificationService) ListChannels() ([]NotificationChannelView, error) {
+	var channels []models.NotificationChannel
+	if err := s.db.Order("id asc").Find(&channels).Error; err != nil {
+		return nil, err
+	}
+	views := make([]NotificationChannelView, 0, len(channels))
+	for _, channel := range channels {
+		views = append(views, channelView(channel))
+	}
+	return views, nil
+}
+
+// GetChannel loads a channel by ID.
+func (s *NotificationService) GetChannel(id uint) (*models.NotificationChannel, error) {
+	var channel models.NotificationChannel
+	if err := s.db.First(&channel, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("notification channel %d not found", id)
+		}
+		return nil, err
+	}
+	return &channel, nil
+}
+
+// CreateChannel validates and stores a new channel.
+func (s *NotificationService) CreateChannel(req NotificationChannelRequest) (*NotificationChannelView, error) {
+	channel := models.NotificationChannel{Enabled: true}
+	if err := applyChannelRequest(&channel, NotificationChannelSettings{}, req); err != nil {
+		return nil, err
+	}
+	if err := s.db.Create(&channel).Error; err != nil {
+		return nil, err
+	}
+	view := channelView(channel)
+	return &view, nil
+}
+
+// UpdateChannel replaces a channel's definition. Masked credentials keep their stored values.
+func (s *NotificationService) UpdateChannel(id uint, req NotificationChannelRequest) (*NotificationChannelView, error) {
+	channel, err := s.GetChannel(id)
+	if err != nil {
+		return nil, err
+	}
+	var previous NotificationChannelSettings
+	if channel.Settings != "" {
+		if err := json.Unmarshal([]byte(channel.Settings), &previous); err != nil {
+			return nil, fmt.Errorf("decode channel settings: %w", err)
+		}
+	}
+	if err := applyChannelRequest(channel, previous, req); err != nil {
+		return nil, err
+	}
+	if err := s.db.Save(channel).Error; err != nil {
+		return nil, err
+	}
+	view := channelView(*channel)
+	return &view, nil
+}
+
+// DeleteChannel removes a channel and drops its queued deliveries; the delivery log is kept.
+func (s *NotificationService) DeleteChannel(id uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("channel_id = ? AND status = ?", id, models.DeliveryStatusPending).
+			Delete(&models.NotificationDelivery{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.NotificationChannel{}, id).Error
+	})
+}
+
+func channelView(channel models.NotificationChannel) NotificationChannelView {
+	var settings NotificationChannelSettings
+	if channel.Settings != "" {
+		_ = json.Unmarshal([]byte(channel.Settings), &settings)
+	}
+	return NotificationChannelView{NotificationChannel: channel, Settings: settings.masked()}
+}
+
+func applyChannelRequest(channel *models.NotificationChannel, previous NotificationChannelSettings, req NotificationChannelRequest) error {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrNotificationInvalid)
+	}
+	settings := req.Settings
+	if settings.Secret == maskedSecret {
+		settings.Secret = previous.Secret
+	}
+	if settings.Password == maskedSecret {
+		settings.Password = previous.Password
+	}
+	if settings.BotToken == maskedSecret {
+		settings.BotToken = previous.BotToken
+	}
+	if len(settings.Headers) > 0 {
+		headers := make(map[string]string, len(settings.Headers))
+		for name, value := range settings.Headers {
+			if value == maskedSecret {
+				value = previousHeader(previous.Headers, name)
+			}
+			headers[name] = value
+		}
+		settings.Headers = headers
+	}
+	if err := validateChannelSettings(req.Type, &settings); err != nil {
+		return err
+	}
+	if req.MinSeverity != "" {
+		if _, ok := severityRank[req.MinSeverity]; !ok {
+			return fmt.Errorf("%w: unknown severity %q", ErrNotificationInvalid, req.MinSeverity)
+		}
+	}
+	if strings.TrimSpace(req.Template) != "" {
+		if _, err := template.New("notification").Parse(req.Template); err != nil {
+			return fmt.Errorf("%w: template: %v", ErrNotificationInvalid, err)
+		}
+	}
+	encoded, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+
+	channel.Name = req.Name
+	channel.Type = req.Type
+	channel.Settings = string(encoded)
+	channel.MinSeverity = req.MinSeverity
+	channel.Tags = normalizeTags(req.Tags)
+	channel.NotifyResolved = req.NotifyResolved
+	channel.Template = strings.TrimSpace(req.Template)
+	if req.Enabled != nil {
+		channel.Enabled = *req.Enabled
+	}
+	return nil
+}
+
+// previousHeader returns the stored value of a header; names are case-insensitive in HTTP.
+func previousHeader(headers map[string]string, name string) string {
+	if value, ok := headers[name]; ok {
+		return value
+	}
+	for stored, value := range headers {
+		if strings.EqualFold(stored, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+func validateChannelSettings(channelType string, settings *NotificationChannelSettings) error {
+	settings.URL = strings.TrimSpace(settings.URL)
+	switch channelType {
+	case models.NotificationChannelWebhook, models.NotificationChannelDingTalk, models.NotificationChannelFeishu:
+		if !strings.HasPrefix(settings.URL, "http://") && !strings.HasPrefix(settings.URL, "https://") {
+			return fmt.Errorf("%w: url must be an http(s) URL", ErrNotificationInvalid)
+		}
+	case models.NotificationChannelEmail:
+		settings.SMTPHost = strings.TrimSpace(settings.SMTPHost)
+		if settings.SMTPHost == "" || strings.TrimSpace(settings.From) == "" {
+			return fmt.Errorf("%w: smtp_host and from are required", ErrNotificationInvalid)
+		}
+		if settings.SMTPPort == 0 {
+			settings.SMTPPort = 587
+			if settings.SMTPTLS {
+				settings.SMTPPort = 465
+			}
+		}
+		var recipients []string
+		for _, addr := range settings.To {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				recipients = append(recipients, addr)
+			}
+		}
+		if len(recipients) == 0 {
+			return fmt.Errorf("%w: at least one recipient is required", ErrNotificationInvalid)
+		}
+		settings.To = recipients
+	case models.NotificationChannelTelegram:
+		if settings.BotToken == "" || strings.TrimSpace(settings.ChatID) == "" {
+			return fmt.Errorf("%w: bot_token and chat_id are required", ErrNotificationInvalid)
+		}
+		settings.APIBase = strings.TrimRight(strings.TrimSpace(settings.APIBase), "/")
+	default:
+		return fmt.Errorf("%w: unknown type %q", ErrNotificationInvalid, channelType)
+	}
+	return nil
+}
+
+func normalizeTags(raw string) string {
+	var tags []string
+	for _, tag := range strings.Split(raw, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return strings.Join(tags, ",")
+}
+
+// NotifyAlert queues a notification of an alert transition on every channel routed to it.
+func (s *NotificationService) NotifyAlert(event string, alert models.Alert, rule models.AlertRule) error {
+	var channels []models.NotificationChannel
+	if err := s.db.Where("enabled = ?", true).Find(&channels).Error; err != nil {
+		return err
+	}
+	if len(channels) == 0 {
+		return nil
+	}
+
+	var node models.Node
+	if alert.NodeID != 0 {
+		if err := s.db.First(&node, alert.NodeID).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+	}
+	msg := NotificationMessage{
+		Event:      event,
+		Title:      fmt.Sprintf("[%s][%s] %s: %s", strings.ToUpper(event), alert.Severity, rule.Name, alert.Subject),
+		AlertID:    alert.ID,
+		Rule:       rule.Name,
+		Kind:       alert.Kind,
+		Severity:   alert.Severity,
+		Subject:    alert.Subject,
+		Summary:    alert.Summary,
+		Value:      alert.Value,
+		Node:       node.Name,
+		NodeID:     alert.NodeID,
+		PeerNodeID: alert.PeerNodeID,
+		StartedAt:  alert.StartedAt,
+		FiredAt:    alert.FiredAt,
+		ResolvedAt: alert.ResolvedAt,
+	}
+
+	now := s.now()
+	queued := 0
+	for _, channel := range channels {
+		if !channelRoutes(channel, event, alert.Severity, node.Tags) {
+			continue
+		}
+		delivery := models.NotificationDelivery{
+			ChannelID:     channel.ID,
+			AlertID:       alert.ID,
+			Event:         event,
+			Status:        models.DeliveryStatusPending,
+			NextAttemptAt: now,
+		}
+		delivery.Title, delivery.Body = renderNotification(channel, msg)
+		if err := s.db.Create(&delivery).Error; err != nil {
+			return err
+		}
+		queued++
+	}
+	if queued > 0 {
+		s.kick()
+	}
+	return nil
+}
+
+// channelRoutes reports whether a channel subscribes to an event of the given severity on a node with nodeTags.
+func channelRoutes(channel models.NotificationChannel, event, severity, nodeTags string) bool {
+	if event == models.NotificationEventResolved && !channel.NotifyResolved {
+		return false
+	}
+	if channel.MinSeverity != "" && severityRank[severity] < severityRank[channel.MinSeverity] {
+		return false
+	}
+	if channel.Tags == "" {
+		return true
+	}
+	for _, want := range strings.Split(channel.Tags, ",") {
+		for _, have := range strings.Split(nodeTags, ",") {
+			if strings.TrimSpace(have) == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// renderNotification returns the title and body of a message for the channel. Webhook bodies are
+// the JSON payload posted as is; other channels get the rendered text.
+func renderNotification(channel models.NotificationChannel, msg NotificationMessage) (string, string) {
+	text, err := renderNotificationText(channel.Template, msg)
+	if err != nil {
+		log.Printf("notifications: channel %s template: %v; using default", channel.Name, err)
+		text, _ = renderNotificationText("", msg)
+	}
+	if channel.Type != models.NotificationChannelWebhook {
+		return truncate(msg.Title, 255), text
+	}
+	payload, _ := json.Marshal(map[string]interface{}{
+		"event":        msg.Event,
+		"title":        msg.Title,
+		"text":         text,
+		"alert_id":     msg.AlertID,
+		"rule":         msg.Rule,
+		"kind":         msg.Kind,
+		"severity":     msg.Severity,
+		"subject":      msg.Subject,
+		"summary":      msg.Summary,
+		"value":        msg.Value,
+		"node":         msg.Node,
+		"node_id":      msg.NodeID,
+		"peer_node_id": msg.PeerNodeID,
+		"started_at":   msg.StartedAt,
+		"fired_at":     msg.FiredAt,
+		"resolved_at":  msg.ResolvedAt,
+	})
+	return truncate(msg.Title, 255), string(payload)
+}
+
+func renderNotificationText(tmpl string, msg NotificationMessage) (string, error) {
+	if tmpl == "" {
+		tmpl = defaultNotificationTemplate
+	}
+	parsed, err := template.New("notification").Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := parsed.Execute(&buf, msg); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// TestChannel sends a test message right away and records it in the delivery log.
+func (s *NotificationService) TestChannel(ctx context.Context, id uint) (*models.NotificationDelivery, error) {
+	channel, err := s.GetChannel(id)
+	if err != nil {
+		return nil, err
+	}
+	now := s.now()
+	msg := NotificationMessage{
+		Event:     models.NotificationEventTest,
+		Title:     fmt.Sprintf("[TEST] %s", channel.Name),
+		Rule:      "测试",
+		Severity:  models.AlertSeverityInfo,
+		Subject:   channel.Name,
+		Summary:   "这是一条来自 Nebula Manager 的测试通知。",
+		StartedAt: now,
+	}
+	delivery := models.NotificationDelivery{
+		ChannelID:     channel.ID,
+		Event:         models.NotificationEventTest,
+		Status:        models.DeliveryStatusPending,
+		NextAttemptAt: now,
+	}
+	delivery.Title, delivery.Body = renderNotification(*channel, msg)
+	if err := s.db.Create(&delivery).Error; err != nil {
+		return nil, err
+	}
+	sendErr := s.send(ctx, *channel, delivery)
+	s.recordAttempt(&delivery, sendErr, false)
+	if err := s.db.Save(&delivery).Error; err != nil {
+		return nil, err
+	}
+	return &delivery, sendErr
+}
+
+// ListDeliveries returns the most recent deliveries matching the filter.
+func (s *NotificationService) ListDeliveries(filter DeliveryFilter) ([]models.NotificationDelivery, error) {
+	query := s.db.Model(&models.NotificationDelivery{})
+	if filter.ChannelID != 0 {
+		query = query.Where("channel_id = ?", filter.ChannelID)
+	}
+	if filter.AlertID != 0 {
+		query = query.Where("alert_id = ?", filter.AlertID)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	var deliveries []models.NotificationDelivery
+	if err := query.Order("id desc").Limit(notificationDeliveryLimit).Find(&deliveries).Error; err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// DeliverDue sends every queued delivery whose next attempt is due and prunes the old log.
+func (s *NotificationService) DeliverDue(ctx context.Context) error {
+	now := s.now()
+	var due []models.NotificationDelivery
+	if err := s.db.Where("status = ? AND next_attempt_at <= ?", models.DeliveryStatusPending, now).
+		Order("next_attempt_at asc").Limit(notificationBatchSize).Find(&due).Error; err != nil {
+		return err
+	}
+	channels := make(map[uint]*models.NotificationChannel)
+	for i := range due {
+		delivery := &due[i]
+		channel, ok := channels[delivery.ChannelID]
+		if !ok {
+			var err error
+			if channel, err = s.GetChannel(delivery.ChannelID); err != nil {
+				channel = nil
+			}
+			channels[delivery.ChannelID] = channel
+		}
+
+		var sendErr error
+		switch {
+		case channel == nil:
+			sendErr = errors.New("channel no longer exists")
+		case !channel.Enabled:
+			sendErr = errors.New("channel is disabled")
+		default:
+			sendErr = s.send(ctx, *channel, *delivery)
+		}
+		s.recordAttempt(delivery, sendErr, channel != nil && channel.Enabled)
+		if err := s.db.Save(delivery).Error; err != nil {
+			return err
+		}
+		if sendErr != nil {
+			log.Printf("notifications: delivery %d to channel %d (attempt %d): %v", delivery.ID, delivery.ChannelID, delivery.Attempts, sendErr)
+		}
+	}
+
+	return s.db.Where("created_at < ? AND status <> ?", now.Add(-notificationLogRetention), models.DeliveryStatusPending).
+		Delete(&models.NotificationDelivery{}).Error
+}
+
+// recordAttempt updates a delivery after a send; failed sends are retried with exponential backoff.
+func (s *NotificationService) recordAttempt(delivery *models.NotificationDelivery, sendErr error, retry bool) {
+	now := s.now()
+	delivery.Attempts++
+	if sendErr == nil {
+		delivery.Status = models.DeliveryStatusSent
+		delivery.LastError = ""
+		delivery.DeliveredAt = &now
+		return
+	}
+	delivery.LastError = truncate(sendErr.Error(), 512)
+	if !retry || delivery.Attempts >= notificationMaxAttempts {
+		delivery.Status = models.DeliveryStatusFailed
+		return
+	}
+	delivery.NextAttemptAt = now.Add(notificationRetryDelay(delivery.Attempts))
+}
+
+// notificationRetryDelay doubles from notificationRetryBase after each failed attempt.
+func notificationRetryDelay(attempts int) time.Duration {
+	delay := notificationRetryBase
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= notificationRetryMax {
+			return notificationRetryMax
+		}
+	}
+	return delay
+}
+
+func (s *NotificationService) send(ctx context.Context, channel models.NotificationChannel, delivery models.NotificationDelivery) error {
+	var settings NotificationChannelSettings
+	if err := json.Unmarshal([]byte(channel.Settings), &settings); err != nil {
+		return fmt.Errorf("decode channel settings: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, notificationSendTimeout)
+	defer cancel()
+
+	switch channel.Type {
+	case models.NotificationChannelWebhook:
+		return s.sendWebhook(ctx, settings, []byte(delivery.Body))
+	case models.NotificationChannelEmail:
+		return sendEmail(ctx, settings, delivery.Title, delivery.Body, s.now())
+	case models.NotificationChannelTelegram:
+		return s.sendTelegram(ctx, settings, delivery.Body)
+	case models.NotificationChannelDingTalk:
+		return s.sendDingTalk(ctx, settings, delivery.Body)
+	case models.NotificationChannelFeishu:
+		return s.sendFeishu(ctx, settings, delivery.Body)
+	default:
+		return fmt.Errorf("unknown channel type %q", channel.Type)
+	}
+}
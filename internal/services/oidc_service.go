@@ -0,0 +1,612 @@
+package services
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"nebula_manager/internal/models"
+)
+
+const (
+	oidcStateTTL        = 10 * time.Minute
+	oidcDiscoveryTTL    = time.Hour
+	oidcKeyRefreshDelay = time.Minute
+	oidcClockSkew       = 2 * time.Minute
+)
+
+// ErrOIDCAccessDenied is returned when an authenticated IdP user maps to no manager role.
+var ErrOIDCAccessDenied = errors.New("no manager role mapped for this account")
+
+// OIDCConfig describes the relying-party settings for OpenID Connect login.
+type OIDCConfig struct {
+	Issuer        string
+	ClientID      string
+	ClientSecret  string
+	RedirectURL   string
+	Scopes        []string
+	UsernameClaim string
+	GroupsClaim   string
+	// RoleMapping maps IdP group names to manager roles. The highest-privileged match wins.
+	RoleMapping map[string]string
+	// DefaultRole is assigned when no group matches; empty denies access.
+	DefaultRole string
+	// TrustIdPMFA lets SSO logins skip the local second factor, for identity providers that enforce
+	// their own.
+	TrustIdPMFA bool
+}
+
+// OIDCIdentity is the verified result of an OIDC login.
+type OIDCIdentity struct {
+	Issuer   string
+	Subject  string
+	Username string
+	Email    string
+	Groups   []string
+	Role     string
+	ReturnTo string
+}
+
+// OIDCService implements the authorization-code flow with PKCE against a single issuer.
+type OIDCService struct {
+	cfg    OIDCConfig
+	client *http.Client
+	now    func() time.Time
+
+	mu            sync.Mutex
+	discovery     *oidcDiscovery
+	discoveredAt  time.Time
+	keys          map[string]crypto.PublicKey
+	keysFetchedAt time.Time
+	pending       map[string]*oidcPending
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcPending struct {
+	verifier  string
+	nonce     string
+	returnTo  string
+	expiresAt time.Time
+}
+
+// NewOIDCService constructs an OIDCService. A nil client uses a default client with a timeout.
+func NewOIDCService(cfg OIDCConfig, client *http.Client) *OIDCService {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "profile", "email"}
+	}
+	if cfg.UsernameClaim == "" {
+		cfg.UsernameClaim = "preferred_username"
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+	cfg.Issuer = strings.TrimRight(cfg.Issuer, "/")
+	return &OIDCService{
+		cfg:     cfg,
+		client:  client,
+		now:     time.Now,
+		keys:    make(map[string]crypto.PublicKey),
+		pending: make(map[string]*oidcPending),
+	}
+}
+
+// Enabled reports whether OIDC login is configured.
+func (s *OIDCService) Enabled() bool {
+	return s != nil && s.cfg.Issuer != "" && s.cfg.ClientID != "" && s.cfg.RedirectURL != ""
+}
+
+// AuthorizationURL starts a login and returns the IdP URL to redirect the browser to, along with the
+// login's state. The caller binds the state to the browser so a callback cannot be replayed elsewhere.
+func (s *OIDCService) AuthorizationURL(ctx context.Context, returnTo string) (string, string, error) {
+	disc, err := s.getDiscovery(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	state, err := randomToken(24)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomToken(24)
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := randomToken(48)
+	if err != nil {
+		return "", "", err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+
+	s.mu.Lock()
+	now := s.now()
+	for key, p := range s.pending {
+		if now.After(p.expiresAt) {
+			delete(s.pending, key)
+		}
+	}
+	s.pending[state] = &oidcPending{verifier: verifier, nonce: nonce, returnTo: returnTo, expiresAt: now.Add(oidcStateTTL)}
+	s.mu.Unlock()
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", s.cfg.ClientID)
+	params.Set("redirect_uri", s.cfg.RedirectURL)
+	params.Set("scope", strings.Join(s.cfg.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	params.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(disc.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return disc.AuthorizationEndpoint + sep + params.Encode(), state, nil
+}
+
+// TrustIdPMFA reports whether SSO logins skip the local second factor.
+func (s *OIDCService) TrustIdPMFA() bool {
+	return s.cfg.TrustIdPMFA
+}
+
+// StateTTL is how long a started login stays valid.
+func (s *OIDCService) StateTTL() time.Duration {
+	return oidcStateTTL
+}
+
+// Exchange completes a login: it validates state, redeems the code and verifies the ID token.
+func (s *OIDCService) Exchange(ctx context.Context, state, code string) (*OIDCIdentity, error) {
+	s.mu.Lock()
+	pending, ok := s.pending[state]
+	delete(s.pending, state)
+	s.mu.Unlock()
+	if !ok || s.now().After(pending.expiresAt) {
+		return nil, errors.New("login state expired or unknown")
+	}
+	if code == "" {
+		return nil, errors.New("authorization code missing")
+	}
+
+	disc, err := s.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", s.cfg.RedirectURL)
+	form.Set("client_id", s.cfg.ClientID)
+	form.Set("code_verifier", pending.verifier)
+	if s.cfg.ClientSecret != "" {
+		form.Set("client_secret", s.cfg.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, disc.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	var tokenResp struct {
+		AccessToken string `json:"access_token"`
+		IDToken     string `json:"id_token"`
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	if err := s.doJSON(req, &tokenResp); err != nil {
+		return nil, fmt.Errorf("token exchange: %w", err)
+	}
+	if tokenResp.Error != "" {
+		return nil, fmt.Errorf("token exchange: %s %s", tokenResp.Error, tokenResp.Description)
+	}
+	if tokenResp.IDToken == "" {
+		return nil, errors.New("token response missing id_token")
+	}
+
+	claims, err := s.verifyIDToken(ctx, tokenResp.IDToken, pending.nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := claims[s.cfg.GroupsClaim]; !ok && disc.UserinfoEndpoint != "" && tokenResp.AccessToken != "" {
+		if info, err := s.fetchUserinfo(ctx, disc.UserinfoEndpoint, tokenResp.AccessToken); err == nil {
+			if sub, _ := info["sub"].(string); sub == claims["sub"] {
+				for key, val := range info {
+					if _, exists := claims[key]; !exists {
+						claims[key] = val
+					}
+				}
+			}
+		}
+	}
+
+	identity := &OIDCIdentity{Issuer: s.cfg.Issuer, ReturnTo: pending.returnTo}
+	identity.Subject, _ = claims["sub"].(string)
+	if identity.Subject == "" {
+		return nil, errors.New("id token carries no subject")
+	}
+	identity.Email, _ = claims["email"].(string)
+	identity.Username, _ = claims[s.cfg.UsernameClaim].(string)
+	if identity.Username == "" {
+		identity.Username = identity.Email
+	}
+	if identity.Username == "" {
+		identity.Username = identity.Subject
+	}
+	identity.Groups = claimStrings(claims[s.cfg.GroupsClaim])
+	identity.Role = s.mapRole(identity.Groups)
+	if identity.Role == "" {
+		return identity, ErrOIDCAccessDenied
+	}
+	return identity, nil
+}
+
+func (s *OIDCService) mapRole(groups []string) string {
+	role := ""
+	for _, group := range groups {
+		mapped, ok := s.cfg.RoleMapping[group]
+		if !ok {
+			continue
+		}
+		if mapped == models.UserRoleAdmin {
+			return mapped
+		}
+		role = mapped
+	}
+	if role == "" {
+		role = s.cfg.DefaultRole
+	}
+	return role
+}
+
+func (s *OIDCService) verifyIDToken(ctx context.Context, raw, nonce string) (map[string]any, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("id token malformed")
+	}
+	headerBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errors.New("id token header malformed")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(headerBytes, &header); err != nil {
+		return nil, errors.New("id token header malformed")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("id token signature malformed")
+	}
+
+	key, err := s.getKey(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWTSignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.New("id token payload malformed")
+	}
+	claims := make(map[string]any)
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errors.New("id token payload malformed")
+	}
+
+	if iss, _ := claims["iss"].(string); strings.TrimRight(iss, "/") != s.cfg.Issuer {
+		return nil, fmt.Errorf("id token issuer mismatch: %s", iss)
+	}
+	audiences := claimStrings(claims["aud"])
+	if !containsString(audiences, s.cfg.ClientID) {
+		return nil, errors.New("id token audience mismatch")
+	}
+	if len(audiences) > 1 {
+		if azp, _ := claims["azp"].(string); azp != s.cfg.ClientID {
+			return nil, errors.New("id token authorized party mismatch")
+		}
+	}
+	now := s.now()
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(oidcClockSkew)) {
+		return nil, errors.New("id token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(oidcClockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return nil, errors.New("id token not yet valid")
+	}
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, errors.New("id token nonce mismatch")
+	}
+	return claims, nil
+}
+
+func (s *OIDCService) getDiscovery(ctx context.Context) (*oidcDiscovery, error) {
+	if !s.Enabled() {
+		return nil, errors.New("oidc login is not configured")
+	}
+	s.mu.Lock()
+	if s.discovery != nil && s.now().Sub(s.discoveredAt) < oidcDiscoveryTTL {
+		disc := s.discovery
+		s.mu.Unlock()
+		return disc, nil
+	}
+	s.mu.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.cfg.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	var disc oidcDiscovery
+	if err := s.doJSON(req, &disc); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	if strings.TrimRight(disc.Issuer, "/") != s.cfg.Issuer {
+		return nil, fmt.Errorf("oidc discovery issuer mismatch: %s", disc.Issuer)
+	}
+	if disc.AuthorizationEndpoint == "" || disc.TokenEndpoint == "" || disc.JWKSURI == "" {
+		return nil, errors.New("oidc discovery document incomplete")
+	}
+
+	s.mu.Lock()
+	s.discovery = &disc
+	s.discoveredAt = s.now()
+	s.mu.Unlock()
+	return &disc, nil
+}
+
+func (s *OIDCService) getKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	key, ok := s.lookupKey(kid)
+	canRefresh := s.now().Sub(s.keysFetchedAt) >= oidcKeyRefreshDelay
+	s.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	if !canRefresh {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	disc, err := s.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, disc.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := s.doJSON(req, &set); err != nil {
+		return nil, fmt.Errorf("fetch jwks: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if parsed, err := jwk.publicKey(); err == nil {
+			keys[jwk.Kid] = parsed
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+	s.keysFetchedAt = s.now()
+	if key, ok := s.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// lookupKey finds a key by ID; with no kid a single published key is accepted. Caller holds s.mu.
+func (s *OIDCService) lookupKey(kid string) (crypto.PublicKey, bool) {
+	if kid != "" {
+		key, ok := s.keys[kid]
+		return key, ok
+	}
+	if len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+func (s *OIDCService) fetchUserinfo(ctx context.Context, endpoint, accessToken string) (map[string]any, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	info := make(map[string]any)
+	if err := s.doJSON(req, &info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (s *OIDCService) doJSON(req *http.Request, out any) error {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusBadRequest {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	if resp.StatusCode == http.StatusBadRequest {
+		return fmt.Errorf("bad request: %s", strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+	}
+}
+
+func verifyJWTSignature(alg string, key crypto.PublicKey, signingInput string, signature []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported id token algorithm %q", alg)
+	}
+	digest := hashBytes(hash, []byte(signingInput))
+
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			return errors.New("id token algorithm does not match key type")
+		}
+		if err := rsa.VerifyPKCS1v15(pub, hash, digest, signature); err != nil {
+			return errors.New("id token signature invalid")
+		}
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(alg, "ES") {
+			return errors.New("id token algorithm does not match key type")
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("id token signature invalid")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		sVal := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, digest, r, sVal) {
+			return errors.New("id token signature invalid")
+		}
+	default:
+		return errors.New("unsupported signing key")
+	}
+	return nil
+}
+
+func hashBytes(hash crypto.Hash, data []byte) []byte {
+	switch hash {
+	case crypto.SHA384:
+		sum := sha512.Sum384(data)
+		return sum[:]
+	case crypto.SHA512:
+		sum := sha512.Sum512(data)
+		return sum[:]
+	default:
+		sum := sha256.Sum256(data)
+		return sum[:]
+	}
+}
+
+func claimStrings(val any) []string {
+	switch typed := val.(type) {
+	case string:
+		if typed == "" {
+			return nil
+		}
+		return []string{typed}
+	case []any:
+		out := make([]string, 0, len(typed))
+		for _, item := range typed {
+			if str, ok := item.(string); ok {
+				out = append(out, str)
+			}
+		}
+		return out
+	default:
+		return nil
+	}
+}
+
+func containsString(list []string, val string) bool {
+	for _, item := range list {
+		if item == val {
+			return true
+		}
+	}
+	return false
+}
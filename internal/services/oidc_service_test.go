@@ -0,0 +1,269 @@
+package services
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"nebula_manager/internal/models"
+)
+
+// mockIdP is a minimal OpenID provider: discovery, a token endpoint enforcing PKCE and a JWKS.
+type mockIdP struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]mockGrant
+}
+
+type mockGrant struct {
+	challenge string
+	claims    map[string]any
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &mockIdP{t: t, key: key, codes: make(map[string]mockGrant)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test-key",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", idp.token)
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// authorize plays the browser and IdP login: it follows the authorization URL and returns the
+// state to hand back along with a code that redeems into an ID token carrying claims.
+func (idp *mockIdP) authorize(authURL string, claims map[string]any) (state, code string) {
+	idp.t.Helper()
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	query := parsed.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		idp.t.Fatalf("authorization request without PKCE: %s", authURL)
+	}
+	grant := mockGrant{challenge: query.Get("code_challenge"), claims: map[string]any{
+		"iss":   idp.server.URL,
+		"aud":   query.Get("client_id"),
+		"nonce": query.Get("nonce"),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"iat":   time.Now().Unix(),
+	}}
+	for name, value := range claims {
+		grant.claims[name] = value
+	}
+	code = "code-" + query.Get("state")
+	idp.mu.Lock()
+	idp.codes[code] = grant
+	idp.mu.Unlock()
+	return query.Get("state"), code
+}
+
+func (idp *mockIdP) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	idp.mu.Lock()
+	grant, ok := idp.codes[r.PostForm.Get("code")]
+	delete(idp.codes, r.PostForm.Get("code"))
+	idp.mu.Unlock()
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		writeTestJSON(w, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		writeTestJSON(w, map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+		return
+	}
+	writeTestJSON(w, map[string]string{"access_token": "access", "id_token": idp.sign(grant.claims)})
+}
+
+func (idp *mockIdP) sign(claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test-key", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(input))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, digest[:])
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func writeTestJSON(w http.ResponseWriter, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func newTestOIDCService(idp *mockIdP, defaultRole string) *OIDCService {
+	return NewOIDCService(OIDCConfig{
+		Issuer:      idp.server.URL,
+		ClientID:    "nebula-manager",
+		RedirectURL: "http://manager.test/api/oidc/callback",
+		RoleMapping: map[string]string{"nebula-admins": models.UserRoleAdmin, "nebula-ops": models.UserRoleViewer},
+		DefaultRole: defaultRole,
+	}, idp.server.Client())
+}
+
+func TestOIDCExchangeAgainstMockIdP(t *testing.T) {
+	idp := newMockIdP(t)
+	svc := newTestOIDCService(idp, "")
+	ctx := context.Background()
+
+	authURL, loginState, err := svc.AuthorizationURL(ctx, "/nodes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, code := idp.authorize(authURL, map[string]any{
+		"sub":                "user-42",
+		"preferred_username": "alice",
+		"groups":             []string{"nebula-ops", "nebula-admins"},
+	})
+	if state != loginState {
+		t.Fatalf("returned state %q, the IdP saw %q", loginState, state)
+	}
+	identity, err := svc.Exchange(ctx, state, code)
+	if err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+	if identity.Issuer != idp.server.URL || identity.Subject != "user-42" || identity.Username != "alice" {
+		t.Fatalf("identity = %+v", identity)
+	}
+	if identity.Role != models.UserRoleAdmin || identity.ReturnTo != "/nodes" {
+		t.Fatalf("role %q return %q, want admin and /nodes", identity.Role, identity.ReturnTo)
+	}
+
+	if _, err := svc.Exchange(ctx, state, code); err == nil {
+		t.Fatal("a login state must not be usable twice")
+	}
+}
+
+func TestOIDCExchangeRejectsBadTokens(t *testing.T) {
+	idp := newMockIdP(t)
+	svc := newTestOIDCService(idp, models.UserRoleViewer)
+	ctx := context.Background()
+
+	cases := map[string]map[string]any{
+		"nonce":    {"sub": "user-1", "nonce": "replayed"},
+		"issuer":   {"sub": "user-1", "iss": "https://evil.test"},
+		"audience": {"sub": "user-1", "aud": "another-client"},
+		"expired":  {"sub": "user-1", "exp": time.Now().Add(-time.Hour).Unix()},
+		"subject":  {"preferred_username": "alice"},
+	}
+	for name, claims := range cases {
+		t.Run(name, func(t *testing.T) {
+			authURL, _, err := svc.AuthorizationURL(ctx, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			state, code := idp.authorize(authURL, claims)
+			if identity, err := svc.Exchange(ctx, state, code); err == nil {
+				t.Fatalf("exchange accepted a bad token: %+v", identity)
+			}
+		})
+	}
+}
+
+func TestOIDCExchangeEnforcesPKCE(t *testing.T) {
+	idp := newMockIdP(t)
+	svc := newTestOIDCService(idp, models.UserRoleViewer)
+	ctx := context.Background()
+
+	authURL, _, err := svc.AuthorizationURL(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, code := idp.authorize(authURL, map[string]any{"sub": "user-1"})
+	// A code intercepted by another client is redeemed without the login's verifier.
+	idp.mu.Lock()
+	grant := idp.codes[code]
+	grant.challenge = "intercepted"
+	idp.codes[code] = grant
+	idp.mu.Unlock()
+	if _, err := svc.Exchange(ctx, state, code); err == nil {
+		t.Fatal("exchange succeeded although the code verifier did not match")
+	}
+}
+
+func TestOIDCExchangeDeniesUnmappedGroups(t *testing.T) {
+	idp := newMockIdP(t)
+	svc := newTestOIDCService(idp, "")
+	ctx := context.Background()
+
+	authURL, _, err := svc.AuthorizationURL(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, code := idp.authorize(authURL, map[string]any{"sub": "user-7", "groups": []string{"marketing"}})
+	if _, err := svc.Exchange(ctx, state, code); !errors.Is(err, ErrOIDCAccessDenied) {
+		t.Fatalf("err = %v, want ErrOIDCAccessDenied", err)
+	}
+}
+
+func TestCheckOIDCAccount(t *testing.T) {
+	key := oidcSubjectKey("https://idp.test", "user-42")
+	other := oidcSubjectKey("https://idp.test", "user-43")
+	if key == oidcSubjectKey("https://other-idp.test", "user-42") {
+		t.Fatal("the same sub at another issuer must be another account")
+	}
+
+	cases := []struct {
+		name     string
+		existing *models.User
+		username string
+		wantErr  bool
+	}{
+		{name: "new account", username: "alice"},
+		{name: "local account", username: "admin", wantErr: true},
+		{name: "local account in another case", username: " Admin", wantErr: true},
+		{name: "same subject", existing: &models.User{Username: "alice", OIDCSubject: &key}, username: "alice"},
+		{name: "other subject", existing: &models.User{Username: "alice", OIDCSubject: &other}, username: "alice", wantErr: true},
+		{name: "unbound legacy account", existing: &models.User{Username: "alice"}, username: "alice"},
+	}
+	for _, tc := range cases {
+		err := checkOIDCAccount(tc.existing, key, tc.username, "admin")
+		if tc.wantErr != errors.Is(err, ErrOIDCUsernameTaken) || (!tc.wantErr && err != nil) {
+			t.Errorf("%s: err = %v, want error %v", tc.name, err, tc.wantErr)
+		}
+	}
+}
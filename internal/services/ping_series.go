@@ -0,0 +1,410 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"nebula_manager/internal/models"
+)
+
+const (
+	// seriesTargetPoints is the number of points per peer an automatic step aims for.
+	seriesTargetPoints = 300
+	// seriesMaxPoints caps the points per peer an explicit step may produce.
+	seriesMaxPoints = 1500
+	// seriesMaxRawSpan bounds the range served from raw samples, which are loaded row by row.
+	seriesMaxRawSpan = 24 * time.Hour
+)
+
+// ErrInvalidSeriesQuery marks series queries rejected because of their range or step.
+var ErrInvalidSeriesQuery = errors.New("invalid series query")
+
+// seriesSteps lists the step widths an automatic step is rounded up to.
+var seriesSteps = []time.Duration{
+	time.Minute,
+	2 * time.Minute,
+	5 * time.Minute,
+	10 * time.Minute,
+	15 * time.Minute,
+	30 * time.Minute,
+	time.Hour,
+	2 * time.Hour,
+	6 * time.Hour,
+	12 * time.Hour,
+	24 * time.Hour,
+}
+
+// Path selections of a latency series. SeriesPathBoth returns the overlay series with the underlay
+// series alongside it.
+const (
+	SeriesPathOverlay  = models.PingPathOverlay
+	SeriesPathUnderlay = models.PingPathUnderlay
+	SeriesPathBoth     = "both"
+)
+
+// NetworkSeriesQuery selects the time range, aggregation step and network path of a latency series.
+// A zero Step lets the service choose one; an empty Path means the overlay.
+type NetworkSeriesQuery struct {
+	From time.Time
+	To   time.Time
+	Step time.Duration
+	Path string
+}
+
+// pingTier is a storage resolution of latency data and how far back it reaches.
+type pingTier struct {
+	resolution int
+	retention  time.Duration
+}
+
+func (t pingTier) step() time.Duration {
+	return time.Duration(t.resolution) * time.Second
+}
+
+func (s *NodeService) pingTiers() []pingTier {
+	return []pingTier{
+		{resolution: 0, retention: s.retention.RawPings},
+		{resolution: models.PingResolution5m, retention: s.retention.Rollups5m},
+		{resolution: models.PingResolution1h, retention: s.retention.Rollups1h},
+	}
+}
+
+func pingResolutionLabel(resolution int) string {
+	switch resolution {
+	case models.PingResolution5m:
+		return "5m"
+	case models.PingResolution1h:
+		return "1h"
+	default:
+		return "raw"
+	}
+}
+
+// planSeriesQuery validates a query and picks the storage tier and step used to answer it.
+func (s *NodeService) planSeriesQuery(query NetworkSeriesQuery) (NetworkSeriesQuery, pingTier, error) {
+	now := time.Now()
+	if query.To.IsZero() || query.To.After(now) {
+		query.To = now
+	}
+	if query.From.IsZero() {
+		query.From = query.To.Add(-time.Hour)
+	}
+	if !query.From.Before(query.To) {
+		return query, pingTier{}, fmt.Errorf("%w: from must be before to", ErrInvalidSeriesQuery)
+	}
+	if query.Step < 0 {
+		return query, pingTier{}, fmt.Errorf("%w: step must be positive", ErrInvalidSeriesQuery)
+	}
+	switch query.Path {
+	case "":
+		query.Path = SeriesPathOverlay
+	case SeriesPathOverlay, SeriesPathUnderlay, SeriesPathBoth:
+	default:
+		return query, pingTier{}, fmt.Errorf("%w: path must be %s, %s or %s", ErrInvalidSeriesQuery, SeriesPathOverlay, SeriesPathUnderlay, SeriesPathBoth)
+	}
+
+	span := query.To.Sub(query.From)
+	age := now.Sub(query.From).Round(time.Minute)
+
+	// The finest tier that still holds data for the start of the range wins.
+	var usable []pingTier
+	for _, tier := range s.pingTiers() {
+		if tier.retention > 0 && age > tier.retention {
+			continue
+		}
+		if tier.resolution == 0 && span.Round(time.Minute) > seriesMaxRawSpan {
+			continue
+		}
+		usable = append(usable, tier)
+	}
+	if len(usable) == 0 {
+		tiers := s.pingTiers()
+		oldest := tiers[len(tiers)-1].retention
+		return query, pingTier{}, fmt.Errorf("%w: latency data older than %s is not retained", ErrInvalidSeriesQuery, formatSpan(oldest))
+	}
+
+	tier := usable[0]
+	if query.Step == 0 {
+		query.Step = autoSeriesStep(span)
+		if query.Step < tier.step() {
+			query.Step = tier.step()
+		}
+	} else {
+		found := false
+		for _, candidate := range usable {
+			if candidate.step() <= query.Step {
+				tier, found = candidate, true
+				break
+			}
+		}
+		if !found {
+			return query, pingTier{}, fmt.Errorf("%w: step %s is finer than the %s resolution available for a %s range starting %s ago",
+				ErrInvalidSeriesQuery, formatSpan(query.Step), formatSpan(usable[0].step()), formatSpan(span.Round(time.Second)), formatSpan(age))
+		}
+	}
+
+	if points := int64(span / query.Step); points > seriesMaxPoints {
+		return query, pingTier{}, fmt.Errorf("%w: a %s range at step %s yields %d points per peer, more than the maximum of %d; use a larger step",
+			ErrInvalidSeriesQuery, formatSpan(span.Round(time.Second)), formatSpan(query.Step), points, seriesMaxPoints)
+	}
+	return query, tier, nil
+}
+
+// autoSeriesStep rounds span/seriesTargetPoints up to a standard step.
+func autoSeriesStep(span time.Duration) time.Duration {
+	target := span / seriesTargetPoints
+	for _, step := range seriesSteps {
+		if step >= target {
+			return step
+		}
+	}
+	return seriesSteps[len(seriesSteps)-1]
+}
+
+// formatSpan renders durations compactly, e.g. "5m", "6h" or "14d".
+func formatSpan(d time.Duration) string {
+	switch {
+	case d >= 24*time.Hour && d%(24*time.Hour) == 0:
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	case d >= time.Hour && d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d >= time.Minute && d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	default:
+		return d.String()
+	}
+}
+
+// loadPingPoints returns the node's latency points over path for the planned query, grouped by peer.
+// For rollup tiers, buckets the retention job has not reached yet are filled from raw samples.
+func (s *NodeService) loadPingPoints(nodeID uint, path string, query NetworkSeriesQuery, tier pingTier) (map[uint][]PingPoint, error) {
+	accumulators := make(map[uint]map[int64]*pingAccumulator)
+	bucketFor := func(peerID uint, ts time.Time) *pingAccumulator {
+		start := ts.Truncate(query.Step).Unix()
+		byBucket, ok := accumulators[peerID]
+		if !ok {
+			byBucket = make(map[int64]*pingAccumulator)
+			accumulators[peerID] = byBucket
+		}
+		acc, ok := byBucket[start]
+		if !ok {
+			acc = &pingAccumulator{}
+			byBucket[start] = acc
+		}
+		return acc
+	}
+
+	rawFrom := query.From
+	if tier.resolution > 0 {
+		var rollups []models.NodePingRollup
+		if err := s.db.Where("node_id = ? AND path = ? AND resolution = ? AND bucket_start >= ? AND bucket_start < ?",
+			nodeID, path, tier.resolution, query.From.Truncate(tier.step()), query.To).
+			Order("bucket_start asc").Find(&rollups).Error; err != nil {
+			return nil, err
+		}
+		for _, rollup := range rollups {
+			bucketFor(rollup.PeerNodeID, rollup.BucketStart).addRollup(rollup)
+			if next := rollup.BucketStart.Add(tier.step()); next.After(rawFrom) {
+				rawFrom = next
+			}
+		}
+	}
+
+	if rawFrom.Before(query.To) {
+		var records []models.NodePing
+		if err := s.db.Where("node_id = ? AND path = ? AND created_at >= ? AND created_at <= ?", nodeID, path, rawFrom, query.To).
+			Order("created_at asc").Find(&records).Error; err != nil {
+			return nil, err
+		}
+		for _, rec := range records {
+			bucketFor(rec.PeerNodeID, rec.CreatedAt).addRaw(rec)
+		}
+	}
+
+	grouped := make(map[uint][]PingPoint, len(accumulators))
+	for peerID, byBucket := range accumulators {
+		points := make([]PingPoint, 0, len(byBucket))
+		for start, acc := range byBucket {
+			points = append(points, acc.point(time.Unix(start, 0)))
+		}
+		sort.Slice(points, func(i, j int) bool { return points[i].Timestamp.Before(points[j].Timestamp) })
+		grouped[peerID] = points
+	}
+	return grouped, nil
+}
+
+// pingPath maps the empty path of samples stored before paths were recorded to the overlay.
+func pingPath(path string) string {
+	if path == "" {
+		return models.PingPathOverlay
+	}
+	return path
+}
+
+// weightedValue is a latency observation; rollup percentiles weigh by their successful samples.
+type weightedValue struct {
+	value  float64
+	weight float64
+}
+
+// pingAccumulator aggregates raw samples and rollups that fall into one step. Raw samples must be
+// added in time order for the jitter of single-echo samples.
+type pingAccumulator struct {
+	samples, failures int
+	sent, received    int
+	succeeded         float64
+	sum               float64
+	min, max          float64
+	p50, p95, p99     []weightedValue
+	jitter            jitterTracker
+}
+
+// samplePackets returns the echoes a sample stands for; samples without counts are a single echo.
+func samplePackets(rec models.NodePing) (sent, received int) {
+	if rec.Sent <= 0 {
+		if rec.Success {
+			return 1, 1
+		}
+		return 1, 0
+	}
+	return rec.Sent, min(max(rec.Received, 0), rec.Sent)
+}
+
+// jitterTracker averages the variation between consecutive round trips. Multi-echo samples bring
+// their own jitter, weighted by their echo intervals; single-echo samples are compared with the
+// previous successful sample.
+type jitterTracker struct {
+	sum, weight float64
+	last        float64
+	hasLast     bool
+}
+
+func (j *jitterTracker) add(rec models.NodePing) {
+	if !rec.Success {
+		return
+	}
+	if _, received := samplePackets(rec); received >= 2 {
+		j.sum += rec.JitterMs * float64(received-1)
+		j.weight += float64(received - 1)
+	} else if j.hasLast {
+		j.sum += math.Abs(rec.LatencyMs - j.last)
+		j.weight++
+	}
+	j.last, j.hasLast = rec.LatencyMs, true
+}
+
+func (j *jitterTracker) value() float64 {
+	if j.weight == 0 {
+		return 0
+	}
+	return j.sum / j.weight
+}
+
+func (a *pingAccumulator) observe(minMs, maxMs float64) {
+	if a.succeeded == 0 || minMs < a.min {
+		a.min = minMs
+	}
+	if a.succeeded == 0 || maxMs > a.max {
+		a.max = maxMs
+	}
+}
+
+func (a *pingAccumulator) addRaw(rec models.NodePing) {
+	a.samples++
+	sent, received := samplePackets(rec)
+	a.sent += sent
+	a.received += received
+	a.jitter.add(rec)
+	if !rec.Success {
+		a.failures++
+		return
+	}
+	if rec.Received > 0 && rec.MaxMs > 0 {
+		a.observe(rec.MinMs, rec.MaxMs)
+	} else {
+		a.observe(rec.LatencyMs, rec.LatencyMs)
+	}
+	a.succeeded++
+	a.sum += rec.LatencyMs
+	sample := weightedValue{value: rec.LatencyMs, weight: 1}
+	a.p50 = append(a.p50, sample)
+	a.p95 = append(a.p95, sample)
+	a.p99 = append(a.p99, sample)
+}
+
+// addRollup merges a stored bucket. Percentiles across several rollups are approximated by the
+// weighted percentile of their per-bucket percentiles.
+func (a *pingAccumulator) addRollup(rollup models.NodePingRollup) {
+	a.samples += rollup.Samples
+	a.failures += rollup.Failures
+	if rollup.PacketsSent > 0 {
+		a.sent += rollup.PacketsSent
+		a.received += rollup.PacketsReceived
+	} else {
+		a.sent += rollup.Samples
+		a.received += rollup.Samples - rollup.Failures
+	}
+	succeeded := float64(rollup.Samples - rollup.Failures)
+	if succeeded <= 0 {
+		return
+	}
+	if rollup.PacketsSent > 0 {
+		// Older buckets have no jitter; counting them as zero would understate it.
+		a.jitter.sum += rollup.JitterMs * succeeded
+		a.jitter.weight += succeeded
+	}
+	a.observe(rollup.MinMs, rollup.MaxMs)
+	a.succeeded += succeeded
+	a.sum += rollup.AvgMs * succeeded
+	a.p50 = append(a.p50, weightedValue{value: rollup.P50Ms, weight: succeeded})
+	a.p95 = append(a.p95, weightedValue{value: rollup.P95Ms, weight: succeeded})
+	a.p99 = append(a.p99, weightedValue{value: rollup.P99Ms, weight: succeeded})
+}
+
+func (a *pingAccumulator) point(ts time.Time) PingPoint {
+	point := PingPoint{
+		Timestamp:       ts,
+		Success:         a.succeeded > 0,
+		Samples:         a.samples,
+		PacketsSent:     a.sent,
+		PacketsReceived: a.received,
+		JitterMs:        a.jitter.value(),
+	}
+	if a.sent > 0 {
+		point.LossPercent = float64(a.sent-a.received) / float64(a.sent) * 100
+	}
+	if a.succeeded > 0 {
+		point.LatencyMs = a.sum / a.succeeded
+		point.MinMs = a.min
+		point.MaxMs = a.max
+		point.P50Ms = weightedPercentile(a.p50, 50)
+		point.P95Ms = weightedPercentile(a.p95, 95)
+		point.P99Ms = weightedPercentile(a.p99, 99)
+	}
+	return point
+}
+
+// weightedPercentile returns the smallest value whose cumulative weight reaches p percent.
+// With unit weights it matches the nearest-rank percentile.
+func weightedPercentile(values []weightedValue, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i].value < values[j].value })
+	total := 0.0
+	for _, val := range values {
+		total += val.weight
+	}
+	threshold := p / 100 * total
+	cumulative := 0.0
+	for _, val := range values {
+		cumulative += val.weight
+		if cumulative >= threshold {
+			return val.value
+		}
+	}
+	return values[len(values)-1].value
+}
@@ -0,0 +1,360 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"nebula_manager/internal/models"
+)
+
+const (
+	maxProbeTimeoutMs = 60000
+	// probeResultsListSize bounds the raw results returned next to the per-peer summaries.
+	probeResultsListSize = 500
+)
+
+// ErrInvalidProbe is returned for probe definitions that fail validation.
+var ErrInvalidProbe = errors.New("invalid probe")
+
+// ProbeService manages probe definitions and reads back their results.
+type ProbeService struct {
+	db *gorm.DB
+}
+
+// NewProbeService constructs a ProbeService.
+func NewProbeService(db *gorm.DB) *ProbeService {
+	return &ProbeService{db: db}
+}
+
+// ProbeRequest carries the payload for creating or updating a probe definition.
+type ProbeRequest struct {
+	Name          string `json:"name" binding:"required"`
+	Type          string `json:"type" binding:"required"`
+	Port          int    `json:"port"`
+	URL           string `json:"url"`
+	ExpectStatus  int    `json:"expect_status"`
+	SkipTLSVerify bool   `json:"skip_tls_verify"`
+	TimeoutMs     int    `json:"timeout_ms"`
+	Address       string `json:"address"`
+	PeerNodeID    uint   `json:"peer_node_id"`
+	Tag           string `json:"tag"`
+	Enabled       *bool  `json:"enabled"`
+	Description   string `json:"description"`
+}
+
+// ProbeSpec is a probe resolved against one target, as handed to the agent with its targets.
+type ProbeSpec struct {
+	ProbeID       uint   `json:"probe_id"`
+	Type          string `json:"type"`
+	Address       string `json:"address,omitempty"`
+	Port          int    `json:"port,omitempty"`
+	URL           string `json:"url,omitempty"`
+	ExpectStatus  int    `json:"expect_status,omitempty"`
+	SkipTLSVerify bool   `json:"skip_tls_verify,omitempty"`
+	TimeoutMs     int    `json:"timeout_ms,omitempty"`
+}
+
+// ProbeSummary aggregates one probe's results towards one peer over the queried range.
+type ProbeSummary struct {
+	ProbeID     uint                `json:"probe_id"`
+	ProbeName   string              `json:"probe_name"`
+	ProbeType   string              `json:"probe_type"`
+	Peer        NodeSummary         `json:"peer"`
+	Samples     int                 `json:"samples"`
+	Failures    int                 `json:"failures"`
+	LossPercent float64             `json:"loss_percent"`
+	AvgMs       float64             `json:"avg_ms"`
+	MaxMs       float64             `json:"max_ms"`
+	Last        *models.ProbeResult `json:"last"`
+}
+
+// NodeProbeResults is a node's probe results within a time range.
+type NodeProbeResults struct {
+	Node      NodeSummary          `json:"node"`
+	From      time.Time            `json:"from"`
+	To        time.Time            `json:"to"`
+	Summaries []ProbeSummary       `json:"summaries"`
+	Results   []models.ProbeResult `json:"results"`
+}
+
+// ProbeResultFilter narrows ListResults to one probe and/or one peer.
+type ProbeResultFilter struct {
+	ProbeID uint
+	PeerID  uint
+}
+
+// List returns all probe definitions.
+func (s *ProbeService) List() ([]models.ProbeDefinition, error) {
+	var probes []models.ProbeDefinition
+	if err := s.db.Order("id asc").Find(&probes).Error; err != nil {
+		return nil, err
+	}
+	return probes, nil
+}
+
+// Get loads a probe definition by ID.
+func (s *ProbeService) Get(id uint) (*models.ProbeDefinition, error) {
+	var probe models.ProbeDefinition
+	if err := s.db.First(&probe, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("probe %d not found", id)
+		}
+		return nil, err
+	}
+	return &probe, nil
+}
+
+// Create validates and stores a new probe definition.
+func (s *ProbeService) Create(req ProbeRequest) (*models.ProbeDefinition, error) {
+	probe := models.ProbeDefinition{Enabled: true}
+	if err := applyProbeRequest(&probe, req); err != nil {
+		return nil, err
+	}
+	if err := s.db.Create(&probe).Error; err != nil {
+		return nil, err
+	}
+	return &probe, nil
+}
+
+// Update replaces a probe definition. Agents pick it up with their next target refresh.
+func (s *ProbeService) Update(id uint, req ProbeRequest) (*models.ProbeDefinition, error) {
+	probe, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyProbeRequest(probe, req); err != nil {
+		return nil, err
+	}
+	if err := s.db.Save(probe).Error; err != nil {
+		return nil, err
+	}
+	return probe, nil
+}
+
+// Delete removes a probe definition together with its results.
+func (s *ProbeService) Delete(id uint) error {
+	probe, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("probe_id = ?", probe.ID).Delete(&models.ProbeResult{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(probe).Error
+	})
+}
+
+func applyProbeRequest(probe *models.ProbeDefinition, req ProbeRequest) error {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidProbe)
+	}
+	if req.TimeoutMs < 0 || req.TimeoutMs > maxProbeTimeoutMs {
+		return fmt.Errorf("%w: timeout_ms must be between 0 and %d", ErrInvalidProbe, maxProbeTimeoutMs)
+	}
+	switch req.Address {
+	case "":
+		req.Address = models.ProbeAddressOverlay
+	case models.ProbeAddressOverlay, models.ProbeAddressPublic:
+	default:
+		return fmt.Errorf("%w: unknown address %q (use %s or %s)", ErrInvalidProbe, req.Address, models.ProbeAddressOverlay, models.ProbeAddressPublic)
+	}
+	req.URL = strings.TrimSpace(req.URL)
+	switch req.Type {
+	case models.ProbeTypeICMP:
+		req.Port, req.URL = 0, ""
+	case models.ProbeTypeTCP:
+		if req.Port < 1 || req.Port > 65535 {
+			return fmt.Errorf("%w: tcp probes need a port between 1 and 65535", ErrInvalidProbe)
+		}
+		req.URL = ""
+	case models.ProbeTypeNebulaPort:
+		// The listen port is only reachable from outside the overlay on the public address.
+		req.Port, req.URL, req.Address = 0, "", models.ProbeAddressPublic
+	case models.ProbeTypeHTTP:
+		if req.URL == "" {
+			req.URL = "http://{address}/"
+		}
+		parsed, err := url.Parse(strings.ReplaceAll(req.URL, "{address}", "127.0.0.1"))
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("%w: url must be an http or https URL such as https://{address}:8443/health", ErrInvalidProbe)
+		}
+		if req.ExpectStatus != 0 && (req.ExpectStatus < 100 || req.ExpectStatus > 599) {
+			return fmt.Errorf("%w: expect_status must be an HTTP status code", ErrInvalidProbe)
+		}
+		req.Port = 0
+	default:
+		return fmt.Errorf("%w: unknown type %q", ErrInvalidProbe, req.Type)
+	}
+	if req.Type != models.ProbeTypeHTTP {
+		req.ExpectStatus, req.SkipTLSVerify = 0, false
+	}
+
+	probe.Name = req.Name
+	probe.Type = req.Type
+	probe.Port = req.Port
+	probe.URL = req.URL
+	probe.ExpectStatus = req.ExpectStatus
+	probe.SkipTLSVerify = req.SkipTLSVerify
+	probe.TimeoutMs = req.TimeoutMs
+	probe.Address = req.Address
+	probe.PeerNodeID = req.PeerNodeID
+	probe.Tag = strings.TrimSpace(req.Tag)
+	probe.Description = strings.TrimSpace(req.Description)
+	if req.Enabled != nil {
+		probe.Enabled = *req.Enabled
+	}
+	return nil
+}
+
+// ListResults returns the node's probe results since from, summarised per probe and peer.
+func (s *ProbeService) ListResults(nodeID uint, from time.Time, filter ProbeResultFilter) (*NodeProbeResults, error) {
+	var node models.Node
+	if err := s.db.First(&node, nodeID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("node %d not found", nodeID)
+		}
+		return nil, err
+	}
+	query := s.db.Where("node_id = ? AND created_at >= ?", nodeID, from)
+	if filter.ProbeID != 0 {
+		query = query.Where("probe_id = ?", filter.ProbeID)
+	}
+	if filter.PeerID != 0 {
+		query = query.Where("peer_node_id = ?", filter.PeerID)
+	}
+	var results []models.ProbeResult
+	if err := query.Order("created_at asc, id asc").Find(&results).Error; err != nil {
+		return nil, err
+	}
+
+	probes, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	probeByID := make(map[uint]models.ProbeDefinition, len(probes))
+	for _, probe := range probes {
+		probeByID[probe.ID] = probe
+	}
+	var peers []models.Node
+	if err := s.db.Where("id <> ?", nodeID).Find(&peers).Error; err != nil {
+		return nil, err
+	}
+	peerByID := make(map[uint]models.Node, len(peers))
+	for _, peer := range peers {
+		peerByID[peer.ID] = peer
+	}
+
+	type summaryKey struct{ probe, peer uint }
+	summaries := map[summaryKey]*ProbeSummary{}
+	latency := map[summaryKey]float64{}
+	for i := range results {
+		result := results[i]
+		key := summaryKey{result.ProbeID, result.PeerNodeID}
+		summary, ok := summaries[key]
+		if !ok {
+			peer, known := peerByID[result.PeerNodeID]
+			if !known {
+				continue
+			}
+			probe := probeByID[result.ProbeID]
+			summary = &ProbeSummary{ProbeID: result.ProbeID, ProbeName: probe.Name, ProbeType: result.ProbeType, Peer: toNodeSummary(peer)}
+			summaries[key] = summary
+		}
+		summary.Samples++
+		if result.Success {
+			latency[key] += result.LatencyMs
+			summary.MaxMs = max(summary.MaxMs, result.LatencyMs)
+		} else {
+			summary.Failures++
+		}
+		summary.Last = &results[i]
+	}
+
+	list := make([]ProbeSummary, 0, len(summaries))
+	for key, summary := range summaries {
+		if ok := summary.Samples - summary.Failures; ok > 0 {
+			summary.AvgMs = latency[key] / float64(ok)
+		}
+		summary.LossPercent = float64(summary.Failures) / float64(summary.Samples) * 100
+		list = append(list, *summary)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].ProbeName != list[j].ProbeName {
+			return list[i].ProbeName < list[j].ProbeName
+		}
+		return strings.ToLower(list[i].Peer.Name) < strings.ToLower(list[j].Peer.Name)
+	})
+
+	// Newest raw results first, bounded; the summaries cover the whole range.
+	recent := make([]models.ProbeResult, 0, min(len(results), probeResultsListSize))
+	for i := len(results) - 1; i >= 0 && len(recent) < probeResultsListSize; i-- {
+		recent = append(recent, results[i])
+	}
+	return &NodeProbeResults{Node: toNodeSummary(node), From: from, To: time.Now(), Summaries: list, Results: recent}, nil
+}
+
+// probeSpecs resolves the enabled probe definitions matching peer into agent instructions. Probes whose
+// address the peer lacks are left out.
+func probeSpecs(probes []models.ProbeDefinition, peer models.Node, settings *models.NetworkSetting) []ProbeSpec {
+	specs := make([]ProbeSpec, 0)
+	for _, probe := range probes {
+		if !probe.Enabled || !probeMatchesPeer(probe, peer) {
+			continue
+		}
+		address := overlayAddress(peer)
+		if probe.Address == models.ProbeAddressPublic {
+			address = strings.TrimSpace(peer.PublicIP)
+		}
+		if address == "" {
+			continue
+		}
+		spec := ProbeSpec{ProbeID: probe.ID, Type: probe.Type, Address: address, TimeoutMs: probe.TimeoutMs}
+		switch probe.Type {
+		case models.ProbeTypeTCP:
+			spec.Port = probe.Port
+		case models.ProbeTypeNebulaPort:
+			spec.Port = nodeListenPort(&peer, settings)
+		case models.ProbeTypeHTTP:
+			host := address
+			if strings.Contains(host, ":") {
+				host = "[" + host + "]"
+			}
+			spec.URL = strings.ReplaceAll(probe.URL, "{address}", host)
+			spec.ExpectStatus = probe.ExpectStatus
+			spec.SkipTLSVerify = probe.SkipTLSVerify
+		}
+		specs = append(specs, spec)
+	}
+	return specs
+}
+
+func probeMatchesPeer(probe models.ProbeDefinition, peer models.Node) bool {
+	if probe.PeerNodeID != 0 && probe.PeerNodeID != peer.ID {
+		return false
+	}
+	if probe.Tag == "" {
+		return true
+	}
+	for _, tag := range strings.Split(peer.Tags, ",") {
+		if strings.TrimSpace(tag) == probe.Tag {
+			return true
+		}
+	}
+	return false
+}
+
+// overlayAddress is the peer's address inside the nebula network.
+func overlayAddress(node models.Node) string {
+	if val := strings.TrimSpace(node.SubnetHost); val != "" {
+		return val
+	}
+	return strings.TrimSpace(node.SubnetIP)
+}
@@ -0,0 +1,299 @@
+package services
+
+import (
+	"context"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"nebula_manager/internal/models"
+)
+
+// rollupChunkBuckets bounds how many buckets of raw samples are loaded per aggregation query.
+const rollupChunkBuckets = 72
+
+// RetentionPolicy controls how long each tier of monitoring data is kept.
+type RetentionPolicy struct {
+	RawPings      time.Duration
+	Rollups5m     time.Duration
+	Rollups1h     time.Duration
+	StatusSamples time.Duration
+	StateEvents   time.Duration
+	Diagnostics   time.Duration
+	Interval      time.Duration
+}
+
+// RetentionService downsamples raw ping samples into rollups and purges expired monitoring data.
+type RetentionService struct {
+	db     *gorm.DB
+	policy RetentionPolicy
+	now    func() time.Time
+	// lastPingID is the newest raw sample seen by the previous run; anything inserted after it with an
+	// older timestamp (e.g. an agent replaying its backlog) reopens the buckets it falls into. It is
+	// persisted as the PingRollupCursor row and loaded by the first run.
+	lastPingID   uint
+	cursorLoaded bool
+}
+
+// NewRetentionService constructs a RetentionService.
+func NewRetentionService(db *gorm.DB, policy RetentionPolicy) *RetentionService {
+	if policy.Interval <= 0 {
+		policy.Interval = 5 * time.Minute
+	}
+	return &RetentionService{db: db, policy: policy, now: time.Now}
+}
+
+// Start runs the retention job in the background until ctx is cancelled.
+func (s *RetentionService) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(s.policy.Interval)
+		defer ticker.Stop()
+		for {
+			if err := s.RunOnce(); err != nil {
+				log.Printf("retention: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// RunOnce builds any missing rollups and then deletes data past its retention age.
+func (s *RetentionService) RunOnce() error {
+	if !s.cursorLoaded {
+		var cursor models.PingRollupCursor
+		if err := s.db.Limit(1).Find(&cursor).Error; err != nil {
+			return err
+		}
+		s.lastPingID, s.cursorLoaded = cursor.LastPingID, true
+	}
+	var arrivals struct {
+		LastID   *uint
+		Earliest *time.Time
+	}
+	if err := s.db.Model(&models.NodePing{}).Select("MAX(id) AS last_id, MIN(created_at) AS earliest").
+		Where("id > ?", s.lastPingID).Scan(&arrivals).Error; err != nil {
+		return err
+	}
+	var late *time.Time
+	if s.lastPingID > 0 {
+		late = arrivals.Earliest
+	}
+	for _, resolution := range []int{models.PingResolution5m, models.PingResolution1h} {
+		if err := s.rollup(resolution, late); err != nil {
+			return err
+		}
+	}
+	if arrivals.LastID != nil {
+		cursor := models.PingRollupCursor{ID: 1, LastPingID: *arrivals.LastID}
+		if err := s.db.Save(&cursor).Error; err != nil {
+			return err
+		}
+		s.lastPingID = cursor.LastPingID
+	}
+	return s.purge()
+}
+
+// rollup aggregates completed buckets since the last stored rollup. The most recent stored
+// bucket is recomputed so samples that arrived late are still counted, as is everything from late on.
+func (s *RetentionService) rollup(resolution int, late *time.Time) error {
+	step := time.Duration(resolution) * time.Second
+	end := s.now().Truncate(step)
+
+	var start time.Time
+	var watermark struct{ Last *time.Time }
+	if err := s.db.Model(&models.NodePingRollup{}).Select("MAX(bucket_start) AS last").
+		Where("resolution = ?", resolution).Scan(&watermark).Error; err != nil {
+		return err
+	}
+	if watermark.Last != nil {
+		start = watermark.Last.Truncate(step)
+	} else {
+		var earliest struct{ First *time.Time }
+		if err := s.db.Model(&models.NodePing{}).Select("MIN(created_at) AS first").Scan(&earliest).Error; err != nil {
+			return err
+		}
+		if earliest.First == nil {
+			return nil
+		}
+		start = earliest.First.Truncate(step)
+	}
+	if late != nil && late.Before(start) {
+		start = late.Truncate(step)
+	}
+	if s.policy.RawPings > 0 {
+		// The bucket holding the raw cutoff has already lost its earlier pings to purge; rebuilding it
+		// would overwrite a complete rollup with a partial one.
+		cutoff := s.now().Add(-s.policy.RawPings)
+		oldest := cutoff.Truncate(step)
+		if oldest.Before(cutoff) {
+			oldest = oldest.Add(step)
+		}
+		if start.Before(oldest) {
+			start = oldest
+		}
+	}
+
+	for chunkStart := start; chunkStart.Before(end); chunkStart = chunkStart.Add(step * rollupChunkBuckets) {
+		chunkEnd := chunkStart.Add(step * rollupChunkBuckets)
+		if chunkEnd.After(end) {
+			chunkEnd = end
+		}
+		var records []models.NodePing
+		if err := s.db.Where("created_at >= ? AND created_at < ?", chunkStart, chunkEnd).Order("created_at asc, id asc").Find(&records).Error; err != nil {
+			return err
+		}
+		rollups := aggregatePings(records, resolution)
+		if len(rollups) == 0 {
+			continue
+		}
+		if err := s.db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "node_id"}, {Name: "peer_node_id"}, {Name: "path"}, {Name: "resolution"}, {Name: "bucket_start"}},
+			DoUpdates: clause.AssignmentColumns([]string{"samples", "failures", "min_ms", "avg_ms", "max_ms", "p50_ms", "p95_ms", "p99_ms", "loss_ratio", "packets_sent", "packets_received", "jitter_ms", "updated_at"}),
+		}).CreateInBatches(&rollups, 500).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *RetentionService) purge() error {
+	now := s.now()
+	if s.policy.RawPings > 0 {
+		if err := s.db.Where("created_at < ?", now.Add(-s.policy.RawPings)).Delete(&models.NodePing{}).Error; err != nil {
+			return err
+		}
+		if err := s.db.Where("created_at < ?", now.Add(-s.policy.RawPings)).Delete(&models.ProbeResult{}).Error; err != nil {
+			return err
+		}
+	}
+	tiers := []struct {
+		resolution int
+		keep       time.Duration
+	}{
+		{models.PingResolution5m, s.policy.Rollups5m},
+		{models.PingResolution1h, s.policy.Rollups1h},
+	}
+	for _, tier := range tiers {
+		if tier.keep <= 0 {
+			continue
+		}
+		if err := s.db.Where("resolution = ? AND bucket_start < ?", tier.resolution, now.Add(-tier.keep)).
+			Delete(&models.NodePingRollup{}).Error; err != nil {
+			return err
+		}
+	}
+	if s.policy.StatusSamples > 0 {
+		if err := s.db.Where("reported_at < ?", now.Add(-s.policy.StatusSamples)).Delete(&models.NodeStatusSample{}).Error; err != nil {
+			return err
+		}
+	}
+	if s.policy.StateEvents > 0 {
+		// Each node's latest transition is its current state and is never purged. The derived table
+		// keeps MySQL from rejecting a subquery on the table being deleted from.
+		latest := s.db.Table("(?) AS latest", s.db.Model(&models.NodeStateEvent{}).Select("MAX(id) AS id").Group("node_id")).Select("id")
+		if err := s.db.Where("at < ? AND id NOT IN (?)", now.Add(-s.policy.StateEvents), latest).
+			Delete(&models.NodeStateEvent{}).Error; err != nil {
+			return err
+		}
+	}
+	if s.policy.Diagnostics > 0 {
+		if err := s.db.Where("created_at < ? AND status NOT IN ?", now.Add(-s.policy.Diagnostics),
+			[]string{models.DiagnosticStatusQueued, models.DiagnosticStatusRunning}).Delete(&models.DiagnosticJob{}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// aggregatePings groups raw samples by node pair and bucket and computes their statistics. Records are
+// expected in time order, which the jitter of single-echo samples depends on.
+func aggregatePings(records []models.NodePing, resolution int) []models.NodePingRollup {
+	type bucketKey struct {
+		node, peer uint
+		path       string
+		start      int64
+	}
+	step := time.Duration(resolution) * time.Second
+	grouped := make(map[bucketKey][]models.NodePing)
+	for _, rec := range records {
+		key := bucketKey{node: rec.NodeID, peer: rec.PeerNodeID, path: pingPath(rec.Path), start: rec.CreatedAt.Truncate(step).Unix()}
+		grouped[key] = append(grouped[key], rec)
+	}
+
+	rollups := make([]models.NodePingRollup, 0, len(grouped))
+	for key, samples := range grouped {
+		rollup := models.NodePingRollup{
+			NodeID:      key.node,
+			PeerNodeID:  key.peer,
+			Path:        key.path,
+			Resolution:  resolution,
+			BucketStart: time.Unix(key.start, 0),
+			Samples:     len(samples),
+		}
+		var acc pingAccumulator
+		latencies := make([]float64, 0, len(samples))
+		for _, sample := range samples {
+			acc.addRaw(sample)
+			if sample.Success {
+				latencies = append(latencies, sample.LatencyMs)
+			} else {
+				rollup.Failures++
+			}
+		}
+		point := acc.point(rollup.BucketStart)
+		rollup.PacketsSent = point.PacketsSent
+		rollup.PacketsReceived = point.PacketsReceived
+		rollup.LossRatio = point.LossPercent / 100
+		rollup.JitterMs = point.JitterMs
+		if len(latencies) > 0 {
+			sort.Float64s(latencies)
+			sum := 0.0
+			for _, val := range latencies {
+				sum += val
+			}
+			rollup.MinMs = point.MinMs
+			rollup.MaxMs = point.MaxMs
+			rollup.AvgMs = sum / float64(len(latencies))
+			rollup.P50Ms = percentile(latencies, 50)
+			rollup.P95Ms = percentile(latencies, 95)
+			rollup.P99Ms = percentile(latencies, 99)
+		}
+		rollups = append(rollups, rollup)
+	}
+	sort.Slice(rollups, func(i, j int) bool {
+		if !rollups[i].BucketStart.Equal(rollups[j].BucketStart) {
+			return rollups[i].BucketStart.Before(rollups[j].BucketStart)
+		}
+		if rollups[i].NodeID != rollups[j].NodeID {
+			return rollups[i].NodeID < rollups[j].NodeID
+		}
+		if rollups[i].PeerNodeID != rollups[j].PeerNodeID {
+			return rollups[i].PeerNodeID < rollups[j].PeerNodeID
+		}
+		return rollups[i].Path < rollups[j].Path
+	})
+	return rollups
+}
+
+// percentile returns the nearest-rank percentile p (0-100) of an ascending slice.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1]
+}
@@ -2,6 +2,10 @@ package services
 
 import (
 	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
 
 	"gorm.io/gorm"
 
@@ -18,6 +22,9 @@ func NewSettingsService(db *gorm.DB) *SettingsService {
 	return &SettingsService{db: db}
 }
 
+// ErrInvalidSettings reports a settings update with unusable values.
+var ErrInvalidSettings = errors.New("invalid network settings")
+
 // UpdateNetworkSettingsRequest carries settings update payload.
 type UpdateNetworkSettingsRequest struct {
 	DefaultSubnet       string `json:"default_subnet"`
@@ -25,6 +32,10 @@ type UpdateNetworkSettingsRequest struct {
 	LighthouseHosts     string `json:"lighthouse_hosts"`
 	CertificateValidity int    `json:"certificate_validity"`
 	Description         string `json:"description"`
+	// StatsListen and DebugSSHListen are loopback host:port addresses; an empty string disables the
+	// endpoint and omitting the field keeps the current value.
+	StatsListen    *string `json:"stats_listen"`
+	DebugSSHListen *string `json:"debug_ssh_listen"`
 }
 
 // Get retrieves the singleton network settings row, creating one if absent.
@@ -69,9 +80,43 @@ func (s *SettingsService) Update(req UpdateNetworkSettingsRequest) (*models.Netw
 	if req.Description != "" {
 		setting.Description = req.Description
 	}
+	if req.StatsListen != nil {
+		listen, err := normalizeLocalListen(*req.StatsListen)
+		if err != nil {
+			return nil, fmt.Errorf("%w: stats_listen: %w", ErrInvalidSettings, err)
+		}
+		setting.StatsListen = listen
+	}
+	if req.DebugSSHListen != nil {
+		listen, err := normalizeLocalListen(*req.DebugSSHListen)
+		if err != nil {
+			return nil, fmt.Errorf("%w: debug_ssh_listen: %w", ErrInvalidSettings, err)
+		}
+		setting.DebugSSHListen = listen
+	}
 
 	if err := s.db.Save(setting).Error; err != nil {
 		return nil, err
 	}
 	return setting, nil
 }
+
+// normalizeLocalListen validates a host:port listen address on the loopback interface. Nebula's stats
+// and sshd endpoints are unauthenticated or privileged and must not be reachable from the network.
+func normalizeLocalListen(listen string) (string, error) {
+	listen = strings.TrimSpace(listen)
+	if listen == "" {
+		return "", nil
+	}
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return "", err
+	}
+	if number, err := strconv.Atoi(port); err != nil || number < 1 || number > 65535 {
+		return "", fmt.Errorf("invalid port %q", port)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return "", fmt.Errorf("%s is not a loopback address", host)
+	}
+	return net.JoinHostPort(host, port), nil
+}
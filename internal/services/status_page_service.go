@@ -0,0 +1,775 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"nebula_manager/internal/models"
+)
+
+var (
+	// ErrStatusPageInvalid marks status page settings or incidents rejected by validation.
+	ErrStatusPageInvalid = errors.New("invalid status page settings")
+	// ErrStatusPageDisabled is returned by the public views while the status page is switched off.
+	ErrStatusPageDisabled = errors.New("status page is disabled")
+	// ErrStatusPageNotFound hides nodes and data that are not published on the status page.
+	ErrStatusPageNotFound = errors.New("not found")
+)
+
+const (
+	// statusPageViewTTL bounds how long node renames and tag changes take to reach the public page.
+	statusPageViewTTL = 30 * time.Second
+	// statusPageReloadGap rate-limits reloads triggered by events for nodes the cached view does not know.
+	statusPageReloadGap = time.Second
+	statusPageUptimeTTL = time.Minute
+	// statusIncidentHistory is how long resolved incidents stay on the public page.
+	statusIncidentHistory = 7 * 24 * time.Hour
+)
+
+// StatusPageService manages what the anonymous status page publishes and renders its views.
+type StatusPageService struct {
+	db     *gorm.DB
+	nodes  *NodeService
+	states *NodeStateService
+	now    func() time.Time
+
+	mu   sync.Mutex
+	view *statusPageView
+
+	uptimeMu sync.Mutex
+	uptime   map[uint][]PublicUptime
+	uptimeAt time.Time
+}
+
+// NewStatusPageService constructs a StatusPageService.
+func NewStatusPageService(db *gorm.DB, nodes *NodeService, states *NodeStateService) *StatusPageService {
+	return &StatusPageService{db: db, nodes: nodes, states: states, now: time.Now}
+}
+
+// StatusPageConfig is the editable status page configuration.
+type StatusPageConfig struct {
+	models.StatusPageSetting
+	NodeIDs []uint                  `json:"node_ids"`
+	Tags    []string                `json:"tags"`
+	Nodes   []models.StatusPageNode `json:"nodes"`
+}
+
+// StatusPageRequest replaces the status page configuration.
+type StatusPageRequest struct {
+	Enabled       bool                    `json:"enabled"`
+	Title         string                  `json:"title"`
+	Description   string                  `json:"description"`
+	Visibility    string                  `json:"visibility"`
+	NodeIDs       []uint                  `json:"node_ids"`
+	Tags          []string                `json:"tags"`
+	HideSubnetIP  bool                    `json:"hide_subnet_ip"`
+	HidePublicIP  bool                    `json:"hide_public_ip"`
+	HideResources bool                    `json:"hide_resources"`
+	HideLatency   bool                    `json:"hide_latency"`
+	GroupBy       string                  `json:"group_by"`
+	Nodes         []models.StatusPageNode `json:"nodes"`
+}
+
+// StatusIncidentRequest creates or replaces an incident note.
+type StatusIncidentRequest struct {
+	Title     string     `json:"title"`
+	Body      string     `json:"body"`
+	Impact    string     `json:"impact"`
+	Status    string     `json:"status"`
+	NodeIDs   []uint     `json:"node_ids"`
+	StartedAt *time.Time `json:"started_at"`
+}
+
+// StatusIncidentDTO is an incident with its affected nodes decoded.
+type StatusIncidentDTO struct {
+	models.StatusIncident
+	NodeIDs []uint `json:"node_ids"`
+}
+
+// PublicStatusPage carries the page-level data shown above the node list.
+type PublicStatusPage struct {
+	Title         string           `json:"title"`
+	Description   string           `json:"description"`
+	GroupBy       string           `json:"group_by"`
+	Groups        []string         `json:"groups"`
+	ShowLatency   bool             `json:"show_latency"`
+	HideResources bool             `json:"hide_resources"`
+	Incidents     []PublicIncident `json:"incidents"`
+	GeneratedAt   time.Time        `json:"generated_at"`
+}
+
+// PublicStatusNode is a node as published on the status page, with redactions and alias applied.
+type PublicStatusNode struct {
+	ID         uint           `json:"id"`
+	Name       string         `json:"name"`
+	Role       string         `json:"role"`
+	SubnetIP   string         `json:"subnet_ip,omitempty"`
+	PublicIP   string         `json:"public_ip,omitempty"`
+	Group      string         `json:"group,omitempty"`
+	State      string         `json:"state"`
+	StateSince string         `json:"state_since,omitempty"`
+	Status     *NodeStatusDTO `json:"status,omitempty"`
+	Uptime     []PublicUptime `json:"uptime"`
+}
+
+// PublicUptime is the availability of a node over one window; nil when nothing was tracked.
+type PublicUptime struct {
+	Window              string   `json:"window"`
+	AvailabilityPercent *float64 `json:"availability_percent"`
+}
+
+// PublicIncident is an incident as shown on the status page.
+type PublicIncident struct {
+	ID         uint       `json:"id"`
+	Title      string     `json:"title"`
+	Body       string     `json:"body"`
+	Impact     string     `json:"impact"`
+	Status     string     `json:"status"`
+	Nodes      []string   `json:"nodes"`
+	StartedAt  time.Time  `json:"started_at"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// statusPageView is the compiled configuration: the settings plus the published nodes.
+type statusPageView struct {
+	setting  models.StatusPageSetting
+	nodes    map[uint]publicNodeView
+	loadedAt time.Time
+}
+
+type publicNodeView struct {
+	name  string
+	group string
+	order int
+}
+
+// Get returns the status page configuration.
+func (s *StatusPageService) Get() (*StatusPageConfig, error) {
+	setting, err := s.getSetting()
+	if err != nil {
+		return nil, err
+	}
+	var entries []models.StatusPageNode
+	if err := s.db.Order("node_id asc").Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	return &StatusPageConfig{
+		StatusPageSetting: *setting,
+		NodeIDs:           splitUintList(setting.NodeIDs),
+		Tags:              splitList(setting.Tags),
+		Nodes:             entries,
+	}, nil
+}
+
+// getSetting retrieves the singleton row, creating it with the historical behaviour (every node
+// published, nothing hidden) if absent.
+func (s *StatusPageService) getSetting() (*models.StatusPageSetting, error) {
+	var setting models.StatusPageSetting
+	if err := s.db.First(&setting).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			setting = models.StatusPageSetting{
+				Enabled:    true,
+				Visibility: models.StatusPageVisibilityAll,
+				GroupBy:    models.StatusPageGroupNone,
+			}
+			if err := s.db.Create(&setting).Error; err != nil {
+				return nil, err
+			}
+			return &setting, nil
+		}
+		return nil, err
+	}
+	return &setting, nil
+}
+
+// Update replaces the status page configuration, including the per-node overrides.
+func (s *StatusPageService) Update(req StatusPageRequest) (*StatusPageConfig, error) {
+	setting, err := s.getSetting()
+	if err != nil {
+		return nil, err
+	}
+
+	switch req.Visibility {
+	case "":
+		req.Visibility = models.StatusPageVisibilityAll
+	case models.StatusPageVisibilityAll, models.StatusPageVisibilitySelected:
+	default:
+		return nil, fmt.Errorf("%w: unknown visibility %q", ErrStatusPageInvalid, req.Visibility)
+	}
+	switch req.GroupBy {
+	case "":
+		req.GroupBy = models.StatusPageGroupNone
+	case models.StatusPageGroupNone, models.StatusPageGroupRole, models.StatusPageGroupTag, models.StatusPageGroupCustom:
+	default:
+		return nil, fmt.Errorf("%w: unknown grouping %q", ErrStatusPageInvalid, req.GroupBy)
+	}
+	req.Title = strings.TrimSpace(req.Title)
+	req.Description = strings.TrimSpace(req.Description)
+	if len(req.Title) > 128 || len(req.Description) > 512 {
+		return nil, fmt.Errorf("%w: title is limited to 128 and description to 512 characters", ErrStatusPageInvalid)
+	}
+	tags := make([]string, 0, len(req.Tags))
+	for _, tag := range req.Tags {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	if req.Visibility == models.StatusPageVisibilitySelected && len(req.NodeIDs) == 0 && len(tags) == 0 {
+		return nil, fmt.Errorf("%w: select at least one node or tag", ErrStatusPageInvalid)
+	}
+
+	entries := make([]models.StatusPageNode, 0, len(req.Nodes))
+	seen := make(map[uint]bool, len(req.Nodes))
+	for _, entry := range req.Nodes {
+		entry.Alias = strings.TrimSpace(entry.Alias)
+		entry.Group = strings.TrimSpace(entry.Group)
+		if entry.NodeID == 0 {
+			return nil, fmt.Errorf("%w: node override without node_id", ErrStatusPageInvalid)
+		}
+		if seen[entry.NodeID] {
+			return nil, fmt.Errorf("%w: duplicate override for node %d", ErrStatusPageInvalid, entry.NodeID)
+		}
+		seen[entry.NodeID] = true
+		if len(entry.Alias) > 128 || len(entry.Group) > 64 {
+			return nil, fmt.Errorf("%w: alias is limited to 128 and group to 64 characters", ErrStatusPageInvalid)
+		}
+		if entry.Alias == "" && entry.Group == "" && entry.SortOrder == 0 {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	setting.Enabled = req.Enabled
+	setting.Title = req.Title
+	setting.Description = req.Description
+	setting.Visibility = req.Visibility
+	setting.NodeIDs = joinUintList(req.NodeIDs)
+	setting.Tags = strings.Join(tags, ",")
+	setting.HideSubnetIP = req.HideSubnetIP
+	setting.HidePublicIP = req.HidePublicIP
+	setting.HideResources = req.HideResources
+	setting.HideLatency = req.HideLatency
+	setting.GroupBy = req.GroupBy
+
+	if err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(setting).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("1 = 1").Delete(&models.StatusPageNode{}).Error; err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			return tx.Create(&entries).Error
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.view = nil
+	s.mu.Unlock()
+	return s.Get()
+}
+
+// loadView compiles the configuration against the current node list.
+func (s *StatusPageService) loadView() (*statusPageView, error) {
+	setting, err := s.getSetting()
+	if err != nil {
+		return nil, err
+	}
+	var entries []models.StatusPageNode
+	if err := s.db.Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	overrides := make(map[uint]models.StatusPageNode, len(entries))
+	for _, entry := range entries {
+		overrides[entry.NodeID] = entry
+	}
+	var nodes []models.Node
+	if err := s.db.Select("id", "name", "role", "tags").Find(&nodes).Error; err != nil {
+		return nil, err
+	}
+
+	selectedIDs := make(map[uint]bool)
+	for _, id := range splitUintList(setting.NodeIDs) {
+		selectedIDs[id] = true
+	}
+	selectedTags := splitList(setting.Tags)
+
+	view := &statusPageView{setting: *setting, nodes: make(map[uint]publicNodeView), loadedAt: s.now()}
+	for _, node := range nodes {
+		nodeTags := splitList(node.Tags)
+		if setting.Visibility == models.StatusPageVisibilitySelected && !selectedIDs[node.ID] && !sharesTag(nodeTags, selectedTags) {
+			continue
+		}
+		override := overrides[node.ID]
+		published := publicNodeView{name: node.Name, order: override.SortOrder}
+		if override.Alias != "" {
+			published.name = override.Alias
+		}
+		switch setting.GroupBy {
+		case models.StatusPageGroupRole:
+			published.group = node.Role
+		case models.StatusPageGroupTag:
+			published.group = firstTag(nodeTags, selectedTags)
+		case models.StatusPageGroupCustom:
+			published.group = override.Group
+		}
+		view.nodes[node.ID] = published
+	}
+	return view, nil
+}
+
+// currentView returns the cached view, reloading it when it has expired. With unknownID set the
+// view is also reloaded (at most once per statusPageReloadGap) if it does not cover that node yet.
+func (s *StatusPageService) currentView(unknownID uint) (*statusPageView, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	view := s.view
+	stale := view == nil || now.Sub(view.loadedAt) >= statusPageViewTTL
+	if !stale && unknownID != 0 {
+		if _, ok := view.nodes[unknownID]; !ok && now.Sub(view.loadedAt) >= statusPageReloadGap {
+			stale = true
+		}
+	}
+	if stale {
+		loaded, err := s.loadView()
+		if err != nil {
+			return nil, err
+		}
+		s.view = loaded
+		view = loaded
+	}
+	return view, nil
+}
+
+func (v *statusPageView) summary(node NodeSummary) NodeSummary {
+	node.Name = v.nodes[node.ID].name
+	if v.setting.HideSubnetIP {
+		node.SubnetIP = ""
+	}
+	if v.setting.HidePublicIP {
+		node.PublicIP = ""
+	}
+	return node
+}
+
+// PublicStatus returns the published nodes and the page header, or ErrStatusPageDisabled.
+func (s *StatusPageService) PublicStatus() ([]PublicStatusNode, *PublicStatusPage, error) {
+	view, err := s.currentView(0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !view.setting.Enabled {
+		return nil, nil, ErrStatusPageDisabled
+	}
+	nodes, err := s.nodes.List()
+	if err != nil {
+		return nil, nil, err
+	}
+	uptime, err := s.uptimes()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result := make([]PublicStatusNode, 0, len(view.nodes))
+	for _, node := range nodes {
+		published, ok := view.nodes[node.ID]
+		if !ok {
+			continue
+		}
+		summary := view.summary(NodeSummary{ID: node.ID, SubnetIP: node.SubnetIP, PublicIP: node.PublicIP})
+		entry := PublicStatusNode{
+			ID:         node.ID,
+			Name:       published.name,
+			Role:       node.Role,
+			SubnetIP:   summary.SubnetIP,
+			PublicIP:   summary.PublicIP,
+			Group:      published.group,
+			State:      node.State,
+			StateSince: node.StateSince,
+			Uptime:     uptime[node.ID],
+		}
+		if !view.setting.HideResources {
+			entry.Status = node.Status
+		}
+		result = append(result, entry)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		a, b := view.nodes[result[i].ID], view.nodes[result[j].ID]
+		if a.group != b.group {
+			// Ungrouped nodes go last.
+			if a.group == "" || b.group == "" {
+				return b.group == ""
+			}
+			return a.group < b.group
+		}
+		if a.order != b.order {
+			return a.order < b.order
+		}
+		return strings.ToLower(a.name) < strings.ToLower(b.name)
+	})
+
+	page := &PublicStatusPage{
+		Title:         view.setting.Title,
+		Description:   view.setting.Description,
+		GroupBy:       view.setting.GroupBy,
+		Groups:        make([]string, 0),
+		ShowLatency:   !view.setting.HideLatency,
+		HideResources: view.setting.HideResources,
+		GeneratedAt:   s.now(),
+	}
+	if view.setting.GroupBy != models.StatusPageGroupNone {
+		for _, node := range result {
+			if n := len(page.Groups); n == 0 || page.Groups[n-1] != node.Group {
+				page.Groups = append(page.Groups, node.Group)
+			}
+		}
+	}
+	if page.Incidents, err = s.publicIncidents(view); err != nil {
+		return nil, nil, err
+	}
+	return result, page, nil
+}
+
+// uptimes returns the availability windows of every node, cached because the 30 day timelines are
+// comparatively expensive and the public page is polled by anonymous visitors.
+func (s *StatusPageService) uptimes() (map[uint][]PublicUptime, error) {
+	s.uptimeMu.Lock()
+	defer s.uptimeMu.Unlock()
+	if s.uptime != nil && s.now().Sub(s.uptimeAt) < statusPageUptimeTTL {
+		return s.uptime, nil
+	}
+	availability, err := s.states.ListAvailability()
+	if err != nil {
+		return nil, err
+	}
+	uptime := make(map[uint][]PublicUptime, len(availability))
+	for _, node := range availability {
+		windows := make([]PublicUptime, len(node.Windows))
+		for i, window := range node.Windows {
+			windows[i] = PublicUptime{Window: window.Window, AvailabilityPercent: window.AvailabilityPercent}
+		}
+		uptime[node.NodeID] = windows
+	}
+	s.uptime = uptime
+	s.uptimeAt = s.now()
+	return uptime, nil
+}
+
+func (s *StatusPageService) publicIncidents(view *statusPageView) ([]PublicIncident, error) {
+	var incidents []models.StatusIncident
+	if err := s.db.Where("resolved_at IS NULL OR resolved_at >= ?", s.now().Add(-statusIncidentHistory)).
+		Order("started_at desc").Find(&incidents).Error; err != nil {
+		return nil, err
+	}
+	result := make([]PublicIncident, 0, len(incidents))
+	for _, incident := range incidents {
+		names := make([]string, 0)
+		for _, id := range splitUintList(incident.NodeIDs) {
+			if published, ok := view.nodes[id]; ok {
+				names = append(names, published.name)
+			}
+		}
+		result = append(result, PublicIncident{
+			ID:         incident.ID,
+			Title:      incident.Title,
+			Body:       incident.Body,
+			Impact:     incident.Impact,
+			Status:     incident.Status,
+			Nodes:      names,
+			StartedAt:  incident.StartedAt,
+			ResolvedAt: incident.ResolvedAt,
+			UpdatedAt:  incident.UpdatedAt,
+		})
+	}
+	return result, nil
+}
+
+// PublicNetworkSeries returns the latency series of a published node towards the other published
+// nodes. Hidden nodes and a hidden latency section are reported as ErrStatusPageNotFound.
+func (s *StatusPageService) PublicNetworkSeries(nodeID uint, query NetworkSeriesQuery) (*NodeNetworkSeries, error) {
+	view, err := s.currentView(0)
+	if err != nil {
+		return nil, err
+	}
+	if !view.setting.Enabled {
+		return nil, ErrStatusPageDisabled
+	}
+	if _, ok := view.nodes[nodeID]; !ok || view.setting.HideLatency {
+		return nil, ErrStatusPageNotFound
+	}
+	// Underlay latency would describe the nodes' public addresses, which the page may hide.
+	query.Path = SeriesPathOverlay
+	series, err := s.nodes.GetNetworkSeries(nodeID, query)
+	if err != nil {
+		return nil, err
+	}
+	series.Node = view.summary(series.Node)
+	peers := series.Peers[:0]
+	for _, peer := range series.Peers {
+		if _, ok := view.nodes[peer.Peer.ID]; ok {
+			peer.Peer = view.summary(peer.Peer)
+			peers = append(peers, peer)
+		}
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return strings.ToLower(peers[i].Peer.Name) < strings.ToLower(peers[j].Peer.Name)
+	})
+	series.Peers = peers
+	return series, nil
+}
+
+// PublicEnabled reports whether the status page is switched on.
+func (s *StatusPageService) PublicEnabled() (bool, error) {
+	view, err := s.currentView(0)
+	if err != nil {
+		return false, err
+	}
+	return view.setting.Enabled, nil
+}
+
+// PublicEvent rewrites an event's public payload for the public stream according to the current
+// settings. It returns false when the event must not be published.
+func (s *StatusPageService) PublicEvent(event Event) (json.RawMessage, bool) {
+	if event.Public == nil {
+		return nil, false
+	}
+	var subject struct {
+		NodeID uint `json:"node_id"`
+		ID     uint `json:"id"`
+	}
+	if err := json.Unmarshal(event.Public, &subject); err != nil {
+		return nil, false
+	}
+	nodeID := subject.NodeID
+	if event.Topic == EventTopicNodeCreated || event.Topic == EventTopicNodeDeleted {
+		nodeID = subject.ID
+	}
+	view, err := s.currentView(nodeID)
+	if err != nil || !view.setting.Enabled {
+		return nil, false
+	}
+	published, ok := view.nodes[nodeID]
+	if !ok {
+		return nil, false
+	}
+
+	switch event.Topic {
+	case EventTopicNodeStatus:
+		if view.setting.HideResources {
+			return nil, false
+		}
+		return event.Public, true
+	case EventTopicNodeState, EventTopicNodeDeleted:
+		return event.Public, true
+	case EventTopicNodeCreated:
+		var node PublicNodeEvent
+		if err := json.Unmarshal(event.Public, &node); err != nil {
+			return nil, false
+		}
+		summary := view.summary(NodeSummary{ID: node.ID, SubnetIP: node.SubnetIP, PublicIP: node.PublicIP})
+		node.Name, node.SubnetIP, node.PublicIP = published.name, summary.SubnetIP, summary.PublicIP
+		return marshalEventPayload(node)
+	case EventTopicNodeNetwork:
+		if view.setting.HideLatency {
+			return nil, false
+		}
+		var network NodeNetworkEvent
+		if err := json.Unmarshal(event.Public, &network); err != nil {
+			return nil, false
+		}
+		samples := network.Samples[:0]
+		for _, sample := range network.Samples {
+			if _, ok := view.nodes[sample.PeerID]; ok && sample.Path != models.PingPathUnderlay {
+				samples = append(samples, sample)
+			}
+		}
+		if len(samples) == 0 {
+			return nil, false
+		}
+		network.Samples = samples
+		return marshalEventPayload(network)
+	}
+	return nil, false
+}
+
+func marshalEventPayload(payload interface{}) (json.RawMessage, bool) {
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return nil, false
+	}
+	return encoded, true
+}
+
+// ListIncidents returns all incidents, newest first.
+func (s *StatusPageService) ListIncidents() ([]StatusIncidentDTO, error) {
+	var incidents []models.StatusIncident
+	if err := s.db.Order("started_at desc").Find(&incidents).Error; err != nil {
+		return nil, err
+	}
+	result := make([]StatusIncidentDTO, len(incidents))
+	for i, incident := range incidents {
+		result[i] = toStatusIncidentDTO(incident)
+	}
+	return result, nil
+}
+
+// GetIncident looks up an incident by ID.
+func (s *StatusPageService) GetIncident(id uint) (*StatusIncidentDTO, error) {
+	var incident models.StatusIncident
+	if err := s.db.First(&incident, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("incident %d not found", id)
+		}
+		return nil, err
+	}
+	dto := toStatusIncidentDTO(incident)
+	return &dto, nil
+}
+
+// CreateIncident validates and stores a new incident.
+func (s *StatusPageService) CreateIncident(req StatusIncidentRequest, actor string) (*StatusIncidentDTO, error) {
+	incident := models.StatusIncident{CreatedBy: actor, StartedAt: s.now()}
+	if err := s.applyIncidentRequest(&incident, req); err != nil {
+		return nil, err
+	}
+	if err := s.db.Create(&incident).Error; err != nil {
+		return nil, err
+	}
+	dto := toStatusIncidentDTO(incident)
+	return &dto, nil
+}
+
+// UpdateIncident replaces an incident. Moving it to resolved stamps the resolution time.
+func (s *StatusPageService) UpdateIncident(id uint, req StatusIncidentRequest) (*StatusIncidentDTO, error) {
+	var incident models.StatusIncident
+	if err := s.db.First(&incident, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("incident %d not found", id)
+		}
+		return nil, err
+	}
+	if err := s.applyIncidentRequest(&incident, req); err != nil {
+		return nil, err
+	}
+	if err := s.db.Save(&incident).Error; err != nil {
+		return nil, err
+	}
+	dto := toStatusIncidentDTO(incident)
+	return &dto, nil
+}
+
+// DeleteIncident removes an incident.
+func (s *StatusPageService) DeleteIncident(id uint) error {
+	return s.db.Delete(&models.StatusIncident{}, id).Error
+}
+
+func (s *StatusPageService) applyIncidentRequest(incident *models.StatusIncident, req StatusIncidentRequest) error {
+	req.Title = strings.TrimSpace(req.Title)
+	if req.Title == "" {
+		return fmt.Errorf("%w: incident title is required", ErrStatusPageInvalid)
+	}
+	if len(req.Title) > 255 {
+		return fmt.Errorf("%w: incident title is limited to 255 characters", ErrStatusPageInvalid)
+	}
+	switch req.Impact {
+	case "":
+		req.Impact = models.IncidentImpactMinor
+	case models.IncidentImpactNone, models.IncidentImpactMinor, models.IncidentImpactMajor, models.IncidentImpactCritical:
+	default:
+		return fmt.Errorf("%w: unknown impact %q", ErrStatusPageInvalid, req.Impact)
+	}
+	switch req.Status {
+	case "":
+		req.Status = models.IncidentStatusInvestigating
+	case models.IncidentStatusInvestigating, models.IncidentStatusIdentified, models.IncidentStatusMonitoring, models.IncidentStatusResolved:
+	default:
+		return fmt.Errorf("%w: unknown status %q", ErrStatusPageInvalid, req.Status)
+	}
+
+	incident.Title = req.Title
+	incident.Body = strings.TrimSpace(req.Body)
+	incident.Impact = req.Impact
+	incident.Status = req.Status
+	incident.NodeIDs = joinUintList(req.NodeIDs)
+	if req.StartedAt != nil {
+		incident.StartedAt = *req.StartedAt
+	}
+	if req.Status == models.IncidentStatusResolved {
+		if incident.ResolvedAt == nil {
+			now := s.now()
+			incident.ResolvedAt = &now
+		}
+	} else {
+		incident.ResolvedAt = nil
+	}
+	return nil
+}
+
+func toStatusIncidentDTO(incident models.StatusIncident) StatusIncidentDTO {
+	return StatusIncidentDTO{StatusIncident: incident, NodeIDs: splitUintList(incident.NodeIDs)}
+}
+
+func splitList(val string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(val, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func splitUintList(val string) []uint {
+	ids := make([]uint, 0)
+	for _, item := range splitList(val) {
+		if id, err := strconv.ParseUint(item, 10, 64); err == nil && id > 0 {
+			ids = append(ids, uint(id))
+		}
+	}
+	return ids
+}
+
+func joinUintList(ids []uint) string {
+	parts := make([]string, 0, len(ids))
+	seen := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		if id == 0 || seen[id] {
+			continue
+		}
+		seen[id] = true
+		parts = append(parts, uintLabel(id))
+	}
+	return strings.Join(parts, ",")
+}
+
+func sharesTag(tags, wanted []string) bool {
+	return len(wanted) > 0 && firstTag(tags, wanted) != ""
+}
+
+// firstTag returns the first of tags that is in wanted, or the first tag at all when wanted is empty.
+func firstTag(tags, wanted []string) string {
+	for _, tag := range tags {
+		if len(wanted) == 0 {
+			return tag
+		}
+		for _, candidate := range wanted {
+			if tag == candidate {
+				return tag
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,526 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"nebula_manager/internal/models"
+	"nebula_manager/internal/utils"
+)
+
+// Second-factor login stages returned to the client after the password step.
+const (
+	MFAStageVerify = "verify"
+	MFAStageEnroll = "enroll"
+)
+
+const (
+	mfaChallengeTTL         = 5 * time.Minute
+	mfaChallengeMaxAttempts = 5
+	recoveryCodeCount       = 10
+)
+
+var (
+	// ErrInvalidTwoFactorCode is returned when a TOTP or recovery code does not verify.
+	ErrInvalidTwoFactorCode = errors.New("invalid two-factor code")
+	// ErrMFAChallengeInvalid is returned for unknown, expired or exhausted login challenges.
+	ErrMFAChallengeInvalid = errors.New("two-factor challenge expired or invalid")
+	// ErrTwoFactorRequired is returned when disabling 2FA is forbidden by policy.
+	ErrTwoFactorRequired = errors.New("two-factor authentication is required for this role")
+	// ErrOIDCUsernameTaken is returned when a single sign-on login claims the username of the local
+	// account or of an account bound to another identity provider subject.
+	ErrOIDCUsernameTaken = errors.New("username belongs to another account")
+)
+
+// UserService manages console user records, roles and TOTP second factors.
+type UserService struct {
+	db         *gorm.DB
+	issuer     string
+	mu         sync.Mutex
+	challenges map[string]*mfaChallenge
+	now        func() time.Time
+}
+
+type mfaChallenge struct {
+	username  string
+	stage     string
+	attempts  int
+	expiresAt time.Time
+}
+
+// TOTPEnrollment carries the material an authenticator app needs to enroll.
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// TwoFactorStatus summarises a user's second-factor state.
+type TwoFactorStatus struct {
+	Enabled                bool `json:"enabled"`
+	Required               bool `json:"required"`
+	RecoveryCodesRemaining int  `json:"recovery_codes_remaining"`
+}
+
+// UpdateSecuritySettingsRequest carries the security policy update payload.
+type UpdateSecuritySettingsRequest struct {
+	TwoFactorRequiredRoles []string `json:"two_factor_required_roles"`
+}
+
+// NewUserService constructs a UserService. The issuer is shown in authenticator apps.
+func NewUserService(db *gorm.DB, issuer string) *UserService {
+	if issuer == "" {
+		issuer = "Nebula Manager"
+	}
+	return &UserService{
+		db:         db,
+		issuer:     issuer,
+		challenges: make(map[string]*mfaChallenge),
+		now:        time.Now,
+	}
+}
+
+// EnsureUser returns the user record for username, creating it or syncing its role as needed.
+func (s *UserService) EnsureUser(username, role string) (*models.User, error) {
+	var user models.User
+	err := s.db.Where("username = ?", username).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		user = models.User{Username: username, Role: role}
+		if err := s.db.Create(&user).Error; err != nil {
+			return nil, err
+		}
+		return &user, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if role != "" && user.Role != role {
+		user.Role = role
+		if err := s.db.Model(&user).Update("role", role).Error; err != nil {
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
+// EnsureOIDCUser returns the account bound to the identity's issuer and subject, creating it under the
+// identity's username or syncing its role. Usernames are IdP-controlled claims, so a login that names
+// localUsername or an account bound to another subject is refused rather than signed in as that user.
+func (s *UserService) EnsureOIDCUser(identity *OIDCIdentity, localUsername string) (*models.User, error) {
+	key := oidcSubjectKey(identity.Issuer, identity.Subject)
+	var user models.User
+	err := s.db.Where("oidc_subject = ?", key).First(&user).Error
+	if err == nil {
+		if user.Role != identity.Role {
+			user.Role = identity.Role
+			if err := s.db.Model(&user).Update("role", identity.Role).Error; err != nil {
+				return nil, err
+			}
+		}
+		return &user, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	var existing *models.User
+	err = s.db.Where("username = ?", identity.Username).First(&user).Error
+	switch {
+	case err == nil:
+		existing = &user
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, err
+	}
+	if err := checkOIDCAccount(existing, key, identity.Username, localUsername); err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		user = models.User{Username: identity.Username, Role: identity.Role, OIDCSubject: &key}
+		if err := s.db.Create(&user).Error; err != nil {
+			return nil, err
+		}
+		return &user, nil
+	}
+	// Rows created by single sign-on before accounts were bound to subjects are claimed on their next login.
+	user.Role, user.OIDCSubject = identity.Role, &key
+	if err := s.db.Model(&user).Updates(map[string]any{"role": identity.Role, "oidc_subject": key}).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// checkOIDCAccount decides whether an identity with subject key may sign in as username, given the
+// account already holding that username, if any.
+func checkOIDCAccount(existing *models.User, key, username, localUsername string) error {
+	if strings.EqualFold(strings.TrimSpace(username), localUsername) {
+		return ErrOIDCUsernameTaken
+	}
+	if existing != nil && existing.OIDCSubject != nil && *existing.OIDCSubject != key {
+		return ErrOIDCUsernameTaken
+	}
+	return nil
+}
+
+// oidcSubjectKey identifies an identity provider account; sub is only unique per issuer.
+func oidcSubjectKey(issuer, subject string) string {
+	sum := sha256.Sum256([]byte(strings.TrimRight(issuer, "/") + "\x00" + subject))
+	return hex.EncodeToString(sum[:])
+}
+
+// GetUser looks up a user by username.
+func (s *UserService) GetUser(username string) (*models.User, error) {
+	var user models.User
+	if err := s.db.Where("username = ?", username).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("user %s not found", username)
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// ListUsers returns all known console users.
+func (s *UserService) ListUsers() ([]models.User, error) {
+	var users []models.User
+	if err := s.db.Order("username asc").Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// MarkLogin records the time of a completed login.
+func (s *UserService) MarkLogin(user *models.User) error {
+	now := s.now()
+	user.LastLoginAt = &now
+	return s.db.Model(user).Update("last_login_at", now).Error
+}
+
+// GetSecuritySettings retrieves the singleton security policy row, creating one if absent.
+func (s *UserService) GetSecuritySettings() (*models.SecuritySetting, error) {
+	var setting models.SecuritySetting
+	if err := s.db.First(&setting).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			setting = models.SecuritySetting{}
+			if err := s.db.Create(&setting).Error; err != nil {
+				return nil, err
+			}
+			return &setting, nil
+		}
+		return nil, err
+	}
+	return &setting, nil
+}
+
+// UpdateSecuritySettings replaces the security policy.
+func (s *UserService) UpdateSecuritySettings(req UpdateSecuritySettingsRequest) (*models.SecuritySetting, error) {
+	setting, err := s.GetSecuritySettings()
+	if err != nil {
+		return nil, err
+	}
+	roles := make([]string, 0, len(req.TwoFactorRequiredRoles))
+	for _, role := range req.TwoFactorRequiredRoles {
+		role = strings.ToLower(strings.TrimSpace(role))
+		if role == "" {
+			continue
+		}
+		if role != models.UserRoleAdmin && role != models.UserRoleViewer {
+			return nil, fmt.Errorf("unknown role %s", role)
+		}
+		roles = append(roles, role)
+	}
+	setting.TwoFactorRequiredRoles = strings.Join(roles, ",")
+	if err := s.db.Save(setting).Error; err != nil {
+		return nil, err
+	}
+	return setting, nil
+}
+
+// TwoFactorRequired reports whether policy enforces 2FA for the role.
+func (s *UserService) TwoFactorRequired(role string) (bool, error) {
+	setting, err := s.GetSecuritySettings()
+	if err != nil {
+		return false, err
+	}
+	for _, required := range strings.Split(setting.TwoFactorRequiredRoles, ",") {
+		if strings.TrimSpace(required) == role {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// BeginLoginChallenge decides whether a password-authenticated user must complete a second step.
+// It returns an empty stage when the session can be issued immediately.
+func (s *UserService) BeginLoginChallenge(user *models.User) (stage, token string, err error) {
+	switch {
+	case user.TOTPEnabled:
+		stage = MFAStageVerify
+	default:
+		required, err := s.TwoFactorRequired(user.Role)
+		if err != nil {
+			return "", "", err
+		}
+		if !required {
+			return "", "", nil
+		}
+		stage = MFAStageEnroll
+	}
+
+	token, err = randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for key, ch := range s.challenges {
+		if now.After(ch.expiresAt) {
+			delete(s.challenges, key)
+		}
+	}
+	s.challenges[token] = &mfaChallenge{username: user.Username, stage: stage, expiresAt: now.Add(mfaChallengeTTL)}
+	return stage, token, nil
+}
+
+// StartChallengeEnrollment issues a TOTP secret for a user forced to enroll during login.
+func (s *UserService) StartChallengeEnrollment(token string) (*TOTPEnrollment, error) {
+	s.mu.Lock()
+	ch, ok := s.challenges[token]
+	valid := ok && ch.stage == MFAStageEnroll && !s.now().After(ch.expiresAt)
+	s.mu.Unlock()
+	if !valid {
+		return nil, ErrMFAChallengeInvalid
+	}
+	return s.StartEnrollment(ch.username)
+}
+
+// CompleteLoginChallenge verifies the second factor for a pending login. For enrollment challenges
+// it also activates 2FA and returns freshly generated recovery codes.
+func (s *UserService) CompleteLoginChallenge(token, code, recoveryCode string) (*models.User, []string, error) {
+	s.mu.Lock()
+	ch, ok := s.challenges[token]
+	if !ok || s.now().After(ch.expiresAt) || ch.attempts >= mfaChallengeMaxAttempts {
+		delete(s.challenges, token)
+		s.mu.Unlock()
+		return nil, nil, ErrMFAChallengeInvalid
+	}
+	ch.attempts++
+	s.mu.Unlock()
+
+	user, err := s.GetUser(ch.username)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var codes []string
+	if ch.stage == MFAStageEnroll {
+		codes, err = s.ConfirmEnrollment(user.Username, code)
+	} else {
+		err = s.verifySecondFactor(user, code, recoveryCode)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.mu.Lock()
+	delete(s.challenges, token)
+	s.mu.Unlock()
+	return user, codes, nil
+}
+
+// StartEnrollment generates a pending TOTP secret; 2FA stays inactive until ConfirmEnrollment.
+func (s *UserService) StartEnrollment(username string) (*TOTPEnrollment, error) {
+	user, err := s.GetUser(username)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, errors.New("two-factor authentication already enabled")
+	}
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.Model(user).Updates(map[string]any{"totp_secret": secret, "totp_last_step": 0}).Error; err != nil {
+		return nil, err
+	}
+	return &TOTPEnrollment{Secret: secret, URI: utils.TOTPProvisioningURI(s.issuer, username, secret)}, nil
+}
+
+// ConfirmEnrollment activates 2FA once the user proves possession of the pending secret.
+func (s *UserService) ConfirmEnrollment(username, code string) ([]string, error) {
+	user, err := s.GetUser(username)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, errors.New("two-factor authentication already enabled")
+	}
+	if user.TOTPSecret == "" {
+		return nil, errors.New("no pending two-factor enrollment")
+	}
+	step, ok := utils.ValidateTOTP(user.TOTPSecret, code, s.now())
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+	codes, hashed, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.Model(user).Updates(map[string]any{
+		"totp_enabled":   true,
+		"totp_last_step": step,
+		"recovery_codes": hashed,
+	}).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTwoFactor turns 2FA off after verifying a current code, unless policy requires it.
+func (s *UserService) DisableTwoFactor(username, code, recoveryCode string) error {
+	user, err := s.GetUser(username)
+	if err != nil {
+		return err
+	}
+	required, err := s.TwoFactorRequired(user.Role)
+	if err != nil {
+		return err
+	}
+	if required {
+		return ErrTwoFactorRequired
+	}
+	if err := s.verifySecondFactor(user, code, recoveryCode); err != nil {
+		return err
+	}
+	return s.clearTwoFactor(user)
+}
+
+// ResetTwoFactor removes a user's second factor without verification (admin recovery path).
+func (s *UserService) ResetTwoFactor(username string) error {
+	user, err := s.GetUser(username)
+	if err != nil {
+		return err
+	}
+	return s.clearTwoFactor(user)
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes after verifying a current TOTP code.
+func (s *UserService) RegenerateRecoveryCodes(username, code string) ([]string, error) {
+	user, err := s.GetUser(username)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.verifySecondFactor(user, code, ""); err != nil {
+		return nil, err
+	}
+	codes, hashed, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.Model(user).Update("recovery_codes", hashed).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// TwoFactorStatus reports the user's 2FA state and whether policy requires it.
+func (s *UserService) TwoFactorStatus(username string) (*TwoFactorStatus, error) {
+	user, err := s.GetUser(username)
+	if err != nil {
+		return nil, err
+	}
+	required, err := s.TwoFactorRequired(user.Role)
+	if err != nil {
+		return nil, err
+	}
+	remaining := 0
+	if user.RecoveryCodes != "" {
+		remaining = len(strings.Split(user.RecoveryCodes, ","))
+	}
+	return &TwoFactorStatus{Enabled: user.TOTPEnabled, Required: required, RecoveryCodesRemaining: remaining}, nil
+}
+
+func (s *UserService) verifySecondFactor(user *models.User, code, recoveryCode string) error {
+	if !user.TOTPEnabled {
+		return errors.New("two-factor authentication not enabled")
+	}
+	if strings.TrimSpace(recoveryCode) != "" {
+		return s.consumeRecoveryCode(user, recoveryCode)
+	}
+	step, ok := utils.ValidateTOTP(user.TOTPSecret, code, s.now())
+	if !ok || step <= user.TOTPLastStep {
+		return ErrInvalidTwoFactorCode
+	}
+	user.TOTPLastStep = step
+	return s.db.Model(user).Update("totp_last_step", step).Error
+}
+
+func (s *UserService) consumeRecoveryCode(user *models.User, code string) error {
+	hash := hashRecoveryCode(code)
+	stored := strings.Split(user.RecoveryCodes, ",")
+	remaining := make([]string, 0, len(stored))
+	found := false
+	for _, candidate := range stored {
+		if candidate == "" {
+			continue
+		}
+		if !found && subtleConstantCompare(candidate, hash) {
+			found = true
+			continue
+		}
+		remaining = append(remaining, candidate)
+	}
+	if !found {
+		return ErrInvalidTwoFactorCode
+	}
+	user.RecoveryCodes = strings.Join(remaining, ",")
+	return s.db.Model(user).Update("recovery_codes", user.RecoveryCodes).Error
+}
+
+func (s *UserService) clearTwoFactor(user *models.User) error {
+	return s.db.Model(user).Updates(map[string]any{
+		"totp_enabled":   false,
+		"totp_secret":    "",
+		"totp_last_step": 0,
+		"recovery_codes": "",
+	}).Error
+}
+
+// generateRecoveryCodes returns plaintext codes (shown once) and their stored hashes.
+func generateRecoveryCodes() ([]string, string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		buf := make([]byte, 5)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, "", fmt.Errorf("generate recovery code: %w", err)
+		}
+		raw := hex.EncodeToString(buf)
+		codes[i] = raw[:5] + "-" + raw[5:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	return codes, strings.Join(hashes, ","), nil
+}
+
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomToken(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
@@ -0,0 +1,5 @@
+package utils
+
+// AgentSSHUser is the user the node agent logs in as on nebula's debug sshd. The controller renders it
+// into sshd.authorized_users together with the key the agent registered.
+const AgentSSHUser = "nebula-agent"
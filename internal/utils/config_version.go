@@ -0,0 +1,23 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// ConfigVersion digests the parts of a node bundle that only change when the controller's view of
+// the network does: config.yml and the CA certificate. Node certificates are reissued with every
+// bundle download and are deliberately left out.
+func ConfigVersion(config, caCert []byte) string {
+	h := sha256.New()
+	h.Write(config)
+	h.Write([]byte{0})
+	h.Write(caCert)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ArtifactHash digests a single deployed file, as reported by the node agent for drift detection.
+func ArtifactHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,106 @@
+package utils
+
+import (
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// NebulaCertInfo holds the fields of a Nebula (v1) certificate needed for monitoring.
+type NebulaCertInfo struct {
+	Name      string
+	Groups    []string
+	NotBefore time.Time
+	NotAfter  time.Time
+	IsCA      bool
+}
+
+// ParseNebulaCertificate decodes the details of a PEM encoded Nebula v1 certificate.
+// The certificate is a protobuf message; only the wire format needed for the details is implemented.
+func ParseNebulaCertificate(certPEM string) (*NebulaCertInfo, error) {
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil {
+		return nil, errors.New("certificate is not PEM encoded")
+	}
+	if block.Type != "NEBULA CERTIFICATE" {
+		return nil, fmt.Errorf("unsupported certificate type %q", block.Type)
+	}
+
+	var details []byte
+	if err := walkProtobuf(block.Bytes, func(field int, wireType int, varint uint64, data []byte) {
+		if field == 1 && wireType == 2 {
+			details = data
+		}
+	}); err != nil {
+		return nil, err
+	}
+	if details == nil {
+		return nil, errors.New("certificate details missing")
+	}
+
+	info := &NebulaCertInfo{}
+	err := walkProtobuf(details, func(field int, wireType int, varint uint64, data []byte) {
+		switch {
+		case field == 1 && wireType == 2:
+			info.Name = string(data)
+		case field == 4 && wireType == 2:
+			info.Groups = append(info.Groups, string(data))
+		case field == 5 && wireType == 0:
+			info.NotBefore = time.Unix(int64(varint), 0)
+		case field == 6 && wireType == 0:
+			info.NotAfter = time.Unix(int64(varint), 0)
+		case field == 8 && wireType == 0:
+			info.IsCA = varint != 0
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if info.NotAfter.IsZero() {
+		return nil, errors.New("certificate expiry missing")
+	}
+	return info, nil
+}
+
+// walkProtobuf calls fn for every top-level field of a protobuf message.
+func walkProtobuf(buf []byte, fn func(field int, wireType int, varint uint64, data []byte)) error {
+	for len(buf) > 0 {
+		key, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return errors.New("malformed certificate field key")
+		}
+		buf = buf[n:]
+		field, wireType := int(key>>3), int(key&7)
+		switch wireType {
+		case 0:
+			val, n := binary.Uvarint(buf)
+			if n <= 0 {
+				return errors.New("malformed certificate varint")
+			}
+			buf = buf[n:]
+			fn(field, wireType, val, nil)
+		case 1:
+			if len(buf) < 8 {
+				return errors.New("truncated certificate field")
+			}
+			buf = buf[8:]
+		case 2:
+			size, n := binary.Uvarint(buf)
+			if n <= 0 || uint64(len(buf)-n) < size {
+				return errors.New("truncated certificate field")
+			}
+			fn(field, wireType, 0, buf[n:n+int(size)])
+			buf = buf[n+int(size):]
+		case 5:
+			if len(buf) < 4 {
+				return errors.New("truncated certificate field")
+			}
+			buf = buf[4:]
+		default:
+			return fmt.Errorf("unsupported protobuf wire type %d", wireType)
+		}
+	}
+	return nil
+}
@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 
 	"nebula_manager/internal/config"
 	"nebula_manager/internal/database"
 	"nebula_manager/internal/handlers"
+	"nebula_manager/internal/metrics"
+	"nebula_manager/internal/middleware"
 	"nebula_manager/internal/routes"
 	"nebula_manager/internal/services"
 )
@@ -18,20 +21,88 @@ func main() {
 	if cfg.EnableAutoMigrate {
 		database.AutoMigrate()
 	}
+	// Callbacks must be registered before background jobs start using the connection.
+	httpStats := metrics.NewHTTPCollector()
+	dbStats, err := metrics.NewDBCollector(conn)
+	if err != nil {
+		log.Fatalf("register database metrics: %v", err)
+	}
 
+	auditService := services.NewAuditService(conn)
 	caService := services.NewCAService(conn)
 	templateService := services.NewTemplateService(conn)
 	settingsService := services.NewSettingsService(conn)
-	authService := services.NewAuthService(cfg.AdminUsername, cfg.AdminPassword, cfg.SessionSecret, cfg.SessionSecureCookie, cfg.StaticAccessToken)
-	nodeService := services.NewNodeService(conn, caService, templateService, settingsService, cfg.DataDir, cfg.APIBaseURL, cfg.NebulaVersion, cfg.NebulaDownloadBase, cfg.NebulaProxyPrefix, cfg.StaticAccessToken)
+	authService := services.NewAuthService(conn, cfg.AdminUsername, cfg.AdminPassword, cfg.SessionSecret, cfg.SessionIdleTimeout, cfg.SessionMaxLifetime, cfg.SessionSecureCookie, cfg.StaticAccessToken)
+	userService := services.NewUserService(conn, cfg.TOTPIssuer)
+	oidcService := services.NewOIDCService(services.OIDCConfig{
+		Issuer:        cfg.OIDCIssuer,
+		ClientID:      cfg.OIDCClientID,
+		ClientSecret:  cfg.OIDCClientSecret,
+		RedirectURL:   cfg.OIDCRedirectURL,
+		Scopes:        cfg.OIDCScopes,
+		UsernameClaim: cfg.OIDCUsernameClaim,
+		GroupsClaim:   cfg.OIDCGroupsClaim,
+		RoleMapping:   cfg.OIDCRoleMapping,
+		DefaultRole:   cfg.OIDCDefaultRole,
+		TrustIdPMFA:   cfg.OIDCTrustIdPMFA,
+	}, nil)
+	loginGuard := services.NewLoginGuard(cfg.LoginMaxFailures, cfg.LoginUserFailures, cfg.LoginLockout, cfg.LoginMaxLockout)
+	retentionPolicy := services.RetentionPolicy{
+		RawPings:      cfg.PingRawRetention,
+		Rollups5m:     cfg.Ping5mRetention,
+		Rollups1h:     cfg.Ping1hRetention,
+		StatusSamples: cfg.StatusRetention,
+		StateEvents:   cfg.StateEventRetention,
+		Diagnostics:   cfg.DiagnosticRetention,
+		Interval:      cfg.RetentionInterval,
+	}
+	eventHub := services.NewEventHub()
+	nodeStateService := services.NewNodeStateService(conn, cfg.HeartbeatInterval, eventHub)
+	nodeStateService.Start(context.Background())
+	nodeService := services.NewNodeService(conn, caService, templateService, settingsService, cfg.DataDir, cfg.APIBaseURL, cfg.NebulaVersion, cfg.NebulaDownloadBase, cfg.NebulaProxyPrefix, cfg.StaticAccessToken, retentionPolicy, nodeStateService, eventHub)
+	retentionService := services.NewRetentionService(conn, retentionPolicy)
+	retentionService.Start(context.Background())
+	notificationService := services.NewNotificationService(conn, nil)
+	notificationService.Start(context.Background())
+	alertService := services.NewAlertService(conn, cfg.AlertInterval, notificationService, eventHub)
+	if err := alertService.EnsureDefaultRules(); err != nil {
+		log.Printf("alerts: seed default rules: %v", err)
+	}
+	alertService.Start(context.Background())
+
+	probeService := services.NewProbeService(conn)
+	diagnosticService := services.NewDiagnosticService(conn, eventHub)
+	agentService := services.NewAgentService(conn, cfg.AgentDir)
+	statusPageService := services.NewStatusPageService(conn, nodeService, nodeStateService)
+	metricsService := services.NewMetricsService(conn, cfg.MetricsLinkWindow, httpStats, dbStats)
 
 	router := routes.New(routes.Dependencies{
-		CA:        handlers.NewCAHandler(caService),
-		Settings:  handlers.NewSettingsHandler(settingsService),
-		Templates: handlers.NewTemplateHandler(templateService),
-		Nodes:     handlers.NewNodeHandler(nodeService),
-		Auth:      handlers.NewAuthHandler(authService),
+		CA:        handlers.NewCAHandler(caService, auditService),
+		Settings:  handlers.NewSettingsHandler(settingsService, auditService),
+		Templates: handlers.NewTemplateHandler(templateService, auditService),
+		Nodes:     handlers.NewNodeHandler(nodeService, nodeStateService, auditService),
+		Auth:      handlers.NewAuthHandler(authService, userService, auditService, loginGuard),
+		Audit:     handlers.NewAuditHandler(auditService),
+		Users:     handlers.NewUserHandler(userService, auditService),
+		OIDC:      handlers.NewOIDCHandler(oidcService, authService, userService, auditService),
+		Alerts:    handlers.NewAlertHandler(alertService, auditService),
+		Notify:    handlers.NewNotificationHandler(notificationService, auditService),
+		Metrics:   handlers.NewMetricsHandler(metricsService),
+		Events:    handlers.NewEventHandler(eventHub, statusPageService),
+		Status:    handlers.NewStatusPageHandler(statusPageService, auditService),
+		Agent:     handlers.NewAgentHandler(agentService),
+		Probes:    handlers.NewProbeHandler(probeService, auditService),
+		Diagnose:  handlers.NewDiagnosticHandler(diagnosticService, auditService),
+		HTTPStats: httpStats,
 		AuthSvc:   authService,
+		Limits: routes.RateLimiters{
+			Login:  middleware.NewRateLimiter(cfg.LoginRateLimit.Requests, cfg.LoginRateLimit.Per),
+			Public: middleware.NewRateLimiter(cfg.PublicRateLimit.Requests, cfg.PublicRateLimit.Per),
+			Agent:  middleware.NewRateLimiter(cfg.AgentRateLimit.Requests, cfg.AgentRateLimit.Per),
+			API:    middleware.NewRateLimiter(cfg.APIRateLimit.Requests, cfg.APIRateLimit.Per),
+		},
+		Scrape:         routes.ScrapeAccess{Token: cfg.MetricsToken, Public: cfg.MetricsPublic},
+		TrustedProxies: cfg.TrustedProxies,
 	}, cfg.FrontendDir)
 
 	addr := fmt.Sprintf(":%s", cfg.ServerPort)
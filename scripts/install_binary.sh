@@ -41,6 +41,18 @@ build_backend() {
   env CGO_ENABLED=0 GOOS=linux GOARCH=$(go env GOARCH) go build -o "$ROOT_DIR/build/nebula_manager" "$ROOT_DIR"
 }
 
+build_agents() {
+  echo "[build] 编译节点代理 nebula-agent"
+  mkdir -p "$ROOT_DIR/build/agent"
+  # 节点代理会自动更新到控制端提供的版本，VERSION 用于在代理版本视图中标识
+  local version
+  version=$(cd "$ROOT_DIR" && git describe --tags --always 2>/dev/null || date -u +%Y%m%d%H%M)
+  for arch in amd64 arm64 arm 386; do
+    (cd "$ROOT_DIR" && env CGO_ENABLED=0 GOOS=linux GOARCH="$arch" GOARM=6 go build -ldflags "-X nebula_manager/internal/agent.Version=$version" -o "$ROOT_DIR/build/agent/nebula-agent-linux-$arch" ./cmd/nebula-agent)
+  done
+  echo "$version" > "$ROOT_DIR/build/agent/VERSION"
+}
+
 install_files() {
   echo "[install] 拷贝文件到 $INSTALL_DIR"
   rm -rf "$INSTALL_DIR"
@@ -53,6 +65,7 @@ install_files() {
   fi
   mkdir -p "$INSTALL_DIR/frontend"
   cp -R "$ROOT_DIR/frontend/dist" "$INSTALL_DIR/frontend/"
+  cp -R "$ROOT_DIR/build/agent" "$INSTALL_DIR/"
   mkdir -p "$DEFAULT_DATA_DIR"
 }
 
@@ -76,6 +89,7 @@ NEBULA_MYSQL_DSN="$mysql_dsn"
 NEBULA_SERVER_PORT="$server_port"
 NEBULA_DATA_DIR="$data_dir"
 NEBULA_FRONTEND_DIR="$INSTALL_DIR/frontend/dist"
+NEBULA_AGENT_DIR="$INSTALL_DIR/agent"
 NEBULA_API_BASE="$api_base"
 ENV
 
@@ -111,6 +125,7 @@ reload_systemd() {
 
 cleanup() {
   rm -f "$ROOT_DIR/build/nebula_manager"
+  rm -rf "$ROOT_DIR/build/agent"
 }
 
 print_summary() {
@@ -135,6 +150,7 @@ main() {
   check_dependency npm
   build_frontend
   build_backend
+  build_agents
   install_files
   write_env_file
   write_service_file
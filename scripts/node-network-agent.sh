@@ -1,6 +1,14 @@
 #!/usr/bin/env bash
 set -euo pipefail
 
+SELF=$(readlink -f "$0" 2>/dev/null || echo "$0")
+SELF_CHECKSUM=$(sha256sum "$SELF" 2>/dev/null | cut -d' ' -f1 || true)
+# 自动更新后的首次运行若失败，恢复上一版本并记住失败的版本，之后不再安装它
+if [[ -f "$SELF.pending" && -f "$SELF.previous" ]]; then
+  rm -f "$SELF.pending"
+  trap 'rc=$?; if [[ $rc -ne 0 ]]; then echo "$SELF_CHECKSUM" >"$SELF.failed"; mv -f "$SELF.previous" "$SELF"; echo "[agent] 更新后的脚本运行失败，已恢复上一版本" >&2; else rm -f "$SELF.failed"; fi' EXIT
+fi
+
 if ! command -v ping >/dev/null 2>&1; then
   echo "[agent] 未找到 ping 命令，请先安装对应的 iputils/ping 工具" >&2
   exit 2
@@ -25,7 +33,13 @@ NODE_ID="${NEBULA_NODE_ID:-}"
 PEERS_RAW="${NEBULA_PEERS:-}"
 TOKEN="${NEBULA_ACCESS_TOKEN:-}"
 PING_TIMEOUT="${NEBULA_AGENT_PING_TIMEOUT:-3}"
-PING_COUNT="${NEBULA_AGENT_PING_COUNT:-1}"
+PING_COUNT="${NEBULA_AGENT_PING_COUNT:-5}"
+PING_INTERVAL="${NEBULA_AGENT_PING_INTERVAL:-0.2}"
+# 与 nebula-agent 共用 env 文件，间隔也可写成 200ms / 1s
+case "$PING_INTERVAL" in
+  *ms) PING_INTERVAL=$(awk -v v="${PING_INTERVAL%ms}" 'BEGIN { printf "%.3f", v / 1000 }') ;;
+  *s) PING_INTERVAL="${PING_INTERVAL%s}" ;;
+esac
 
 if [[ -z "$NODE_ID" ]]; then
   echo "[agent] 需要设置 NEBULA_NODE_ID（当前节点在控制台中的 ID）" >&2
@@ -35,6 +49,10 @@ if [[ -z "$TOKEN" ]]; then
   echo "[agent] 需要设置 NEBULA_ACCESS_TOKEN（可使用 NEBULA_STATIC_TOKEN 或登录获取）" >&2
   exit 1
 fi
+# 自动更新在替换前以 NEBULA_AGENT_SELF_CHECK=1 试运行新版本，到此即结束
+if [[ "${NEBULA_AGENT_SELF_CHECK:-0}" == "1" ]]; then
+  exit 0
+fi
 
 # 动态刷新目标列表（默认开启，可通过 NEBULA_DYNAMIC_TARGETS=0 关闭）
 if [[ "${NEBULA_DYNAMIC_TARGETS:-1}" == "1" ]]; then
@@ -87,29 +105,38 @@ for entry in "${PEERS[@]}"; do
   fi
 
   timestamp=$(date -u +"%Y-%m-%dT%H:%M:%SZ")
-  success=true
+  success=false
   latency="0"
-
-  if output=$(ping -n -c "$PING_COUNT" -W "$PING_TIMEOUT" "$target" 2>/dev/null); then
-    match=$(printf '%s\n' "$output" | grep -oE 'time[=<][0-9.]+ ?ms' | tail -n 1)
-    if [[ -n "$match" ]]; then
-      latency=${match#time}
-      latency=${latency#?}
-      latency=${latency%ms*}
-      latency=${latency// /}
-    else
-      success=false
+  sent="$PING_COUNT"
+  received=0
+  stats=""
+
+  # ping 失败（全部丢包）时仍会输出汇总行，因此不依赖退出码
+  output=$(ping -n -c "$PING_COUNT" -i "$PING_INTERVAL" -W "$PING_TIMEOUT" "$target" 2>/dev/null)
+  counts=$(printf '%s\n' "$output" | grep -oE '[0-9]+ packets transmitted, [0-9]+ (packets )?received' | head -n 1)
+  if [[ -n "$counts" ]]; then
+    sent=${counts%% *}
+    received=${counts#*, }
+    received=${received%% *}
+  fi
+  # 汇总行形如 "rtt min/avg/max/mdev = 0.041/0.052/0.070/0.011 ms"（BusyBox 没有 mdev）
+  rtt=$(printf '%s\n' "$output" | grep -oE '= [0-9.]+/[0-9.]+/[0-9.]+(/[0-9.]+)? ms' | head -n 1)
+  if [[ "$received" -gt 0 && -n "$rtt" ]]; then
+    rtt=${rtt#= }
+    rtt=${rtt% ms}
+    IFS='/' read -r rtt_min rtt_avg rtt_max rtt_mdev <<<"$rtt"
+    success=true
+    latency="$rtt_avg"
+    stats=$(printf ',"min_ms":%.3f,"max_ms":%.3f' "$rtt_min" "$rtt_max")
+    if [[ -n "$rtt_mdev" ]]; then
+      stats+=$(printf ',"mdev_ms":%.3f' "$rtt_mdev")
     fi
   else
-    success=false
-  fi
-
-  if [[ "$success" != true ]]; then
-    latency="0"
+    received=0
   fi
 
   latency_fmt=$(printf '%.3f' "$latency")
-  sample=$(printf '{"peer_id":%s,"latency_ms":%s,"success":%s,"timestamp":"%s"}' "$peer_id" "$latency_fmt" "$success" "$timestamp")
+  sample=$(printf '{"peer_id":%s,"latency_ms":%s,"success":%s,"sent":%s,"received":%s%s,"timestamp":"%s"}' "$peer_id" "$latency_fmt" "$success" "$sent" "$received" "$stats" "$timestamp")
 
   if [[ "$first" == true ]]; then
     samples+="$sample"
@@ -137,7 +164,11 @@ echo "[agent] 上报完成: $response"
 
 if [[ "${NEBULA_DISABLE_STATUS:-0}" != "1" ]]; then
   if command -v python3 >/dev/null 2>&1; then
-    status_payload=$(python3 - <<'PY'
+    update_error=""
+    if [[ -s "$SELF.failed" ]]; then
+      update_error="updated script failed its first run and was rolled back"
+    fi
+    status_payload=$(AGENT_CHECKSUM="$SELF_CHECKSUM" AGENT_UPDATE_ERROR="$update_error" python3 - <<'PY'
 import json
 import os
 import shutil
@@ -273,6 +304,15 @@ try:
         'uptime': uptime,
         'reported_at': reported_at,
     }
+    checksum = os.environ.get('AGENT_CHECKSUM', '')
+    if checksum:
+        # 脚本没有版本号，以校验和前缀标识
+        payload['agent'] = {
+            'version': checksum[:12],
+            'platform': 'script',
+            'checksum': checksum,
+            'update_error': os.environ.get('AGENT_UPDATE_ERROR', ''),
+        }
     print(json.dumps(payload))
 except Exception:
     pass
@@ -289,3 +329,25 @@ PY
     echo "[agent] python3 不可用，跳过运行状态上报" >&2
   fi
 fi
+
+# 自动更新（默认开启，可通过 NEBULA_AGENT_AUTO_UPDATE=0 关闭）：控制端发布的脚本与本地不同时下载，
+# 校验 SHA-256、语法并试运行后原子替换，下次运行生效
+if [[ "${NEBULA_AGENT_AUTO_UPDATE:-1}" != "0" && -n "$SELF_CHECKSUM" && -w "$(dirname "$SELF")" ]]; then
+  release=$(curl -fsS -H "Authorization: Bearer ${TOKEN}" "$API_URL/api/agent/release/script" 2>/dev/null || true)
+  expected=$(printf '%s' "$release" | grep -oE '"sha256": *"[0-9a-f]{64}"' | grep -oE '[0-9a-f]{64}' || true)
+  failed=$(cat "$SELF.failed" 2>/dev/null || true)
+  if [[ -n "$expected" && "$expected" != "$SELF_CHECKSUM" && "$expected" != "$failed" ]]; then
+    if curl -fsS -H "Authorization: Bearer ${TOKEN}" "$API_URL/api/agent/script" -o "$SELF.new" \
+      && [[ "$(sha256sum "$SELF.new" | cut -d' ' -f1)" == "$expected" ]] && bash -n "$SELF.new" \
+      && NEBULA_AGENT_SELF_CHECK=1 bash "$SELF.new" >/dev/null 2>&1; then
+      chmod 755 "$SELF.new"
+      cp -p "$SELF" "$SELF.previous"
+      touch "$SELF.pending"
+      mv -f "$SELF.new" "$SELF"
+      echo "[agent] 脚本探针已更新，下次运行生效"
+    else
+      rm -f "$SELF.new"
+      echo "[agent] 脚本探针更新失败" >&2
+    fi
+  fi
+fi
@@ -39,6 +39,15 @@ if [ -f "$ROOT_DIR/config.yaml.default" ]; then
 fi
 cp -R "$DIST_DIR" "$STAGING_COMMON/frontend"
 
+# Node agent binaries are served to install scripts, so every package carries all Linux builds
+mkdir -p "$STAGING_COMMON/agent"
+for arch in amd64 arm64 arm 386; do
+  echo "[build] nebula-agent linux/$arch"
+  env CGO_ENABLED=0 GOOS=linux GOARCH="$arch" GOARM=6 go build -ldflags "-X nebula_manager/internal/agent.Version=$VERSION" -o "$STAGING_COMMON/agent/nebula-agent-linux-$arch" ./cmd/nebula-agent
+done
+# Agents update themselves to these builds; VERSION labels them in the fleet view
+echo "$VERSION" > "$STAGING_COMMON/agent/VERSION"
+
 for target in "${TARGETS[@]}"; do
   IFS=/ read -r GOOS GOARCH <<<"$target"
   echo "[build] targeting $GOOS/$GOARCH"